@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = "/etc/bluebanquise-installer.yaml"
+
+var configFile string
+
+// flagSource looks up a default value for a flag by name.
+type flagSource func(name string) (string, bool)
+
+// envPrefix is prepended to flag names to build their environment variable names.
+const envPrefix = "BB_"
+
+// loadFlagDefaults applies BB_* environment variables and config file values to
+// the flags of cmd that were not set explicitly.
+func loadFlagDefaults(cmd *cobra.Command) error {
+	sources := []flagSource{envSource(os.LookupEnv)}
+
+	path := configFile
+	if path == "" {
+		path = os.Getenv(envVarName("config"))
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+
+	if path != "" {
+		values, err := loadConfigFile(path)
+		if err != nil {
+			utils.LogError("Failed to load config file", err, "path", path)
+			return err
+		}
+		utils.LogInfo("Loaded config file", "path", path, "keys", len(values))
+		sources = append(sources, mapSource(values))
+	}
+
+	return applyFlagDefaults(cmd.Flags(), sources...)
+}
+
+// envVarName returns the environment variable bound to a flag, e.g. collections-path -> BB_COLLECTIONS_PATH.
+func envVarName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// envSource returns a flagSource reading BB_* variables through lookup.
+func envSource(lookup func(string) (string, bool)) flagSource {
+	return func(name string) (string, bool) {
+		return lookup(envVarName(name))
+	}
+}
+
+// loadConfigFile reads a YAML file mapping flag names to default values.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	raw := map[string]any{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("invalid value for %q in config file %s: nested keys are not supported", key, path)
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+	return values, nil
+}
+
+// mapSource returns a flagSource backed by a map of flag names to values.
+func mapSource(values map[string]string) flagSource {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+// defaultedAnnotation marks the flags set by applyFlagDefaults: flags.Set marks
+// them changed like the flags given on the command line.
+const defaultedAnnotation = "bluebanquise-installer/defaulted"
+
+// setOnCommandLine reports whether the flag name was given on the command line,
+// as opposed to left at its default or set by a BB_* variable or the config file.
+func setOnCommandLine(flags *pflag.FlagSet, name string) bool {
+	f := flags.Lookup(name)
+	return f != nil && f.Changed && f.Annotations[defaultedAnnotation] == nil
+}
+
+// applyFlagDefaults sets every flag that was not given on the command line from the
+// first source that provides a value, so sources must be ordered by precedence.
+// The flags it sets are annotated, see setOnCommandLine.
+func applyFlagDefaults(flags *pflag.FlagSet, sources ...flagSource) error {
+	var errs []string
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		for _, source := range sources {
+			value, ok := source(f.Name)
+			if !ok {
+				continue
+			}
+			if err := flags.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Sprintf("--%s: %v", f.Name, err))
+				return
+			}
+			if err := flags.SetAnnotation(f.Name, defaultedAnnotation, []string{"true"}); err != nil {
+				errs = append(errs, fmt.Sprintf("--%s: %v", f.Name, err))
+			}
+			return
+		}
+	})
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid default values: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
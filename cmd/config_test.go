@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFlagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("user", "bluebanquise", "")
+	flags.String("home", "/var/lib/bluebanquise", "")
+	flags.Bool("skip-environment", false, "")
+	return flags
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := `user: myuser
+home: /opt/bluebanquise
+skip-environment: true
+extra-packages:
+  - vim
+  - htop
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	values, err := loadConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "myuser", values["user"])
+	assert.Equal(t, "/opt/bluebanquise", values["home"])
+	assert.Equal(t, "true", values["skip-environment"])
+	assert.Equal(t, "vim,htop", values["extra-packages"])
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := loadConfigFile(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Nested keys", func(t *testing.T) {
+		nested := filepath.Join(t.TempDir(), "nested.yaml")
+		require.NoError(t, os.WriteFile(nested, []byte("online:\n  user: myuser\n"), 0644))
+		_, err := loadConfigFile(nested)
+		assert.Error(t, err)
+	})
+}
+
+func TestApplyFlagDefaultsPrecedence(t *testing.T) {
+	config := mapSource(map[string]string{
+		"user":             "configuser",
+		"home":             "/opt/config",
+		"skip-environment": "true",
+	})
+
+	t.Run("Config overrides built-in default", func(t *testing.T) {
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		require.NoError(t, applyFlagDefaults(flags, config))
+
+		user, _ := flags.GetString("user")
+		skip, _ := flags.GetBool("skip-environment")
+		assert.Equal(t, "configuser", user)
+		assert.True(t, skip)
+	})
+
+	t.Run("Explicit flag overrides config", func(t *testing.T) {
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{"--user", "flaguser"}))
+		require.NoError(t, applyFlagDefaults(flags, config))
+
+		user, _ := flags.GetString("user")
+		home, _ := flags.GetString("home")
+		assert.Equal(t, "flaguser", user)
+		assert.Equal(t, "/opt/config", home)
+
+		// Both are changed, only the flag was given on the command line
+		assert.True(t, flags.Changed("home"))
+		assert.True(t, setOnCommandLine(flags, "user"))
+		assert.False(t, setOnCommandLine(flags, "home"))
+	})
+
+	t.Run("First source wins", func(t *testing.T) {
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		higher := mapSource(map[string]string{"user": "higheruser"})
+		require.NoError(t, applyFlagDefaults(flags, higher, config))
+
+		user, _ := flags.GetString("user")
+		home, _ := flags.GetString("home")
+		assert.Equal(t, "higheruser", user)
+		assert.Equal(t, "/opt/config", home)
+	})
+
+	t.Run("Built-in default without sources", func(t *testing.T) {
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		require.NoError(t, applyFlagDefaults(flags))
+
+		user, _ := flags.GetString("user")
+		assert.Equal(t, "bluebanquise", user)
+	})
+
+	t.Run("Invalid value", func(t *testing.T) {
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		err := applyFlagDefaults(flags, mapSource(map[string]string{"skip-environment": "maybe"}))
+		assert.Error(t, err)
+	})
+}
+
+func TestEnvVarName(t *testing.T) {
+	assert.Equal(t, "BB_USER", envVarName("user"))
+	assert.Equal(t, "BB_COLLECTIONS_PATH", envVarName("collections-path"))
+	assert.Equal(t, "BB_SKIP_ENVIRONMENT", envVarName("skip-environment"))
+}
+
+func TestApplyFlagDefaultsEnv(t *testing.T) {
+	config := mapSource(map[string]string{"user": "configuser", "home": "/opt/config"})
+
+	t.Run("BB_USER sets user when flag is omitted", func(t *testing.T) {
+		t.Setenv("BB_USER", "envuser")
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		require.NoError(t, applyFlagDefaults(flags, envSource(os.LookupEnv)))
+
+		user, _ := flags.GetString("user")
+		assert.Equal(t, "envuser", user)
+	})
+
+	t.Run("Flag wins over BB_USER", func(t *testing.T) {
+		t.Setenv("BB_USER", "envuser")
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{"--user", "flaguser"}))
+		require.NoError(t, applyFlagDefaults(flags, envSource(os.LookupEnv)))
+
+		user, _ := flags.GetString("user")
+		assert.Equal(t, "flaguser", user)
+	})
+
+	t.Run("Env wins over config file", func(t *testing.T) {
+		t.Setenv("BB_USER", "envuser")
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		require.NoError(t, applyFlagDefaults(flags, envSource(os.LookupEnv), config))
+
+		user, _ := flags.GetString("user")
+		home, _ := flags.GetString("home")
+		assert.Equal(t, "envuser", user)
+		assert.Equal(t, "/opt/config", home)
+	})
+
+	t.Run("Boolean env value", func(t *testing.T) {
+		t.Setenv("BB_SKIP_ENVIRONMENT", "true")
+		flags := newTestFlagSet()
+		require.NoError(t, flags.Parse([]string{}))
+		require.NoError(t, applyFlagDefaults(flags, envSource(os.LookupEnv)))
+
+		skip, _ := flags.GetBool("skip-environment")
+		assert.True(t, skip)
+	})
+}
@@ -1,21 +1,23 @@
 package cmd
 
 import (
-	"fmt"
 	"os"
-	"path/filepath"
 
-	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
 )
 
-var (
-	downloadPath         string
-	downloadCollections  bool
-	downloadRequirements bool
-	downloadCoreVars     bool
-	downloadCmd          = &cobra.Command{
+// downloadOptions holds the flags of the download command.
+type downloadOptions struct {
+	installer.DownloadOptions
+	mirror mirrorOptions
+}
+
+// newDownloadCmd returns the download command bound to its own options.
+func newDownloadCmd() *cobra.Command {
+	opts := &downloadOptions{}
+	cmd := &cobra.Command{
 		Use:   "download",
 		Short: "Download BlueBanquise collections and requirements for offline installation",
 		Long: `Download BlueBanquise collections and requirements from GitHub for offline installation.
@@ -38,195 +40,47 @@ Examples:
   # Download core variables only
   ./bluebanquise-installer download --path /tmp/core-vars --core-vars
 
-  # Download everything
-  ./bluebanquise-installer download --path /tmp/offline --collections --requirements --core-vars`,
-		Run: func(cmd *cobra.Command, args []string) {
-			if downloadPath == "" {
-				utils.LogError("Missing download path", nil)
-				fmt.Println("Error: --path is required")
-				os.Exit(1)
-			}
+  # Download requirements for several distributions, into <path>/requirements/<os>-<version>/
+  ./bluebanquise-installer download --path /tmp/offline --requirements --target-os rhel:9 --target-os ubuntu:22.04
 
-			if !downloadCollections && !downloadRequirements && !downloadCoreVars {
-				utils.LogError("No download type specified", nil)
-				fmt.Println("Error: specify at least one of --collections, --requirements, or --core-vars")
-				os.Exit(1)
-			}
+  # Download everything
+  ./bluebanquise-installer download --path /tmp/offline --collections --requirements --core-vars
 
-			utils.LogInfo("Starting BlueBanquise download",
-				"path", downloadPath,
-				"collections", downloadCollections,
-				"requirements", downloadRequirements,
-				"core-vars", downloadCoreVars)
-
-			// Create base download directory
-			if err := os.MkdirAll(downloadPath, 0755); err != nil {
-				utils.LogError("Error creating download directory", err, "path", downloadPath)
-				fmt.Printf("Error creating download directory: %v\n", err)
-				os.Exit(1)
+  # Show what would be downloaded, without downloading or writing anything
+  ./bluebanquise-installer download --path /tmp/offline --collections --requirements --core-vars --dry-run`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := opts.mirror.configureTLS(); err != nil {
+				utils.LogError("Invalid TLS configuration", err)
+				printError(err)
+				exitWithError()
 			}
 
-			if downloadCollections {
-				downloadCollectionsToPath()
-			}
-			if downloadRequirements {
-				downloadRequirementsToPath()
-			}
-			if downloadCoreVars {
-				downloadCoreVarsToPath()
+			if err := installer.New().Download(cmd.Context(), opts.DownloadOptions); err != nil {
+				printError(err)
+				exitWithError()
 			}
 		},
 	}
-)
-
-func downloadCollectionsToPath() {
-	collectionsPath := filepath.Join(downloadPath, "collections")
-	utils.LogInfo("Downloading collections", "path", collectionsPath)
-
-	// Create collections directory
-	if err := os.MkdirAll(collectionsPath, 0755); err != nil {
-		utils.LogError("Error creating collections directory", err, "path", collectionsPath)
-		fmt.Printf("Error creating collections directory: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Create temporary Python environment outside download directory
-	tempVenv := filepath.Join(os.TempDir(), "bluebanquise_download_venv")
-	if err := utils.RunCommand("/usr/bin/python3", "-m", "venv", tempVenv); err != nil {
-		utils.LogError("Error creating temporary virtual environment", err, "path", tempVenv)
-		fmt.Printf("Error creating temporary virtual environment: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Install ansible-galaxy in temp environment
-	python3 := filepath.Join(tempVenv, "bin", "python3")
-	if err := utils.RunCommand(python3, "-m", "pip", "install", "ansible-core"); err != nil {
-		utils.LogError("Error installing ansible-core", err)
-		fmt.Printf("Error installing ansible-core: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Download tarballs
-	ansibleGalaxy := filepath.Join(tempVenv, "bin", "ansible-galaxy")
-
-	utils.LogInfo("Downloading BlueBanquise collection tarball")
-	fmt.Println("Downloading BlueBanquise collection tarball...")
-	if err := utils.RunCommand(ansibleGalaxy,
-		"collection", "download",
-		"git+https://github.com/bluebanquise/bluebanquise.git#/collections/infrastructure,master",
-		"-p", collectionsPath); err != nil {
-		utils.LogError("Error downloading BlueBanquise tarball", err)
-		fmt.Printf("Error downloading BlueBanquise tarball: %v\n", err)
-		os.Exit(1)
-	}
-
-	utils.LogInfo("Downloading community.general collection tarball")
-	fmt.Println("Downloading community.general collection tarball...")
-	if err := utils.RunCommand(ansibleGalaxy,
-		"collection", "download",
-		"community.general",
-		"-p", collectionsPath); err != nil {
-		utils.LogError("Error downloading community.general tarball", err)
-		fmt.Printf("Error downloading community.general tarball: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Clean up temp environment
-	if err := os.RemoveAll(tempVenv); err != nil {
-		utils.LogWarning("Could not remove temporary environment", "error", err, "path", tempVenv)
-		fmt.Printf("Warning: could not remove temporary environment: %v\n", err)
-	}
 
-	utils.LogInfo("Collections downloaded successfully", "path", collectionsPath)
-	fmt.Printf("Collections downloaded successfully to: %s\n", collectionsPath)
-	fmt.Println("Transfer this directory to your target machine and use with:")
-	fmt.Printf("  ./bluebanquise-installer offline --collections-path %s\n", collectionsPath)
-}
-
-func downloadRequirementsToPath() {
-	requirementsPath := filepath.Join(downloadPath, "requirements")
-	utils.LogInfo("Downloading Python requirements", "path", requirementsPath)
-
-	// Create requirements directory
-	if err := os.MkdirAll(requirementsPath, 0755); err != nil {
-		utils.LogError("Error creating requirements directory", err, "path", requirementsPath)
-		fmt.Printf("Error creating requirements directory: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Detect OS to get the correct requirements
-	osID, version, err := system.DetectOS()
-	if err != nil {
-		utils.LogError("Error detecting OS", err)
-		fmt.Printf("Error detecting OS: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Get requirements for this OS
-	var requirements []string
-	for _, pkg := range system.DependenciePackages {
-		if pkg.OSID == osID && pkg.Version == version {
-			requirements = system.PythonRequirements
-			break
-		}
-	}
-
-	if len(requirements) == 0 {
-		utils.LogError("No requirements found for OS", nil, "os", osID, "version", version)
-		fmt.Printf("No requirements found for %s %s\n", osID, version)
-		os.Exit(1)
-	}
-
-	utils.LogInfo("Downloading requirements for OS", "os", osID, "version", version, "requirements", requirements)
-	fmt.Printf("Downloading Python requirements for %s %s...\n", osID, version)
-
-	if err := utils.DownloadRequirements(requirements, requirementsPath); err != nil {
-		utils.LogError("Error downloading requirements", err)
-		fmt.Printf("Error downloading requirements: %v\n", err)
-		os.Exit(1)
-	}
-
-	utils.LogInfo("Python requirements downloaded successfully", "path", requirementsPath)
-	fmt.Printf("Python requirements downloaded successfully to: %s\n", requirementsPath)
-	fmt.Println("Transfer this directory to your target machine and use with:")
-	fmt.Printf("  ./bluebanquise-installer offline --collections-path <collections-path> --requirements-path %s\n", requirementsPath)
-}
-
-func downloadCoreVarsToPath() {
-	coreVarsPath := filepath.Join(downloadPath, "core-vars")
-	utils.LogInfo("Downloading core variables", "path", coreVarsPath)
-
-	// Create core-vars directory
-	if err := os.MkdirAll(coreVarsPath, 0755); err != nil {
-		utils.LogError("Error creating core-vars directory", err, "path", coreVarsPath)
-		fmt.Printf("Error creating core-vars directory: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Download core variables from GitHub
-	utils.LogInfo("Downloading core variables from GitHub")
-	fmt.Println("Downloading core variables from GitHub...")
-	if err := utils.DownloadFile("https://raw.githubusercontent.com/bluebanquise/bluebanquise/refs/heads/master/resources/bb_core.yml", filepath.Join(coreVarsPath, "bb_core.yml")); err != nil {
-		utils.LogError("Error downloading core variables", err)
-		fmt.Printf("Error downloading core variables: %v\n", err)
+	cmd.Flags().StringVarP(&opts.Path, "path", "p", "", "Path to download collections (required)")
+	cmd.Flags().BoolVarP(&opts.Collections, "collections", "c", false, "Download collections/tarballs for offline installation")
+	cmd.Flags().BoolVarP(&opts.Requirements, "requirements", "r", false, "Download Python requirements for offline installation")
+	cmd.Flags().StringVar(&opts.AnsibleVersion, "ansible-version", "", "Ansible release to download with --requirements, e.g. 9.2.0 (default: latest)")
+	cmd.Flags().StringArrayVar(&opts.TargetOS, "target-os", nil, "Download --requirements for os:version instead of the host OS, e.g. rhel:9, repeat for several targets")
+	cmd.Flags().StringVar(&opts.TargetPython, "target-python", "", "Python version to download --requirements wheels for, e.g. 3.11 (default: that of each --target-os)")
+	cmd.Flags().StringArrayVar(&opts.TargetPlatforms, "target-platform", nil, "Wheel platform to download --requirements for, e.g. manylinux_2_28_x86_64, repeat for several (default: those of each --target-os)")
+	cmd.Flags().BoolVarP(&opts.CoreVars, "core-vars", "v", false, "Download core variables for offline installation")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print the planned downloads without downloading or writing anything")
+	cmd.Flags().BoolVar(&opts.KeepTemp, "keep-temp", false, "Keep the temporary virtual environment used to download collections")
+	opts.mirror.addTLSFlags(cmd)
+	if err := cmd.MarkFlagRequired("path"); err != nil {
+		utils.LogError("Error marking path flag as required", err)
 		os.Exit(1)
 	}
 
-	utils.LogInfo("Core variables downloaded successfully", "path", coreVarsPath)
-	fmt.Printf("Core variables downloaded successfully to: %s\n", coreVarsPath)
-	fmt.Println("Transfer this file to your target machine and use with:")
-	fmt.Printf("  ./bluebanquise-installer offline --collections-path <collections-path> --core-vars-path %s/bb_core.yml\n", coreVarsPath)
+	return cmd
 }
 
 func init() {
-	downloadCmd.Flags().StringVarP(&downloadPath, "path", "p", "", "Path to download collections (required)")
-	downloadCmd.Flags().BoolVarP(&downloadCollections, "collections", "c", false, "Download collections/tarballs for offline installation")
-	downloadCmd.Flags().BoolVarP(&downloadRequirements, "requirements", "r", false, "Download Python requirements for offline installation")
-	downloadCmd.Flags().BoolVarP(&downloadCoreVars, "core-vars", "v", false, "Download core variables for offline installation")
-	if err := downloadCmd.MarkFlagRequired("path"); err != nil {
-		utils.LogError("Error marking path flag as required", err)
-		os.Exit(1)
-	}
-
-	rootCmd.AddCommand(downloadCmd)
+	rootCmd.AddCommand(newDownloadCmd())
 }
@@ -28,7 +28,7 @@ func TestDownloadCommand(t *testing.T) {
 	// Test valid command structure
 	t.Run("valid command structure", func(t *testing.T) {
 		// Test that the command can be created and has the right flags
-		cmd := downloadCmd
+		cmd := newDownloadCmd()
 
 		// Check that required flags exist
 		pathFlag := cmd.Flags().Lookup("path")
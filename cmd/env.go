@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// envOptions holds the flags of the env command.
+type envOptions struct {
+	userName string
+	userHome string
+	shell    string
+}
+
+// newEnvCmd returns the env command bound to its own options.
+func newEnvCmd() *cobra.Command {
+	opts := &envOptions{}
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Print shell commands to activate the BlueBanquise environment",
+		Long: `Print the shell commands that activate the BlueBanquise Python virtual
+environment and set ANSIBLE_CONFIG for a given user.
+
+The output is meant to be evaluated by the shell.
+
+Examples:
+  # Activate the environment of the default user in bash/zsh
+  eval "$(./bluebanquise-installer env)"
+
+  # Activate the environment of a custom user in fish
+  ./bluebanquise-installer env --user myuser --shell fish | source`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Keep stdout for the snippet only
+			utils.SetConsoleOutput(os.Stderr)
+			return setupCommand(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			userHome := opts.userHome
+			if userHome == "" {
+				home, err := bootstrap.LookupUserHome(opts.userName)
+				if err != nil {
+					utils.LogError("Error resolving user home", err, "user", opts.userName)
+					fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+					exitWithError()
+				}
+				userHome = home
+			}
+
+			shell := opts.shell
+			if shell == "" {
+				shell = filepath.Base(os.Getenv("SHELL"))
+			}
+
+			snippet, err := activationSnippet(shell, userHome)
+			if err != nil {
+				utils.LogError("Error generating activation snippet", err, "shell", shell)
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				exitWithError()
+			}
+			fmt.Print(snippet)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.userName, "user", "u", "bluebanquise", "Username the environment belongs to")
+	cmd.Flags().StringVarP(&opts.userHome, "home", "H", "", "Home directory of the user (default: from the user database)")
+	cmd.Flags().StringVarP(&opts.shell, "shell", "s", "", "Shell syntax to print: bash, zsh, sh, fish or csh (default: from $SHELL)")
+	return cmd
+}
+
+// activationSnippet returns the shell lines activating the environment installed in userHome.
+func activationSnippet(shell, userHome string) (string, error) {
+	activate := filepath.Join(bootstrap.VenvBin(userHome), "activate")
+	ansibleConfig := bootstrap.AnsibleConfigPath(userHome)
+
+	var lines []string
+	switch shell {
+	case "", ".", "bash", "zsh", "sh", "ksh":
+		lines = []string{
+			fmt.Sprintf("source %s", activate),
+			fmt.Sprintf("export ANSIBLE_CONFIG=%s", ansibleConfig),
+		}
+	case "fish":
+		lines = []string{
+			fmt.Sprintf("source %s.fish", activate),
+			fmt.Sprintf("set -gx ANSIBLE_CONFIG %s", ansibleConfig),
+		}
+	case "csh", "tcsh":
+		lines = []string{
+			fmt.Sprintf("source %s.csh", activate),
+			fmt.Sprintf("setenv ANSIBLE_CONFIG %s", ansibleConfig),
+		}
+	default:
+		return "", fmt.Errorf("unsupported shell %q (expected bash, zsh, sh, fish or csh)", shell)
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func init() {
+	rootCmd.AddCommand(newEnvCmd())
+}
@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivationSnippet(t *testing.T) {
+	t.Run("bash", func(t *testing.T) {
+		snippet, err := activationSnippet("bash", "/opt/bluebanquise")
+		require.NoError(t, err)
+		assert.Equal(t, "source /opt/bluebanquise/ansible_venv/bin/activate\n"+
+			"export ANSIBLE_CONFIG=/opt/bluebanquise/bluebanquise/ansible.cfg\n", snippet)
+	})
+
+	t.Run("fish", func(t *testing.T) {
+		snippet, err := activationSnippet("fish", "/opt/bluebanquise")
+		require.NoError(t, err)
+		assert.Equal(t, "source /opt/bluebanquise/ansible_venv/bin/activate.fish\n"+
+			"set -gx ANSIBLE_CONFIG /opt/bluebanquise/bluebanquise/ansible.cfg\n", snippet)
+	})
+
+	t.Run("Unsupported shell", func(t *testing.T) {
+		_, err := activationSnippet("powershell", "/opt/bluebanquise")
+		assert.Error(t, err)
+	})
+}
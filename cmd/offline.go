@@ -2,28 +2,88 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
-	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
 )
 
-var (
-	collectionsPath        string
-	requirementsPath       string
-	coreVarsPath           string
-	userName               string
-	userHome               string
-	offlineSkipEnvironment bool
-	offlineDebug           bool
-)
+// offlineOptions holds the flags of the offline command.
+type offlineOptions struct {
+	installer.OfflineOptions
+	// collectionsPaths are the --collections-path values, the first one is
+	// CollectionsPath and the others ExtraCollectionsPaths.
+	collectionsPaths []string
+	// checkCollections reports the collections files instead of installing.
+	checkCollections bool
+	noSudoers        bool
+	mirror           mirrorOptions
+}
+
+// splitCollectionsPaths returns the paths of the --collections-path values,
+// each of them a path or a comma-separated list of paths. A git+ URL is kept
+// whole, its comma separates the version.
+func splitCollectionsPaths(values []string) []string {
+	var paths []string
+	for _, value := range values {
+		if utils.IsGitCollectionSource(value) {
+			paths = append(paths, value)
+			continue
+		}
+		for _, path := range strings.Split(value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// writeCollectionsChecks writes the verdicts of checks to w as a table for each
+// collections path, and fails when a path would not install.
+func writeCollectionsChecks(w io.Writer, checks []installer.CollectionsCheck) error {
+	var failed []string
+	for n, check := range checks {
+		if n > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "Files of %s:\n", check.Path)
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "FILE\tVERDICT\tCOLLECTION\tDETAIL")
+		valid := 0
+		for _, file := range check.Files {
+			collection := strings.TrimSpace(file.Collection + " " + file.Version)
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", file.File, file.Verdict, collection, file.Detail)
+			if file.Verdict == utils.FileValidCollection {
+				valid++
+			}
+		}
+		table.Flush()
+		fmt.Fprintf(w, "%d file(s), %d valid collection(s)\n", len(check.Files), valid)
+		if check.Failed() {
+			failed = append(failed, check.Path)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("collections check failed for %s: unreadable or wrong format files, or no valid collection", strings.Join(failed, ", "))
+		utils.LogError("Collections check failed", err, "paths", failed)
+		return err
+	}
+	return nil
+}
 
-var offlineCmd = &cobra.Command{
-	Use:   "offline",
-	Short: "Install BlueBanquise in offline mode",
-	Long: `Install BlueBanquise in offline mode using local collections, tarballs, and requirements.
+// newOfflineCmd returns the offline command bound to its own options.
+func newOfflineCmd() *cobra.Command {
+	opts := &offlineOptions{}
+	cmd := &cobra.Command{
+		Use:   "offline",
+		Short: "Install BlueBanquise in offline mode",
+		Long: `Install BlueBanquise in offline mode using local collections, tarballs, and requirements.
 	
 This command will:
 1. Check system prerequisites
@@ -36,141 +96,96 @@ This command will:
 8. Install BlueBanquise collections from local path
 
 Use --collections-path to specify the BlueBanquise collections directory.
-You can use --requirements-path for offline Python packages.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		if collectionsPath == "" {
-			utils.LogError("Missing required path", nil, "collections_path", collectionsPath)
-			fmt.Println("Error: --collections-path is required for offline installation")
-			os.Exit(1)
-		}
-
-		utils.LogInfo("Starting BlueBanquise offline installation",
-			"collections_path", collectionsPath,
-			"requirements_path", requirementsPath,
-			"user", userName,
-			"home", userHome,
-			"skip_environment", offlineSkipEnvironment,
-			"debug", offlineDebug)
-
-		// Validate collections path
-		utils.LogInfo("Validating collections path", "path", collectionsPath)
-		fmt.Println("Validating collections path...")
-		if err := utils.CheckCollectionsPrerequisites(collectionsPath); err != nil {
-			utils.LogError("Collections validation failed", err, "path", collectionsPath)
-			fmt.Printf("Collections validation failed: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Validate requirements path if provided
-		if requirementsPath != "" {
-			utils.LogInfo("Validating requirements path", "path", requirementsPath)
-			fmt.Println("Validating requirements path...")
-			if err := utils.CheckRequirementsPrerequisites(requirementsPath); err != nil {
-				utils.LogError("Requirements validation failed", err, "path", requirementsPath)
-				fmt.Printf("Requirements validation failed: %v\n", err)
-				os.Exit(1)
+Repeat it to install the archives of several directories together, each
+collection version once.
+You can use --requirements-path for offline Python packages.
+Use --from-bundle instead of --collections-path to take the collections,
+requirements and core variables from a directory or .tar.gz laid out by the
+download command.
+Use --check-collections to print a verdict for each collections file (valid
+collection, not an archive, unreadable or wrong format) without installing.
+Use --skip-environment, --skip-collections and --skip-core-vars to run
+only some of the installation phases.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return deriveUserHome(cmd.Flags())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			sudoersMode, err := resolveSudoersMode(cmd.Flags(), opts.SudoersMode, opts.noSudoers)
+			if err != nil {
+				utils.LogError("Invalid sudoers configuration", err)
+				printError(err)
+				exitWithError()
 			}
-		}
 
-		// Validate core vars path if provided
-		if coreVarsPath != "" {
-			utils.LogInfo("Validating core variables path", "path", coreVarsPath)
-			fmt.Println("Validating core variables path...")
-			if _, err := os.Stat(coreVarsPath); err != nil {
-				utils.LogError("Core variables path validation failed", err, "path", coreVarsPath)
-				fmt.Printf("Core variables path validation failed: %v\n", err)
-				os.Exit(1)
+			downloadOptions, err := opts.mirror.downloadOptions()
+			if err != nil {
+				utils.LogError("Invalid mirror configuration", err)
+				printError(err)
+				exitWithError()
 			}
-		}
 
-		// Detectar OS
-		utils.LogInfo("Detecting operating system")
-		osID, version, err := system.DetectOS()
-		if err != nil {
-			utils.LogError("Error detecting OS", err)
-			fmt.Printf("Error detecting OS: %v\n", err)
-			os.Exit(1)
-		}
-		utils.LogInfo("OS detected", "os", osID, "version", version)
-		fmt.Printf("Detected OS: %s %s\n", osID, version)
-
-		// Find packages for this OS
-		var packages []string
-		for _, pkg := range system.DependenciePackages {
-			if pkg.OSID == osID && pkg.Version == version {
-				packages = pkg.Packages
-				break
+			opts.SudoersMode = sudoersMode
+			opts.Mirror = downloadOptions
+			if paths := splitCollectionsPaths(opts.collectionsPaths); len(paths) > 0 {
+				opts.CollectionsPath = paths[0]
+				opts.ExtraCollectionsPaths = paths[1:]
 			}
-		}
-
-		if len(packages) == 0 {
-			utils.LogError("No package definition found", nil, "os", osID, "version", version)
-			fmt.Printf("No package definition found for %s %s\n", osID, version)
-			os.Exit(1)
-		}
-
-		// Install system packages
-		utils.LogInfo("Installing system packages", "packages", packages)
-		fmt.Println("Installing system packages...")
-		if err := utils.InstallPackages(packages); err != nil {
-			utils.LogError("Error installing packages", err, "packages", packages)
-			fmt.Printf("Error installing packages: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Create bluebanquise user
-		utils.LogInfo("Creating BlueBanquise user", "user", userName, "home", userHome)
-		if err := bootstrap.CreateBluebanquiseUser(userName, userHome); err != nil {
-			utils.LogError("Error creating user", err, "user", userName, "home", userHome)
-			fmt.Printf("Error creating user: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Configure environment (unless skipped)
-		if !offlineSkipEnvironment {
-			utils.LogInfo("Configuring environment")
-			if err := bootstrap.ConfigureEnvironmentOffline(userName, userHome, requirementsPath); err != nil {
-				utils.LogError("Error configuring environment", err)
-				fmt.Printf("Error configuring environment: %v\n", err)
-				os.Exit(1)
+			if opts.checkCollections {
+				checks, err := installer.New().CheckCollections(cmd.Context(), opts.OfflineOptions)
+				if err == nil {
+					err = writeCollectionsChecks(os.Stdout, checks)
+				}
+				if err != nil {
+					printError(err)
+					exitWithError()
+				}
+				return
 			}
-		} else {
-			utils.LogInfo("Skipping environment configuration")
-		}
-
-		// Install collections (requires configured environment)
-		utils.LogInfo("Installing collections from path", "path", collectionsPath)
-		if err := bootstrap.InstallCollectionsFromPath(collectionsPath, userHome); err != nil {
-			utils.LogError("Error installing collections from path", err, "path", collectionsPath)
-			fmt.Printf("Error installing collections from path: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Install core vars offline if provided
-		if coreVarsPath != "" {
-			utils.LogInfo("Installing core variables offline")
-			if err := bootstrap.InstallCoreVariablesOffline(coreVarsPath, userHome); err != nil {
-				utils.LogError("Error installing core variables", err)
-				fmt.Printf("Error installing core variables: %v\n", err)
-				os.Exit(1)
+			if err := installer.New().Offline(cmd.Context(), opts.OfflineOptions); err != nil {
+				printError(err)
+				exitWithError()
 			}
-		} else {
-			utils.LogInfo("No core variables path provided, skipping core variables installation")
-		}
 
-		utils.LogInfo("Offline installation completed successfully")
-		utils.ShowCompletionMessage(userName, userHome)
-	},
+			utils.ShowCompletionMessage(opts.UserName, opts.UserHome, bootstrap.InstalledEnvWrapper(opts.UserHome))
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&opts.collectionsPaths, "collections-path", "c", nil, "Path to BlueBanquise collections (directory, .tar.gz bundle, quoted glob of archives, git+ URL or HTTP(S) index URL), repeat or separate with commas to install local ones together")
+	cmd.Flags().BoolVar(&opts.checkCollections, "check-collections", false, "Print a verdict for each file of --collections-path or --from-bundle and exit without installing")
+	cmd.Flags().StringVar(&opts.FromBundle, "from-bundle", "", "Directory or .tar.gz made by the download command, instead of --collections-path")
+	cmd.Flags().BoolVar(&opts.DownloadIfMissing, "download-if-missing", false, "Install collections online when --collections-path is missing or empty")
+	cmd.Flags().BoolVar(&opts.FollowSymlinks, "follow-symlinks", false, "Accept symlinks of --collections-path or --from-bundle pointing outside of it")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "Replace installed collections with the --collections-path archives whatever their version")
+	cmd.Flags().StringSliceVar(&opts.OnlyCollections, "only-collections", nil, "Install only these namespace.name collections of the --collections-path archives, matched by their MANIFEST.json")
+	cmd.Flags().BoolVar(&opts.VerifySignatures, "verify-signatures", false, "Verify the signatures of the installed collections with ansible-galaxy collection verify, fail when one does not verify")
+	cmd.Flags().StringVar(&opts.Keyring, "keyring", "", "GnuPG keyring of the trusted collection signing keys, for --verify-signatures")
+	cmd.Flags().StringVarP(&opts.RequirementsPath, "requirements-path", "r", "", "Path to Python requirements for offline installation")
+	cmd.Flags().StringVarP(&opts.CoreVarsPath, "core-vars-path", "v", "", "Path to core variables for offline installation")
+	cmd.Flags().StringVarP(&opts.UserName, "user", "u", "bluebanquise", "Username for BlueBanquise")
+	cmd.Flags().StringVarP(&opts.UserHome, "home", "H", "", "Home directory for BlueBanquise user (default /var/lib/<user>)")
+	cmd.Flags().BoolVarP(&opts.SkipEnvironment, "skip-environment", "e", false, "Skip environment configuration")
+	cmd.Flags().BoolVar(&opts.SkipCollections, "skip-collections", false, "Skip collections installation")
+	cmd.Flags().BoolVar(&opts.SkipCoreVars, "skip-core-vars", false, "Skip core variables installation")
+	cmd.Flags().BoolVar(&opts.VerifyChecksums, "verify-checksums", false, "Verify requirements and collection archives against their SHA256SUMS or checksums.txt manifest")
+	cmd.Flags().StringVar(&opts.InventoryURL, "inventory-url", "", "Git repository or .tar.gz URL of a pre-built inventory to import")
+	cmd.Flags().StringVar(&opts.AnsibleConfigTemplate, "ansible-config-template", "", "ansible.cfg template installed instead of the default, $HOME is replaced by the user home")
+	cmd.Flags().BoolVar(&opts.AllowRootUser, "allow-root-user", false, "Allow installing for the root user (not recommended)")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "Fail on pip dependency conflicts and pip check errors instead of warning")
+	cmd.Flags().BoolVar(&opts.Verbose, "verbose", false, "Print the output of ansible-galaxy when it succeeds")
+	cmd.Flags().BoolVarP(&opts.Debug, "debug", "d", false, "Enable debug mode")
+	cmd.Flags().BoolVar(&opts.noSudoers, "no-sudoers", false, "Do not modify sudoers (same as --sudoers-mode none)")
+	cmd.Flags().StringVar(&opts.Shell, "shell", bootstrap.DefaultShell, "Login shell of the created BlueBanquise user, e.g. /usr/sbin/nologin to only run it with sudo -u")
+	cmd.Flags().BoolVar(&opts.HardenHome, "harden-home", true, "Set the user home and its .ssh directory to 0700, use --harden-home=false to keep the home mode")
+	cmd.Flags().StringVar(&opts.SudoersMode, "sudoers-mode", bootstrap.SudoersModeNopasswd, "Sudoers mode for the BlueBanquise user (nopasswd, passwd, none)")
+	cmd.Flags().BoolVar(&opts.Packages.DownloadFirst, "download-first", false, "Download system packages in a first pass, then install them (dnf, yum, apt-get, zypper)")
+	cmd.Flags().IntVar(&opts.Packages.DownloadConcurrency, "download-concurrency", 0, "Parallel package downloads with --download-first, dnf only (default: dnf setting)")
+	cmd.Flags().StringSliceVar(&opts.Packages.ExtraPackages, "extra-packages", nil, "Extra system packages installed with the OS packages, e.g. sshpass,rsync,nfs-utils")
+	opts.mirror.addFlags(cmd)
+	cmd.MarkFlagsMutuallyExclusive("collections-path", "from-bundle")
+
+	return cmd
 }
 
 func init() {
-	offlineCmd.Flags().StringVarP(&collectionsPath, "collections-path", "c", "", "Path to BlueBanquise collections")
-	offlineCmd.Flags().StringVarP(&requirementsPath, "requirements-path", "r", "", "Path to Python requirements for offline installation")
-	offlineCmd.Flags().StringVarP(&coreVarsPath, "core-vars-path", "v", "", "Path to core variables for offline installation")
-	offlineCmd.Flags().StringVarP(&userName, "user", "u", "bluebanquise", "Username for BlueBanquise")
-	offlineCmd.Flags().StringVarP(&userHome, "home", "H", "/var/lib/bluebanquise", "Home directory for BlueBanquise user")
-	offlineCmd.Flags().BoolVarP(&offlineSkipEnvironment, "skip-environment", "e", false, "Skip environment configuration")
-	offlineCmd.Flags().BoolVarP(&offlineDebug, "debug", "d", false, "Enable debug mode")
-
-	rootCmd.AddCommand(offlineCmd)
+	rootCmd.AddCommand(newOfflineCmd())
 }
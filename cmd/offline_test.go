@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCollectionsPaths(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{name: "None", values: nil, want: nil},
+		{name: "One path", values: []string{"/srv/collections"}, want: []string{"/srv/collections"}},
+		{name: "Repeated flag", values: []string{"/srv/a", "/srv/b.tar.gz"}, want: []string{"/srv/a", "/srv/b.tar.gz"}},
+		{name: "Comma list", values: []string{"/srv/a, /srv/b,"}, want: []string{"/srv/a", "/srv/b"}},
+		{
+			name:   "Git URL kept whole",
+			values: []string{"git+https://git.example.com/bluebanquise.git#/collections/infrastructure,master"},
+			want:   []string{"git+https://git.example.com/bluebanquise.git#/collections/infrastructure,master"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitCollectionsPaths(tt.values))
+		})
+	}
+}
+
+func TestWriteCollectionsChecks(t *testing.T) {
+	utils.InitTestLogger()
+
+	valid := utils.CollectionFileReport{File: "bluebanquise-infrastructure-3.0.0.tar.gz", Verdict: utils.FileValidCollection, Collection: "bluebanquise.infrastructure", Version: "3.0.0"}
+	notes := utils.CollectionFileReport{File: "README.txt", Verdict: utils.FileNotArchive, Detail: "no .tar.gz/.tgz extension, not installed"}
+	truncated := utils.CollectionFileReport{File: "truncated.tar.gz", Verdict: utils.FileUnreadable, Detail: "truncated or corrupt: unexpected EOF"}
+
+	var out bytes.Buffer
+	err := writeCollectionsChecks(&out, []installer.CollectionsCheck{{Path: "/srv/collections", Files: []utils.CollectionFileReport{valid, notes}}})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Files of /srv/collections:\n")
+	assert.Contains(t, out.String(), "bluebanquise-infrastructure-3.0.0.tar.gz  valid collection  bluebanquise.infrastructure 3.0.0")
+	assert.Contains(t, out.String(), "README.txt                                not an archive")
+	assert.Contains(t, out.String(), "no .tar.gz/.tgz extension, not installed\n")
+	assert.Contains(t, out.String(), "2 file(s), 1 valid collection(s)\n")
+
+	out.Reset()
+	err = writeCollectionsChecks(&out, []installer.CollectionsCheck{
+		{Path: "/srv/a", Files: []utils.CollectionFileReport{valid}},
+		{Path: "/srv/b", Files: []utils.CollectionFileReport{valid, truncated}},
+		{Path: "/srv/c", Files: []utils.CollectionFileReport{notes}},
+	})
+	assert.ErrorContains(t, err, "collections check failed for /srv/b, /srv/c")
+	assert.Contains(t, out.String(), "truncated.tar.gz                          unreadable")
+	assert.Contains(t, out.String(), "\nFiles of /srv/c:\n")
+}
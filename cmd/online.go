@@ -1,26 +1,26 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
-	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
 )
 
-var (
-	onlineUserName        string
-	onlineUserHome        string
-	onlineSkipEnvironment bool
-	onlineDebug           bool
-)
+// onlineOptions holds the flags of the online command.
+type onlineOptions struct {
+	installer.OnlineOptions
+	noSudoers bool
+	mirror    mirrorOptions
+}
 
-var onlineCmd = &cobra.Command{
-	Use:   "online",
-	Short: "Install BlueBanquise in online mode",
-	Long: `Install BlueBanquise in online mode downloading collections from GitHub.
+// newOnlineCmd returns the online command bound to its own options.
+func newOnlineCmd() *cobra.Command {
+	opts := &onlineOptions{}
+	cmd := &cobra.Command{
+		Use:   "online",
+		Short: "Install BlueBanquise in online mode",
+		Long: `Install BlueBanquise in online mode downloading collections from GitHub.
 	
 	This command will:
 	1. Check system prerequisites
@@ -28,117 +28,74 @@ var onlineCmd = &cobra.Command{
 	3. Install required system packages
 	4. Create bluebanquise user
 	5. Configure Python virtual environment
-	6. Install BlueBanquise collections from GitHub`,
-	Run: func(cmd *cobra.Command, args []string) {
-		utils.LogInfo("Starting BlueBanquise online installation",
-			"user", onlineUserName,
-			"home", onlineUserHome,
-			"skip_environment", onlineSkipEnvironment,
-			"debug", onlineDebug)
-
-		// Check system prerequisites
-		utils.LogInfo("Checking system prerequisites")
-		fmt.Println("Checking system prerequisites...")
-		if err := utils.SystemCheck(); err != nil {
-			utils.LogError("System check failed", err)
-			fmt.Printf("System check failed: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Detect OS
-		utils.LogInfo("Detecting operating system")
-		osID, version, err := system.DetectOS()
-		if err != nil {
-			utils.LogError("Error detecting OS", err)
-			fmt.Printf("Error detecting OS: %v\n", err)
-			os.Exit(1)
-		}
-		utils.LogInfo("OS detected", "os", osID, "version", version)
-		fmt.Printf("Detected OS: %s %s\n", osID, version)
-
-		// Find packages for this OS
-		var packages []string
-		var postHook func() error
-		for _, pkg := range system.DependenciePackages {
-			if pkg.OSID == osID && pkg.Version == version {
-				packages = pkg.Packages
-				postHook = pkg.PostHook
-				break
+	6. Install BlueBanquise collections from GitHub
+	7. Install BlueBanquise core variables from GitHub
+
+	Use --collections-path or --requirements-path to take collections or
+	Python packages from local paths while the rest comes from the network.
+	Use --skip-environment, --skip-collections and --skip-core-vars to run
+	only some of the installation phases.`,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return deriveUserHome(cmd.Flags())
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			sudoersMode, err := resolveSudoersMode(cmd.Flags(), opts.SudoersMode, opts.noSudoers)
+			if err != nil {
+				utils.LogError("Invalid sudoers configuration", err)
+				printError(err)
+				exitWithError()
 			}
-		}
 
-		if len(packages) == 0 {
-			utils.LogError("No package definition found", nil, "os", osID, "version", version)
-			fmt.Printf("No package definition found for %s %s\n", osID, version)
-			os.Exit(1)
-		}
-
-		// Install system packages
-		utils.LogInfo("Installing system packages", "packages", packages)
-		fmt.Println("Installing system packages...")
-		if err := utils.InstallPackages(packages); err != nil {
-			utils.LogError("Error installing packages", err, "packages", packages)
-			fmt.Printf("Error installing packages: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Run post-installation hook if exists
-		if postHook != nil {
-			utils.LogInfo("Running post-installation hook")
-			fmt.Println("Running post-installation hook...")
-			if err := postHook(); err != nil {
-				utils.LogError("Error in post-installation hook", err)
-				fmt.Printf("Error in post-installation hook: %v\n", err)
-				os.Exit(1)
+			downloadOptions, err := opts.mirror.downloadOptions()
+			if err != nil {
+				utils.LogError("Invalid mirror configuration", err)
+				printError(err)
+				exitWithError()
 			}
-		}
-
-		// Create bluebanquise user
-		utils.LogInfo("Creating BlueBanquise user", "user", onlineUserName, "home", onlineUserHome)
-		if err := bootstrap.CreateBluebanquiseUser(onlineUserName, onlineUserHome); err != nil {
-			utils.LogError("Error creating user", err, "user", onlineUserName, "home", onlineUserHome)
-			fmt.Printf("Error creating user: %v\n", err)
-			os.Exit(1)
-		}
 
-		// Configure environment (unless skipped)
-		if !onlineSkipEnvironment {
-			utils.LogInfo("Configuring environment")
-			if err := bootstrap.ConfigureEnvironment(onlineUserName, onlineUserHome, ""); err != nil {
-				utils.LogError("Error configuring environment", err)
-				fmt.Printf("Error configuring environment: %v\n", err)
-				os.Exit(1)
+			opts.SudoersMode = sudoersMode
+			opts.Mirror = downloadOptions
+			if err := installer.New().Online(cmd.Context(), opts.OnlineOptions); err != nil {
+				printError(err)
+				exitWithError()
 			}
-		} else {
-			utils.LogInfo("Skipping environment configuration")
-		}
 
-		// Install collections online
-		utils.LogInfo("Installing collections online")
-		if err := bootstrap.InstallCollectionsOnline(onlineUserHome); err != nil {
-			utils.LogError("Error installing collections", err)
-			fmt.Printf("Error installing collections: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Install core variables online
-		utils.LogInfo("Installing core variables online")
-		if err := bootstrap.InstallCoreVariablesOnline(onlineUserHome); err != nil {
-			utils.LogError("Error installing core variables", err)
-			fmt.Printf("Error installing core variables: %v\n", err)
-			os.Exit(1)
-		}
-
-		utils.LogInfo("Online installation completed successfully")
-		utils.ShowCompletionMessage(onlineUserName, onlineUserHome)
-	},
+			utils.ShowCompletionMessage(opts.UserName, opts.UserHome, bootstrap.InstalledEnvWrapper(opts.UserHome))
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.UserName, "user", "u", "bluebanquise", "Username for BlueBanquise")
+	cmd.Flags().StringVarP(&opts.UserHome, "home", "H", "", "Home directory for BlueBanquise user (default /var/lib/<user>)")
+	cmd.Flags().BoolVarP(&opts.SkipEnvironment, "skip-environment", "e", false, "Skip environment configuration")
+	cmd.Flags().BoolVar(&opts.SkipCollections, "skip-collections", false, "Skip collections installation")
+	cmd.Flags().BoolVar(&opts.SkipCoreVars, "skip-core-vars", false, "Skip core variables installation")
+	cmd.Flags().StringVarP(&opts.CollectionsPath, "collections-path", "c", "", "Install collections from a local directory, .tar.gz bundle, quoted glob of archives, git+ URL or HTTP(S) index URL instead of GitHub")
+	cmd.Flags().BoolVar(&opts.FollowSymlinks, "follow-symlinks", false, "Accept symlinks of --collections-path pointing outside of it")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "Replace installed collections with the --collections-path archives whatever their version")
+	cmd.Flags().StringSliceVar(&opts.OnlyCollections, "only-collections", nil, "Install only these namespace.name collections of the --collections-path archives, matched by their MANIFEST.json")
+	cmd.Flags().BoolVar(&opts.VerifySignatures, "verify-signatures", false, "Verify the signatures of the installed collections with ansible-galaxy collection verify, fail when one does not verify")
+	cmd.Flags().StringVar(&opts.Keyring, "keyring", "", "GnuPG keyring of the trusted collection signing keys, for --verify-signatures")
+	cmd.Flags().StringVarP(&opts.RequirementsPath, "requirements-path", "r", "", "Install Python packages from a local directory instead of the network")
+	cmd.Flags().StringSliceVar(&opts.CoreVarsURLs, "core-vars-url", nil, "URL of a core variables .yml file, repeat for several files (default: bb_core.yml from GitHub)")
+	cmd.Flags().StringVar(&opts.AnsibleVersion, "ansible-version", "", "Ansible release to install, e.g. 9.2.0 (default: latest)")
+	cmd.Flags().StringVar(&opts.AnsibleConfigTemplate, "ansible-config-template", "", "ansible.cfg template installed instead of the default, $HOME is replaced by the user home")
+	cmd.Flags().StringVar(&opts.InventoryURL, "inventory-url", "", "Git repository or .tar.gz URL of a pre-built inventory to import")
+	cmd.Flags().BoolVar(&opts.AllowRootUser, "allow-root-user", false, "Allow installing for the root user (not recommended)")
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "Fail on pip dependency conflicts and pip check errors instead of warning")
+	cmd.Flags().BoolVar(&opts.Verbose, "verbose", false, "Print the output of ansible-galaxy when it succeeds")
+	cmd.Flags().BoolVarP(&opts.Debug, "debug", "d", false, "Enable debug mode")
+	cmd.Flags().BoolVar(&opts.noSudoers, "no-sudoers", false, "Do not modify sudoers (same as --sudoers-mode none)")
+	cmd.Flags().StringVar(&opts.Shell, "shell", bootstrap.DefaultShell, "Login shell of the created BlueBanquise user, e.g. /usr/sbin/nologin to only run it with sudo -u")
+	cmd.Flags().BoolVar(&opts.HardenHome, "harden-home", true, "Set the user home and its .ssh directory to 0700, use --harden-home=false to keep the home mode")
+	cmd.Flags().StringVar(&opts.SudoersMode, "sudoers-mode", bootstrap.SudoersModeNopasswd, "Sudoers mode for the BlueBanquise user (nopasswd, passwd, none)")
+	cmd.Flags().BoolVar(&opts.Packages.DownloadFirst, "download-first", false, "Download system packages in a first pass, then install them (dnf, yum, apt-get, zypper)")
+	cmd.Flags().IntVar(&opts.Packages.DownloadConcurrency, "download-concurrency", 0, "Parallel package downloads with --download-first, dnf only (default: dnf setting)")
+	cmd.Flags().StringSliceVar(&opts.Packages.ExtraPackages, "extra-packages", nil, "Extra system packages installed with the OS packages, e.g. sshpass,rsync,nfs-utils")
+	opts.mirror.addFlags(cmd)
+
+	return cmd
 }
 
 func init() {
-	onlineCmd.Flags().StringVarP(&onlineUserName, "user", "u", "bluebanquise", "Username for BlueBanquise")
-	onlineCmd.Flags().StringVarP(&onlineUserHome, "home", "H", "/var/lib/bluebanquise", "Home directory for BlueBanquise user")
-	onlineCmd.Flags().BoolVarP(&onlineSkipEnvironment, "skip-environment", "e", false, "Skip environment configuration")
-	onlineCmd.Flags().BoolVarP(&onlineDebug, "debug", "d", false, "Enable debug mode")
-
-	rootCmd.AddCommand(onlineCmd)
+	rootCmd.AddCommand(newOnlineCmd())
 }
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// preseedCommands are the commands a preseed file can be written for.
+var preseedCommands = []string{"online", "offline", "download"}
+
+// newPreseedCmd returns the preseed command bound to its own options.
+func newPreseedCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "preseed [online|offline|download]",
+		Short: "Write a config file with every option of an installation command",
+		Long: `Write a config file, in the format read by --config, holding every option of
+the online (default), offline or download command, each described by a
+comment and commented out at its default value. Uncomment and edit the options
+capturing the decisions of an unattended installation, then feed it back with
+--config: the options left commented out keep their defaults. Explicit flags
+and BB_* environment variables still take precedence over the file.
+
+Examples:
+  # Write the options of an online installation
+  ./bluebanquise-installer preseed --output /srv/image/bluebanquise.yaml
+
+  # Run the installation it describes once edited
+  sudo ./bluebanquise-installer online --config /srv/image/bluebanquise.yaml
+
+  # Write the options of an offline installation to stdout
+  ./bluebanquise-installer preseed offline`,
+		Args:      cobra.MatchAll(cobra.MaximumNArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: preseedCommands,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Keep stdout for the config file only
+			utils.SetConsoleOutput(os.Stderr)
+			return setupCommand(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			name := "online"
+			if len(args) == 1 {
+				name = args[0]
+			}
+			target, _, err := rootCmd.Find([]string{name})
+			if err != nil {
+				utils.LogError("Unknown preseed command", err, "command", name)
+				printError(err)
+				exitWithError()
+			}
+
+			config := preseedConfig(target)
+			if output == "" {
+				fmt.Print(config)
+				return
+			}
+			// The file may be edited to hold a mirror password
+			if err := os.WriteFile(output, []byte(config), 0600); err != nil {
+				utils.LogError("Error writing preseed file", err, "path", output)
+				printError(fmt.Errorf("error writing preseed file: %v", err))
+				exitWithError()
+			}
+			utils.LogInfo("Preseed file written", "command", name, "path", output)
+			fmt.Fprintf(os.Stderr, "Options of %s written to %s, run it with --config %s\n", name, output, output)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write the config to (default: stdout)")
+	return cmd
+}
+
+// preseedConfig returns the config file of the options of cmd, its own ones
+// first and the global ones afterwards. Every option is written commented out
+// at its default value: fed back unedited, the file sets nothing, so options
+// such as retry-delay keep meaning "not given" and user-agent follows the
+// release of the installer.
+func preseedConfig(cmd *cobra.Command) string {
+	var config strings.Builder
+	fmt.Fprintf(&config, "# Options of bluebanquise-installer %s, written by the preseed command.\n", cmd.Name())
+	config.WriteString("# Every option is commented out at its default value, uncomment and edit the ones to set,\n")
+	fmt.Fprintf(&config, "# then run: bluebanquise-installer %s --config <this file>\n", cmd.Name())
+	config.WriteString("# Explicit flags and BB_* environment variables take precedence over this file.\n")
+
+	writeFlags := func(title string, flags *pflag.FlagSet) {
+		fmt.Fprintf(&config, "\n# %s\n", title)
+		flags.VisitAll(func(f *pflag.Flag) {
+			if f.Hidden || f.Name == "config" || f.Name == "help" {
+				return
+			}
+			fmt.Fprintf(&config, "\n# %s\n", strings.ReplaceAll(f.Usage, "\n", "\n# "))
+			fmt.Fprintf(&config, "# %s: %s\n", f.Name, preseedValue(f))
+		})
+	}
+	writeFlags(cmd.Name()+" options", cmd.LocalFlags())
+	writeFlags("Global options", cmd.InheritedFlags())
+	return config.String()
+}
+
+// preseedValue returns the default value of f as YAML.
+func preseedValue(f *pflag.Flag) string {
+	if _, ok := f.Value.(pflag.SliceValue); ok {
+		data, _ := json.Marshal(defaultSlice(f.DefValue))
+		return string(data)
+	}
+	if f.Value.Type() == "string" {
+		data, _ := json.Marshal(f.DefValue)
+		return string(data)
+	}
+	return f.DefValue
+}
+
+// defaultSlice returns the items of the default value of a list flag, written
+// by pflag as [a,b].
+func defaultSlice(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	if value == "" {
+		return []string{}
+	}
+	items, err := csv.NewReader(strings.NewReader(value)).Read()
+	if err != nil {
+		return strings.Split(value, ",")
+	}
+	return items
+}
+
+func init() {
+	rootCmd.AddCommand(newPreseedCmd())
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreseedConfigRoundTrip(t *testing.T) {
+	for _, name := range preseedCommands {
+		t.Run(name, func(t *testing.T) {
+			target, _, err := rootCmd.Find([]string{name})
+			require.NoError(t, err)
+
+			config := preseedConfig(target)
+			path := filepath.Join(t.TempDir(), "preseed.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+			values, err := loadConfigFile(path)
+			require.NoError(t, err)
+
+			// Every option is in the file commented out, loading it back
+			// unedited sets none of them
+			assert.Empty(t, values)
+			flags := pflag.NewFlagSet(name, pflag.ContinueOnError)
+			flags.AddFlagSet(target.LocalFlags())
+			flags.AddFlagSet(target.InheritedFlags())
+			copied := pflag.NewFlagSet(name, pflag.ContinueOnError)
+			flags.VisitAll(func(f *pflag.Flag) {
+				if f.Name == "config" || f.Name == "help" {
+					return
+				}
+				assert.Contains(t, config, "\n# "+f.Name+": "+preseedValue(f)+"\n", "option %s", f.Name)
+				copied.AddFlag(&pflag.Flag{Name: f.Name, Value: newDefaultValue(t, f), DefValue: f.DefValue})
+			})
+			require.NoError(t, applyFlagDefaults(copied, mapSource(values)))
+			copied.VisitAll(func(f *pflag.Flag) {
+				assert.False(t, f.Changed, "option %s", f.Name)
+				assert.Equal(t, f.DefValue, f.Value.String(), "option %s", f.Name)
+			})
+		})
+	}
+
+	t.Run("Edited value", func(t *testing.T) {
+		target, _, err := rootCmd.Find([]string{"offline"})
+		require.NoError(t, err)
+		config := strings.Replace(preseedConfig(target), "\n# user: \"bluebanquise\"\n", "\nuser: \"admin\"\n", 1)
+		config = strings.Replace(config, "# only-collections: []", "only-collections: [bluebanquise.infrastructure, community.general]", 1)
+		config = strings.Replace(config, "# retries: 2", "retries: 5", 1)
+
+		path := filepath.Join(t.TempDir(), "preseed.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0600))
+		values, err := loadConfigFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"user":             "admin",
+			"only-collections": "bluebanquise.infrastructure,community.general",
+			"retries":          "5",
+		}, values)
+	})
+}
+
+// newDefaultValue returns a fresh value of the type of f set to its default,
+// so the flags of the registered commands are left unchanged.
+func newDefaultValue(t *testing.T, f *pflag.Flag) pflag.Value {
+	flags := pflag.NewFlagSet("value", pflag.ContinueOnError)
+	switch f.Value.Type() {
+	case "bool":
+		flags.Bool("value", false, "")
+	case "int":
+		flags.Int("value", 0, "")
+	case "duration":
+		flags.Duration("value", 0, "")
+	case "stringSlice":
+		flags.StringSlice("value", nil, "")
+	case "stringArray":
+		flags.StringArray("value", nil, "")
+	default:
+		flags.String("value", "", "")
+	}
+	value := flags.Lookup("value").Value
+	if _, ok := value.(pflag.SliceValue); ok {
+		require.NoError(t, value.(pflag.SliceValue).Replace(defaultSlice(f.DefValue)))
+	} else {
+		require.NoError(t, value.Set(f.DefValue))
+	}
+	return value
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// newRepairCmd returns the repair command bound to its own options.
+func newRepairCmd() *cobra.Command {
+	opts := &installer.RepairOptions{}
+	cmd := &cobra.Command{
+		Use:   "repair",
+		Short: "Repair an existing BlueBanquise installation",
+		Long: `Repair parts of an existing BlueBanquise installation without running the
+whole installation again. Collections, core variables and configuration files
+are left untouched.
+
+With --rebuild-venv, the python of the virtual environment is run and, when it
+fails (common once a distribution upgrade removed the base interpreter), the
+virtual environment is removed, created again with the Python of the current
+OS and its Python packages are reinstalled, from the network or from
+--requirements-path. A virtual environment that runs is left as is.
+
+With --reinstall-broken, pip check lists the packages whose requirements are
+missing or at an incompatible version, as left by an interrupted pip install,
+and those packages are reinstalled at their installed version with their
+requirements, from the network or from --requirements-path.
+
+Examples:
+  # Rebuild the virtual environment of the default user after an OS upgrade
+  sudo ./bluebanquise-installer repair --rebuild-venv
+
+  # Reinstall the packages an interrupted installation left broken
+  sudo ./bluebanquise-installer repair --reinstall-broken
+
+  # Rebuild it from downloaded requirements
+  sudo ./bluebanquise-installer repair --rebuild-venv --requirements-path /tmp/offline/requirements`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := installer.New().Repair(cmd.Context(), *opts); err != nil {
+				utils.LogError("Repair failed", err)
+				printError(err)
+				exitWithError()
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.UserName, "user", "u", "bluebanquise", "Username of the installation to repair")
+	cmd.Flags().StringVarP(&opts.UserHome, "home", "H", "", "Home directory of the user (default: from the user database)")
+	cmd.Flags().BoolVar(&opts.RebuildVenv, "rebuild-venv", false, "Rebuild the virtual environment when its python no longer runs")
+	cmd.Flags().BoolVar(&opts.ReinstallBroken, "reinstall-broken", false, "Reinstall the Python packages pip check reports with missing or incompatible requirements")
+	cmd.Flags().StringVarP(&opts.RequirementsPath, "requirements-path", "r", "", "Reinstall Python packages from a local directory instead of the network")
+	cmd.Flags().StringVar(&opts.AnsibleVersion, "ansible-version", "", "Ansible release to reinstall, e.g. 9.2.0 (default: latest)")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newRepairCmd())
+}
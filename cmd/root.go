@@ -1,12 +1,53 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
+// logToStdout copies log lines to the console in addition to the log file.
+var logToStdout bool
+
+// pythonVersions are the Python versions preferred over the OS defaults.
+var pythonVersions []string
+
+// colorMode and noColor are the --color and --no-color options.
+var (
+	colorMode string
+	noColor   bool
+)
+
+// stateDir is the --state-dir option.
+var stateDir string
+
+// logDirMode and logFileMode are the --log-dir-mode and --log-file-mode options.
+var (
+	logDirMode  string
+	logFileMode string
+)
+
+// retries and retryDelay are the --retries and --retry-delay options, only
+// applied when set so each operation keeps its own default otherwise.
+var (
+	retries    int
+	retryDelay time.Duration
+)
+
+// userAgent is the --user-agent option.
+var userAgent string
+
+// unsupportedOS holds the flags allowing an installation on an OS without
+// package definition.
+var unsupportedOS unsupportedOSOptions
+
 var rootCmd = &cobra.Command{
 	Use:   "bluebanquise-installer",
 	Short: "BlueBanquise Installer CLI",
@@ -21,22 +62,276 @@ Available commands:
   offline   - Install BlueBanquise in offline mode (use --collections-path)
   download  - Download collections for offline installation
   status    - Check BlueBanquise installation status
+  repair    - Repair an existing installation, e.g. rebuild a broken virtual environment
+  env       - Print shell commands to activate the BlueBanquise environment
+  selftest  - Run Ansible against a host to check the installation works
+  wizard    - Interactively choose and run an installation
+  preseed   - Write a config file with every option of an installation, for --config
 
 All commands support custom user configuration with --user and --home flags.
 
+Default flag values can be set in a YAML config file (see --config).
+
 For more information, visit: https://bluebanquise.com`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return setupCommand(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		utils.LogInfo("Showing help information")
 		if err := cmd.Help(); err != nil {
 			utils.LogError("Error showing help", err)
-			os.Exit(1)
+			exitWithError()
 		}
 	},
 }
 
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&logToStdout, "log-to-stdout", true, "Copy log lines to the console, use --log-to-stdout=false to only show progress messages")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file with default flag values (default "+defaultConfigFile+" if present)")
+	rootCmd.PersistentFlags().StringSliceVar(&pythonVersions, "python-version", nil, "Python versions to prefer for the virtual environment, in order, e.g. 3.12,3.11")
+	rootCmd.PersistentFlags().StringVar(&stateDir, "state-dir", "", "Directory for the log file and temporary files, e.g. the single writable mount of a container (default /var/log/bluebanquise and the system temporary directory)")
+	rootCmd.PersistentFlags().StringVar(&logDirMode, "log-dir-mode", "", "Octal permissions of a log directory created by the installer (default LOG_DIR_MODE or 0750)")
+	rootCmd.PersistentFlags().StringVar(&logFileMode, "log-file-mode", "", "Octal permissions of the log file (default LOG_FILE_MODE or 0640)")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", 2, "Retries of downloads, pip, package and collection installs failing with a transient network error")
+	rootCmd.PersistentFlags().DurationVar(&retryDelay, "retry-delay", 0, "Wait before the first retry, doubled for each next one, e.g. 10s (default 2s for downloads, 5s for the others)")
+	rootCmd.PersistentFlags().StringVar(&userAgent, "user-agent", utils.DefaultUserAgent(), "User-Agent header of HTTP downloads, e.g. to identify the installer to a proxy or mirror")
+	rootCmd.PersistentFlags().StringVar(&colorMode, "color", utils.ColorAuto, "Color status and check results: auto (when stdout is a terminal and NO_COLOR is unset), always or never")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colors, same as --color never")
+	unsupportedOS.addFlags(rootCmd)
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	err := rootCmd.Execute()
+	utils.RemoveCABundle()
+	if err != nil {
 		utils.LogError("Root command execution failed", err)
-		os.Exit(1)
+		exitWithError()
+	}
+	utils.CloseLogger()
+}
+
+// setupCommand applies the global options of cmd: config file and BB_*
+// defaults, state directory, console and color output, retries, user agent,
+// unsupported OS and Python preference. Every PersistentPreRunE calls it, so
+// the commands overriding the one of the root command cannot drift from it.
+func setupCommand(cmd *cobra.Command) error {
+	if err := loadFlagDefaults(cmd); err != nil {
+		return err
+	}
+	if err := applyStateDir(); err != nil {
+		return err
+	}
+	utils.SetLogToConsole(logToStdout)
+	mode := colorMode
+	if noColor {
+		mode = utils.ColorNever
+	}
+	if err := utils.SetColorMode(mode, os.Stdout); err != nil {
+		return err
+	}
+	if err := applyRetryOptions(cmd.Flags()); err != nil {
+		return err
+	}
+	if err := utils.SetUserAgent(userAgent); err != nil {
+		return err
+	}
+	if err := unsupportedOS.apply(); err != nil {
+		return err
+	}
+	return system.SetPythonPreference(pythonVersions)
+}
+
+// initLogger opens the log file, tests replace it.
+var initLogger = utils.InitLogger
+
+// applyStateDir moves the log file and temporary files under --state-dir,
+// then opens the log file with the --log-dir-mode and --log-file-mode
+// permissions. It is only opened once the flags are parsed, so the default
+// /var/log/bluebanquise is not created when --state-dir is set.
+func applyStateDir() error {
+	if stateDir != "" {
+		if err := utils.SetStateDir(stateDir); err != nil {
+			return err
+		}
+	}
+	if err := utils.SetLogModes(logDirMode, logFileMode); err != nil {
+		return err
+	}
+	if _, err := initLogger(); err != nil {
+		return fmt.Errorf("failed to initialize logger: %v", err)
+	}
+	return nil
+}
+
+// applyRetryOptions sets the --retries and --retry-delay overrides of every
+// retried operation, when given on the command line or by a config source.
+func applyRetryOptions(flags *pflag.FlagSet) error {
+	if flags.Changed("retries") {
+		if err := utils.SetRetries(retries); err != nil {
+			return err
+		}
+	}
+	if flags.Changed("retry-delay") {
+		return utils.SetRetryDelay(retryDelay)
+	}
+	return nil
+}
+
+// printError prints err and, for a validation error, the remediation hint below it.
+func printError(err error) {
+	fmt.Print(formatError(err))
+}
+
+// formatError returns the lines printError prints for err.
+func formatError(err error) string {
+	text := fmt.Sprintf("%s %v\n", utils.ColorFail("Error:"), err)
+	if validationErr, ok := utils.AsValidationError(err); ok && validationErr.Remediation != "" {
+		text += fmt.Sprintf("%s %s\n", utils.ColorWarn("Hint:"), validationErr.Remediation)
+	}
+	return text
+}
+
+// exitWithError points the operator to the log file, closes it and exits with status 1.
+func exitWithError() {
+	if path := utils.LogFilePath(); path != "" {
+		fmt.Fprintf(os.Stderr, "See full log at %s\n", path)
+	}
+	utils.CloseLogger()
+	os.Exit(1)
+}
+
+// resolveSudoersMode combines --sudoers-mode and --no-sudoers into a single
+// validated mode. When they conflict and only one of them was given on the
+// command line, it wins over the other one, set by a BB_* variable or the
+// config file.
+func resolveSudoersMode(flags *pflag.FlagSet, mode string, noSudoers bool) (string, error) {
+	if noSudoers {
+		if mode == bootstrap.SudoersModeNopasswd || mode == bootstrap.SudoersModeNone {
+			return bootstrap.SudoersModeNone, nil
+		}
+		modeGiven, noSudoersGiven := setOnCommandLine(flags, "sudoers-mode"), setOnCommandLine(flags, "no-sudoers")
+		switch {
+		case noSudoersGiven && !modeGiven:
+			return bootstrap.SudoersModeNone, nil
+		case modeGiven && !noSudoersGiven:
+			// Validated below
+		default:
+			return "", fmt.Errorf("--no-sudoers conflicts with --sudoers-mode %s", mode)
+		}
+	}
+	if err := bootstrap.ValidateSudoersMode(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
+// deriveUserHome defaults --home to the home of <user> when the account exists,
+// /var/lib/<user> otherwise, when --home was not given on the command line. A
+// --home of a BB_* variable or the config file is kept unless --user is given
+// on the command line, the home then belongs to another user.
+func deriveUserHome(flags *pflag.FlagSet) error {
+	home := flags.Lookup("home")
+	if home == nil || setOnCommandLine(flags, "home") {
+		return nil
+	}
+	if home.Changed && !setOnCommandLine(flags, "user") {
+		return nil
+	}
+
+	userName, err := flags.GetString("user")
+	if err != nil {
+		return err
+	}
+	if userName == "" {
+		return nil
+	}
+
+	return home.Value.Set(installer.TargetUserHome(userName))
+}
+
+// mirrorOptions holds the credentials, headers and certificate options of HTTP
+// downloads.
+type mirrorOptions struct {
+	user               string
+	password           string
+	headers            []string
+	caCert             string
+	insecureSkipVerify bool
+}
+
+// addFlags registers the flags setting credentials and headers for HTTP downloads.
+func (m *mirrorOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&m.user, "mirror-user", "", "User for HTTP basic auth on downloads")
+	cmd.Flags().StringVar(&m.password, "mirror-password", "", "Password for HTTP basic auth on downloads (prefer BB_MIRROR_PASSWORD)")
+	cmd.Flags().StringArrayVar(&m.headers, "mirror-header", nil, "Extra \"Name: value\" header sent with downloads, repeat for several headers")
+	m.addTLSFlags(cmd)
+}
+
+// addTLSFlags registers the certificate verification flags only, for commands
+// whose downloads are never sent the mirror credentials.
+func (m *mirrorOptions) addTLSFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&m.caCert, "ca-cert", "", "PEM file of CA certificates trusted for downloads and pip, e.g. of an internal mirror")
+	cmd.Flags().BoolVar(&m.insecureSkipVerify, "insecure-skip-verify", false, "Disable TLS certificate verification of downloads and pip (insecure)")
+}
+
+// downloadOptions builds the download options from the mirror flags and
+// configures the certificate verification of downloads and pip.
+func (m mirrorOptions) downloadOptions() (utils.DownloadOptions, error) {
+	if m.password != "" && m.user == "" {
+		return utils.DownloadOptions{}, fmt.Errorf("--mirror-password requires --mirror-user")
+	}
+	if err := m.configureTLS(); err != nil {
+		return utils.DownloadOptions{}, err
+	}
+
+	headers, err := utils.ParseHeaders(m.headers)
+	if err != nil {
+		return utils.DownloadOptions{}, err
+	}
+
+	return utils.DownloadOptions{
+		Username: m.user,
+		Password: m.password,
+		Headers:  headers,
+	}, nil
+}
+
+// configureTLS configures the certificate verification of downloads and pip
+// from the --ca-cert and --insecure-skip-verify flags.
+func (m mirrorOptions) configureTLS() error {
+	if m.caCert != "" && m.insecureSkipVerify {
+		return fmt.Errorf("--ca-cert conflicts with --insecure-skip-verify")
 	}
+	return utils.ConfigureTLS(utils.TLSOptions{CACert: m.caCert, InsecureSkipVerify: m.insecureSkipVerify})
+}
+
+// unsupportedOSOptions replaces the package definition of an unlisted OS.
+type unsupportedOSOptions struct {
+	allow    bool
+	python   string
+	packages []string
+}
+
+// addFlags registers the flags allowing an installation on an unlisted OS.
+func (u *unsupportedOSOptions) addFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&u.allow, "allow-unsupported-os", false, "Proceed on an OS without package definition, at your own risk (requires --python and --packages)")
+	cmd.PersistentFlags().StringVar(&u.python, "python", "", "Python interpreter used on an unsupported OS, e.g. /usr/bin/python3.11")
+	cmd.PersistentFlags().StringSliceVar(&u.packages, "packages", nil, "System packages installed on an unsupported OS, e.g. python3.11,git,curl,openssh-clients")
+}
+
+// apply checks the flags go together and enables the override of the
+// package definition when --allow-unsupported-os is set.
+func (u unsupportedOSOptions) apply() error {
+	if !u.allow {
+		if u.python != "" || len(u.packages) > 0 {
+			return fmt.Errorf("--python and --packages require --allow-unsupported-os")
+		}
+		return nil
+	}
+	if u.python == "" || len(u.packages) == 0 {
+		return fmt.Errorf("--allow-unsupported-os requires --python and --packages")
+	}
+
+	utils.LogWarning("Unsupported operating systems are allowed, the installation may fail or be incomplete", "python", u.python, "packages", u.packages)
+	return system.AllowUnsupportedOS(u.python, u.packages)
 }
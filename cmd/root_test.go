@@ -2,10 +2,17 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"os"
 	"testing"
 
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRootCommand(t *testing.T) {
@@ -76,3 +83,226 @@ For more information, visit: https://bluebanquise.com`,
 	}
 	return rootCmd
 }
+
+func TestResolveSudoersMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		config      map[string]string
+		expected    string
+		expectError bool
+	}{
+		{name: "Default mode", expected: bootstrap.SudoersModeNopasswd},
+		{name: "Password mode", args: []string{"--sudoers-mode", "passwd"}, expected: bootstrap.SudoersModePasswd},
+		{name: "No sudoers flag", args: []string{"--no-sudoers"}, expected: bootstrap.SudoersModeNone},
+		{name: "No sudoers with none mode", args: []string{"--no-sudoers", "--sudoers-mode", "none"}, expected: bootstrap.SudoersModeNone},
+		{name: "No sudoers conflicts with passwd", args: []string{"--no-sudoers", "--sudoers-mode", "passwd"}, expectError: true},
+		{name: "Invalid mode", args: []string{"--sudoers-mode", "invalid"}, expectError: true},
+		{name: "Config conflict", config: map[string]string{"no-sudoers": "true", "sudoers-mode": "passwd"}, expectError: true},
+		{name: "No sudoers flag over config mode", args: []string{"--no-sudoers"}, config: map[string]string{"sudoers-mode": "passwd"}, expected: bootstrap.SudoersModeNone},
+		{name: "Mode flag over config no sudoers", args: []string{"--sudoers-mode", "passwd"}, config: map[string]string{"no-sudoers": "true"}, expected: bootstrap.SudoersModePasswd},
+		{name: "Invalid mode flag over config no sudoers", args: []string{"--sudoers-mode", "invalid"}, config: map[string]string{"no-sudoers": "true"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mode string
+			var noSudoers bool
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.StringVar(&mode, "sudoers-mode", bootstrap.SudoersModeNopasswd, "")
+			flags.BoolVar(&noSudoers, "no-sudoers", false, "")
+			require.NoError(t, flags.Parse(tt.args))
+			require.NoError(t, applyFlagDefaults(flags, mapSource(tt.config)))
+
+			resolved, err := resolveSudoersMode(flags, mode, noSudoers)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resolved)
+		})
+	}
+}
+
+func TestDeriveUserHome(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		expectedHome string
+	}{
+		{name: "Defaults", args: nil, expectedHome: "/var/lib/bluebanquise"},
+		{name: "Custom user", args: []string{"--user", "myuser"}, expectedHome: "/var/lib/myuser"},
+		{name: "Custom home", args: []string{"--home", "/opt/bluebanquise"}, expectedHome: "/opt/bluebanquise"},
+		{name: "Custom user and home", args: []string{"--user", "myuser", "--home", "/opt/bluebanquise"}, expectedHome: "/opt/bluebanquise"},
+		{name: "Explicit default home for custom user", args: []string{"-u", "myuser", "-H", "/var/lib/bluebanquise"}, expectedHome: "/var/lib/bluebanquise"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userName, userHome string
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			flags.StringVarP(&userName, "user", "u", "bluebanquise", "")
+			flags.StringVarP(&userHome, "home", "H", "", "")
+			require.NoError(t, flags.Parse(tt.args))
+
+			require.NoError(t, deriveUserHome(flags))
+			assert.Equal(t, tt.expectedHome, userHome)
+		})
+	}
+
+	t.Run("Home from config source", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			args         []string
+			config       map[string]string
+			expectedHome string
+		}{
+			{name: "Kept", config: map[string]string{"home": "/srv/bluebanquise"}, expectedHome: "/srv/bluebanquise"},
+			{name: "Kept with the config user", config: map[string]string{"user": "myuser", "home": "/srv/bluebanquise"}, expectedHome: "/srv/bluebanquise"},
+			{name: "Derived for a user given on the command line", args: []string{"--user", "myuser"}, config: map[string]string{"home": "/srv/bluebanquise"}, expectedHome: "/var/lib/myuser"},
+			{name: "Command line home", args: []string{"--home", "/opt/bluebanquise"}, config: map[string]string{"home": "/srv/bluebanquise"}, expectedHome: "/opt/bluebanquise"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var userName, userHome string
+				flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+				flags.StringVarP(&userName, "user", "u", "bluebanquise", "")
+				flags.StringVarP(&userHome, "home", "H", "", "")
+				require.NoError(t, flags.Parse(tt.args))
+				require.NoError(t, applyFlagDefaults(flags, mapSource(tt.config)))
+
+				require.NoError(t, deriveUserHome(flags))
+				assert.Equal(t, tt.expectedHome, userHome)
+			})
+		}
+	})
+
+	t.Run("No home flag", func(t *testing.T) {
+		flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		assert.NoError(t, deriveUserHome(flags))
+	})
+}
+
+func TestMirrorDownloadOptions(t *testing.T) {
+	mirror := mirrorOptions{user: "mirror", password: "s3cret", headers: []string{"X-Artifact-Token: abc"}}
+	opts, err := mirror.downloadOptions()
+	require.NoError(t, err)
+	assert.Equal(t, "mirror", opts.Username)
+	assert.Equal(t, "s3cret", opts.Password)
+	assert.Equal(t, map[string]string{"X-Artifact-Token": "abc"}, opts.Headers)
+
+	_, err = mirrorOptions{password: "s3cret"}.downloadOptions()
+	assert.Error(t, err)
+
+	_, err = mirrorOptions{headers: []string{"invalid"}}.downloadOptions()
+	assert.Error(t, err)
+
+	_, err = mirrorOptions{caCert: "/etc/pki/mirror-ca.pem", insecureSkipVerify: true}.downloadOptions()
+	assert.Error(t, err)
+
+	_, err = mirrorOptions{caCert: "/nonexistent/mirror-ca.pem"}.downloadOptions()
+	assert.Error(t, err)
+}
+
+func TestUnsupportedOSOptions(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name        string
+		opts        unsupportedOSOptions
+		expectError bool
+	}{
+		{name: "Not allowed"},
+		{name: "Python without allow", opts: unsupportedOSOptions{python: "/usr/bin/python3"}, expectError: true},
+		{name: "Packages without allow", opts: unsupportedOSOptions{packages: []string{"git"}}, expectError: true},
+		{name: "Allow without python", opts: unsupportedOSOptions{allow: true, packages: []string{"git"}}, expectError: true},
+		{name: "Allow without packages", opts: unsupportedOSOptions{allow: true, python: "/usr/bin/python3"}, expectError: true},
+		{name: "Relative python", opts: unsupportedOSOptions{allow: true, python: "python3", packages: []string{"git"}}, expectError: true},
+		{name: "Allowed with overrides", opts: unsupportedOSOptions{allow: true, python: "/usr/bin/python3", packages: []string{"python3", "git"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.apply()
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCommandFlagsAreIndependent(t *testing.T) {
+	online, offline := newOnlineCmd(), newOfflineCmd()
+	require.NoError(t, online.ParseFlags([]string{"--user", "onlineuser", "--mirror-user", "mirror", "--skip-core-vars"}))
+	require.NoError(t, offline.ParseFlags([]string{"--home", "/opt/offline"}))
+
+	tests := []struct {
+		cmd      *cobra.Command
+		flag     string
+		expected string
+	}{
+		{online, "user", "onlineuser"},
+		{online, "home", ""},
+		{online, "mirror-user", "mirror"},
+		{online, "skip-core-vars", "true"},
+		{offline, "user", "bluebanquise"},
+		{offline, "home", "/opt/offline"},
+		{offline, "mirror-user", ""},
+		{offline, "skip-core-vars", "false"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.cmd.Flags().Lookup(tt.flag).Value.String(), "%s --%s", tt.cmd.Name(), tt.flag)
+	}
+
+	// A second instance of the same command starts from the defaults
+	again := newOnlineCmd()
+	assert.Equal(t, "bluebanquise", again.Flags().Lookup("user").Value.String())
+	assert.Equal(t, "", again.Flags().Lookup("mirror-user").Value.String())
+
+	download, status := newDownloadCmd(), newStatusCmd()
+	require.NoError(t, download.ParseFlags([]string{"--path", "/tmp/offline", "-v"}))
+	assert.Equal(t, "true", download.Flags().Lookup("core-vars").Value.String())
+	assert.Equal(t, "false", status.Flags().Lookup("verbose").Value.String())
+}
+
+func TestFormatError(t *testing.T) {
+	require.NoError(t, utils.SetColorMode(utils.ColorNever, os.Stdout))
+
+	assert.Equal(t, "Error: boom\n", formatError(errors.New("boom")))
+
+	err := fmt.Errorf("collections validation failed: %w", utils.NewValidationError(utils.CodeCollectionsPathMissing,
+		"run download --collections on a connected host", errors.New("collections path does not exist: /srv/collections")))
+	assert.Equal(t, "Error: collections validation failed: collections path does not exist: /srv/collections\n"+
+		"Hint: run download --collections on a connected host\n", formatError(err))
+}
+
+func TestCommandsShareRootSetup(t *testing.T) {
+	utils.InitTestLogger()
+	defer utils.SetConsoleOutput(os.Stdout)
+
+	originalInit := initLogger
+	defer func() { initLogger = originalInit }()
+	initLogger = func() (string, error) { return "", nil }
+
+	original := colorMode
+	defer func() {
+		colorMode = original
+		require.NoError(t, utils.SetColorMode(utils.ColorNever, os.Stdout))
+	}()
+
+	// env and preseed keep stdout for their output but apply the global
+	// options like every other command, an invalid --color fails them all
+	for _, cmd := range []*cobra.Command{newEnvCmd(), newPreseedCmd()} {
+		t.Run(cmd.Name(), func(t *testing.T) {
+			hook := cmd.PersistentPreRunE
+
+			colorMode = "sometimes"
+			assert.ErrorContains(t, hook(cmd, nil), "sometimes")
+			colorMode = utils.ColorNever
+			assert.NoError(t, hook(cmd, nil))
+		})
+	}
+}
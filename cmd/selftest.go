@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// selftestOptions holds the flags of the selftest command.
+type selftestOptions struct {
+	userName string
+	userHome string
+	host     string
+}
+
+// newSelftestCmd returns the selftest command bound to its own options.
+func newSelftestCmd() *cobra.Command {
+	opts := &selftestOptions{}
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Run Ansible against a host to check the installation works",
+		Long: `Run an Ansible ping against a host as the BlueBanquise user, using the
+installed virtual environment and ansible.cfg.
+
+This command must be run as root.
+
+Examples:
+  # Ping localhost as the default user
+  sudo ./bluebanquise-installer selftest
+
+  # Ping a node of the inventory as a custom user
+  sudo ./bluebanquise-installer selftest --user myuser --host node001`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if os.Geteuid() != 0 {
+				utils.LogError("Self-test requires root", nil, "euid", os.Geteuid())
+				fmt.Println("Error: selftest must be run as root")
+				exitWithError()
+			}
+
+			userHome := opts.userHome
+			if userHome == "" {
+				home, err := bootstrap.LookupUserHome(opts.userName)
+				if err != nil {
+					utils.LogError("Error resolving user home", err, "user", opts.userName)
+					printError(err)
+					exitWithError()
+				}
+				userHome = home
+			}
+
+			fmt.Printf("Pinging %s with Ansible as %s... ", opts.host, opts.userName)
+			if err := bootstrap.RunSelfTest(opts.userName, userHome, opts.host); err != nil {
+				fmt.Println("FAILED")
+				printError(err)
+				exitWithError()
+			}
+			fmt.Println("OK")
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.userName, "user", "u", "bluebanquise", "Username to run Ansible as")
+	cmd.Flags().StringVarP(&opts.userHome, "home", "H", "", "Home directory of the user (default: from the user database)")
+	cmd.Flags().StringVar(&opts.host, "host", bootstrap.DefaultSelfTestHost, "Host or group to ping")
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(newSelftestCmd())
+}
@@ -3,16 +3,17 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
-	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/spf13/cobra"
 )
 
-var (
-	statusUserName string
-	statusCmd      = &cobra.Command{
+// newStatusCmd returns the status command bound to its own options.
+func newStatusCmd() *cobra.Command {
+	opts := &installer.StatusOptions{}
+	var allUsers bool
+	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Check BlueBanquise installation status",
 		Long: `Check the status of BlueBanquise installation.
@@ -21,124 +22,69 @@ This command verifies:
 - User existence and home directory
 - Python virtual environment
 - Ansible installation
-- BlueBanquise collections
+- BlueBanquise collections, listing each installed collection and its version
 - Core variables
+- SELinux file contexts (with --deep, when SELinux is enforcing)
+
+By default status only reads files and never runs a command, so it can be used
+on nodes where running the virtual environment binaries is restricted. With
+--deep it also runs ansible --version, pip check, pip list and the SELinux checks.
+
+With --verbose, the versions of the key Python packages installed in the
+virtual environment are listed and compared to their minimum versions.
+With --output json, the checks are printed as a JSON document instead.
+
+With --user all (or --all), every user of /etc/passwd whose home has a virtual
+environment or collections is checked, and status fails if any is not ready.
 
 Examples:
   # Check status for default user (bluebanquise)
   ./bluebanquise-installer status
 
   # Check status for specific user
-  ./bluebanquise-installer status --user myuser`,
-		Run: func(cmd *cobra.Command, args []string) {
-			if err := checkStatus(); err != nil {
-				utils.LogError("Status check failed", err)
-				fmt.Printf("Status check failed: %v\n", err)
-				os.Exit(1)
-			}
-		},
-	}
-)
-
-func checkStatus() error {
-	utils.LogInfo("Checking BlueBanquise installation status", "user", statusUserName)
-
-	// Check user and home directory
-	userHome, err := getUserHome(statusUserName)
-	if err != nil {
-		return fmt.Errorf("%s user home directory not found", statusUserName)
-	}
-
-	fmt.Printf("✓ User %s home directory: %s\n", statusUserName, userHome)
-
-	// Check Python virtual environment
-	venvDir := filepath.Join(userHome, "ansible_venv")
-	if _, err := os.Stat(venvDir); os.IsNotExist(err) {
-		return fmt.Errorf("python virtual environment not found")
-	}
-
-	fmt.Printf("✓ Python virtual environment: %s\n", venvDir)
-
-	// Check if activate script exists
-	activateScript := filepath.Join(venvDir, "bin", "activate")
-	if _, err := os.Stat(activateScript); os.IsNotExist(err) {
-		return fmt.Errorf("virtual environment activate script not found")
-	}
-
-	// Check Ansible installation
-	ansiblePath := filepath.Join(venvDir, "bin", "ansible")
-	if _, err := os.Stat(ansiblePath); os.IsNotExist(err) {
-		return fmt.Errorf("ansible not found in virtual environment")
-	}
+  ./bluebanquise-installer status --user myuser
 
-	fmt.Printf("✓ Ansible: %s\n", ansiblePath)
+  # Also list the Python package versions
+  ./bluebanquise-installer status --verbose
 
-	ansibleGalaxyPath := filepath.Join(venvDir, "bin", "ansible-galaxy")
-	if _, err := os.Stat(ansibleGalaxyPath); os.IsNotExist(err) {
-		return fmt.Errorf("ansible-galaxy not found in virtual environment")
-	}
-
-	fmt.Printf("✓ Ansible Galaxy: %s\n", ansibleGalaxyPath)
-
-	// Check BlueBanquise collections
-	collectionsDir := filepath.Join(userHome, ".ansible", "collections")
-	if _, err := os.Stat(collectionsDir); os.IsNotExist(err) {
-		return fmt.Errorf("bluebanquise collections not found")
-	}
-
-	fmt.Printf("✓ Collections directory: %s\n", collectionsDir)
-
-	// Check if infrastructure collection exists
-	infraCollectionDir := filepath.Join(collectionsDir, "ansible_collections", "bluebanquise", "infrastructure")
-	if _, err := os.Stat(infraCollectionDir); os.IsNotExist(err) {
-		return fmt.Errorf("bluebanquise infrastructure collection not found")
-	}
-
-	fmt.Printf("✓ BlueBanquise infrastructure collection: %s\n", infraCollectionDir)
-
-	// Check core variables
-	coreVarsPath := filepath.Join(userHome, "bluebanquise", "inventory", "group_vars", "all", "bb_core.yml")
-	if _, err := os.Stat(coreVarsPath); os.IsNotExist(err) {
-		fmt.Printf("⚠ Core variables not found: %s\n", coreVarsPath)
-	} else {
-		fmt.Printf("✓ Core variables: %s\n", coreVarsPath)
-	}
+  # Also run the installed binaries and check SELinux contexts
+  ./bluebanquise-installer status --deep
 
-	utils.LogInfo("BlueBanquise installation status check completed successfully", "user", statusUserName)
-	fmt.Println("\n✓ BlueBanquise installation is ready!")
-	return nil
-}
-
-func getUserHome(userName string) (string, error) {
-	if userName == "" {
-		userName = "bluebanquise"
-	}
-
-	_, _, err := bootstrap.GetUserInfo(userName)
-	if err != nil {
-		return "", err
-	}
+  # Report the status as JSON
+  ./bluebanquise-installer status --output json
 
-	// Get home directory from /etc/passwd or use default
-	homeDir := fmt.Sprintf("/home/%s", userName)
-	if _, err := os.Stat(homeDir); os.IsNotExist(err) {
-		// Try alternative locations
-		altDirs := []string{
-			fmt.Sprintf("/var/lib/%s", userName),
-			fmt.Sprintf("/opt/%s", userName),
-		}
-		for _, dir := range altDirs {
-			if _, err := os.Stat(dir); err == nil {
-				homeDir = dir
-				break
+  # Check every user with a BlueBanquise installation
+  ./bluebanquise-installer status --all`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if allUsers {
+				if opts.UserName != "" && opts.UserName != installer.StatusAllUsers {
+					fmt.Printf("Error: --all conflicts with --user %s\n", opts.UserName)
+					exitWithError()
+				}
+				opts.UserName = installer.StatusAllUsers
+			}
+			if opts.Output == installer.StatusOutputJSON {
+				// Keep stdout for the JSON document only
+				utils.SetConsoleOutput(os.Stderr)
 			}
-		}
+			if err := installer.New().Status(cmd.Context(), *opts); err != nil {
+				utils.LogError("Status check failed", err)
+				if opts.Output != installer.StatusOutputJSON {
+					fmt.Printf("Status check failed: %v\n", err)
+				}
+				exitWithError()
+			}
+		},
 	}
 
-	return homeDir, nil
+	cmd.Flags().StringVarP(&opts.UserName, "user", "u", "", "Username to check status for, or all (default: bluebanquise)")
+	cmd.Flags().BoolVar(&allUsers, "all", false, "Check every user with a BlueBanquise installation (same as --user all)")
+	cmd.Flags().BoolVarP(&opts.Verbose, "verbose", "v", false, "Show the versions of the key Python packages")
+	cmd.Flags().BoolVar(&opts.Deep, "deep", false, "Also run checks executing commands (ansible --version, pip check, pip list, SELinux)")
+	cmd.Flags().StringVarP(&opts.Output, "output", "o", installer.StatusOutputText, "Output format: text or json")
+	return cmd
 }
 
 func init() {
-	statusCmd.Flags().StringVarP(&statusUserName, "user", "u", "", "Username to check status for (default: bluebanquise)")
-	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(newStatusCmd())
 }
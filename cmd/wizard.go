@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+// Modes proposed by the wizard, named after the command they run.
+const (
+	wizardOnline   = "online"
+	wizardOffline  = "offline"
+	wizardDownload = "download"
+)
+
+// Sources of the collections proposed by the wizard.
+const (
+	collectionsGitHub = "github"
+	collectionsLocal  = "local"
+	collectionsBundle = "bundle"
+	collectionsSkip   = "skip"
+)
+
+// defaultDownloadPath is proposed as the download directory.
+const defaultDownloadPath = "/tmp/bluebanquise-offline"
+
+// errWizardCancelled is returned when the summary is not confirmed.
+var errWizardCancelled = errors.New("installation cancelled")
+
+// stdinIsTerminal reports whether the wizard can prompt, replaced by tests.
+var stdinIsTerminal = func() bool { return utils.IsTerminal(os.Stdin) }
+
+// wizardPlan holds the answers of the wizard, as the options of the command
+// of mode.
+type wizardPlan struct {
+	mode     string
+	online   installer.OnlineOptions
+	offline  installer.OfflineOptions
+	download installer.DownloadOptions
+	// proxy is exported as HTTPS_PROXY and HTTP_PROXY when set.
+	proxy  string
+	mirror mirrorOptions
+}
+
+// newWizardCmd returns the wizard command.
+func newWizardCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "wizard",
+		Short: "Interactively choose and run an installation",
+		Long: `Ask which installation to run (online, offline or download), the user,
+home, proxy, mirror and collections to use, then run it with those options.
+
+The equivalent command line is printed before anything runs, so the same
+installation can be repeated without the wizard. The wizard needs an
+interactive terminal, use the online, offline and download commands with
+their flags in scripts.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !stdinIsTerminal() {
+				err := errors.New("the wizard needs an interactive terminal, use the online, offline or download command with flags instead")
+				utils.LogError("Wizard without terminal", err)
+				printError(err)
+				exitWithError()
+			}
+
+			plan, err := askWizard(utils.NewPrompter(os.Stdin, os.Stdout))
+			if errors.Is(err, errWizardCancelled) {
+				fmt.Println("Installation cancelled.")
+				return
+			}
+			if err != nil {
+				utils.LogError("Wizard failed", err)
+				printError(err)
+				exitWithError()
+			}
+
+			if err := runWizardPlan(cmd, plan); err != nil {
+				printError(err)
+				exitWithError()
+			}
+		},
+	}
+}
+
+// askWizard asks the installation to run and returns its plan once the
+// summary is confirmed.
+func askWizard(p *utils.Prompter) (wizardPlan, error) {
+	fmt.Println("BlueBanquise installation wizard")
+	fmt.Println("  online:   install from the network (GitHub and PyPI)")
+	fmt.Println("  offline:  install from collections and requirements downloaded beforehand")
+	fmt.Println("  download: download them on a connected host for an offline installation")
+	fmt.Println()
+
+	plan := wizardPlan{}
+	mode, err := p.Choose("Installation mode", []string{wizardOnline, wizardOffline, wizardDownload}, wizardOnline)
+	if err != nil {
+		return plan, err
+	}
+	plan.mode = mode
+
+	switch mode {
+	case wizardDownload:
+		err = askWizardDownload(p, &plan.download)
+	case wizardOffline:
+		err = askWizardOffline(p, &plan.offline)
+	default:
+		err = askWizardOnline(p, &plan.online)
+	}
+	if err != nil {
+		return plan, err
+	}
+
+	// Offline installations do not reach the network
+	if mode != wizardOffline {
+		if plan.proxy, err = p.Ask("HTTP(S) proxy, empty for none", os.Getenv("HTTPS_PROXY")); err != nil {
+			return plan, err
+		}
+		// The downloads of download mode only reach GitHub and PyPI
+		if mode == wizardOnline {
+			if plan.mirror.user, err = p.Ask("Mirror user for HTTP basic auth, empty for none", ""); err != nil {
+				return plan, err
+			}
+		}
+		if plan.mirror.caCert, err = p.Ask("CA certificate of the mirror, empty for none", ""); err != nil {
+			return plan, err
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("The wizard will run:")
+	fmt.Printf("  %s\n", plan.commandLine())
+	if plan.mirror.user != "" {
+		fmt.Println("Set the mirror password with BB_MIRROR_PASSWORD.")
+	}
+	proceed, err := p.Confirm("Proceed?", true)
+	if err != nil {
+		return plan, err
+	}
+	if !proceed {
+		return plan, errWizardCancelled
+	}
+	return plan, nil
+}
+
+// askWizardUser asks the user and home of an installation.
+func askWizardUser(p *utils.Prompter) (string, string, error) {
+	userName, err := p.Ask("User name", "bluebanquise")
+	if err != nil {
+		return "", "", err
+	}
+	userHome, err := p.Ask("Home directory", installer.TargetUserHome(userName))
+	if err != nil {
+		return "", "", err
+	}
+	return userName, userHome, nil
+}
+
+// askWizardOnline asks the options of an online installation.
+func askWizardOnline(p *utils.Prompter, opts *installer.OnlineOptions) error {
+	var err error
+	if opts.UserName, opts.UserHome, err = askWizardUser(p); err != nil {
+		return err
+	}
+	opts.SudoersMode = bootstrap.SudoersModeNopasswd
+
+	source, err := p.Choose("Collections source", []string{collectionsGitHub, collectionsLocal, collectionsSkip}, collectionsGitHub)
+	if err != nil {
+		return err
+	}
+	switch source {
+	case collectionsLocal:
+		opts.CollectionsPath, err = askWizardPath(p, "Collections directory, .tar.gz bundle or glob of archives")
+	case collectionsSkip:
+		opts.SkipCollections = true
+	}
+	return err
+}
+
+// askWizardOffline asks the options of an offline installation.
+func askWizardOffline(p *utils.Prompter, opts *installer.OfflineOptions) error {
+	var err error
+	if opts.UserName, opts.UserHome, err = askWizardUser(p); err != nil {
+		return err
+	}
+	opts.SudoersMode = bootstrap.SudoersModeNopasswd
+
+	source, err := p.Choose("Collections source, a download bundle or a local path", []string{collectionsBundle, collectionsLocal}, collectionsBundle)
+	if err != nil {
+		return err
+	}
+	if source == collectionsBundle {
+		opts.FromBundle, err = askWizardPath(p, "Download directory or .tar.gz bundle")
+		return err
+	}
+	if opts.CollectionsPath, err = askWizardPath(p, "Collections directory, .tar.gz bundle or glob of archives"); err != nil {
+		return err
+	}
+	if opts.RequirementsPath, err = p.Ask("Python requirements directory, empty for none", ""); err != nil {
+		return err
+	}
+	opts.CoreVarsPath, err = p.Ask("Core variables file, empty for none", "")
+	return err
+}
+
+// askWizardDownload asks the options of a download.
+func askWizardDownload(p *utils.Prompter, opts *installer.DownloadOptions) error {
+	var err error
+	if opts.Path, err = p.Ask("Download directory", defaultDownloadPath); err != nil {
+		return err
+	}
+	for {
+		if opts.Collections, err = p.Confirm("Download the collections?", true); err != nil {
+			return err
+		}
+		if opts.Requirements, err = p.Confirm("Download the Python requirements?", true); err != nil {
+			return err
+		}
+		if opts.CoreVars, err = p.Confirm("Download the core variables?", true); err != nil {
+			return err
+		}
+		if opts.Collections || opts.Requirements || opts.CoreVars {
+			return nil
+		}
+		fmt.Println("Please choose at least one component to download.")
+	}
+}
+
+// askWizardPath asks a path until the answer is not empty.
+func askWizardPath(p *utils.Prompter, question string) (string, error) {
+	for {
+		path, err := p.Ask(question, "")
+		if err != nil || path != "" {
+			return path, err
+		}
+		fmt.Println("Please enter a path.")
+	}
+}
+
+// commandLine returns the command line running the same installation as the
+// plan.
+func (w wizardPlan) commandLine() string {
+	args := []string{"bluebanquise-installer", w.mode}
+	flag := func(name, value string) {
+		if value != "" {
+			args = append(args, "--"+name, shellQuote(value))
+		}
+	}
+	toggle := func(name string, set bool) {
+		if set {
+			args = append(args, "--"+name)
+		}
+	}
+
+	switch w.mode {
+	case wizardDownload:
+		flag("path", w.download.Path)
+		toggle("collections", w.download.Collections)
+		toggle("requirements", w.download.Requirements)
+		toggle("core-vars", w.download.CoreVars)
+	case wizardOffline:
+		flag("user", w.offline.UserName)
+		flag("home", w.offline.UserHome)
+		flag("from-bundle", w.offline.FromBundle)
+		flag("collections-path", w.offline.CollectionsPath)
+		flag("requirements-path", w.offline.RequirementsPath)
+		flag("core-vars-path", w.offline.CoreVarsPath)
+	default:
+		flag("user", w.online.UserName)
+		flag("home", w.online.UserHome)
+		flag("collections-path", w.online.CollectionsPath)
+		toggle("skip-collections", w.online.SkipCollections)
+	}
+	flag("mirror-user", w.mirror.user)
+	flag("ca-cert", w.mirror.caCert)
+
+	command := strings.Join(args, " ")
+	if w.proxy != "" {
+		command = "HTTPS_PROXY=" + shellQuote(w.proxy) + " HTTP_PROXY=" + shellQuote(w.proxy) + " " + command
+	}
+	return command
+}
+
+// shellQuote quotes value for a POSIX shell when it holds other characters
+// than letters, digits and path punctuation.
+func shellQuote(value string) string {
+	if strings.Trim(value, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789/._-:@=,") == "" {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// runWizardPlan runs the installation of plan as its command would.
+func runWizardPlan(cmd *cobra.Command, plan wizardPlan) error {
+	if plan.proxy != "" {
+		os.Setenv("HTTPS_PROXY", plan.proxy)
+		os.Setenv("HTTP_PROXY", plan.proxy)
+	}
+	plan.mirror.password = os.Getenv("BB_MIRROR_PASSWORD")
+	mirror, err := plan.mirror.downloadOptions()
+	if err != nil {
+		utils.LogError("Invalid mirror configuration", err)
+		return err
+	}
+	utils.LogInfo("Running wizard plan", "mode", plan.mode, "command", plan.commandLine())
+
+	switch plan.mode {
+	case wizardDownload:
+		return installer.New().Download(cmd.Context(), plan.download)
+	case wizardOffline:
+		plan.offline.Mirror = mirror
+		if err := installer.New().Offline(cmd.Context(), plan.offline); err != nil {
+			return err
+		}
+		utils.ShowCompletionMessage(plan.offline.UserName, plan.offline.UserHome, bootstrap.InstalledEnvWrapper(plan.offline.UserHome))
+	default:
+		plan.online.Mirror = mirror
+		if err := installer.New().Online(cmd.Context(), plan.online); err != nil {
+			return err
+		}
+		utils.ShowCompletionMessage(plan.online.UserName, plan.online.UserHome, bootstrap.InstalledEnvWrapper(plan.online.UserHome))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newWizardCmd())
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/installer"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAskWizard(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "")
+
+	tests := []struct {
+		name        string
+		input       []string
+		want        wizardPlan
+		wantCommand string
+		wantErr     error
+	}{
+		{
+			name:  "Online defaults",
+			input: []string{"", "", "/srv/bluebanquise", "", "", "", "", ""},
+			want: wizardPlan{
+				mode:   wizardOnline,
+				online: installer.OnlineOptions{UserName: "bluebanquise", UserHome: "/srv/bluebanquise", SudoersMode: bootstrap.SudoersModeNopasswd},
+			},
+			wantCommand: "bluebanquise-installer online --user bluebanquise --home /srv/bluebanquise",
+		},
+		{
+			name:  "Online with local collections, proxy and mirror",
+			input: []string{"online", "admin", "/home/admin", "local", "/srv/collections", "http://proxy:3128", "mirror", "/etc/pki/mirror.pem", "y"},
+			want: wizardPlan{
+				mode: wizardOnline,
+				online: installer.OnlineOptions{
+					UserName:        "admin",
+					UserHome:        "/home/admin",
+					SudoersMode:     bootstrap.SudoersModeNopasswd,
+					CollectionsPath: "/srv/collections",
+				},
+				proxy:  "http://proxy:3128",
+				mirror: mirrorOptions{user: "mirror", caCert: "/etc/pki/mirror.pem"},
+			},
+			wantCommand: "HTTPS_PROXY=http://proxy:3128 HTTP_PROXY=http://proxy:3128 bluebanquise-installer online --user admin --home /home/admin --collections-path /srv/collections --mirror-user mirror --ca-cert /etc/pki/mirror.pem",
+		},
+		{
+			name:  "Online without collections",
+			input: []string{"online", "", "/srv/bluebanquise", "skip", "", "", "", ""},
+			want: wizardPlan{
+				mode:   wizardOnline,
+				online: installer.OnlineOptions{UserName: "bluebanquise", UserHome: "/srv/bluebanquise", SudoersMode: bootstrap.SudoersModeNopasswd, SkipCollections: true},
+			},
+			wantCommand: "bluebanquise-installer online --user bluebanquise --home /srv/bluebanquise --skip-collections",
+		},
+		{
+			name:  "Offline from a bundle",
+			input: []string{"offline", "", "/srv/bluebanquise", "", "", "/srv/offline.tar.gz", ""},
+			want: wizardPlan{
+				mode:    wizardOffline,
+				offline: installer.OfflineOptions{UserName: "bluebanquise", UserHome: "/srv/bluebanquise", SudoersMode: bootstrap.SudoersModeNopasswd, FromBundle: "/srv/offline.tar.gz"},
+			},
+			wantCommand: "bluebanquise-installer offline --user bluebanquise --home /srv/bluebanquise --from-bundle /srv/offline.tar.gz",
+		},
+		{
+			name:  "Offline from local paths",
+			input: []string{"offline", "", "/srv/bluebanquise", "local", "/srv/offline/*.tar.gz", "/srv/offline/requirements", "", ""},
+			want: wizardPlan{
+				mode: wizardOffline,
+				offline: installer.OfflineOptions{
+					UserName:         "bluebanquise",
+					UserHome:         "/srv/bluebanquise",
+					SudoersMode:      bootstrap.SudoersModeNopasswd,
+					CollectionsPath:  "/srv/offline/*.tar.gz",
+					RequirementsPath: "/srv/offline/requirements",
+				},
+			},
+			wantCommand: "bluebanquise-installer offline --user bluebanquise --home /srv/bluebanquise --collections-path '/srv/offline/*.tar.gz' --requirements-path /srv/offline/requirements",
+		},
+		{
+			name:  "Download asks again without components",
+			input: []string{"download", "", "n", "n", "n", "y", "n", "y", "", "", ""},
+			want: wizardPlan{
+				mode:     wizardDownload,
+				download: installer.DownloadOptions{Path: defaultDownloadPath, Collections: true, CoreVars: true},
+			},
+			wantCommand: "bluebanquise-installer download --path /tmp/bluebanquise-offline --collections --core-vars",
+		},
+		{
+			name:    "Cancelled",
+			input:   []string{"", "", "/srv/bluebanquise", "", "", "", "", "n"},
+			wantErr: errWizardCancelled,
+		},
+		{
+			name:    "Input closed",
+			input:   []string{"offline", ""},
+			wantErr: utils.ErrNoAnswer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := strings.Join(tt.input, "\n") + "\n"
+			plan, err := askWizard(utils.NewPrompter(strings.NewReader(input), &strings.Builder{}))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, plan)
+			assert.Equal(t, tt.wantCommand, plan.commandLine())
+		})
+	}
+}
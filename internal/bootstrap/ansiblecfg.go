@@ -0,0 +1,134 @@
+package bootstrap
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// defaultAnsibleConfig is the ansible.cfg installed when no template is given.
+//
+//go:embed templates/ansible.cfg.tmpl
+var defaultAnsibleConfig string
+
+// InstallAnsibleConfig writes ansible.cfg to AnsibleConfigPath, rendered from
+// templatePath or from the built-in default when it is empty. Without a
+// template an existing ansible.cfg is kept, so local changes survive a rerun.
+func InstallAnsibleConfig(userHome, templatePath string) error {
+	configPath := AnsibleConfigPath(userHome)
+	if templatePath == "" {
+		if _, err := os.Stat(configPath); err == nil {
+			utils.LogInfo("Keeping existing ansible.cfg", "path", configPath)
+			return nil
+		}
+	}
+
+	content, err := RenderAnsibleConfig(templatePath, userHome)
+	if err != nil {
+		utils.LogError("Invalid ansible.cfg template", err, "template", templatePath)
+		return err
+	}
+
+	utils.LogInfo("Writing ansible.cfg", "path", configPath, "template", templatePath)
+	fmt.Printf("Writing %s...\n", configPath)
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		utils.LogError("Failed to create bluebanquise directory", err, "path", filepath.Dir(configPath))
+		return fmt.Errorf("failed to create bluebanquise directory: %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		utils.LogError("Failed to write ansible.cfg", err, "path", configPath)
+		return fmt.Errorf("failed to write ansible.cfg: %v", err)
+	}
+	return nil
+}
+
+// RenderAnsibleConfig returns the ansible.cfg of templatePath, or of the
+// built-in default when it is empty, with $HOME and ${HOME} replaced by
+// userHome. The result must parse as INI.
+func RenderAnsibleConfig(templatePath, userHome string) (string, error) {
+	template := defaultAnsibleConfig
+	if templatePath != "" {
+		data, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read ansible.cfg template: %v", err)
+		}
+		template = string(data)
+	}
+
+	content := strings.NewReplacer("${HOME}", userHome, "$HOME", userHome).Replace(template)
+	if err := validateINI(content); err != nil {
+		if templatePath == "" {
+			templatePath = "built-in ansible.cfg"
+		}
+		return "", fmt.Errorf("%s is not a valid INI file: %v", templatePath, err)
+	}
+	return content, nil
+}
+
+// ValidateAnsibleConfigTemplate checks that templatePath renders to a valid
+// ansible.cfg, before anything is changed on the system.
+func ValidateAnsibleConfigTemplate(templatePath string) error {
+	_, err := RenderAnsibleConfig(templatePath, "/home")
+	return err
+}
+
+// validateINI checks content the way Python's configparser reads ansible.cfg:
+// every option belongs to a [section], is written key = value or key: value,
+// and neither sections nor options of a section are repeated. Indented lines
+// continue the value of the previous option.
+func validateINI(content string) error {
+	var section string
+	sections := map[string]bool{}
+	options := map[string]bool{}
+	inOption := false
+
+	for i, line := range strings.Split(content, "\n") {
+		number := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if !inOption {
+				return fmt.Errorf("line %d: unexpected indentation", number)
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			if !strings.HasSuffix(trimmed, "]") || len(trimmed) < 3 {
+				return fmt.Errorf("line %d: invalid section header %q", number, trimmed)
+			}
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if sections[section] {
+				return fmt.Errorf("line %d: duplicate section [%s]", number, section)
+			}
+			sections[section] = true
+			inOption = false
+			continue
+		}
+
+		if section == "" {
+			return fmt.Errorf("line %d: option outside of a section", number)
+		}
+		separator := strings.IndexAny(trimmed, "=:")
+		if separator <= 0 {
+			return fmt.Errorf("line %d: expected key = value, got %q", number, trimmed)
+		}
+		key := section + "." + strings.ToLower(strings.TrimSpace(trimmed[:separator]))
+		if options[key] {
+			return fmt.Errorf("line %d: duplicate option %s", number, strings.TrimSpace(trimmed[:separator]))
+		}
+		options[key] = true
+		inOption = true
+	}
+
+	if len(sections) == 0 {
+		return fmt.Errorf("no section found")
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderAnsibleConfig(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		content, err := RenderAnsibleConfig("", "/var/lib/bluebanquise")
+		require.NoError(t, err)
+		assert.Contains(t, content, "inventory = /var/lib/bluebanquise/bluebanquise/inventory\n")
+		assert.Contains(t, content, "collections_path = /var/lib/bluebanquise/.ansible/collections\n")
+		assert.NotContains(t, content, "$HOME")
+	})
+
+	t.Run("Template", func(t *testing.T) {
+		template := filepath.Join(t.TempDir(), "ansible.cfg")
+		require.NoError(t, os.WriteFile(template, []byte("[defaults]\ninventory = $HOME/inventory\nroles_path = ${HOME}/roles:\n  /etc/ansible/roles\n"), 0644))
+
+		content, err := RenderAnsibleConfig(template, "/opt/bb")
+		require.NoError(t, err)
+		assert.Equal(t, "[defaults]\ninventory = /opt/bb/inventory\nroles_path = /opt/bb/roles:\n  /etc/ansible/roles\n", content)
+	})
+
+	t.Run("Missing template", func(t *testing.T) {
+		_, err := RenderAnsibleConfig(filepath.Join(t.TempDir(), "missing.cfg"), "/opt/bb")
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid template", func(t *testing.T) {
+		template := filepath.Join(t.TempDir(), "ansible.cfg")
+		require.NoError(t, os.WriteFile(template, []byte("inventory = $HOME/inventory\n"), 0644))
+
+		_, err := RenderAnsibleConfig(template, "/opt/bb")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "line 1: option outside of a section")
+	})
+}
+
+func TestValidateINI(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "Valid", content: "# comment\n[defaults]\nforks = 20\n; comment\ntimeout: 30\n\n[ssh_connection]\npipelining = True\n"},
+		{name: "Continuation line", content: "[defaults]\nroles_path = /a:\n    /b\n"},
+		{name: "Empty", content: "", wantErr: "no section found"},
+		{name: "Option outside of a section", content: "forks = 20\n", wantErr: "line 1: option outside of a section"},
+		{name: "Invalid section header", content: "[defaults\n", wantErr: "line 1: invalid section header"},
+		{name: "Duplicate section", content: "[defaults]\n[defaults]\n", wantErr: "line 2: duplicate section [defaults]"},
+		{name: "Duplicate option", content: "[defaults]\nforks = 20\nForks = 10\n", wantErr: "line 3: duplicate option Forks"},
+		{name: "Missing separator", content: "[defaults]\nforks 20\n", wantErr: "line 2: expected key = value"},
+		{name: "Unexpected indentation", content: "[defaults]\n  forks = 20\n", wantErr: "line 2: unexpected indentation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateINI(tt.content)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestInstallAnsibleConfig(t *testing.T) {
+	utils.InitTestLogger()
+
+	t.Run("Default fallback", func(t *testing.T) {
+		home := t.TempDir()
+		require.NoError(t, InstallAnsibleConfig(home, ""))
+
+		data, err := os.ReadFile(AnsibleConfigPath(home))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "inventory = "+home+"/bluebanquise/inventory")
+	})
+
+	t.Run("Default keeps an existing file", func(t *testing.T) {
+		home := t.TempDir()
+		require.NoError(t, os.MkdirAll(BluebanquiseDir(home), 0755))
+		require.NoError(t, os.WriteFile(AnsibleConfigPath(home), []byte("[defaults]\nforks = 5\n"), 0644))
+
+		require.NoError(t, InstallAnsibleConfig(home, ""))
+		data, err := os.ReadFile(AnsibleConfigPath(home))
+		require.NoError(t, err)
+		assert.Equal(t, "[defaults]\nforks = 5\n", string(data))
+	})
+
+	t.Run("Template replaces an existing file", func(t *testing.T) {
+		home := t.TempDir()
+		require.NoError(t, os.MkdirAll(BluebanquiseDir(home), 0755))
+		require.NoError(t, os.WriteFile(AnsibleConfigPath(home), []byte("[defaults]\nforks = 5\n"), 0644))
+		template := filepath.Join(t.TempDir(), "site.cfg")
+		require.NoError(t, os.WriteFile(template, []byte("[defaults]\ninventory = $HOME/site\n"), 0644))
+
+		require.NoError(t, InstallAnsibleConfig(home, template))
+		data, err := os.ReadFile(AnsibleConfigPath(home))
+		require.NoError(t, err)
+		assert.Equal(t, "[defaults]\ninventory = "+home+"/site\n", string(data))
+	})
+}
@@ -2,29 +2,34 @@ package bootstrap
 
 import (
 	"context"
+	_ "embed"
 	"fmt"
 	"io"
-	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
+	"os/user"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/lmagdanello/bluebanquise-installer/internal/system"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 )
 
-// InstallCollectionsOnline installs BlueBanquise collections from GitHub.
-func InstallCollectionsOnline(userHome string) error {
+// InstallCollectionsOnline installs BlueBanquise collections from GitHub,
+// retrying network failures until ctx is done.
+func InstallCollectionsOnline(ctx context.Context, userHome string) error {
 	utils.LogInfo("Installing collections online", "home", userHome)
 
-	venvDir := filepath.Join(userHome, "ansible_venv")
-	venvBin := filepath.Join(venvDir, "bin")
+	venvDir := VenvDir(userHome)
+	venvBin := VenvBin(userHome)
 	ansibleGalaxy := filepath.Join(venvBin, "ansible-galaxy")
-	collectionsDir := filepath.Join(userHome, ".ansible", "collections")
+	collectionsDir := CollectionsDir(userHome)
 
-	// Verify ansible-galaxy exists, create environment if it doesn't
+	// Fail when ansible-galaxy is missing or broken, the environment is not rebuilt here
 	if err := ensureAnsibleGalaxy(venvDir, ansibleGalaxy); err != nil {
 		return err
 	}
@@ -34,13 +39,15 @@ func InstallCollectionsOnline(userHome string) error {
 		utils.LogError("Failed to create collections directory", err, "path", collectionsDir)
 		return fmt.Errorf("failed to create collections directory: %v", err)
 	}
+	owner, err := prepareCollectionsOwner(userHome)
+	if err != nil {
+		return err
+	}
 
 	utils.LogInfo("Installing BlueBanquise collections", "collections_dir", collectionsDir)
 	fmt.Println("Installing BlueBanquise collections...")
 
-	utils.LogCommand(ansibleGalaxy, "collection", "install", "git+https://github.com/bluebanquise/bluebanquise.git#/collections/infrastructure,master", "-p", collectionsDir)
-	cmd := exec.Command(ansibleGalaxy, "collection", "install", "git+https://github.com/bluebanquise/bluebanquise.git#/collections/infrastructure,master", "-p", collectionsDir)
-	if err := cmd.Run(); err != nil {
+	if err := installCollectionOnline(ctx, owner, ansibleGalaxy, "git+https://github.com/bluebanquise/bluebanquise.git#/collections/infrastructure,master", collectionsDir); err != nil {
 		utils.LogError("Failed to install BlueBanquise collections", err)
 		return fmt.Errorf("failed to install BlueBanquise collections: %v", err)
 	}
@@ -48,26 +55,70 @@ func InstallCollectionsOnline(userHome string) error {
 	utils.LogInfo("Installing community.general collection", "collections_dir", collectionsDir)
 	fmt.Println("Installing community.general collection...")
 
-	utils.LogCommand(ansibleGalaxy, "collection", "install", "community.general", "-p", collectionsDir)
-	cmd = exec.Command(ansibleGalaxy, "collection", "install", "community.general", "-p", collectionsDir)
-	if err := cmd.Run(); err != nil {
+	if err := installCollectionOnline(ctx, owner, ansibleGalaxy, "community.general", collectionsDir); err != nil {
 		utils.LogError("Failed to install community.general collection", err)
 		return fmt.Errorf("failed to install community.general collection: %v", err)
 	}
 
+	if err := verifyInfrastructureCollection(owner, ansibleGalaxy, collectionsDir); err != nil {
+		return err
+	}
+
 	utils.LogInfo("Collections installed successfully online", "collections_dir", collectionsDir)
 	return nil
 }
 
+// InstallCollectionsFromGit installs BlueBanquise collections from a git+
+// source, e.g. an internal mirror of the BlueBanquise repository, which
+// ansible-galaxy clones itself. Retries stop once ctx is done.
+func InstallCollectionsFromGit(ctx context.Context, source, userHome string) error {
+	utils.LogInfo("Installing collections from git", "source", utils.RedactURL(source), "home", userHome)
+
+	venvDir := VenvDir(userHome)
+	venvBin := VenvBin(userHome)
+	ansibleGalaxy := filepath.Join(venvBin, "ansible-galaxy")
+	collectionsDir := CollectionsDir(userHome)
+
+	// Fail when ansible-galaxy is missing or broken, the environment is not rebuilt here
+	if err := ensureAnsibleGalaxy(venvDir, ansibleGalaxy); err != nil {
+		return err
+	}
+
+	// Create collections directory if it doesn't exist.
+	if err := os.MkdirAll(collectionsDir, 0755); err != nil {
+		utils.LogError("Failed to create collections directory", err, "path", collectionsDir)
+		return fmt.Errorf("failed to create collections directory: %v", err)
+	}
+	owner, err := prepareCollectionsOwner(userHome)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Installing collections from %s...\n", utils.RedactURL(source))
+	if err := installCollectionOnline(ctx, owner, ansibleGalaxy, source, collectionsDir); err != nil {
+		utils.LogError("Failed to install collections from git", err, "source", utils.RedactURL(source))
+		return fmt.Errorf("failed to install collections from %s: %v", utils.RedactURL(source), err)
+	}
+
+	if err := verifyInfrastructureCollection(owner, ansibleGalaxy, collectionsDir); err != nil {
+		return err
+	}
+
+	utils.LogInfo("Collections installed successfully from git", "source", utils.RedactURL(source), "collections_dir", collectionsDir)
+	return nil
+}
+
 // InstallCollectionsFromPath installs BlueBanquise collections from a given path.
-func InstallCollectionsFromPath(path, userHome string) error {
-	utils.LogInfo("Installing collections from path", "path", path, "home", userHome)
-	venvDir := filepath.Join(userHome, "ansible_venv")
-	venvBin := filepath.Join(venvDir, "bin")
+// When only is set, only the collections it names as namespace.name are
+// installed, matched against the MANIFEST.json of each archive.
+func InstallCollectionsFromPath(path, userHome string, force bool, only []string) error {
+	utils.LogInfo("Installing collections from path", "path", path, "home", userHome, "only", only)
+	venvDir := VenvDir(userHome)
+	venvBin := VenvBin(userHome)
 	ansibleGalaxy := filepath.Join(venvBin, "ansible-galaxy")
-	collectionsDir := filepath.Join(userHome, ".ansible", "collections")
+	collectionsDir := CollectionsDir(userHome)
 
-	// Verify ansible-galaxy exists, create environment if it doesn't
+	// Fail when ansible-galaxy is missing or broken, the environment is not rebuilt here
 	if err := ensureAnsibleGalaxy(venvDir, ansibleGalaxy); err != nil {
 		return err
 	}
@@ -77,6 +128,11 @@ func InstallCollectionsFromPath(path, userHome string) error {
 		utils.LogError("Failed to create collections directory", err, "path", collectionsDir)
 		return fmt.Errorf("failed to create collections directory: %v", err)
 	}
+	owner, err := prepareCollectionsOwner(userHome)
+	if err != nil {
+		return err
+	}
+
 	// Check if path is a file or directory.
 	info, err := os.Stat(path)
 	if err != nil {
@@ -84,47 +140,397 @@ func InstallCollectionsFromPath(path, userHome string) error {
 		return fmt.Errorf("failed to stat path: %v", err)
 	}
 	if info.IsDir() {
-		// Directory containing multiple tarballs/collections.
-		utils.LogInfo("Processing directory", "path", path)
-		entries, err := os.ReadDir(path)
+		layout, root, err := utils.DetectCollectionsLayout(path)
 		if err != nil {
-			utils.LogError("Failed to read directory", err, "path", path)
+			utils.LogError("Unsupported collections layout", err, "path", path)
+			return err
+		}
+		if layout == utils.CollectionsLayoutInstalled {
+			if err := copyInstalledCollections(root, collectionsDir, only); err != nil {
+				return err
+			}
+			// The copy is made as the installer, hand it over to the owner of the home
+			if owner != "" {
+				if err := chownTree(collectionsDir, owner); err != nil {
+					return err
+				}
+			}
+			if selectsInfrastructure(only) {
+				if err := verifyInfrastructureCollection(owner, ansibleGalaxy, collectionsDir); err != nil {
+					return err
+				}
+			}
+			utils.LogInfo("Collections installed successfully from path", "path", path)
+			return nil
+		}
+
+		// Directory containing tarballs, possibly in subdirectories.
+		utils.LogInfo("Processing directory", "path", root)
+		archives, err := utils.CollectionArchives(root)
+		if err != nil {
+			utils.LogError("Failed to read directory", err, "path", root)
 			return fmt.Errorf("failed to read directory: %v", err)
 		}
-		for _, entry := range entries {
-			if !entry.IsDir() {
-				name := entry.Name()
-				if strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") {
-					file := filepath.Join(path, name)
-					utils.LogInfo("Installing collection from file", "file", name, "path", file)
-					fmt.Printf("Installing collection from file: %s\n", name)
-					utils.LogCommand(ansibleGalaxy, "collection", "install", file, "-p", collectionsDir)
-					cmd := exec.Command(ansibleGalaxy, "collection", "install", file, "-p", collectionsDir)
-					if err := cmd.Run(); err != nil {
-						utils.LogError("Failed to install collection from file", err, "file", name, "path", file)
-						return fmt.Errorf("failed to install collection from file %s: %v", name, err)
-					}
+		// Check every archive first, so a corrupt one installs nothing
+		for _, name := range archives {
+			if err := checkCollectionArchive(filepath.Join(root, name)); err != nil {
+				return err
+			}
+		}
+		selected, skipped, err := selectCollectionArchives(root, archives, only)
+		if err != nil {
+			return err
+		}
+		var install []string
+		for _, name := range selected {
+			if _, ok := collectionDecision(filepath.Join(root, name), collectionsDir, force); ok {
+				install = append(install, name)
+			} else {
+				skipped = append(skipped, name)
+			}
+		}
+		// A manifest written by ansible-galaxy collection download is preferred,
+		// ansible-galaxy then orders the dependencies itself
+		_, statErr := os.Stat(filepath.Join(root, utils.GalaxyRequirementsFile))
+		staged := archives
+		if statErr == nil {
+			staged = append(slices.Clone(archives), utils.GalaxyRequirementsFile)
+		}
+		stageDir, cleanup, err := stageCollectionArchives(owner, root, staged)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		if statErr == nil {
+			if err := installCollectionsFromRequirements(owner, ansibleGalaxy, stageDir, install, skipped, collectionsDir, force); err != nil {
+				return err
+			}
+		} else {
+			for _, name := range install {
+				if err := installCollectionArchive(owner, ansibleGalaxy, stageDir, name, collectionsDir, force); err != nil {
+					return err
 				}
 			}
 		}
 	} else {
 		// Single file.
-		utils.LogInfo("Installing collection from single file", "file", filepath.Base(path), "path", path)
-		fmt.Printf("Installing collection from file: %s\n", filepath.Base(path))
-		utils.LogCommand(ansibleGalaxy, "collection", "install", path, "-p", collectionsDir)
-		cmd := exec.Command(ansibleGalaxy, "collection", "install", path, "-p", collectionsDir)
-		if err := cmd.Run(); err != nil {
-			utils.LogError("Failed to install collection from file", err, "path", path)
-			return fmt.Errorf("failed to install collection from file: %v", err)
+		if err := checkCollectionArchive(path); err != nil {
+			return err
+		}
+		if _, _, err := selectCollectionArchives(filepath.Dir(path), []string{filepath.Base(path)}, only); err != nil {
+			return err
+		}
+		if _, ok := collectionDecision(path, collectionsDir, force); ok {
+			stageDir, cleanup, err := stageCollectionArchives(owner, filepath.Dir(path), []string{filepath.Base(path)})
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+			if err := installCollectionArchive(owner, ansibleGalaxy, stageDir, filepath.Base(path), collectionsDir, force); err != nil {
+				return err
+			}
+		}
+	}
+	if selectsInfrastructure(only) {
+		if err := verifyInfrastructureCollection(owner, ansibleGalaxy, collectionsDir); err != nil {
+			return err
 		}
 	}
 	utils.LogInfo("Collections installed successfully from path", "path", path)
 	return nil
 }
 
-// InstallCoreVariablesOnline installs core variables by downloading from GitHub.
-func InstallCoreVariablesOnline(userHome string) error {
-	utils.LogInfo("Installing core variables online", "home", userHome)
+// selectCollectionArchives splits the archives of dir into those of the
+// collections named by only and the others, matched against the namespace and
+// name of their MANIFEST.json. Every archive is selected when only is empty.
+// With a filter, an archive whose manifest cannot be read is left out, and a
+// collection of only matching no archive fails.
+func selectCollectionArchives(dir string, archives, only []string) ([]string, []string, error) {
+	if len(only) == 0 {
+		return archives, nil, nil
+	}
+
+	var selected, others, found []string
+	for _, name := range archives {
+		namespace, collection, _, err := utils.ArchiveCollectionInfo(filepath.Join(dir, name))
+		if err != nil {
+			utils.LogWarning("Could not read collection manifest, leaving it out of --only-collections", "archive", name, "error", err)
+			others = append(others, name)
+			continue
+		}
+		if slices.Contains(only, namespace+"."+collection) {
+			selected = append(selected, name)
+			found = append(found, namespace+"."+collection)
+		} else {
+			utils.LogInfo("Collection not selected by --only-collections, skipping", "archive", name, "collection", namespace+"."+collection)
+			others = append(others, name)
+		}
+	}
+	if err := checkSelectedCollections(dir, only, found); err != nil {
+		return nil, nil, err
+	}
+	return selected, others, nil
+}
+
+// checkSelectedCollections fails when a collection of only is not in found, the
+// collections of dir it matched.
+func checkSelectedCollections(dir string, only, found []string) error {
+	var missing []string
+	for _, name := range only {
+		if !slices.Contains(found, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	utils.LogError("Selected collections not found", nil, "path", dir, "missing", missing)
+	return fmt.Errorf("collections %s selected by --only-collections not found in %s", strings.Join(missing, ", "), dir)
+}
+
+// galaxyInstallArgs returns the ansible-galaxy collection install arguments
+// for args, with --force when force is set.
+func galaxyInstallArgs(force bool, args ...string) []string {
+	install := []string{"collection", "install"}
+	if force {
+		install = append(install, "--force")
+	}
+	return append(install, args...)
+}
+
+// Decisions on a collection archive compared with the installed collection.
+const (
+	collectionInstall   = "install"
+	collectionCurrent   = "skip"
+	collectionReinstall = "reinstall"
+	collectionUpgrade   = "upgrade"
+	collectionDowngrade = "downgrade"
+)
+
+// collectionDecision compares the version of the MANIFEST.json of archive with
+// the same collection installed in collectionsDir, logs the decision and
+// reports whether archive is to be installed. Without force an installed
+// collection is kept, as ansible-galaxy only replaces it with --force: a
+// current one is skipped and another version is kept with a warning. An
+// archive whose version cannot be read is installed.
+func collectionDecision(archive, collectionsDir string, force bool) (string, bool) {
+	namespace, name, version, err := utils.ArchiveCollectionInfo(archive)
+	if err != nil {
+		utils.LogWarning("Could not read collection version, installing it", "archive", archive, "error", err)
+		return collectionInstall, true
+	}
+	collection := namespace + "." + name
+	installed, err := utils.InstalledCollectionVersion(filepath.Join(collectionsDir, "ansible_collections", namespace, name))
+	if err != nil {
+		utils.LogInfo("Collection decision", "collection", collection, "version", version, "decision", collectionInstall)
+		return collectionInstall, true
+	}
+
+	decision := collectionReinstall
+	switch utils.CompareVersions(version, installed) {
+	case 1:
+		decision = collectionUpgrade
+	case -1:
+		decision = collectionDowngrade
+	}
+	if !force && decision == collectionReinstall {
+		decision = collectionCurrent
+	}
+	utils.LogInfo("Collection decision", "collection", collection, "version", version, "installed", installed, "decision", decision, "force", force)
+
+	switch {
+	case force:
+		fmt.Printf("Collection %s %s is installed, %s to %s (--force)\n", collection, installed, decision, version)
+		return decision, true
+	case decision == collectionCurrent:
+		fmt.Printf("Collection %s %s is already installed, skipping\n", collection, installed)
+		return decision, false
+	default:
+		utils.LogWarning("Keeping installed collection version, use --force to replace it", "collection", collection, "installed", installed, "archive_version", version)
+		fmt.Printf("Warning: collection %s %s is installed, keeping it instead of %s %s (use --force to replace it)\n", collection, installed, decision, version)
+		return decision, false
+	}
+}
+
+// galaxyRetryPolicy retries online collection installs failing on the network,
+// tests shorten it.
+var galaxyRetryPolicy = utils.RetryPolicy{Retries: 2, Delay: 5 * time.Second}
+
+// installCollectionOnline installs the collection source from the network,
+// retrying when ansible-galaxy fails on the network until ctx is done.
+// ansible-galaxy runs with the TLS configuration of --ca-cert or
+// --insecure-skip-verify.
+func installCollectionOnline(ctx context.Context, owner, ansibleGalaxy, source, collectionsDir string) error {
+	command, args := utils.GalaxyTLSCommand(ansibleGalaxy, "install", source, "-p", collectionsDir)
+	return utils.Retry(ctx, "ansible-galaxy collection install", galaxyRetryPolicy,
+		func(err error) bool { return utils.IsTransientCommandOutput(err.Error()) },
+		func() error {
+			return runAnsibleGalaxy(owner, command, args...)
+		})
+}
+
+// stageCollectionArchives copies the files names of dir, relative paths, into a
+// temporary directory given to owner, as ansible-galaxy runs as owner through
+// su and cannot read archives under a root-only path such as /root. dir itself
+// is returned when owner is empty. The returned function removes the copy.
+func stageCollectionArchives(owner, dir string, names []string) (string, func(), error) {
+	if owner == "" {
+		return dir, func() {}, nil
+	}
+	stageDir, err := os.MkdirTemp(utils.TempDir(), "bluebanquise-archives-")
+	if err != nil {
+		utils.LogError("Failed to create collections staging directory", err)
+		return "", nil, fmt.Errorf("failed to create collections staging directory: %v", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(stageDir); err != nil {
+			utils.LogWarning("Failed to remove collections staging directory", "path", stageDir, "error", err)
+		}
+	}
+
+	utils.LogInfo("Staging collection archives for the home owner", "path", dir, "staging", stageDir, "user", owner)
+	for _, name := range names {
+		target := filepath.Join(stageDir, name)
+		err := os.MkdirAll(filepath.Dir(target), 0755)
+		if err == nil {
+			err = copyFile(filepath.Join(dir, name), target)
+		}
+		if err != nil {
+			cleanup()
+			utils.LogError("Failed to stage collection archive", err, "archive", name, "path", dir)
+			return "", nil, fmt.Errorf("failed to stage collection archive %s: %v", name, err)
+		}
+	}
+	if err := chownTree(stageDir, owner); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return stageDir, cleanup, nil
+}
+
+// installCollectionArchive installs the collection archive name of dir, over
+// the installed version with force.
+func installCollectionArchive(owner, ansibleGalaxy, dir, name, collectionsDir string, force bool) error {
+	file := filepath.Join(dir, name)
+	utils.LogInfo("Installing collection from file", "file", name, "path", file, "force", force)
+	fmt.Printf("Installing collection from file: %s\n", name)
+	if err := runAnsibleGalaxy(owner, ansibleGalaxy, galaxyInstallArgs(force, file, "-p", collectionsDir)...); err != nil {
+		utils.LogError("Failed to install collection from file", err, "file", name, "path", file)
+		return fmt.Errorf("failed to install collection from file %s: %v", name, err)
+	}
+	return nil
+}
+
+// infrastructureCollection is the collection every installation must provide.
+const infrastructureCollection = "bluebanquise.infrastructure"
+
+// selectsInfrastructure reports whether the --only-collections filter
+// installs the infrastructure collection, which is then verified: an empty
+// filter or one naming it.
+func selectsInfrastructure(only []string) bool {
+	return len(only) == 0 || slices.Contains(only, infrastructureCollection)
+}
+
+// verifyInfrastructureCollection lists the infrastructure collection with
+// ansible-galaxy and fails when it is not installed in collectionsDir, so an
+// installation that silently installed nothing is not reported as a success.
+func verifyInfrastructureCollection(owner, ansibleGalaxy, collectionsDir string) error {
+	utils.LogInfo("Verifying installed collections", "collection", infrastructureCollection, "collections_dir", collectionsDir)
+	command, args := GalaxyCommand(owner, ansibleGalaxy, "collection", "list", infrastructureCollection, "-p", collectionsDir)
+	output, err := commandOutput(command, args...)
+	if err == nil && listsCollection(output, infrastructureCollection) {
+		return nil
+	}
+
+	utils.LogError("Collection missing after installation", err, "collection", infrastructureCollection, "collections_dir", collectionsDir, "output", output)
+	if err != nil {
+		return fmt.Errorf("collection %s not found in %s after installation: %v, output: %s", infrastructureCollection, collectionsDir, err, strings.TrimSpace(output))
+	}
+	return fmt.Errorf("collection %s not found in %s after installation, check that the collections path holds its archive", infrastructureCollection, collectionsDir)
+}
+
+// listsCollection reports whether ansible-galaxy collection list output has a
+// line for name.
+func listsCollection(output, name string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCollectionArchive verifies that a collection archive is a readable
+// gzip-compressed tar before ansible-galaxy installs it.
+func checkCollectionArchive(file string) error {
+	if err := utils.CheckArchiveIntegrity(file); err != nil {
+		utils.LogError("Collection archive integrity check failed", err, "path", file)
+		return err
+	}
+	return nil
+}
+
+// copyInstalledCollections copies every collection of an installed
+// ansible_collections tree into collectionsDir, replacing existing copies.
+func copyInstalledCollections(root, collectionsDir string, only []string) error {
+	collections, err := utils.InstalledCollections(root)
+	if err != nil {
+		utils.LogError("Failed to read installed collections", err, "path", root)
+		return fmt.Errorf("failed to read installed collections: %v", err)
+	}
+	if len(only) > 0 {
+		var selected, found []string
+		for _, collection := range collections {
+			name := strings.ReplaceAll(collection, string(filepath.Separator), ".")
+			if slices.Contains(only, name) {
+				selected = append(selected, collection)
+				found = append(found, name)
+			}
+		}
+		if err := checkSelectedCollections(root, only, found); err != nil {
+			return err
+		}
+		collections = selected
+	}
+
+	for _, collection := range collections {
+		dest := filepath.Join(collectionsDir, "ansible_collections", collection)
+		utils.LogInfo("Copying installed collection", "collection", collection, "source", filepath.Join(root, collection), "dest", dest)
+		fmt.Printf("Installing collection from tree: %s\n", strings.ReplaceAll(collection, string(filepath.Separator), "."))
+
+		if err := os.RemoveAll(dest); err != nil {
+			utils.LogError("Failed to remove existing collection", err, "path", dest)
+			return fmt.Errorf("failed to remove existing collection %s: %v", collection, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			utils.LogError("Failed to create collection namespace directory", err, "path", filepath.Dir(dest))
+			return fmt.Errorf("failed to create collection namespace directory: %v", err)
+		}
+		if err := os.CopyFS(dest, os.DirFS(filepath.Join(root, collection))); err != nil {
+			utils.LogError("Failed to copy collection", err, "collection", collection)
+			return fmt.Errorf("failed to copy collection %s: %v", collection, err)
+		}
+	}
+	return nil
+}
+
+// DefaultCoreVarsURL is the core variables file downloaded when no URL is given.
+const DefaultCoreVarsURL = "https://raw.githubusercontent.com/bluebanquise/bluebanquise/refs/heads/master/resources/bb_core.yml"
+
+// defaultCoreVars is the bb_core.yml shipped with the installer, installed when
+// DefaultCoreVarsURL cannot be downloaded.
+//
+//go:embed bluebanquise/inventory/group_vars/all/bb_core.yml
+var defaultCoreVars []byte
+
+// downloadFile downloads core variables, tests replace it to simulate failures.
+var downloadFile = utils.DownloadFileWithRetry
+
+// InstallCoreVariablesOnline installs core variables by downloading each of urls
+// into group_vars/all, or the default bb_core.yml from GitHub when urls is empty.
+// Retries stop once ctx is done.
+func InstallCoreVariablesOnline(ctx context.Context, userHome string, urls []string, opts utils.DownloadOptions) error {
+	utils.LogInfo("Installing core variables online", "home", userHome, "urls", redactURLs(urls))
 
 	// Validate userHome is not empty.
 	if userHome == "" {
@@ -132,9 +538,19 @@ func InstallCoreVariablesOnline(userHome string) error {
 		return fmt.Errorf("user home directory cannot be empty")
 	}
 
+	if len(urls) == 0 {
+		urls = []string{DefaultCoreVarsURL}
+	}
+
+	// Resolve destination file names before downloading anything.
+	fileNames, err := coreVarsFileNames(urls)
+	if err != nil {
+		utils.LogError("Invalid core variables URL", err, "urls", redactURLs(urls))
+		return err
+	}
+
 	// Create inventory directory structure.
-	inventoryDir := filepath.Join(userHome, "bluebanquise", "inventory")
-	groupVarsDir := filepath.Join(inventoryDir, "group_vars", "all")
+	groupVarsDir := GroupVarsAllDir(userHome)
 
 	utils.LogInfo("Creating inventory directory structure", "path", groupVarsDir)
 	if err := os.MkdirAll(groupVarsDir, 0755); err != nil {
@@ -142,58 +558,82 @@ func InstallCoreVariablesOnline(userHome string) error {
 		return fmt.Errorf("failed to create inventory directory: %v", err)
 	}
 
-	// Download bb_core.yml from GitHub.
-	bbCoreURL := "https://raw.githubusercontent.com/bluebanquise/bluebanquise/refs/heads/master/resources/bb_core.yml"
-	bbCorePath := filepath.Join(groupVarsDir, "bb_core.yml")
-
-	utils.LogInfo("Downloading bb_core.yml", "url", bbCoreURL, "path", bbCorePath)
 	fmt.Println("Downloading core variables from GitHub...")
+	for i, coreVarsURL := range urls {
+		destFile := filepath.Join(groupVarsDir, fileNames[i])
+		utils.LogInfo("Downloading core variable file", "url", utils.RedactURL(coreVarsURL), "path", destFile)
+		fmt.Printf("Installing core variable file: %s\n", fileNames[i])
+
+		if err := downloadFile(ctx, coreVarsURL, destFile, CoreVarsDownloadOptions(coreVarsURL, opts)); err != nil {
+			if coreVarsURL != DefaultCoreVarsURL {
+				utils.LogError("Failed to download core variable file", err, "url", utils.RedactURL(coreVarsURL))
+				return fmt.Errorf("failed to download %s: %v", fileNames[i], err)
+			}
+			if err := installDefaultCoreVars(destFile, err); err != nil {
+				return err
+			}
+		}
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	utils.LogInfo("Core variables installed successfully online", "path", groupVarsDir, "files", len(urls))
+	fmt.Println("Core variables installed successfully.")
+	return nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", bbCoreURL, http.NoBody)
-	if err != nil {
-		utils.LogError("Failed to create request", err, "url", bbCoreURL)
-		return fmt.Errorf("failed to create request: %v", err)
+// CoreVarsDownloadOptions returns the mirror credentials and headers of opts
+// for a user supplied core variables URL, and none for DefaultCoreVarsURL: the
+// mirror secrets must not be sent to GitHub.
+func CoreVarsDownloadOptions(coreVarsURL string, opts utils.DownloadOptions) utils.DownloadOptions {
+	if coreVarsURL == DefaultCoreVarsURL {
+		return utils.DownloadOptions{}
 	}
+	return opts
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		utils.LogError("Failed to download bb_core.yml", err, "url", bbCoreURL)
-		return fmt.Errorf("failed to download bb_core.yml: %v", err)
-	}
-	defer func() {
-		if closeErr := resp.Body.Close(); closeErr != nil {
-			utils.LogWarning("Failed to close response body", "error", closeErr)
-		}
-	}()
+// installDefaultCoreVars writes the embedded bb_core.yml to destFile after the
+// download of DefaultCoreVarsURL failed with downloadErr.
+func installDefaultCoreVars(destFile string, downloadErr error) error {
+	utils.LogWarning("Failed to download core variables, installing the embedded default", "url", DefaultCoreVarsURL, "error", downloadErr, "path", destFile)
+	fmt.Printf("Warning: could not download core variables (%v), installing the bb_core.yml shipped with the installer.\n", downloadErr)
+	fmt.Printf("Review %s and update it from %s once GitHub is reachable.\n", destFile, DefaultCoreVarsURL)
 
-	if resp.StatusCode != http.StatusOK {
-		utils.LogError("Failed to download bb_core.yml", nil, "status", resp.StatusCode, "url", bbCoreURL)
-		return fmt.Errorf("failed to download bb_core.yml: HTTP %d", resp.StatusCode)
+	if err := os.WriteFile(destFile, defaultCoreVars, 0644); err != nil {
+		utils.LogError("Failed to write default core variables", err, "path", destFile)
+		return fmt.Errorf("failed to write default core variables: %v", err)
 	}
+	return nil
+}
 
-	file, err := os.Create(bbCorePath)
-	if err != nil {
-		utils.LogError("Failed to create bb_core.yml file", err, "path", bbCorePath)
-		return fmt.Errorf("failed to create bb_core.yml file: %v", err)
+// redactURLs returns urls with their passwords hidden, for logs.
+func redactURLs(urls []string) []string {
+	redacted := make([]string, 0, len(urls))
+	for _, u := range urls {
+		redacted = append(redacted, utils.RedactURL(u))
 	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil {
-			utils.LogWarning("Failed to close file", "error", closeErr)
+	return redacted
+}
+
+// coreVarsFileNames returns the YAML file name of each core variables URL.
+func coreVarsFileNames(urls []string) ([]string, error) {
+	seen := map[string]string{}
+	names := make([]string, 0, len(urls))
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid core variables URL: %s", rawURL)
 		}
-	}()
 
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		utils.LogError("Failed to write bb_core.yml file", err, "path", bbCorePath)
-		return fmt.Errorf("failed to write bb_core.yml file: %v", err)
+		name := path.Base(parsed.Path)
+		if !strings.HasSuffix(name, ".yml") && !strings.HasSuffix(name, ".yaml") {
+			return nil, fmt.Errorf("core variables URL must point to a .yml or .yaml file: %s", rawURL)
+		}
+		if previous, found := seen[name]; found {
+			return nil, fmt.Errorf("core variables URLs %s and %s both install %s", previous, rawURL, name)
+		}
+		seen[name] = rawURL
+		names = append(names, name)
 	}
-
-	utils.LogInfo("Core variables installed successfully online", "path", bbCorePath)
-	fmt.Println("Core variables installed successfully.")
-	return nil
+	return names, nil
 }
 
 // InstallCoreVariablesOffline installs core variables from local path.
@@ -207,8 +647,7 @@ func InstallCoreVariablesOffline(coreVarsPath, userHome string) error {
 	}
 
 	// Create inventory directory structure.
-	inventoryDir := filepath.Join(userHome, "bluebanquise", "inventory")
-	groupVarsDir := filepath.Join(inventoryDir, "group_vars", "all")
+	groupVarsDir := GroupVarsAllDir(userHome)
 
 	utils.LogInfo("Creating inventory directory structure", "path", groupVarsDir)
 	if err := os.MkdirAll(groupVarsDir, 0755); err != nil {
@@ -266,6 +705,23 @@ func InstallCoreVariablesOffline(coreVarsPath, userHome string) error {
 	return nil
 }
 
+// EnsureInventoryDir creates the inventory directory of userHome, for
+// installations where core variables are skipped and nothing else creates it.
+func EnsureInventoryDir(userHome string) error {
+	if userHome == "" {
+		utils.LogError("User home directory is empty", nil)
+		return fmt.Errorf("user home directory cannot be empty")
+	}
+
+	inventoryDir := InventoryDir(userHome)
+	utils.LogInfo("Creating inventory directory", "path", inventoryDir)
+	if err := os.MkdirAll(inventoryDir, 0755); err != nil {
+		utils.LogError("Failed to create inventory directory", err, "path", inventoryDir)
+		return fmt.Errorf("failed to create inventory directory: %v", err)
+	}
+	return nil
+}
+
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -291,29 +747,142 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-// ensureAnsibleGalaxy ensures that ansible-galaxy is available in the virtual environment.
+// ensureAnsibleGalaxy checks that a working ansible-galaxy is available in the
+// virtual environment before installing collections. The environment is never
+// removed or reinstalled here, which would download unpinned packages from
+// PyPI, also on an offline host: a missing or broken ansible-galaxy stops the
+// installation and points to repair --rebuild-venv, which honours
+// --ansible-version and --requirements-path.
 func ensureAnsibleGalaxy(venvDir, ansibleGalaxy string) error {
-	if _, err := os.Stat(ansibleGalaxy); os.IsNotExist(err) {
-		utils.LogInfo("ansible-galaxy not found, creating environment", "path", ansibleGalaxy)
-		fmt.Println("Creating Python environment for collections installation...")
+	output, err := checkAnsibleGalaxy(ansibleGalaxy)
+	if err == nil {
+		return nil
+	}
 
-		// Create virtual environment
-		if err := createVirtualEnvironment(venvDir); err != nil {
-			return fmt.Errorf("failed to create virtual environment: %v", err)
-		}
+	if _, statErr := os.Stat(ansibleGalaxy); statErr != nil {
+		utils.LogError("ansible-galaxy not found", statErr, "path", ansibleGalaxy, "venv", venvDir)
+		return fmt.Errorf("ansible-galaxy not found at %s, configure the environment first (run without --skip-environment) "+
+			"or rebuild it with repair --rebuild-venv", ansibleGalaxy)
+	}
+	utils.LogError("ansible-galaxy is present but broken", err, "path", ansibleGalaxy, "venv", venvDir, "output", output)
+	return fmt.Errorf("ansible-galaxy at %s does not run (%v), rebuild the virtual environment with repair --rebuild-venv "+
+		"(with --requirements-path on an offline host, --ansible-version to keep a pinned release)", ansibleGalaxy, err)
+}
 
-		// Install requirements to get ansible-galaxy
-		utils.LogInfo("Installing Python requirements for ansible-galaxy", "requirements", system.PythonRequirements)
-		if err := utils.InstallRequirements(venvDir, system.PythonRequirements); err != nil {
-			utils.LogError("Failed to install Python packages", err, "venv", venvDir)
-			return fmt.Errorf("failed to install Python packages: %v", err)
-		}
+// geteuid returns the effective user ID of the installer, tests replace it.
+var geteuid = os.Geteuid
+
+// collectionsOwner returns the owner of userHome when the installer runs as root
+// and the home belongs to another user, so ansible-galaxy can run as that user.
+// It returns an empty string when ansible-galaxy should run as the installer.
+func collectionsOwner(userHome string) string {
+	if geteuid() != 0 {
+		return ""
+	}
+
+	info, err := os.Stat(userHome)
+	if err != nil {
+		utils.LogWarning("Could not read home owner, installing collections as root", "home", userHome, "error", err)
+		fmt.Println("Warning: installing collections as root, they will be owned by root")
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Uid == 0 {
+		utils.LogWarning("Home is owned by root, installing collections as root", "home", userHome)
+		fmt.Println("Warning: installing collections as root, they will be owned by root")
+		return ""
+	}
+	owner, err := user.LookupId(strconv.FormatUint(uint64(stat.Uid), 10))
+	if err != nil {
+		utils.LogWarning("Could not resolve home owner, installing collections as root", "home", userHome, "uid", stat.Uid, "error", err)
+		fmt.Println("Warning: installing collections as root, they will be owned by root")
+		return ""
+	}
+	return owner.Username
+}
 
-		// Verify ansible-galaxy exists now
-		if _, err := os.Stat(ansibleGalaxy); os.IsNotExist(err) {
-			utils.LogError("ansible-galaxy still not found after environment setup", err, "path", ansibleGalaxy)
-			return fmt.Errorf("ansible-galaxy not found at %s after environment setup: %v", ansibleGalaxy, err)
+// prepareCollectionsOwner resolves the user ansible-galaxy runs as and gives it
+// the collections directory created by the installer.
+func prepareCollectionsOwner(userHome string) (string, error) {
+	owner := collectionsOwner(userHome)
+	if owner == "" {
+		return "", nil
+	}
+	utils.LogInfo("Installing collections as the home owner", "user", owner, "home", userHome)
+	if err := chownTree(filepath.Dir(CollectionsDir(userHome)), owner); err != nil {
+		return "", err
+	}
+	return owner, nil
+}
+
+// chownTree gives path and everything below it to userName and its primary group.
+func chownTree(path, userName string) error {
+	owner, err := user.Lookup(userName)
+	if err != nil {
+		utils.LogError("Failed to look up user", err, "user", userName)
+		return fmt.Errorf("failed to look up user %s: %v", userName, err)
+	}
+	uid, err := strconv.Atoi(owner.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %s for user %s: %v", owner.Uid, userName, err)
+	}
+	gid, err := strconv.Atoi(owner.Gid)
+	if err != nil {
+		return fmt.Errorf("invalid gid %s for user %s: %v", owner.Gid, userName, err)
+	}
+
+	err = filepath.WalkDir(path, func(name string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
+		return os.Lchown(name, uid, gid)
+	})
+	if err != nil {
+		utils.LogError("Failed to change ownership", err, "path", path, "user", userName)
+		return fmt.Errorf("failed to change ownership of %s to %s: %v", path, userName, err)
 	}
 	return nil
 }
+
+// GalaxyCommand returns the command running ansible-galaxy with args as owner
+// through su and runAsShell, or directly when owner is empty.
+func GalaxyCommand(owner, ansibleGalaxy string, args ...string) (string, []string) {
+	if owner == "" {
+		return ansibleGalaxy, args
+	}
+	quoted := []string{shellQuote(ansibleGalaxy)}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return runAsCommand(owner, "exec "+strings.Join(quoted, " "))
+}
+
+// runAnsibleGalaxy runs ansible-galaxy with args as owner, or as the installer
+// when owner is empty. Its output is logged, included in the returned error on
+// failure and only printed on success in verbose mode.
+func runAnsibleGalaxy(owner, ansibleGalaxy string, args ...string) error {
+	command, commandArgs := GalaxyCommand(owner, ansibleGalaxy, args...)
+	output, err := commandOutput(command, commandArgs...)
+	if err != nil {
+		utils.LogError("ansible-galaxy failed", err, "args", args, "output", output)
+		return fmt.Errorf("%v, output: %s", err, strings.TrimSpace(output))
+	}
+
+	utils.LogInfo("ansible-galaxy completed", "args", args, "output", output)
+	if utils.Verbose() {
+		fmt.Print(output)
+	}
+	return nil
+}
+
+// checkAnsibleGalaxy runs ansible-galaxy --version as a smoke test and returns its output.
+func checkAnsibleGalaxy(ansibleGalaxy string) (string, error) {
+	if _, err := os.Stat(ansibleGalaxy); err != nil {
+		return "", err
+	}
+	output, err := commandOutput(ansibleGalaxy, "--version")
+	if err != nil {
+		return output, fmt.Errorf("ansible-galaxy --version failed: %v", err)
+	}
+	return output, nil
+}
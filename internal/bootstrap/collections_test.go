@@ -1,8 +1,17 @@
 package bootstrap
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
@@ -16,6 +25,13 @@ func init() {
 }
 
 func TestInstallCoreVariablesOnline(t *testing.T) {
+	// A single attempt, retries are covered by the utils tests
+	original := downloadFile
+	defer func() { downloadFile = original }()
+	downloadFile = func(_ context.Context, url, path string, opts utils.DownloadOptions) error {
+		return utils.DownloadFile(url, path, opts)
+	}
+
 	tests := []struct {
 		name        string
 		userHome    string
@@ -43,7 +59,7 @@ func TestInstallCoreVariablesOnline(t *testing.T) {
 				}()
 			}
 
-			err := InstallCoreVariablesOnline(tt.userHome)
+			err := InstallCoreVariablesOnline(context.Background(), tt.userHome, nil, utils.DownloadOptions{})
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -222,3 +238,745 @@ func TestCopyFile(t *testing.T) {
 		})
 	}
 }
+
+func TestInstallCoreVariablesOnlineMultipleURLs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vars/bb_core.yml":
+			_, _ = w.Write([]byte("bb_core_iceberg_naming: 'iceberg'\n"))
+		case "/vars/bb_network.yaml":
+			_, _ = w.Write([]byte("bb_network: true\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("Download every file", func(t *testing.T) {
+		userHome := t.TempDir()
+		urls := []string{server.URL + "/vars/bb_core.yml", server.URL + "/vars/bb_network.yaml"}
+		require.NoError(t, InstallCoreVariablesOnline(context.Background(), userHome, urls, utils.DownloadOptions{}))
+
+		groupVarsDir := GroupVarsAllDir(userHome)
+		data, err := os.ReadFile(filepath.Join(groupVarsDir, "bb_core.yml"))
+		require.NoError(t, err)
+		assert.Equal(t, "bb_core_iceberg_naming: 'iceberg'\n", string(data))
+		data, err = os.ReadFile(filepath.Join(groupVarsDir, "bb_network.yaml"))
+		require.NoError(t, err)
+		assert.Equal(t, "bb_network: true\n", string(data))
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		userHome := t.TempDir()
+		err := InstallCoreVariablesOnline(context.Background(), userHome, []string{server.URL + "/vars/missing.yml"}, utils.DownloadOptions{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Duplicate file names", func(t *testing.T) {
+		userHome := t.TempDir()
+		err := InstallCoreVariablesOnline(context.Background(), userHome, []string{server.URL + "/a/bb_core.yml", server.URL + "/b/bb_core.yml"}, utils.DownloadOptions{})
+		assert.Error(t, err)
+		assert.NoDirExists(t, GroupVarsAllDir(userHome))
+	})
+
+	t.Run("Not a YAML file", func(t *testing.T) {
+		userHome := t.TempDir()
+		err := InstallCoreVariablesOnline(context.Background(), userHome, []string{server.URL + "/vars/"}, utils.DownloadOptions{})
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckAnsibleGalaxy(t *testing.T) {
+	venvBin := t.TempDir()
+	ansibleGalaxy := filepath.Join(venvBin, "ansible-galaxy")
+	require.NoError(t, os.WriteFile(ansibleGalaxy, nil, 0755))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	tests := []struct {
+		name        string
+		path        string
+		output      string
+		runErr      error
+		expectRun   bool
+		expectError bool
+	}{
+		{name: "Working ansible-galaxy", path: ansibleGalaxy, output: "ansible-galaxy [core 2.16.6]", expectRun: true},
+		{name: "Broken ansible-galaxy", path: ansibleGalaxy, output: "ModuleNotFoundError: No module named 'ansible'", runErr: errors.New("exit status 1"), expectRun: true, expectError: true},
+		{name: "Missing ansible-galaxy", path: filepath.Join(venvBin, "missing"), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls [][]string
+			commandOutput = func(command string, args ...string) (string, error) {
+				calls = append(calls, append([]string{command}, args...))
+				return tt.output, tt.runErr
+			}
+
+			output, err := checkAnsibleGalaxy(tt.path)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.expectRun {
+				assert.Equal(t, [][]string{{tt.path, "--version"}}, calls)
+				assert.Equal(t, tt.output, output)
+			} else {
+				assert.Empty(t, calls)
+			}
+		})
+	}
+
+	t.Run("Working environment is not rebuilt", func(t *testing.T) {
+		commandOutput = func(command string, args ...string) (string, error) {
+			return "ansible-galaxy [core 2.16.6]", nil
+		}
+		assert.NoError(t, ensureAnsibleGalaxy(filepath.Dir(venvBin), ansibleGalaxy))
+		assert.FileExists(t, ansibleGalaxy)
+	})
+
+	t.Run("Broken environment is kept", func(t *testing.T) {
+		var calls [][]string
+		commandOutput = func(command string, args ...string) (string, error) {
+			calls = append(calls, append([]string{command}, args...))
+			return "ModuleNotFoundError: No module named 'ansible'", errors.New("exit status 1")
+		}
+		err := ensureAnsibleGalaxy(filepath.Dir(venvBin), ansibleGalaxy)
+		assert.ErrorContains(t, err, "repair --rebuild-venv")
+		assert.FileExists(t, ansibleGalaxy)
+		assert.Equal(t, [][]string{{ansibleGalaxy, "--version"}}, calls, "only the smoke test runs")
+	})
+
+	t.Run("Missing ansible-galaxy is not installed", func(t *testing.T) {
+		var calls [][]string
+		commandOutput = func(command string, args ...string) (string, error) {
+			calls = append(calls, append([]string{command}, args...))
+			return "", nil
+		}
+		venvDir := filepath.Join(t.TempDir(), "ansible_venv")
+		err := ensureAnsibleGalaxy(venvDir, filepath.Join(venvDir, "bin", "ansible-galaxy"))
+		assert.ErrorContains(t, err, "ansible-galaxy not found")
+		assert.NoDirExists(t, venvDir)
+		assert.Empty(t, calls)
+	})
+}
+
+func TestCopyInstalledCollections(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "ansible_collections")
+	source := filepath.Join(root, "bluebanquise", "infrastructure")
+	require.NoError(t, os.MkdirAll(filepath.Join(source, "roles", "nic"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "MANIFEST.json"), []byte("{}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(source, "roles", "nic", "main.yml"), []byte("---\n"), 0644))
+
+	collectionsDir := t.TempDir()
+	stale := filepath.Join(collectionsDir, "ansible_collections", "bluebanquise", "infrastructure", "stale.yml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(stale), 0755))
+	require.NoError(t, os.WriteFile(stale, nil, 0644))
+
+	require.NoError(t, copyInstalledCollections(root, collectionsDir, nil))
+
+	dest := filepath.Join(collectionsDir, "ansible_collections", "bluebanquise", "infrastructure")
+	assert.FileExists(t, filepath.Join(dest, "MANIFEST.json"))
+	assert.FileExists(t, filepath.Join(dest, "roles", "nic", "main.yml"))
+	assert.NoFileExists(t, stale)
+
+	err := copyInstalledCollections(root, collectionsDir, []string{"community.general"})
+	assert.ErrorContains(t, err, "community.general selected by --only-collections not found")
+}
+
+func TestInstallCollectionsFromPathGalaxyOutput(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	writeCollectionArchive(t, filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	galaxyOutput := "ERROR! Unexpected Exception: not a valid collection artifact"
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) == 1 && args[0] == "--version" {
+			return "ansible-galaxy [core 2.16.6]", nil
+		}
+		return galaxyOutput + "\n", errors.New("exit status 1")
+	}
+
+	err := InstallCollectionsFromPath(collectionsPath, userHome, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 1")
+	assert.Contains(t, err.Error(), galaxyOutput)
+
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) > 1 && args[1] == "list" {
+			return galaxyListFixture, nil
+		}
+		return "Installing 'bluebanquise.infrastructure:3.0.0'", nil
+	}
+	assert.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, false, nil))
+}
+
+func TestInstallCollectionsOnlineRetry(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	original, originalPolicy := commandOutput, galaxyRetryPolicy
+	defer func() { commandOutput, galaxyRetryPolicy = original, originalPolicy }()
+	galaxyRetryPolicy.Delay = 0
+
+	tests := []struct {
+		name         string
+		failure      string
+		wantInstalls int
+		wantErr      bool
+	}{
+		// Two failed attempts of the infrastructure collection, then both collections
+		{name: "Network failure is retried", failure: "fatal: unable to access 'https://github.com/': Could not resolve host: github.com", wantInstalls: 4},
+		{name: "Other failure is not retried", failure: "ERROR! Unexpected Exception: not a valid collection artifact", wantInstalls: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			installs, failures := 0, 2
+			commandOutput = func(command string, args ...string) (string, error) {
+				switch {
+				case len(args) > 1 && args[1] == "list":
+					return galaxyListFixture, nil
+				case len(args) > 1 && args[1] == "install":
+					installs++
+					if installs <= failures {
+						return tt.failure, errors.New("exit status 1")
+					}
+				}
+				return "ansible-galaxy [core 2.16.6]", nil
+			}
+
+			err := InstallCollectionsOnline(context.Background(), userHome)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantInstalls, installs)
+		})
+	}
+}
+
+// galaxyListFixture is the ansible-galaxy collection list output of an installed
+// infrastructure collection.
+const galaxyListFixture = `
+# /var/lib/bluebanquise/.ansible/collections/ansible_collections
+Collection                  Version
+--------------------------- -------
+bluebanquise.infrastructure 3.0.0
+`
+
+func TestInstallCollectionsFromPathVerifiesCollection(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	writeCollectionArchive(t, filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	tests := []struct {
+		name       string
+		listOutput string
+		listErr    error
+		wantErr    string
+	}{
+		{name: "Collection installed", listOutput: galaxyListFixture},
+		{
+			name:       "Collection missing",
+			listOutput: "# /var/lib/bluebanquise/.ansible/collections/ansible_collections\nCollection        Version\ncommunity.general 9.0.0\n",
+			wantErr:    "collection bluebanquise.infrastructure not found",
+		},
+		{
+			name:       "List fails",
+			listOutput: "ERROR! - None of the provided paths were usable.",
+			listErr:    errors.New("exit status 1"),
+			wantErr:    "None of the provided paths were usable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listArgs []string
+			commandOutput = func(command string, args ...string) (string, error) {
+				if len(args) > 1 && args[1] == "list" {
+					listArgs = args
+					return tt.listOutput, tt.listErr
+				}
+				return "ansible-galaxy [core 2.16.6]", nil
+			}
+
+			err := InstallCollectionsFromPath(collectionsPath, userHome, false, nil)
+			assert.Equal(t, []string{"collection", "list", "bluebanquise.infrastructure", "-p", CollectionsDir(userHome)}, listArgs)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestInstallCollectionsFromPathOnlyCollectionsVerification(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	writeVersionedCollectionArchive(t, filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "community", "general", "9.0.0")
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+	listed := false
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) > 1 && args[1] == "list" {
+			listed = true
+			return "", errors.New("exit status 1")
+		}
+		return "ansible-galaxy [core 2.16.6]", nil
+	}
+
+	// A filter leaving out the infrastructure collection does not verify it
+	require.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, false, []string{"community.general"}))
+	assert.False(t, listed)
+
+	assert.True(t, selectsInfrastructure(nil))
+	assert.True(t, selectsInfrastructure([]string{"community.general", "bluebanquise.infrastructure"}))
+	assert.False(t, selectsInfrastructure([]string{"community.general"}))
+}
+
+func TestInstallCollectionsFromPathCorruptArchive(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	writeCollectionArchive(t, filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"))
+	truncated := filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz")
+	writeCollectionArchive(t, truncated)
+	info, err := os.Stat(truncated)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(truncated, info.Size()/2))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	var installed []string
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) == 1 && args[0] == "--version" {
+			return "ansible-galaxy [core 2.16.6]", nil
+		}
+		installed = append(installed, args...)
+		return "", nil
+	}
+
+	err = InstallCollectionsFromPath(collectionsPath, userHome, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "corrupt archive: "+truncated)
+	assert.Empty(t, installed, "no archive must be installed")
+
+	err = InstallCollectionsFromPath(truncated, userHome, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "corrupt archive: "+truncated)
+}
+
+// writeCollectionArchive writes a small .tar.gz collection archive to path.
+func writeCollectionArchive(t *testing.T, path string) {
+	t.Helper()
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "MANIFEST.json"), []byte(strings.Repeat("{}", 4096)), 0644))
+	require.NoError(t, exec.Command("tar", "-czf", path, "-C", source, ".").Run())
+}
+
+func TestGalaxyCommand(t *testing.T) {
+	tests := []struct {
+		name            string
+		owner           string
+		expectedCommand string
+		expectedArgs    []string
+	}{
+		{
+			name:            "Current user",
+			expectedCommand: "/var/lib/bluebanquise/ansible_venv/bin/ansible-galaxy",
+			expectedArgs:    []string{"collection", "install", "/tmp/it's here.tar.gz", "-p", "/var/lib/bluebanquise/.ansible/collections"},
+		},
+		{
+			name:            "Target user",
+			owner:           "bluebanquise",
+			expectedCommand: "su",
+			expectedArgs: []string{"-s", "/bin/sh", "-", "bluebanquise", "-c",
+				`exec '/var/lib/bluebanquise/ansible_venv/bin/ansible-galaxy' 'collection' 'install' '/tmp/it'\''s here.tar.gz' '-p' '/var/lib/bluebanquise/.ansible/collections'`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, args := GalaxyCommand(tt.owner, "/var/lib/bluebanquise/ansible_venv/bin/ansible-galaxy",
+				"collection", "install", "/tmp/it's here.tar.gz", "-p", "/var/lib/bluebanquise/.ansible/collections")
+			assert.Equal(t, tt.expectedCommand, command)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestCollectionsOwner(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := geteuid
+	defer func() { geteuid = original }()
+
+	userHome := t.TempDir()
+
+	geteuid = func() int { return 1000 }
+	assert.Empty(t, collectionsOwner(userHome), "not running as root")
+
+	geteuid = func() int { return 0 }
+	assert.Empty(t, collectionsOwner(filepath.Join(userHome, "missing")), "missing home")
+
+	if os.Geteuid() != 0 {
+		t.Skip("changing the home owner requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("nobody user not available")
+	}
+	require.NoError(t, os.Chown(userHome, 0, 0))
+	assert.Empty(t, collectionsOwner(userHome), "home owned by root")
+
+	uid, err := strconv.Atoi(nobody.Uid)
+	require.NoError(t, err)
+	require.NoError(t, os.Chown(userHome, uid, 0))
+	assert.Equal(t, "nobody", collectionsOwner(userHome))
+
+	require.NoError(t, os.MkdirAll(CollectionsDir(userHome), 0755))
+	owner, err := prepareCollectionsOwner(userHome)
+	require.NoError(t, err)
+	assert.Equal(t, "nobody", owner)
+
+	info, err := os.Stat(CollectionsDir(userHome))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(uid), info.Sys().(*syscall.Stat_t).Uid)
+}
+
+func TestStageCollectionArchives(t *testing.T) {
+	utils.InitTestLogger()
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.tar.gz"), []byte("a"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "b.tar.gz"), []byte("b"), 0600))
+
+	stageDir, cleanup, err := stageCollectionArchives("", dir, []string{"a.tar.gz"})
+	require.NoError(t, err)
+	cleanup()
+	assert.Equal(t, dir, stageDir, "no owner uses the archives in place")
+
+	if os.Geteuid() != 0 {
+		t.Skip("staging for another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("nobody user not available")
+	}
+	uid, err := strconv.Atoi(nobody.Uid)
+	require.NoError(t, err)
+
+	stageDir, cleanup, err = stageCollectionArchives("nobody", dir, []string{"a.tar.gz", filepath.Join("sub", "b.tar.gz")})
+	require.NoError(t, err)
+	assert.NotEqual(t, dir, stageDir)
+	for _, name := range []string{"", "a.tar.gz", "sub", filepath.Join("sub", "b.tar.gz")} {
+		info, err := os.Stat(filepath.Join(stageDir, name))
+		require.NoError(t, err)
+		assert.Equal(t, uint32(uid), info.Sys().(*syscall.Stat_t).Uid, name)
+	}
+	content, err := os.ReadFile(filepath.Join(stageDir, "sub", "b.tar.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(content))
+
+	cleanup()
+	assert.NoDirExists(t, stageDir)
+}
+
+func TestInstallCoreVariablesOnlineEmbeddedFallback(t *testing.T) {
+	original := downloadFile
+	defer func() { downloadFile = original }()
+
+	var downloaded []string
+	downloadFile = func(_ context.Context, url, path string, opts utils.DownloadOptions) error {
+		downloaded = append(downloaded, url)
+		return &utils.DownloadError{StatusCode: http.StatusTooManyRequests}
+	}
+
+	userHome := t.TempDir()
+	require.NoError(t, InstallCoreVariablesOnline(context.Background(), userHome, nil, utils.DownloadOptions{}))
+	assert.Equal(t, []string{DefaultCoreVarsURL}, downloaded)
+
+	content, err := os.ReadFile(filepath.Join(GroupVarsAllDir(userHome), "bb_core.yml"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, content)
+	assert.Equal(t, defaultCoreVars, content)
+
+	// Custom URLs have no fallback
+	err = InstallCoreVariablesOnline(context.Background(), t.TempDir(), []string{"https://mirror.example.com/site.yml"}, utils.DownloadOptions{})
+	assert.ErrorContains(t, err, "failed to download site.yml")
+}
+
+func TestInstallCoreVariablesOnlineMirrorCredentials(t *testing.T) {
+	original := downloadFile
+	defer func() { downloadFile = original }()
+
+	requests := map[string]http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path] = r.Header.Clone()
+		_, _ = w.Write([]byte("---\n"))
+	}))
+	defer server.Close()
+
+	// DefaultCoreVarsURL is served by the test server, with the options it was given
+	downloadFile = func(_ context.Context, url, path string, opts utils.DownloadOptions) error {
+		if url == DefaultCoreVarsURL {
+			url = server.URL + "/default/bb_core.yml"
+		}
+		return utils.DownloadFile(url, path, opts)
+	}
+
+	mirror := utils.DownloadOptions{Username: "admin", Password: "secret", Headers: map[string]string{"X-Token": "token"}}
+	require.NoError(t, InstallCoreVariablesOnline(context.Background(), t.TempDir(), nil, mirror))
+	require.NoError(t, InstallCoreVariablesOnline(context.Background(), t.TempDir(), []string{server.URL + "/mirror/site.yml"}, mirror))
+
+	require.Contains(t, requests, "/default/bb_core.yml")
+	assert.Empty(t, requests["/default/bb_core.yml"].Get("Authorization"))
+	assert.Empty(t, requests["/default/bb_core.yml"].Get("X-Token"))
+
+	require.Contains(t, requests, "/mirror/site.yml")
+	assert.NotEmpty(t, requests["/mirror/site.yml"].Get("Authorization"))
+	assert.Equal(t, "token", requests["/mirror/site.yml"].Get("X-Token"))
+}
+
+func TestEnsureInventoryDir(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, EnsureInventoryDir(userHome))
+	assert.DirExists(t, InventoryDir(userHome))
+	assert.NoFileExists(t, filepath.Join(GroupVarsAllDir(userHome), "bb_core.yml"))
+
+	// Existing directories are kept
+	require.NoError(t, EnsureInventoryDir(userHome))
+
+	assert.Error(t, EnsureInventoryDir(""))
+}
+
+// writeVersionedCollectionArchive writes a collection archive whose
+// MANIFEST.json names namespace.name at version.
+func writeVersionedCollectionArchive(t *testing.T, path, namespace, name, version string) {
+	t.Helper()
+	source := t.TempDir()
+	manifest := `{"collection_info": {"namespace": "` + namespace + `", "name": "` + name + `", "version": "` + version + `"}}`
+	require.NoError(t, os.WriteFile(filepath.Join(source, "MANIFEST.json"), []byte(manifest), 0644))
+	require.NoError(t, exec.Command("tar", "-czf", path, "-C", source, ".").Run())
+}
+
+// writeInstalledCollection installs a MANIFEST.json of namespace.name at
+// version under collectionsDir.
+func writeInstalledCollection(t *testing.T, collectionsDir, namespace, name, version string) {
+	t.Helper()
+	dir := filepath.Join(collectionsDir, "ansible_collections", namespace, name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	manifest := `{"collection_info": {"namespace": "` + namespace + `", "name": "` + name + `", "version": "` + version + `"}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "MANIFEST.json"), []byte(manifest), 0644))
+}
+
+func TestCollectionDecision(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name         string
+		installed    string
+		force        bool
+		wantDecision string
+		wantInstall  bool
+	}{
+		{name: "Not installed", wantDecision: collectionInstall, wantInstall: true},
+		{name: "Current version", installed: "3.0.0", wantDecision: collectionCurrent},
+		{name: "Current version with force", installed: "3.0.0", force: true, wantDecision: collectionReinstall, wantInstall: true},
+		{name: "Older version", installed: "2.4.1", wantDecision: collectionUpgrade},
+		{name: "Older version with force", installed: "2.4.1", force: true, wantDecision: collectionUpgrade, wantInstall: true},
+		{name: "Newer version", installed: "3.1.0", wantDecision: collectionDowngrade},
+		{name: "Newer version with force", installed: "3.1.0", force: true, wantDecision: collectionDowngrade, wantInstall: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collectionsDir := t.TempDir()
+			if tt.installed != "" {
+				writeInstalledCollection(t, collectionsDir, "bluebanquise", "infrastructure", tt.installed)
+			}
+			archive := filepath.Join(t.TempDir(), "bluebanquise-infrastructure-3.0.0.tar.gz")
+			writeVersionedCollectionArchive(t, archive, "bluebanquise", "infrastructure", "3.0.0")
+
+			decision, install := collectionDecision(archive, collectionsDir, tt.force)
+			assert.Equal(t, tt.wantDecision, decision)
+			assert.Equal(t, tt.wantInstall, install)
+		})
+	}
+
+	t.Run("Unreadable manifest", func(t *testing.T) {
+		collectionsDir := t.TempDir()
+		writeInstalledCollection(t, collectionsDir, "bluebanquise", "infrastructure", "3.0.0")
+		archive := filepath.Join(t.TempDir(), "bluebanquise-infrastructure-3.0.0.tar.gz")
+		writeCollectionArchive(t, archive)
+
+		decision, install := collectionDecision(archive, collectionsDir, false)
+		assert.Equal(t, collectionInstall, decision)
+		assert.True(t, install)
+	})
+}
+
+func TestInstallCollectionsFromPathInstalledVersion(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+	writeInstalledCollection(t, CollectionsDir(userHome), "bluebanquise", "infrastructure", "3.0.0")
+
+	collectionsPath := t.TempDir()
+	writeVersionedCollectionArchive(t, filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"), "bluebanquise", "infrastructure", "3.0.0")
+	writeVersionedCollectionArchive(t, filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "community", "general", "9.0.0")
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	var installs [][]string
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) > 1 && args[1] == "list" {
+			return galaxyListFixture, nil
+		}
+		if len(args) > 1 && args[1] == "install" {
+			installs = append(installs, args)
+		}
+		return "ansible-galaxy [core 2.16.6]", nil
+	}
+
+	require.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, false, nil))
+	assert.Equal(t, [][]string{
+		{"collection", "install", filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "-p", CollectionsDir(userHome)},
+	}, installs, "the current collection is skipped")
+
+	installs = nil
+	require.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, true, nil))
+	assert.Equal(t, [][]string{
+		{"collection", "install", "--force", filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"), "-p", CollectionsDir(userHome)},
+		{"collection", "install", "--force", filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "-p", CollectionsDir(userHome)},
+	}, installs, "force reinstalls the current collection")
+}
+
+func TestSelectCollectionArchives(t *testing.T) {
+	utils.InitTestLogger()
+
+	dir := t.TempDir()
+	writeVersionedCollectionArchive(t, filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz"), "bluebanquise", "infrastructure", "3.0.0")
+	writeVersionedCollectionArchive(t, filepath.Join(dir, "community-general-9.0.0.tar.gz"), "community", "general", "9.0.0")
+	// The archive name does not matter, its MANIFEST.json does
+	writeVersionedCollectionArchive(t, filepath.Join(dir, "renamed.tar.gz"), "ansible", "posix", "1.5.4")
+	writeCollectionArchive(t, filepath.Join(dir, "no-manifest.tar.gz"))
+	archives := []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-9.0.0.tar.gz", "renamed.tar.gz", "no-manifest.tar.gz"}
+
+	tests := []struct {
+		name         string
+		only         []string
+		wantSelected []string
+		wantOthers   []string
+		wantErr      string
+	}{
+		{
+			name:         "No filter selects every archive",
+			wantSelected: archives,
+		},
+		{
+			name:         "One collection",
+			only:         []string{"bluebanquise.infrastructure"},
+			wantSelected: []string{"bluebanquise-infrastructure-3.0.0.tar.gz"},
+			wantOthers:   []string{"community-general-9.0.0.tar.gz", "renamed.tar.gz", "no-manifest.tar.gz"},
+		},
+		{
+			name:         "Matched by manifest",
+			only:         []string{"ansible.posix", "community.general"},
+			wantSelected: []string{"community-general-9.0.0.tar.gz", "renamed.tar.gz"},
+			wantOthers:   []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "no-manifest.tar.gz"},
+		},
+		{
+			name:    "Name of an archive is not a collection",
+			only:    []string{"renamed.tar"},
+			wantErr: "collections renamed.tar selected by --only-collections not found",
+		},
+		{
+			name:    "Missing collection",
+			only:    []string{"bluebanquise.infrastructure", "community.crypto"},
+			wantErr: "collections community.crypto selected by --only-collections not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, others, err := selectCollectionArchives(dir, archives, tt.only)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSelected, selected)
+			assert.Equal(t, tt.wantOthers, others)
+		})
+	}
+}
+
+func TestInstallCollectionsFromPathOnlyCollections(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	writeVersionedCollectionArchive(t, filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"), "bluebanquise", "infrastructure", "3.0.0")
+	writeVersionedCollectionArchive(t, filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "community", "general", "9.0.0")
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	var installs [][]string
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) > 1 && args[1] == "list" {
+			return galaxyListFixture, nil
+		}
+		if len(args) > 1 && args[1] == "install" {
+			installs = append(installs, args)
+		}
+		return "ansible-galaxy [core 2.16.6]", nil
+	}
+
+	require.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, false, []string{"bluebanquise.infrastructure"}))
+	assert.Equal(t, [][]string{
+		{"collection", "install", filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"), "-p", CollectionsDir(userHome)},
+	}, installs)
+
+	installs = nil
+	err := InstallCollectionsFromPath(collectionsPath, userHome, false, []string{"community.crypto"})
+	assert.ErrorContains(t, err, "community.crypto selected by --only-collections not found")
+	assert.Empty(t, installs, "nothing is installed when a selected collection is missing")
+}
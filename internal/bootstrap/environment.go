@@ -1,25 +1,40 @@
 package bootstrap
 
 import (
+	"bytes"
+	"context"
+	_ "embed"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
+	"text/template"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/system"
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 )
 
-const (
-	defaultPythonCmd = "/usr/bin/python3"
-	rhelOSID         = "rhel"
-)
+const rhelOSID = "rhel"
+
+//go:embed templates/env_wrapper.tmpl
+var envWrapperTemplate string
+
+// commandOutput runs a command and returns its combined output. Tests replace
+// it since the real commands need a full installation.
+var commandOutput = func(command string, args ...string) (string, error) {
+	utils.LogCommand(command, args...)
+	output, err := exec.Command(command, args...).CombinedOutput()
+	return string(output), err
+}
 
 // ConfigureEnvironment sets up the BlueBanquise Python virtual environment and required env vars.
-func ConfigureEnvironment(userName, userHome, collectionsPath string) error {
+// A non-empty ansibleVersion pins the installed ansible release. Retries of the
+// package and pip installs stop once ctx is done.
+func ConfigureEnvironment(ctx context.Context, userName, userHome, collectionsPath, sudoersMode, ansibleVersion string) error {
 	utils.LogInfo("Configuring BlueBanquise environment", "user", userName, "home", userHome)
 
-	venvDir := filepath.Join(userHome, "ansible_venv")
-	bashrc := filepath.Join(userHome, ".bashrc")
+	venvDir := VenvDir(userHome)
 
 	osID, version, err := system.DetectOS()
 	if err != nil {
@@ -48,44 +63,28 @@ func ConfigureEnvironment(userName, userHome, collectionsPath string) error {
 	}
 
 	// Find packages for this OS
-	var packages []string
-	for _, pkg := range system.DependenciePackages {
-		if pkg.OSID == osID && pkg.Version == version {
-			packages = pkg.Packages
-			break
-		}
-	}
-
-	if len(packages) == 0 {
-		utils.LogError("No package definition found", nil, "os", osID, "version", version)
-		return fmt.Errorf("no package definition found for %s %s", osID, version)
+	definition, err := system.PackagesFor(osID, version)
+	if err != nil {
+		utils.LogError("No package definition found", err, "os", osID, "version", version)
+		return err
 	}
+	packages := definition.Packages
 
 	// Install system packages
 	utils.LogInfo("Installing system packages for virtual environment", "packages", packages)
-	if err := utils.InstallPackages(packages); err != nil {
+	if err := utils.InstallPackages(ctx, packages); err != nil {
 		utils.LogError("Failed to install system packages", err, "packages", packages)
 		return fmt.Errorf("failed to install system packages: %v", err)
 	}
 
-	// Determine Python command based on OS
-	var pythonCmd string
-	switch osID {
-	case rhelOSID:
-		switch version {
-		case "7":
-			pythonCmd = "/opt/rh/rh-python38/root/usr/bin/python3"
-		case "8":
-			pythonCmd = "/usr/bin/python3.9"
-		case "9":
-			pythonCmd = "/usr/bin/python3.12"
-		default:
-			pythonCmd = "/usr/bin/python3"
-		}
-	case "opensuse-leap":
-		pythonCmd = "/usr/bin/python3.11"
-	default:
-		pythonCmd = defaultPythonCmd
+	// Determine Python command based on OS and make sure it can create a venv
+	pythonCmd := system.PythonCommandFor(osID, version)
+	if err := system.CheckPythonVersion(pythonCmd, osID, version); err != nil {
+		utils.LogError("Unsupported Python version", err, "python_cmd", pythonCmd)
+		return err
+	}
+	if err := checkVenvModule(osID, pythonCmd); err != nil {
+		return err
 	}
 
 	utils.LogCommand(pythonCmd, "-m", "venv", venvDir)
@@ -94,30 +93,28 @@ func ConfigureEnvironment(userName, userHome, collectionsPath string) error {
 		return fmt.Errorf("failed to create virtualenv: %v", err)
 	}
 
-	utils.LogInfo("Installing Python requirements", "requirements", system.PythonRequirements)
-	if err := utils.InstallRequirements(venvDir, system.PythonRequirements); err != nil {
+	requirements, err := utils.PinAnsibleRequirements(system.PythonRequirements, ansibleVersion)
+	if err != nil {
+		utils.LogError("Invalid ansible version", err, "ansible_version", ansibleVersion)
+		return err
+	}
+
+	utils.LogInfo("Installing Python requirements", "requirements", requirements)
+	if err := utils.InstallRequirements(ctx, venvDir, requirements); err != nil {
 		utils.LogError("Failed to install Python packages", err, "venv", venvDir)
 		return fmt.Errorf("failed to install Python packages: %v", err)
 	}
-
-	// Add to .bashrc
-	utils.LogInfo("Updating .bashrc with environment variables", "file", bashrc)
-	exportLines := []string{
-		fmt.Sprintf("source %s", filepath.Join(venvDir, "bin", "activate")),
-		"export ANSIBLE_CONFIG=$HOME/bluebanquise/ansible.cfg",
+	if err := checkInstalledRequirements(venvDir); err != nil {
+		return err
 	}
-	for _, line := range exportLines {
-		if err := utils.AppendLineIfMissing(bashrc, line); err != nil {
-			utils.LogError("Failed to update .bashrc", err, "line", line)
-			return fmt.Errorf("failed to update .bashrc: %v", err)
-		}
+
+	if err := configureActivation(userName, userHome, venvDir); err != nil {
+		return err
 	}
 
 	// Ensure sudoers has PYTHONPATH preserved
-	utils.LogInfo("Updating sudoers to preserve PYTHONPATH")
-	if err := utils.EnsureLineInSudoers(`Defaults env_keep += "PYTHONPATH"`); err != nil {
-		utils.LogError("Failed to update sudoers", err)
-		return fmt.Errorf("failed to update sudoers: %v", err)
+	if err := ensureSudoersEnvKeep(sudoersMode); err != nil {
+		return err
 	}
 
 	// Configure SSH
@@ -129,7 +126,7 @@ func ConfigureEnvironment(userName, userHome, collectionsPath string) error {
 	}
 
 	// Create bluebanquise directory for ansible.cfg
-	bluebanquiseDir := filepath.Join(userHome, "bluebanquise")
+	bluebanquiseDir := BluebanquiseDir(userHome)
 	utils.LogInfo("Creating bluebanquise directory", "path", bluebanquiseDir)
 	if err := os.MkdirAll(bluebanquiseDir, 0755); err != nil {
 		utils.LogError("Failed to create bluebanquise directory", err, "path", bluebanquiseDir)
@@ -142,7 +139,8 @@ func ConfigureEnvironment(userName, userHome, collectionsPath string) error {
 }
 
 // ConfigureEnvironmentOffline sets up the BlueBanquise Python virtual environment using offline requirements.
-func ConfigureEnvironmentOffline(userName, userHome, requirementsPath string) error {
+// With verifyChecksums, the requirements are checked against their checksum manifest before pip installs them.
+func ConfigureEnvironmentOffline(userName, userHome, requirementsPath, sudoersMode string, verifyChecksums bool) error {
 	utils.LogInfo("Configuring BlueBanquise environment offline", "user", userName, "home", userHome, "requirements_path", requirementsPath)
 
 	// Detect OS and configure RHEL7 specific settings
@@ -151,18 +149,18 @@ func ConfigureEnvironmentOffline(userName, userHome, requirementsPath string) er
 	}
 
 	// Create virtual environment
-	venvDir := filepath.Join(userHome, "ansible_venv")
+	venvDir := VenvDir(userHome)
 	if err := createVirtualEnvironment(venvDir); err != nil {
 		return err
 	}
 
 	// Install requirements offline if path provided
-	if err := installOfflineRequirements(venvDir, requirementsPath); err != nil {
+	if err := installOfflineRequirements(venvDir, requirementsPath, verifyChecksums); err != nil {
 		return err
 	}
 
 	// Configure environment files
-	if err := configureEnvironmentFiles(userHome, venvDir); err != nil {
+	if err := configureEnvironmentFiles(userName, userHome, venvDir, sudoersMode); err != nil {
 		return err
 	}
 
@@ -171,6 +169,57 @@ func ConfigureEnvironmentOffline(userName, userHome, requirementsPath string) er
 	return nil
 }
 
+// RebuildVirtualEnvironment removes the virtual environment of userHome and
+// creates it again with the Python of the detected OS, installing the Python
+// requirements from requirementsPath, or from the network pinned to
+// ansibleVersion when it is empty. The shell and sudoers configuration
+// pointing to the virtual environment is left as is. Retries stop once ctx is
+// done.
+func RebuildVirtualEnvironment(ctx context.Context, userHome, requirementsPath, ansibleVersion string) error {
+	venvDir := VenvDir(userHome)
+	utils.LogInfo("Rebuilding Python virtual environment", "path", venvDir, "requirements_path", requirementsPath, "ansible_version", ansibleVersion)
+
+	requirements, err := utils.PinAnsibleRequirements(system.PythonRequirements, ansibleVersion)
+	if err != nil {
+		utils.LogError("Invalid ansible version", err, "ansible_version", ansibleVersion)
+		return err
+	}
+
+	fmt.Printf("Removing virtual environment %s...\n", venvDir)
+	if err := os.RemoveAll(venvDir); err != nil {
+		utils.LogError("Failed to remove virtual environment", err, "path", venvDir)
+		return fmt.Errorf("failed to remove virtual environment: %v", err)
+	}
+	if err := createVirtualEnvironment(venvDir); err != nil {
+		return err
+	}
+
+	if requirementsPath != "" {
+		return installOfflineRequirements(venvDir, requirementsPath, false)
+	}
+	utils.LogInfo("Installing Python requirements", "requirements", requirements)
+	if err := utils.InstallRequirements(ctx, venvDir, requirements); err != nil {
+		utils.LogError("Failed to install Python packages", err, "venv", venvDir)
+		return fmt.Errorf("failed to install Python packages: %v", err)
+	}
+	return checkInstalledRequirements(venvDir)
+}
+
+// CheckVirtualEnvironment verifies that an existing virtual environment provides a working ansible-galaxy.
+func CheckVirtualEnvironment(userHome string) error {
+	ansibleGalaxy := filepath.Join(VenvBin(userHome), "ansible-galaxy")
+	utils.LogInfo("Checking existing virtual environment", "path", ansibleGalaxy)
+	if _, err := os.Stat(ansibleGalaxy); err != nil {
+		utils.LogError("Virtual environment not usable", err, "path", ansibleGalaxy)
+		return fmt.Errorf("ansible-galaxy not found at %s, run without --skip-environment first", ansibleGalaxy)
+	}
+	if output, err := checkAnsibleGalaxy(ansibleGalaxy); err != nil {
+		utils.LogError("Virtual environment not usable", err, "path", ansibleGalaxy, "output", output)
+		return fmt.Errorf("ansible-galaxy at %s does not run (%v), run without --skip-environment first", ansibleGalaxy, err)
+	}
+	return nil
+}
+
 // configureOSSpecificSettings handles OS-specific configuration like RHEL7 rh-python38.
 func configureOSSpecificSettings(userHome string) error {
 	osID, version, err := system.DetectOS()
@@ -192,7 +241,9 @@ func configureOSSpecificSettings(userHome string) error {
 	return nil
 }
 
-// createVirtualEnvironment creates the Python virtual environment.
+// createVirtualEnvironment creates the Python virtual environment with the
+// Python of the detected OS, once checked to be recent enough and to provide
+// the venv module.
 func createVirtualEnvironment(venvDir string) error {
 	utils.LogInfo("Creating Python virtual environment", "path", venvDir)
 	fmt.Println("Creating Python virtual environment...")
@@ -204,24 +255,14 @@ func createVirtualEnvironment(venvDir string) error {
 		return fmt.Errorf("failed to detect OS: %v", err)
 	}
 
-	// Determine Python command based on OS
-	var pythonCmd string
-	switch osID {
-	case rhelOSID:
-		switch version {
-		case "7":
-			pythonCmd = "/opt/rh/rh-python38/root/usr/bin/python3"
-		case "8":
-			pythonCmd = "/usr/bin/python3.9"
-		case "9":
-			pythonCmd = "/usr/bin/python3.12"
-		default:
-			pythonCmd = defaultPythonCmd
-		}
-	case "opensuse-leap":
-		pythonCmd = "/usr/bin/python3.11"
-	default:
-		pythonCmd = defaultPythonCmd
+	// Determine Python command based on OS and make sure it can create a venv
+	pythonCmd := system.PythonCommandFor(osID, version)
+	if err := system.CheckPythonVersion(pythonCmd, osID, version); err != nil {
+		utils.LogError("Unsupported Python version", err, "python_cmd", pythonCmd)
+		return err
+	}
+	if err := checkVenvModule(osID, pythonCmd); err != nil {
+		return err
 	}
 
 	utils.LogCommand(pythonCmd, "-m", "venv", venvDir)
@@ -233,28 +274,160 @@ func createVirtualEnvironment(venvDir string) error {
 	return nil
 }
 
+// checkVenvModule probes that pythonCmd provides the venv and ensurepip modules,
+// which some distributions ship in a separate package, before creating the venv.
+func checkVenvModule(osID, pythonCmd string) error {
+	output, err := commandOutput(pythonCmd, "-c", "import venv, ensurepip")
+	if err == nil {
+		return nil
+	}
+
+	utils.LogError("Python cannot create virtual environments", err, "python_cmd", pythonCmd, "output", output)
+	return fmt.Errorf("%s cannot create virtual environments (%v): install %s and retry", pythonCmd, err, venvPackageHint(osID, pythonCmd))
+}
+
+// venvPackageHint names the package providing the venv module for pythonCmd.
+func venvPackageHint(osID, pythonCmd string) string {
+	switch osID {
+	case "ubuntu", "debian":
+		return fmt.Sprintf("the %s-venv package", filepath.Base(pythonCmd))
+	default:
+		return fmt.Sprintf("the package providing the venv module for %s", pythonCmd)
+	}
+}
+
 // installOfflineRequirements installs Python requirements from offline path.
-func installOfflineRequirements(venvDir, requirementsPath string) error {
-	if requirementsPath != "" {
-		utils.LogInfo("Installing Python requirements offline", "requirements_path", requirementsPath)
-		if err := utils.InstallRequirementsOffline(venvDir, requirementsPath); err != nil {
-			utils.LogError("Failed to install Python packages offline", err, "venv", venvDir, "requirements_path", requirementsPath)
-			return fmt.Errorf("failed to install Python packages offline: %v", err)
-		}
-	} else {
+func installOfflineRequirements(venvDir, requirementsPath string, verifyChecksums bool) error {
+	if requirementsPath == "" {
 		utils.LogInfo("No requirements path provided, skipping Python package installation")
+		return nil
+	}
+	utils.LogInfo("Installing Python requirements offline", "requirements_path", requirementsPath)
+	if err := utils.InstallRequirementsOffline(venvDir, requirementsPath, verifyChecksums); err != nil {
+		utils.LogError("Failed to install Python packages offline", err, "venv", venvDir, "requirements_path", requirementsPath)
+		return fmt.Errorf("failed to install Python packages offline: %v", err)
+	}
+	return checkInstalledRequirements(venvDir)
+}
+
+// checkVenvRequirements runs pip check in a virtual environment, tests replace it.
+var checkVenvRequirements = utils.CheckVenvRequirements
+
+// checkInstalledRequirements runs pip check once the requirements of venvDir
+// are installed, so packages left without their requirements are reported
+// instead of breaking at runtime. Like pip dependency conflicts, they are a
+// warning pointing to repair --reinstall-broken, or an error in strict mode.
+func checkInstalledRequirements(venvDir string) error {
+	broken, err := checkVenvRequirements(venvDir)
+	if err == nil && len(broken) == 0 {
+		return nil
+	}
+
+	var problems []string
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, requirement := range broken {
+		problems = append(problems, requirement.Line)
+	}
+	if utils.Strict() {
+		utils.LogError("Broken Python requirements after installation", err, "venv", venvDir, "problems", problems)
+		return fmt.Errorf("the virtual environment has broken Python requirements, run bluebanquise-installer repair --reinstall-broken:\n  %s", strings.Join(problems, "\n  "))
+	}
+	utils.LogWarning("Broken Python requirements after installation", "venv", venvDir, "problems", problems)
+	fmt.Println("Warning: the virtual environment has broken Python requirements, run bluebanquise-installer repair --reinstall-broken:")
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
 	}
 	return nil
 }
 
-// configureEnvironmentFiles sets up .bashrc, sudoers, SSH, and bluebanquise directory.
-func configureEnvironmentFiles(userHome, venvDir string) error {
-	bashrc := filepath.Join(userHome, ".bashrc")
+// configureActivation activates the virtual environment in the .bashrc of
+// userName. A user whose shell refuses logins, such as nologin or false, never
+// reads it: a script running a command in the environment, for sudo -u from an
+// admin account, is written instead.
+func configureActivation(userName, userHome, venvDir string) error {
+	shell, err := userShell(userName)
+	if err != nil {
+		utils.LogWarning("Could not read the user shell, activating the environment in .bashrc", "user", userName, "error", err)
+	} else if !InteractiveShell(shell) {
+		return writeEnvWrapper(userName, userHome, venvDir, shell)
+	}
 
-	// Add to .bashrc
+	bashrc := filepath.Join(userHome, ".bashrc")
 	utils.LogInfo("Updating .bashrc with environment variables", "file", bashrc)
+	return appendBashrcExports(bashrc, venvDir)
+}
+
+// writeEnvWrapper writes the EnvWrapperPath script of userHome from the
+// embedded template, for userName whose shell does not read .bashrc.
+func writeEnvWrapper(userName, userHome, venvDir, shell string) error {
+	if err := checkActivateScript(venvDir); err != nil {
+		return err
+	}
+	wrapper := EnvWrapperPath(userHome)
+	utils.LogInfo("User shell does not read .bashrc, writing environment wrapper", "user", userName, "shell", shell, "path", wrapper)
+
+	tmpl, err := template.New("wrapper").Funcs(template.FuncMap{"quote": shellQuote}).Parse(envWrapperTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to parse environment wrapper template: %v", err)
+	}
+	var buf bytes.Buffer
+	data := struct {
+		User          string
+		Wrapper       string
+		Venv          string
+		AnsibleConfig string
+		Home          string
+	}{
+		User:          userName,
+		Wrapper:       wrapper,
+		Venv:          venvDir,
+		AnsibleConfig: AnsibleConfigPath(userHome),
+		Home:          userHome,
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return fmt.Errorf("failed to render environment wrapper template: %v", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(wrapper), 0755)
+	if err == nil {
+		err = os.WriteFile(wrapper, buf.Bytes(), 0755)
+	}
+	if err == nil {
+		// WriteFile keeps the mode of an existing file
+		err = os.Chmod(wrapper, 0755)
+	}
+	if err != nil {
+		utils.LogError("Failed to write environment wrapper", err, "path", wrapper)
+		return fmt.Errorf("failed to write environment wrapper: %v", err)
+	}
+	fmt.Printf("The shell of %s is %s, run BlueBanquise commands with: sudo -u %s %s <command>\n", userName, shell, userName, wrapper)
+	return nil
+}
+
+// checkActivateScript fails when the activate script of venvDir is missing,
+// the virtual environment is then incomplete.
+func checkActivateScript(venvDir string) error {
+	activateScript := filepath.Join(venvDir, "bin", "activate")
+	if _, err := os.Stat(activateScript); err != nil {
+		utils.LogError("Virtual environment activate script not found", err, "path", activateScript)
+		return fmt.Errorf("virtual environment activate script not found at %s, the virtual environment is incomplete: %v", activateScript, err)
+	}
+	return nil
+}
+
+// appendBashrcExports activates the virtual environment and sets ANSIBLE_CONFIG
+// in bashrc. The activate script must exist, otherwise every login shell of the
+// user would fail to source it.
+func appendBashrcExports(bashrc, venvDir string) error {
+	if err := checkActivateScript(venvDir); err != nil {
+		return err
+	}
+	activateScript := filepath.Join(venvDir, "bin", "activate")
+
 	exportLines := []string{
-		fmt.Sprintf("source %s", filepath.Join(venvDir, "bin", "activate")),
+		fmt.Sprintf("source %s", activateScript),
 		"export ANSIBLE_CONFIG=$HOME/bluebanquise/ansible.cfg",
 	}
 	for _, line := range exportLines {
@@ -263,12 +436,19 @@ func configureEnvironmentFiles(userHome, venvDir string) error {
 			return fmt.Errorf("failed to update .bashrc: %v", err)
 		}
 	}
+	return nil
+}
+
+// configureEnvironmentFiles sets up the environment activation, sudoers, SSH,
+// and bluebanquise directory.
+func configureEnvironmentFiles(userName, userHome, venvDir, sudoersMode string) error {
+	if err := configureActivation(userName, userHome, venvDir); err != nil {
+		return err
+	}
 
 	// Ensure sudoers has PYTHONPATH preserved
-	utils.LogInfo("Updating sudoers to preserve PYTHONPATH")
-	if err := utils.EnsureLineInSudoers(`Defaults env_keep += "PYTHONPATH"`); err != nil {
-		utils.LogError("Failed to update sudoers", err)
-		return fmt.Errorf("failed to update sudoers: %v", err)
+	if err := ensureSudoersEnvKeep(sudoersMode); err != nil {
+		return err
 	}
 
 	// Configure SSH
@@ -280,7 +460,7 @@ func configureEnvironmentFiles(userHome, venvDir string) error {
 	}
 
 	// Create bluebanquise directory for ansible.cfg
-	bluebanquiseDir := filepath.Join(userHome, "bluebanquise")
+	bluebanquiseDir := BluebanquiseDir(userHome)
 	utils.LogInfo("Creating bluebanquise directory", "path", bluebanquiseDir)
 	if err := os.MkdirAll(bluebanquiseDir, 0755); err != nil {
 		utils.LogError("Failed to create bluebanquise directory", err, "path", bluebanquiseDir)
@@ -289,3 +469,18 @@ func configureEnvironmentFiles(userHome, venvDir string) error {
 
 	return nil
 }
+
+// ensureSudoersEnvKeep adds the PYTHONPATH env_keep line to sudoers unless sudoers management is disabled.
+func ensureSudoersEnvKeep(sudoersMode string) error {
+	if sudoersMode == SudoersModeNone {
+		utils.LogInfo("Skipping sudoers PYTHONPATH update", "sudoers_mode", sudoersMode)
+		return nil
+	}
+
+	utils.LogInfo("Updating sudoers to preserve PYTHONPATH")
+	if err := utils.EnsureLineInSudoers(EnvKeepLine); err != nil {
+		utils.LogError("Failed to update sudoers", err)
+		return fmt.Errorf("failed to update sudoers: %v", err)
+	}
+	return nil
+}
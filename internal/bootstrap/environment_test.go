@@ -0,0 +1,199 @@
+package bootstrap
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckVenvModule(t *testing.T) {
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	tests := []struct {
+		name         string
+		osID         string
+		pythonCmd    string
+		output       string
+		runErr       error
+		expectError  bool
+		expectedHint string
+	}{
+		{name: "venv available", osID: "ubuntu", pythonCmd: "/usr/bin/python3.12"},
+		{
+			name:         "ensurepip missing on Ubuntu",
+			osID:         "ubuntu",
+			pythonCmd:    "/usr/bin/python3.12",
+			output:       "ModuleNotFoundError: No module named 'ensurepip'",
+			runErr:       errors.New("exit status 1"),
+			expectError:  true,
+			expectedHint: "python3.12-venv package",
+		},
+		{
+			name:         "venv missing elsewhere",
+			osID:         "rhel",
+			pythonCmd:    "/usr/bin/python3.9",
+			output:       "ModuleNotFoundError: No module named 'venv'",
+			runErr:       errors.New("exit status 1"),
+			expectError:  true,
+			expectedHint: "venv module for /usr/bin/python3.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls [][]string
+			commandOutput = func(command string, args ...string) (string, error) {
+				calls = append(calls, append([]string{command}, args...))
+				return tt.output, tt.runErr
+			}
+
+			err := checkVenvModule(tt.osID, tt.pythonCmd)
+			assert.Equal(t, [][]string{{tt.pythonCmd, "-c", "import venv, ensurepip"}}, calls)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedHint)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConfigureEnvironmentFilesMissingActivate(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	venvDir := VenvDir(userHome)
+	require.NoError(t, os.MkdirAll(filepath.Join(venvDir, "bin"), 0755))
+
+	err := configureEnvironmentFiles("bluebanquise", userHome, venvDir, SudoersModeNone)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), filepath.Join(venvDir, "bin", "activate"))
+
+	// .bashrc is left untouched
+	_, err = os.Stat(filepath.Join(userHome, ".bashrc"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAppendBashrcExports(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	venvDir := VenvDir(userHome)
+	bashrc := filepath.Join(userHome, ".bashrc")
+	require.NoError(t, os.MkdirAll(filepath.Join(venvDir, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(venvDir, "bin", "activate"), nil, 0644))
+
+	require.NoError(t, appendBashrcExports(bashrc, venvDir))
+	data, err := os.ReadFile(bashrc)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "source "+filepath.Join(venvDir, "bin", "activate"))
+	assert.Contains(t, string(data), "export ANSIBLE_CONFIG=$HOME/bluebanquise/ansible.cfg")
+}
+
+func TestCheckInstalledRequirements(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := checkVenvRequirements
+	defer func() { checkVenvRequirements = original }()
+	defer utils.SetStrict(false)
+
+	broken := []utils.BrokenRequirement{{
+		Package:     "ansible-core",
+		Version:     "2.16.6",
+		Requirement: "jinja2",
+		Line:        "ansible-core 2.16.6 requires jinja2, which is not installed.",
+	}}
+
+	tests := []struct {
+		name    string
+		broken  []utils.BrokenRequirement
+		err     error
+		strict  bool
+		wantErr string
+	}{
+		{name: "Consistent", strict: true},
+		{name: "Broken warns", broken: broken},
+		{name: "Broken fails in strict mode", broken: broken, strict: true, wantErr: "ansible-core 2.16.6 requires jinja2, which is not installed."},
+		{name: "pip check failure warns", err: errors.New("pip check failed: exit status 1")},
+		{name: "pip check failure fails in strict mode", err: errors.New("pip check failed: exit status 1"), strict: true, wantErr: "pip check failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkVenvRequirements = func(venvPath string) ([]utils.BrokenRequirement, error) {
+				assert.Equal(t, "/venv", venvPath)
+				return tt.broken, tt.err
+			}
+			utils.SetStrict(tt.strict)
+
+			err := checkInstalledRequirements("/venv")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				assert.ErrorContains(t, err, "repair --reinstall-broken")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestConfigureActivation(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := userShell
+	defer func() { userShell = original }()
+
+	tests := []struct {
+		name        string
+		shell       string
+		lookupErr   error
+		wantWrapper bool
+	}{
+		{name: "Bash reads .bashrc", shell: "/bin/bash"},
+		{name: "Unknown shell falls back to .bashrc", lookupErr: errors.New("exit status 2")},
+		{name: "nologin gets a wrapper", shell: "/usr/sbin/nologin", wantWrapper: true},
+		{name: "false gets a wrapper", shell: "/bin/false", wantWrapper: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userShell = func(string) (string, error) { return tt.shell, tt.lookupErr }
+			userHome := t.TempDir()
+			venvDir := VenvDir(userHome)
+			require.NoError(t, os.MkdirAll(filepath.Join(venvDir, "bin"), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(venvDir, "bin", "activate"), nil, 0644))
+
+			require.NoError(t, configureActivation("bluebanquise", userHome, venvDir))
+
+			bashrc := filepath.Join(userHome, ".bashrc")
+			if !tt.wantWrapper {
+				assert.FileExists(t, bashrc)
+				assert.Empty(t, InstalledEnvWrapper(userHome))
+				return
+			}
+			assert.NoFileExists(t, bashrc)
+			wrapper := InstalledEnvWrapper(userHome)
+			require.Equal(t, EnvWrapperPath(userHome), wrapper)
+			info, err := os.Stat(wrapper)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+			// The command runs in the environment, from the home
+			output, err := exec.Command(wrapper, "sh", "-c", `echo "$VIRTUAL_ENV:$ANSIBLE_CONFIG:$(pwd):${PATH%%:*}"`).CombinedOutput()
+			require.NoError(t, err, string(output))
+			assert.Equal(t, venvDir+":"+AnsibleConfigPath(userHome)+":"+userHome+":"+VenvBin(userHome)+"\n", string(output))
+
+			output, err = exec.Command(wrapper).CombinedOutput()
+			assert.Error(t, err)
+			assert.Contains(t, string(output), "usage:")
+		})
+	}
+}
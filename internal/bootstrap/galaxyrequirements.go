@@ -0,0 +1,147 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// galaxyRequirements is a collections requirements file. Entries are kept as
+// decoded, so their version, type and source keys are written back unchanged.
+type galaxyRequirements struct {
+	Collections []any `yaml:"collections"`
+}
+
+// installCollectionsFromRequirements installs the collections of dir with
+// ansible-galaxy collection install -r, so ansible-galaxy resolves the order of
+// their dependencies. The entries of the skipped archives are left out. The
+// archives the manifest does not reference, as left by several downloads into
+// the same directory, are installed one by one afterwards.
+func installCollectionsFromRequirements(owner, ansibleGalaxy, dir string, archives, skipped []string, collectionsDir string, force bool) error {
+	manifest := filepath.Join(dir, utils.GalaxyRequirementsFile)
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		utils.LogError("Failed to read collections requirements", err, "path", manifest)
+		return fmt.Errorf("failed to read collections requirements: %v", err)
+	}
+	resolved, referenced, err := resolveGalaxyRequirements(dir, data, skipped)
+	if err != nil {
+		utils.LogError("Invalid collections requirements", err, "path", manifest)
+		return fmt.Errorf("invalid collections requirements %s: %v", manifest, err)
+	}
+	if resolved == nil {
+		utils.LogInfo("Every collection of the requirements is already installed", "path", manifest)
+		return installUnreferencedArchives(owner, ansibleGalaxy, dir, archives, referenced, collectionsDir, force)
+	}
+
+	// ansible-galaxy runs from the home of the owner, the archive paths of the
+	// manifest are made absolute in a copy it can read
+	file, err := os.CreateTemp(utils.TempDir(), "bluebanquise-requirements-*.yml")
+	if err != nil {
+		utils.LogError("Failed to create temporary requirements file", err)
+		return fmt.Errorf("failed to create temporary requirements file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(resolved)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		utils.LogError("Failed to write temporary requirements file", err, "path", file.Name())
+		return fmt.Errorf("failed to write temporary requirements file: %v", err)
+	}
+
+	utils.LogInfo("Installing collections from requirements", "path", manifest, "archives", referenced)
+	fmt.Printf("Installing collections from %s\n", manifest)
+	if err := runAnsibleGalaxy(owner, ansibleGalaxy, galaxyInstallArgs(force, "-r", file.Name(), "-p", collectionsDir)...); err != nil {
+		utils.LogError("Failed to install collections from requirements", err, "path", manifest)
+		return fmt.Errorf("failed to install collections from %s: %v", manifest, err)
+	}
+
+	return installUnreferencedArchives(owner, ansibleGalaxy, dir, archives, referenced, collectionsDir, force)
+}
+
+// installUnreferencedArchives installs the archives the requirements of dir do
+// not reference one by one.
+func installUnreferencedArchives(owner, ansibleGalaxy, dir string, archives, referenced []string, collectionsDir string, force bool) error {
+	for _, name := range archives {
+		if slices.Contains(referenced, name) {
+			continue
+		}
+		utils.LogWarning("Collection archive not listed in requirements, installing it separately", "file", name, "dir", dir)
+		if err := installCollectionArchive(owner, ansibleGalaxy, dir, name, collectionsDir, force); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveGalaxyRequirements returns the requirements data with the entries
+// naming an archive of dir rewritten to its absolute path, and the paths of
+// those archives relative to dir. The entries of the skipped archives are
+// removed, the data is nil when none is left. Other entries are left unchanged.
+func resolveGalaxyRequirements(dir string, data []byte, skipped []string) ([]byte, []string, error) {
+	var requirements galaxyRequirements
+	if err := yaml.Unmarshal(data, &requirements); err != nil {
+		return nil, nil, err
+	}
+	if len(requirements.Collections) == 0 {
+		return nil, nil, fmt.Errorf("no collections listed")
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var referenced []string
+	// resolve returns the path of an archive entry and whether it is kept
+	resolve := func(name string) (string, bool) {
+		if filepath.IsAbs(name) || !utils.IsCollectionArchive(name) {
+			return name, true
+		}
+		path := filepath.Join(absDir, name)
+		if _, err := os.Stat(path); err != nil {
+			return name, true
+		}
+		referenced = append(referenced, filepath.Clean(name))
+		return path, !slices.Contains(skipped, filepath.Clean(name))
+	}
+
+	var collections []any
+	for _, entry := range requirements.Collections {
+		keep := true
+		switch entry := entry.(type) {
+		case string:
+			var path string
+			path, keep = resolve(entry)
+			if keep {
+				collections = append(collections, path)
+			}
+			continue
+		case map[string]any:
+			if name, ok := entry["name"].(string); ok {
+				entry["name"], keep = resolve(name)
+			}
+		}
+		if keep {
+			collections = append(collections, entry)
+		}
+	}
+	if len(collections) == 0 {
+		return nil, referenced, nil
+	}
+	requirements.Collections = collections
+
+	resolved, err := yaml.Marshal(requirements)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resolved, referenced, nil
+}
@@ -0,0 +1,70 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestInstallCollectionsFromPathRequirements(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible-galaxy"), nil, 0755))
+
+	collectionsPath := t.TempDir()
+	for _, name := range []string{
+		"bluebanquise-infrastructure-3.0.0.tar.gz",
+		"community-general-9.0.0.tar.gz",
+		"community-crypto-2.0.0.tar.gz",
+	} {
+		writeCollectionArchive(t, filepath.Join(collectionsPath, name))
+	}
+	// As written by ansible-galaxy collection download, community.crypto comes
+	// from an earlier download into the same directory
+	require.NoError(t, os.WriteFile(filepath.Join(collectionsPath, utils.GalaxyRequirementsFile), []byte(`collections:
+- name: community-general-9.0.0.tar.gz
+  version: 9.0.0
+- name: bluebanquise-infrastructure-3.0.0.tar.gz
+  version: 3.0.0
+- community.docker
+`), 0644))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	var installs [][]string
+	var requirements galaxyRequirements
+	commandOutput = func(command string, args ...string) (string, error) {
+		if len(args) > 1 && args[1] == "list" {
+			return galaxyListFixture, nil
+		}
+		if len(args) > 1 && args[1] == "install" {
+			installs = append(installs, args)
+			if args[2] == "-r" {
+				data, err := os.ReadFile(args[3])
+				require.NoError(t, err)
+				require.NoError(t, yaml.Unmarshal(data, &requirements))
+			}
+		}
+		return "ansible-galaxy [core 2.16.6]", nil
+	}
+
+	require.NoError(t, InstallCollectionsFromPath(collectionsPath, userHome, false, nil))
+
+	require.Len(t, installs, 2)
+	assert.Equal(t, "-r", installs[0][2])
+	assert.Equal(t, []string{"-p", CollectionsDir(userHome)}, installs[0][4:])
+	assert.Equal(t, []string{"collection", "install", filepath.Join(collectionsPath, "community-crypto-2.0.0.tar.gz"), "-p", CollectionsDir(userHome)}, installs[1])
+	assert.NoFileExists(t, installs[0][3], "temporary requirements file is removed")
+
+	assert.Equal(t, []any{
+		map[string]any{"name": filepath.Join(collectionsPath, "community-general-9.0.0.tar.gz"), "version": "9.0.0"},
+		map[string]any{"name": filepath.Join(collectionsPath, "bluebanquise-infrastructure-3.0.0.tar.gz"), "version": "3.0.0"},
+		"community.docker",
+	}, requirements.Collections)
+}
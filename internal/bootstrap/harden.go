@@ -0,0 +1,59 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// Modes set by HardenHome, useradd --create-home leaves the home at 0755,
+// exposing the SSH keys and configuration to the other local users.
+const (
+	HardenedHomeMode os.FileMode = 0700
+	HardenedSSHMode  os.FileMode = 0700
+)
+
+// HardenHome restricts the home of userName to its owner and gives the .ssh
+// directory written by the installer as root back to userName at 0700. A home
+// not owned by userName keeps its mode, 0700 would lock the user out of it.
+func HardenHome(userName, userHome string) error {
+	utils.LogInfo("Hardening home directory permissions", "user", userName, "home", userHome)
+	fmt.Println("Hardening home directory permissions...")
+
+	account, err := lookupUser(userName)
+	if err != nil {
+		utils.LogError("Failed to look up user", err, "user", userName)
+		return fmt.Errorf("failed to look up user %s: %v", userName, err)
+	}
+	info, err := os.Stat(userHome)
+	if err != nil {
+		utils.LogError("Failed to read home directory", err, "home", userHome)
+		return fmt.Errorf("failed to read home directory %s: %v", userHome, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && strconv.FormatUint(uint64(stat.Uid), 10) != account.Uid {
+		utils.LogWarning("Home is not owned by the user, leaving its permissions", "home", userHome, "user", userName, "uid", stat.Uid)
+		fmt.Printf("Warning: %s is not owned by %s, leaving its permissions as is\n", userHome, userName)
+	} else if err := os.Chmod(userHome, HardenedHomeMode); err != nil {
+		utils.LogError("Failed to set home directory permissions", err, "home", userHome)
+		return fmt.Errorf("failed to set home directory permissions: %v", err)
+	}
+
+	sshDir := filepath.Join(userHome, ".ssh")
+	if _, err := os.Stat(sshDir); os.IsNotExist(err) {
+		return nil
+	}
+	if err := chownTree(sshDir, userName); err != nil {
+		return err
+	}
+	if err := os.Chmod(sshDir, HardenedSSHMode); err != nil {
+		utils.LogError("Failed to set .ssh directory permissions", err, "path", sshDir)
+		return fmt.Errorf("failed to set .ssh directory permissions: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package bootstrap
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHardenHome(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	original := lookupUser
+	defer func() { lookupUser = original }()
+
+	tests := []struct {
+		name     string
+		uid      string
+		withSSH  bool
+		homeMode os.FileMode
+	}{
+		{name: "Home and .ssh", uid: current.Uid, withSSH: true, homeMode: HardenedHomeMode},
+		{name: "Home without .ssh", uid: current.Uid, homeMode: HardenedHomeMode},
+		{name: "Home of another owner", uid: "4242", withSSH: true, homeMode: 0755},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookupUser = func(name string) (*user.User, error) {
+				return &user.User{Username: name, Uid: tt.uid, Gid: current.Gid}, nil
+			}
+
+			home := t.TempDir()
+			require.NoError(t, os.Chmod(home, 0755))
+			sshDir := filepath.Join(home, ".ssh")
+			if tt.withSSH {
+				require.NoError(t, os.Mkdir(sshDir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(sshDir, "authorized_keys"), nil, 0600))
+			}
+
+			require.NoError(t, HardenHome(current.Username, home))
+
+			info, err := os.Stat(home)
+			require.NoError(t, err)
+			assert.Equal(t, tt.homeMode, info.Mode().Perm())
+
+			info, err = os.Stat(sshDir)
+			if !tt.withSSH {
+				assert.True(t, os.IsNotExist(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, HardenedSSHMode, info.Mode().Perm())
+		})
+	}
+}
+
+func TestHardenHomeMissing(t *testing.T) {
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	err = HardenHome(current.Username, filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "failed to read home directory")
+}
@@ -0,0 +1,151 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// inventoryMarkers are the directories identifying an Ansible inventory root.
+var inventoryMarkers = []string{"group_vars", "host_vars"}
+
+// InstallInventoryFromURL fetches a pre-built inventory from a git repository or a
+// tarball URL and places it in ~/bluebanquise/inventory. An existing inventory is
+// kept as a timestamped backup. Retries of the download stop once ctx is done.
+func InstallInventoryFromURL(ctx context.Context, inventoryURL, userHome string, opts utils.DownloadOptions) error {
+	utils.LogInfo("Installing inventory from URL", "url", utils.RedactURL(inventoryURL), "home", userHome)
+	fmt.Printf("Importing inventory from %s...\n", utils.RedactURL(inventoryURL))
+
+	tempDir, err := os.MkdirTemp(utils.TempDir(), "bluebanquise-inventory-")
+	if err != nil {
+		utils.LogError("Failed to create temporary directory", err)
+		return fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			utils.LogWarning("Could not remove temporary directory", "error", err, "path", tempDir)
+		}
+	}()
+
+	sourceDir := filepath.Join(tempDir, "source")
+	if err := fetchInventory(ctx, inventoryURL, tempDir, sourceDir, opts); err != nil {
+		return err
+	}
+
+	root, err := findInventoryRoot(sourceDir)
+	if err != nil {
+		utils.LogError("Invalid inventory", err, "url", utils.RedactURL(inventoryURL))
+		return err
+	}
+
+	// Never copy the git metadata into the inventory
+	if err := os.RemoveAll(filepath.Join(root, ".git")); err != nil {
+		return fmt.Errorf("failed to remove git metadata: %v", err)
+	}
+
+	inventoryDir := InventoryDir(userHome)
+	if _, err := os.Stat(inventoryDir); err == nil {
+		backup := fmt.Sprintf("%s.bak-%s", inventoryDir, time.Now().Format("20060102-150405"))
+		utils.LogInfo("Backing up existing inventory", "path", inventoryDir, "backup", backup)
+		fmt.Printf("Existing inventory moved to %s\n", backup)
+		if err := os.Rename(inventoryDir, backup); err != nil {
+			utils.LogError("Failed to back up existing inventory", err, "path", inventoryDir)
+			return fmt.Errorf("failed to back up existing inventory: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(inventoryDir), 0755); err != nil {
+		utils.LogError("Failed to create bluebanquise directory", err, "path", filepath.Dir(inventoryDir))
+		return fmt.Errorf("failed to create bluebanquise directory: %v", err)
+	}
+	if err := os.CopyFS(inventoryDir, os.DirFS(root)); err != nil {
+		utils.LogError("Failed to copy inventory", err, "source", root, "dest", inventoryDir)
+		return fmt.Errorf("failed to copy inventory: %v", err)
+	}
+
+	utils.LogInfo("Inventory installed successfully", "url", utils.RedactURL(inventoryURL), "path", inventoryDir)
+	fmt.Println("Inventory imported successfully.")
+	return nil
+}
+
+// isGitURL reports whether an inventory URL points to a git repository.
+func isGitURL(inventoryURL string) bool {
+	return strings.HasPrefix(inventoryURL, "git+") ||
+		strings.HasPrefix(inventoryURL, "git@") ||
+		strings.HasPrefix(inventoryURL, "ssh://") ||
+		strings.HasSuffix(inventoryURL, ".git")
+}
+
+// fetchInventory clones or downloads and extracts the inventory into sourceDir.
+// The download options only apply to tarball URLs.
+func fetchInventory(ctx context.Context, inventoryURL, tempDir, sourceDir string, opts utils.DownloadOptions) error {
+	if isGitURL(inventoryURL) {
+		repo := strings.TrimPrefix(inventoryURL, "git+")
+		if err := utils.RunCommand("git", "clone", "--depth", "1", repo, sourceDir); err != nil {
+			return fmt.Errorf("failed to clone inventory repository %s: %v", repo, err)
+		}
+		return nil
+	}
+
+	if !strings.HasSuffix(inventoryURL, ".tar.gz") && !strings.HasSuffix(inventoryURL, ".tgz") {
+		return fmt.Errorf("unsupported inventory URL %s (expected a git repository or a .tar.gz/.tgz archive)", utils.RedactURL(inventoryURL))
+	}
+
+	archive := filepath.Join(tempDir, "inventory.tar.gz")
+	if err := utils.DownloadFileWithRetry(ctx, inventoryURL, archive, opts); err != nil {
+		return fmt.Errorf("failed to download inventory: %v", err)
+	}
+	if err := utils.CheckArchiveMembers(archive); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(sourceDir, 0755); err != nil {
+		return fmt.Errorf("failed to create extraction directory: %v", err)
+	}
+	if err := utils.RunCommand("tar", "-xzf", archive, "-C", sourceDir); err != nil {
+		return fmt.Errorf("failed to extract inventory archive: %v", err)
+	}
+	return nil
+}
+
+// findInventoryRoot returns dir, or its single top-level subdirectory when the
+// archive wraps the inventory, after validating the inventory structure.
+func findInventoryRoot(dir string) (string, error) {
+	if validateInventory(dir) == nil {
+		return dir, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read inventory: %v", err)
+	}
+	var subdirs []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != ".git" {
+			subdirs = append(subdirs, entry.Name())
+		}
+	}
+	if len(subdirs) == 1 {
+		root := filepath.Join(dir, subdirs[0])
+		if err := validateInventory(root); err != nil {
+			return "", err
+		}
+		return root, nil
+	}
+
+	return "", validateInventory(dir)
+}
+
+// validateInventory checks that dir looks like an Ansible inventory.
+func validateInventory(dir string) error {
+	for _, marker := range inventoryMarkers {
+		if info, err := os.Stat(filepath.Join(dir, marker)); err == nil && info.IsDir() {
+			return nil
+		}
+	}
+	return fmt.Errorf("no %s directory found in inventory", strings.Join(inventoryMarkers, " or "))
+}
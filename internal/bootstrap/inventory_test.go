@@ -0,0 +1,74 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindInventoryRoot(t *testing.T) {
+	tests := []struct {
+		name         string
+		dirs         []string
+		expectedRoot string
+		expectError  bool
+	}{
+		{
+			name:         "Inventory at top level",
+			dirs:         []string{"group_vars/all", "host_vars"},
+			expectedRoot: "",
+		},
+		{
+			name:         "Only host_vars",
+			dirs:         []string{"host_vars"},
+			expectedRoot: "",
+		},
+		{
+			name:         "Inventory wrapped in archive directory",
+			dirs:         []string{"inventory-main/group_vars/all"},
+			expectedRoot: "inventory-main",
+		},
+		{
+			name:        "Missing inventory directories",
+			dirs:        []string{"roles", "playbooks"},
+			expectError: true,
+		},
+		{
+			name:        "Wrapped directory without inventory",
+			dirs:        []string{"inventory-main/roles"},
+			expectError: true,
+		},
+		{
+			name:        "Empty directory",
+			dirs:        nil,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, d := range tt.dirs {
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+			}
+
+			root, err := findInventoryRoot(dir)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, tt.expectedRoot), root)
+		})
+	}
+}
+
+func TestIsGitURL(t *testing.T) {
+	assert.True(t, isGitURL("https://github.com/example/inventory.git"))
+	assert.True(t, isGitURL("git+https://github.com/example/inventory"))
+	assert.True(t, isGitURL("git@github.com:example/inventory.git"))
+	assert.False(t, isGitURL("https://example.com/inventory.tar.gz"))
+}
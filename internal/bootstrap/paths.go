@@ -0,0 +1,88 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// VenvDir returns the Python virtual environment directory of a BlueBanquise home.
+func VenvDir(userHome string) string {
+	return filepath.Join(userHome, "ansible_venv")
+}
+
+// VenvBin returns the bin directory of the Python virtual environment.
+func VenvBin(userHome string) string {
+	return filepath.Join(VenvDir(userHome), "bin")
+}
+
+// CollectionsDir returns the Ansible collections directory of a BlueBanquise home.
+func CollectionsDir(userHome string) string {
+	return filepath.Join(userHome, ".ansible", "collections")
+}
+
+// BluebanquiseDir returns the directory holding ansible.cfg and the inventory.
+func BluebanquiseDir(userHome string) string {
+	return filepath.Join(userHome, "bluebanquise")
+}
+
+// AnsibleConfigPath returns the ansible.cfg path exported as ANSIBLE_CONFIG.
+func AnsibleConfigPath(userHome string) string {
+	return filepath.Join(BluebanquiseDir(userHome), "ansible.cfg")
+}
+
+// EnvWrapperPath returns the script running a command in the BlueBanquise
+// environment, written for a user whose shell does not read .bashrc.
+func EnvWrapperPath(userHome string) string {
+	return filepath.Join(userHome, "bin", "bluebanquise-env")
+}
+
+// InstalledEnvWrapper returns EnvWrapperPath when the script was written, or
+// an empty string when the environment is activated by .bashrc.
+func InstalledEnvWrapper(userHome string) string {
+	wrapper := EnvWrapperPath(userHome)
+	if _, err := os.Stat(wrapper); err != nil {
+		return ""
+	}
+	return wrapper
+}
+
+// InventoryDir returns the inventory directory of a BlueBanquise home.
+func InventoryDir(userHome string) string {
+	return filepath.Join(BluebanquiseDir(userHome), "inventory")
+}
+
+// GroupVarsAllDir returns the inventory group_vars/all directory holding core variables.
+func GroupVarsAllDir(userHome string) string {
+	return filepath.Join(InventoryDir(userHome), "group_vars", "all")
+}
+
+// CoreVarsFile returns the bb_core.yml of the inventory of a BlueBanquise home.
+func CoreVarsFile(userHome string) string {
+	return filepath.Join(GroupVarsAllDir(userHome), "bb_core.yml")
+}
+
+// lookupUser reads the user database, tests replace it.
+var lookupUser = user.Lookup
+
+// LookupUserHome returns the home directory of userName from the user database.
+func LookupUserHome(userName string) (string, error) {
+	u, err := lookupUser(userName)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user %s: %v", userName, err)
+	}
+	return u.HomeDir, nil
+}
+
+// CheckUserHome fails when userName already exists with a home directory other
+// than userHome: the account would keep its home while the installation writes
+// to userHome, splitting the installation across two directories.
+func CheckUserHome(userName, userHome string) error {
+	existing, err := LookupUserHome(userName)
+	if err != nil || existing == "" || filepath.Clean(existing) == filepath.Clean(userHome) {
+		return nil
+	}
+	return fmt.Errorf("user %s already exists with home %s, not %s: pass --home %s to install in its home, or choose another --user",
+		userName, existing, userHome, existing)
+}
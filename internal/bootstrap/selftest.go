@@ -0,0 +1,61 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// DefaultSelfTestHost is the host pinged by the self-test when none is given.
+const DefaultSelfTestHost = "localhost"
+
+// SelfTestCommand returns the command running an Ansible ping against host as
+// userName, with the venv of userHome on PATH and ANSIBLE_CONFIG set.
+func SelfTestCommand(userName, userHome, host string) (string, []string) {
+	script := fmt.Sprintf(`export PATH=%s:"$PATH" ANSIBLE_CONFIG=%s; exec ansible %s -m ping`,
+		shellQuote(VenvBin(userHome)), shellQuote(AnsibleConfigPath(userHome)), shellQuote(host))
+	return runAsCommand(userName, script)
+}
+
+// RunSelfTest pings host with Ansible as userName using the installed environment.
+func RunSelfTest(userName, userHome, host string) error {
+	utils.LogInfo("Running self-test", "user", userName, "home", userHome, "host", host)
+
+	if host == "" {
+		return fmt.Errorf("self-test host cannot be empty")
+	}
+
+	ansible := filepath.Join(VenvBin(userHome), "ansible")
+	if _, err := os.Stat(ansible); err != nil {
+		utils.LogError("Ansible not found", err, "path", ansible)
+		return fmt.Errorf("ansible not found at %s, install BlueBanquise first", ansible)
+	}
+
+	command, args := SelfTestCommand(userName, userHome, host)
+	output, err := commandOutput(command, args...)
+	utils.LogInfo("Self-test output", "output", output)
+	if err != nil {
+		utils.LogError("Self-test failed", err, "host", host)
+		return fmt.Errorf("ansible ping of %s failed: %v\n%s", host, err, strings.TrimSpace(output))
+	}
+
+	utils.LogInfo("Self-test passed", "host", host)
+	return nil
+}
+
+// runAsShell runs the commands of the installer as another user, whatever its
+// login shell: su - runs that shell, and nologin or false refuse the command.
+const runAsShell = "/bin/sh"
+
+// runAsCommand returns the su command running script as userName with runAsShell.
+func runAsCommand(userName, script string) (string, []string) {
+	return "su", []string{"-s", runAsShell, "-", userName, "-c", script}
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,85 @@
+package bootstrap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTestCommand(t *testing.T) {
+	command, args := SelfTestCommand("bluebanquise", "/var/lib/bluebanquise", "localhost")
+
+	assert.Equal(t, "su", command)
+	assert.Equal(t, []string{
+		"-s", "/bin/sh", "-", "bluebanquise", "-c",
+		`export PATH='/var/lib/bluebanquise/ansible_venv/bin':"$PATH" ANSIBLE_CONFIG='/var/lib/bluebanquise/bluebanquise/ansible.cfg'; exec ansible 'localhost' -m ping`,
+	}, args)
+
+	_, args = SelfTestCommand("bluebanquise", "/var/lib/bluebanquise", "node'1")
+	assert.Contains(t, args[5], `exec ansible 'node'\''1' -m ping`)
+}
+
+func TestRunSelfTest(t *testing.T) {
+	userHome := t.TempDir()
+	require.NoError(t, os.MkdirAll(VenvBin(userHome), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(VenvBin(userHome), "ansible"), nil, 0755))
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	tests := []struct {
+		name        string
+		userHome    string
+		host        string
+		output      string
+		runErr      error
+		expectError bool
+	}{
+		{name: "Ping succeeds", userHome: userHome, host: "localhost", output: "localhost | SUCCESS"},
+		{name: "Ping fails", userHome: userHome, host: "node1", output: "node1 | UNREACHABLE!", runErr: errors.New("exit status 4"), expectError: true},
+		{name: "Empty host", userHome: userHome, host: "", expectError: true},
+		{name: "Ansible missing", userHome: t.TempDir(), host: "localhost", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls [][]string
+			commandOutput = func(command string, args ...string) (string, error) {
+				calls = append(calls, append([]string{command}, args...))
+				return tt.output, tt.runErr
+			}
+
+			err := RunSelfTest("bluebanquise", tt.userHome, tt.host)
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.runErr != nil {
+					assert.Contains(t, err.Error(), tt.output)
+				}
+			} else {
+				assert.NoError(t, err)
+				require.Len(t, calls, 1)
+				assert.Equal(t, "su", calls[0][0])
+				assert.Equal(t, "bluebanquise", calls[0][4])
+			}
+		})
+	}
+}
+
+func TestRunAsCommandNologinUser(t *testing.T) {
+	original := userShell
+	defer func() { userShell = original }()
+	userShell = func(string) (string, error) { return "/usr/sbin/nologin", nil }
+
+	// su - would run the nologin shell of the user, runAsShell replaces it
+	command, args := GalaxyCommand("bbservice", "/var/lib/bbservice/ansible_venv/bin/ansible-galaxy", "--version")
+	assert.Equal(t, "su", command)
+	assert.Equal(t, []string{"-s", "/bin/sh", "-", "bbservice", "-c", `exec '/var/lib/bbservice/ansible_venv/bin/ansible-galaxy' '--version'`}, args)
+
+	command, args = SelfTestCommand("bbservice", "/var/lib/bbservice", "localhost")
+	assert.Equal(t, "su", command)
+	assert.Equal(t, []string{"-s", "/bin/sh", "-", "bbservice", "-c"}, args[:5])
+}
@@ -0,0 +1,130 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// SignatureSuffix names the detached signature of a collection archive in a
+// collections directory: <archive>.asc, a GnuPG signature of the MANIFEST.json
+// of the collection as published by a Galaxy server.
+const SignatureSuffix = ".asc"
+
+// onlineCollections are the collections installed by InstallCollectionsOnline.
+var onlineCollections = []string{infrastructureCollection, "community.general"}
+
+// CollectionSignature is an installed collection to verify with
+// VerifyCollectionSignatures.
+type CollectionSignature struct {
+	// Collection is namespace.name.
+	Collection string
+	// Signature is the detached signature file of a collection installed from
+	// an archive, verified offline. When empty, the collection is verified
+	// against the signatures of the Galaxy server.
+	Signature string
+}
+
+// OnlineCollectionSignatures returns the collections installed from the
+// network, verified against the Galaxy server.
+func OnlineCollectionSignatures() []CollectionSignature {
+	var collections []CollectionSignature
+	for _, name := range onlineCollections {
+		collections = append(collections, CollectionSignature{Collection: name})
+	}
+	return collections
+}
+
+// CollectionSignatures returns the collections of the archives of the
+// collections directory dir, restricted to only when it is set, with their
+// detached signature. An archive linked into dir, as for a glob or several
+// collections paths, has its signature next to the file the link points to.
+// It fails, listing them, when archives have no signature, and for an
+// installed collections tree, which has none.
+func CollectionSignatures(dir string, only []string) ([]CollectionSignature, error) {
+	layout, root, err := utils.DetectCollectionsLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	if layout == utils.CollectionsLayoutInstalled {
+		return nil, fmt.Errorf("signatures cannot be verified for the installed collections tree %s, give the collection archives and their %s signatures", dir, SignatureSuffix)
+	}
+	archives, err := utils.CollectionArchives(root)
+	if err != nil {
+		utils.LogError("Failed to read directory", err, "path", root)
+		return nil, fmt.Errorf("failed to read directory: %v", err)
+	}
+
+	var collections []CollectionSignature
+	var missing []string
+	for _, name := range archives {
+		archive := filepath.Join(root, name)
+		namespace, collection, _, err := utils.ArchiveCollectionInfo(archive)
+		if err != nil {
+			utils.LogError("Cannot read collection manifest to verify its signature", err, "archive", archive)
+			return nil, fmt.Errorf("cannot verify the signature of %s: %v", name, err)
+		}
+		collection = namespace + "." + collection
+		if len(only) > 0 && !slices.Contains(only, collection) {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(archive); err == nil {
+			archive = resolved
+		}
+		signature := archive + SignatureSuffix
+		if _, err := os.Stat(signature); err != nil {
+			missing = append(missing, name+SignatureSuffix)
+			continue
+		}
+		collections = append(collections, CollectionSignature{Collection: collection, Signature: signature})
+	}
+	if len(missing) > 0 {
+		utils.LogError("Collection signatures missing", nil, "path", dir, "missing", missing)
+		return nil, fmt.Errorf("collection signatures missing in %s: %s", dir, strings.Join(missing, ", "))
+	}
+	utils.LogInfo("Collection signatures found", "path", dir, "collections", collections)
+	return collections, nil
+}
+
+// galaxyVerifyArgs returns the ansible-galaxy arguments verifying collection
+// installed in collectionsDir with the keys of keyring: offline against its
+// signature file when it has one, against the Galaxy server otherwise.
+func galaxyVerifyArgs(keyring, collectionsDir string, collection CollectionSignature) []string {
+	args := []string{"collection", "verify", "--keyring", keyring, "-p", collectionsDir}
+	if collection.Signature != "" {
+		args = append(args, "--offline", "--signature", "file://"+collection.Signature)
+	}
+	return append(args, collection.Collection)
+}
+
+// VerifyCollectionSignatures runs ansible-galaxy collection verify for each of
+// collections installed in userHome, checking their signature with the GnuPG
+// keyring and their files against their signed MANIFEST.json. It fails,
+// listing them, when a collection does not verify.
+func VerifyCollectionSignatures(userHome, keyring string, collections []CollectionSignature) error {
+	ansibleGalaxy := filepath.Join(VenvBin(userHome), "ansible-galaxy")
+	collectionsDir := CollectionsDir(userHome)
+	owner := collectionsOwner(userHome)
+
+	var failed []string
+	for _, collection := range collections {
+		utils.LogInfo("Verifying collection signature", "collection", collection.Collection, "signature", collection.Signature, "keyring", keyring)
+		fmt.Printf("Verifying the signature of %s... ", collection.Collection)
+		if err := runAnsibleGalaxy(owner, ansibleGalaxy, galaxyVerifyArgs(keyring, collectionsDir, collection)...); err != nil {
+			utils.LogError("Collection signature verification failed", err, "collection", collection.Collection)
+			fmt.Printf("%s: %v\n", utils.ColorFail("FAILED"), err)
+			failed = append(failed, collection.Collection)
+			continue
+		}
+		fmt.Println(utils.ColorPass("OK"))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("signature verification failed for %s", strings.Join(failed, ", "))
+	}
+	utils.LogInfo("Collection signatures verified", "collections", len(collections))
+	return nil
+}
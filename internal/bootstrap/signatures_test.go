@@ -0,0 +1,109 @@
+package bootstrap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGalaxyVerifyArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		collection CollectionSignature
+		want       []string
+	}{
+		{
+			name:       "Against the Galaxy server",
+			collection: CollectionSignature{Collection: "community.general"},
+			want:       []string{"collection", "verify", "--keyring", "/etc/ansible/keyring.kbx", "-p", "/var/lib/bluebanquise/.ansible/collections", "community.general"},
+		},
+		{
+			name:       "Offline with a signature file",
+			collection: CollectionSignature{Collection: "bluebanquise.infrastructure", Signature: "/srv/collections/bluebanquise-infrastructure-3.0.0.tar.gz.asc"},
+			want: []string{"collection", "verify", "--keyring", "/etc/ansible/keyring.kbx", "-p", "/var/lib/bluebanquise/.ansible/collections",
+				"--offline", "--signature", "file:///srv/collections/bluebanquise-infrastructure-3.0.0.tar.gz.asc", "bluebanquise.infrastructure"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, galaxyVerifyArgs("/etc/ansible/keyring.kbx", "/var/lib/bluebanquise/.ansible/collections", tt.collection))
+		})
+	}
+}
+
+func TestCollectionSignatures(t *testing.T) {
+	dir := t.TempDir()
+	infrastructure := filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz")
+	general := filepath.Join(dir, "community-general-8.0.0.tar.gz")
+	writeVersionedCollectionArchive(t, infrastructure, "bluebanquise", "infrastructure", "3.0.0")
+	writeVersionedCollectionArchive(t, general, "community", "general", "8.0.0")
+	require.NoError(t, os.WriteFile(infrastructure+SignatureSuffix, []byte("signature"), 0644))
+
+	// An archive without signature fails the check
+	_, err := CollectionSignatures(dir, nil)
+	assert.ErrorContains(t, err, "collection signatures missing in "+dir+": community-general-8.0.0.tar.gz.asc")
+
+	// Unless it is left out by --only-collections
+	signatures, err := CollectionSignatures(dir, []string{"bluebanquise.infrastructure"})
+	require.NoError(t, err)
+	assert.Equal(t, []CollectionSignature{{Collection: "bluebanquise.infrastructure", Signature: infrastructure + SignatureSuffix}}, signatures)
+
+	require.NoError(t, os.WriteFile(general+SignatureSuffix, []byte("signature"), 0644))
+	signatures, err = CollectionSignatures(dir, nil)
+	require.NoError(t, err)
+	assert.Len(t, signatures, 2)
+
+	// The signature of a linked archive is next to its target
+	linked := t.TempDir()
+	require.NoError(t, os.Symlink(infrastructure, filepath.Join(linked, filepath.Base(infrastructure))))
+	signatures, err = CollectionSignatures(linked, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []CollectionSignature{{Collection: "bluebanquise.infrastructure", Signature: infrastructure + SignatureSuffix}}, signatures)
+
+	// An installed tree has no signatures
+	installed := t.TempDir()
+	writeInstalledCollection(t, installed, "bluebanquise", "infrastructure", "3.0.0")
+	_, err = CollectionSignatures(installed, nil)
+	assert.ErrorContains(t, err, "signatures cannot be verified for the installed collections tree")
+}
+
+func TestVerifyCollectionSignatures(t *testing.T) {
+	originalOutput, originalEuid := commandOutput, geteuid
+	defer func() { commandOutput, geteuid = originalOutput, originalEuid }()
+	geteuid = func() int { return 1000 }
+
+	userHome := t.TempDir()
+	collections := []CollectionSignature{
+		{Collection: "bluebanquise.infrastructure", Signature: "/srv/collections/bluebanquise-infrastructure-3.0.0.tar.gz.asc"},
+		{Collection: "community.general", Signature: "/srv/collections/community-general-8.0.0.tar.gz.asc"},
+	}
+
+	var calls []string
+	commandOutput = func(command string, args ...string) (string, error) {
+		calls = append(calls, command+" "+strings.Join(args, " "))
+		return "", nil
+	}
+	require.NoError(t, VerifyCollectionSignatures(userHome, "/etc/ansible/keyring.kbx", collections))
+	require.Len(t, calls, 2)
+	assert.Equal(t, filepath.Join(VenvBin(userHome), "ansible-galaxy")+" collection verify --keyring /etc/ansible/keyring.kbx -p "+CollectionsDir(userHome)+
+		" --offline --signature file:///srv/collections/bluebanquise-infrastructure-3.0.0.tar.gz.asc bluebanquise.infrastructure", calls[0])
+
+	// Every collection is verified, the failing ones are listed
+	calls = nil
+	commandOutput = func(command string, args ...string) (string, error) {
+		calls = append(calls, command+" "+strings.Join(args, " "))
+		if args[len(args)-1] == "bluebanquise.infrastructure" {
+			return "Signature verification failed for 'bluebanquise.infrastructure': BADSIG", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	err := VerifyCollectionSignatures(userHome, "/etc/ansible/keyring.kbx", collections)
+	assert.EqualError(t, err, "signature verification failed for bluebanquise.infrastructure")
+	assert.Len(t, calls, 2)
+}
@@ -4,61 +4,366 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/user"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 )
 
-func CreateBluebanquiseUser(userName, userHome string) error {
-	utils.LogInfo("Creating BlueBanquise user", "user", userName, "home", userHome)
+// Sudoers modes supported when creating the BlueBanquise user.
+const (
+	SudoersModeNopasswd = "nopasswd"
+	SudoersModePasswd   = "passwd"
+	SudoersModeNone     = "none"
+)
+
+// sudoersDir holds the sudoers drop-ins, tests replace it.
+var sudoersDir = "/etc/sudoers.d"
+
+// shadowFile holds the password hashes checked in passwd mode, tests replace it.
+var shadowFile = "/etc/shadow"
+
+// runUserCommand runs the getent, groupadd, useradd, userdel and groupdel
+// commands of the user creation, tests replace it.
+var runUserCommand = func(name string, args ...string) error {
+	return exec.Command(name, args...).Run()
+}
+
+// EnvKeepSudoersFile is the drop-in of sudoersDir holding EnvKeepLine, shared
+// by every BlueBanquise user.
+const EnvKeepSudoersFile = "bluebanquise"
+
+// EnvKeepLine keeps PYTHONPATH in the environment of commands run with sudo.
+const EnvKeepLine = `Defaults env_keep += "PYTHONPATH"`
+
+// mainSudoersFile is the sudoers file expected to include sudoersDir.
+const mainSudoersFile = "/etc/sudoers"
+
+// DefaultShell is the login shell given to a created BlueBanquise user.
+const DefaultShell = "/bin/bash"
+
+// SudoersModes lists the accepted values for the sudoers mode.
+var SudoersModes = []string{SudoersModeNopasswd, SudoersModePasswd, SudoersModeNone}
+
+// ValidateShell checks that shell is the absolute path of an existing file.
+func ValidateShell(shell string) error {
+	if !filepath.IsAbs(shell) {
+		return fmt.Errorf("shell must be an absolute path: %s", shell)
+	}
+	if _, err := os.Stat(shell); err != nil {
+		return fmt.Errorf("shell %s does not exist", shell)
+	}
+	return nil
+}
+
+// InteractiveShell reports whether shell runs the commands of a login, as
+// opposed to nologin and false which refuse it and never read .bashrc.
+func InteractiveShell(shell string) bool {
+	switch filepath.Base(shell) {
+	case "nologin", "false":
+		return false
+	}
+	return true
+}
+
+// userShell returns the login shell of userName from the user database, tests
+// replace it.
+var userShell = func(userName string) (string, error) {
+	output, err := exec.Command("getent", "passwd", userName).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user %s: %v", userName, err)
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), ":")
+	if len(fields) < 7 {
+		return "", fmt.Errorf("unexpected passwd entry for user %s: %s", userName, strings.TrimSpace(string(output)))
+	}
+	return fields[6], nil
+}
+
+// ValidateSudoersMode checks that mode is one of the supported sudoers modes.
+func ValidateSudoersMode(mode string) error {
+	for _, m := range SudoersModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid sudoers mode %q (expected one of: %s)", mode, strings.Join(SudoersModes, ", "))
+}
+
+// CreateBluebanquiseUser creates the group and the system account userName
+// with the login shell shell, unless they exist, and writes its sudoers entry.
+func CreateBluebanquiseUser(userName, userHome, sudoersMode, shell string) error {
+	utils.LogInfo("Creating BlueBanquise user", "user", userName, "home", userHome, "shell", shell)
+
+	if userName == "" {
+		utils.LogError("User name is empty", nil)
+		return fmt.Errorf("user name cannot be empty")
+	}
+	if err := utils.ValidateInstallPath("home directory", userHome); err != nil {
+		utils.LogError("Invalid home directory", err, "home", userHome)
+		return err
+	}
+
 	fmt.Printf("Creating %s user... ", userName)
 
 	// Default UID/GID for bluebanquise user
 	uid := "377"
 	gid := "377"
 
+	// Undo what this run created when a later step fails, so a re-run does
+	// not skip a half-created user and hide the original failure
+	created := userCreation{userName: userName, userHome: userHome}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			created.rollback()
+		}
+	}()
+
 	// Check if group exists
-	if err := exec.Command("getent", "group", userName).Run(); err != nil {
+	if err := runUserCommand("getent", "group", userName); err != nil {
 		utils.LogInfo("Creating group", "group", userName, "gid", gid)
-		cmd := exec.Command("groupadd", "--gid", gid, userName)
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		if err := cmd.Run(); err != nil {
+		if err := runUserCommand("groupadd", "--gid", gid, userName); err != nil {
 			utils.LogError("Failed to create group", err, "group", userName, "gid", gid)
 			return fmt.Errorf("failed to create group: %v", err)
 		}
+		created.group = true
 	} else {
 		utils.LogInfo("Group already exists", "group", userName)
 	}
 
 	// Check if user exists
-	if err := exec.Command("getent", "passwd", userName).Run(); err != nil {
+	if err := runUserCommand("getent", "passwd", userName); err != nil {
 		utils.LogInfo("Creating user", "user", userName, "uid", uid, "gid", gid, "home", userHome)
-		cmd := exec.Command("useradd",
+		_, statErr := os.Stat(userHome)
+		created.home = os.IsNotExist(statErr)
+		if err := runUserCommand("useradd",
 			"--gid", gid,
 			"--uid", uid,
 			"--create-home",
 			"--home-dir", userHome,
-			"--shell", "/bin/bash",
-			"--system", userName)
-		cmd.Stdout = nil
-		cmd.Stderr = nil
-		if err := cmd.Run(); err != nil {
+			"--shell", shell,
+			"--system", userName); err != nil {
 			utils.LogError("Failed to create user", err, "user", userName, "uid", uid, "gid", gid)
 			return fmt.Errorf("failed to create user: %v", err)
 		}
+		created.user = true
 	} else {
 		utils.LogInfo("User already exists", "user", userName)
+		if existing, err := userShell(userName); err == nil && existing != shell {
+			utils.LogWarning("User already exists with another shell, keeping it", "user", userName, "shell", existing, "requested_shell", shell)
+			fmt.Printf("(keeping the %s shell of the existing user, not %s) ", existing, shell)
+		}
 	}
 
 	// Create sudoers entry
-	sudoers := fmt.Sprintf("%s ALL=(ALL:ALL) NOPASSWD:ALL\n", userName)
-	sudoersPath := fmt.Sprintf("/etc/sudoers.d/%s", userName)
-	utils.LogInfo("Creating sudoers entry", "user", userName, "path", sudoersPath)
+	sudoersPath := filepath.Join(sudoersDir, SudoersFileName(userName))
+	if _, err := os.Lstat(sudoersPath); os.IsNotExist(err) {
+		created.sudoersFile = sudoersPath
+	}
+	if err := writeSudoersEntry(sudoersDir, userName, userHome, sudoersMode); err != nil {
+		return err
+	}
+	if sudoersMode != SudoersModeNone {
+		checkSudoersInclude(mainSudoersFile, sudoersDir)
+	}
+	if sudoersMode == SudoersModePasswd {
+		checkUserPassword(shadowFile, userName)
+	}
+
+	succeeded = true
+	utils.LogInfo("BlueBanquise user created successfully", "user", userName, "home", userHome)
+	fmt.Println("OK")
+	return nil
+}
+
+// userCreation records what CreateBluebanquiseUser created in this run.
+type userCreation struct {
+	userName string
+	userHome string
+	group    bool
+	user     bool
+	// home is set when the home did not exist before useradd created it
+	home bool
+	// sudoersFile is the drop-in path when it did not exist before this run
+	sudoersFile string
+}
+
+// rollback undoes the steps recorded in c, in reverse order. Failures are
+// logged and do not stop the remaining steps.
+func (c userCreation) rollback() {
+	if c.sudoersFile != "" {
+		switch err := os.Remove(c.sudoersFile); {
+		case err == nil:
+			utils.LogInfo("Rollback: removed sudoers file", "path", c.sudoersFile)
+		case !os.IsNotExist(err):
+			utils.LogWarning("Rollback: failed to remove sudoers file", "path", c.sudoersFile, "error", err)
+		}
+	}
+	if c.user {
+		if err := runUserCommand("userdel", c.userName); err != nil {
+			utils.LogWarning("Rollback: failed to delete user", "user", c.userName, "error", err)
+		} else {
+			utils.LogInfo("Rollback: deleted user", "user", c.userName)
+		}
+		if c.home {
+			if err := os.RemoveAll(c.userHome); err != nil {
+				utils.LogWarning("Rollback: failed to remove home directory", "home", c.userHome, "error", err)
+			}
+		}
+	}
+	if c.group {
+		if err := runUserCommand("groupdel", c.userName); err != nil {
+			utils.LogWarning("Rollback: failed to delete group", "group", c.userName, "error", err)
+		} else {
+			utils.LogInfo("Rollback: deleted group", "group", c.userName)
+		}
+	}
+}
+
+// ValidateTargetUser refuses to install for root (by name, UID 0 or /root home)
+// unless allowRoot is set, since the venv, SSH keys and sudoers entry would be
+// written for the superuser.
+func ValidateTargetUser(userName, userHome string, allowRoot bool) error {
+	isRoot := userName == "root" || filepath.Clean(userHome) == "/root"
+	if u, err := user.Lookup(userName); err == nil && u.Uid == "0" {
+		isRoot = true
+	}
+
+	if !isRoot {
+		return nil
+	}
+
+	if allowRoot {
+		utils.LogWarning("Installing for the root user", "user", userName, "home", userHome)
+		return nil
+	}
+
+	utils.LogError("Refusing to install for the root user", nil, "user", userName, "home", userHome)
+	return fmt.Errorf("refusing to install for root (user %s, home %s): this would place the Ansible venv and SSH keys in root's home "+
+		"and add a redundant sudoers entry; use a dedicated user or pass --allow-root-user", userName, userHome)
+}
+
+// sudoersEntry returns the sudoers drop-in content for the given mode.
+// An empty string means no drop-in should be written.
+func sudoersEntry(userName, userHome, mode string) (string, error) {
+	switch mode {
+	case SudoersModeNopasswd:
+		return fmt.Sprintf("%s ALL=(ALL:ALL) NOPASSWD:ALL\n", userName), nil
+	case SudoersModePasswd:
+		return fmt.Sprintf("%s ALL=(ALL:ALL) ALL\n", userName), nil
+	case SudoersModeNone:
+		return "", nil
+	default:
+		return "", ValidateSudoersMode(mode)
+	}
+}
+
+// checkSudoersInclude warns when sudoersFile does not include dir, as the
+// drop-ins written there are then ignored by sudo. sudoersFile is never edited.
+// It reports whether the include directive was found.
+func checkSudoersInclude(sudoersFile, dir string) bool {
+	content, err := os.ReadFile(sudoersFile)
+	if err != nil {
+		utils.LogWarning("Could not read sudoers file to check its includes", "file", sudoersFile, "error", err)
+		return false
+	}
+	if sudoersIncludesDir(string(content), dir) {
+		return true
+	}
+
+	utils.LogWarning("Sudoers drop-in directory is not included, the sudoers entry is ignored", "file", sudoersFile, "dir", dir)
+	fmt.Printf("Warning: %s does not include %s, the sudoers entry written there is ignored.\n", sudoersFile, dir)
+	fmt.Printf("Add the following line to %s with visudo (use #includedir with sudo older than 1.9.1):\n", sudoersFile)
+	fmt.Printf("  @includedir %s\n", dir)
+	return false
+}
+
+// checkUserPassword warns when userName has no password it could type at the
+// sudo prompt of the passwd mode, as a user just created by useradd. The user
+// is kept, the password is set by an administrator with passwd.
+func checkUserPassword(shadow, userName string) {
+	ok, err := userHasPassword(shadow, userName)
+	if err != nil {
+		utils.LogWarning("Could not check the password of the user", "user", userName, "file", shadow, "error", err)
+		return
+	}
+	if ok {
+		return
+	}
+	utils.LogWarning("User has no password, sudo cannot be used in passwd mode until one is set", "user", userName)
+	fmt.Printf("Warning: %s has no password and cannot use sudo in passwd mode, set one with: passwd %s\n", userName, userName)
+}
+
+// userHasPassword reports whether the shadow entry of userName holds a password
+// hash. An empty field, "*" and the "!" or "!!" left by useradd are not one, and
+// neither is a hash locked with a leading "!".
+func userHasPassword(shadow, userName string) (bool, error) {
+	content, err := os.ReadFile(shadow)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 2 || fields[0] != userName {
+			continue
+		}
+		hash := fields[1]
+		return hash != "" && hash != "*" && !strings.HasPrefix(hash, "!"), nil
+	}
+	return false, fmt.Errorf("user %s not found in %s", userName, shadow)
+}
+
+// sudoersIncludesDir reports whether sudoers content has an #includedir or
+// @includedir directive for dir. "# includedir" with a space is a comment.
+func sudoersIncludesDir(content, dir string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || (fields[0] != "#includedir" && fields[0] != "@includedir") {
+			continue
+		}
+		if filepath.Clean(fields[1]) == filepath.Clean(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSudoersEntry writes the sudoers drop-in for userName into dir.
+func writeSudoersEntry(dir, userName, userHome, mode string) error {
+	sudoers, err := sudoersEntry(userName, userHome, mode)
+	if err != nil {
+		utils.LogError("Invalid sudoers mode", err, "mode", mode)
+		return err
+	}
+
+	if mode == SudoersModeNopasswd {
+		// Kept as the default for backward compatibility only.
+		utils.LogWarning("Granting unrestricted passwordless sudo, consider --sudoers-mode passwd", "user", userName, "mode", mode)
+	}
+
+	if sudoers == "" {
+		utils.LogInfo("Skipping sudoers entry", "user", userName, "mode", mode)
+		return nil
+	}
+
+	fileName := SudoersFileName(userName)
+	if fileName != userName {
+		utils.LogWarning("User name would make sudo ignore the sudoers file, using a sanitized file name", "user", userName, "file", fileName)
+		fmt.Printf("Warning: sudo ignores %s/%s, writing the sudoers entry of %s to %s/%s instead\n", dir, userName, userName, dir, fileName)
+	}
+	sudoersPath := filepath.Join(dir, fileName)
+	if err := checkSudoersFileUser(sudoersPath, userName); err != nil {
+		utils.LogError("Sudoers file belongs to another user", err, "user", userName, "path", sudoersPath)
+		return err
+	}
+	utils.LogInfo("Creating sudoers entry", "user", userName, "path", sudoersPath, "mode", mode)
 
 	// Create sudoers.d directory if it doesn't exist
-	if err := os.MkdirAll("/etc/sudoers.d", 0755); err != nil {
-		utils.LogError("Failed to create sudoers.d directory", err, "path", "/etc/sudoers.d")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		utils.LogError("Failed to create sudoers.d directory", err, "path", dir)
 		return fmt.Errorf("failed to create sudoers.d directory: %v", err)
 	}
 
@@ -67,8 +372,44 @@ func CreateBluebanquiseUser(userName, userHome string) error {
 		return fmt.Errorf("failed to write sudoers file: %v", err)
 	}
 
-	utils.LogInfo("BlueBanquise user created successfully", "user", userName, "home", userHome)
-	fmt.Println("OK")
+	return nil
+}
+
+// SudoersFileName returns the sudoers drop-in file name of userName. sudo skips
+// the files of an included directory whose name contains a dot or ends with a
+// tilde, so every character other than a letter, digit, dash or underscore is
+// replaced with an underscore. Several users can map to the same name, e.g.
+// john.doe and john_doe, writeSudoersEntry then refuses to replace the file of
+// the other one.
+func SudoersFileName(userName string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, userName)
+}
+
+// checkSudoersFileUser fails when the sudoers file at path has a rule for a
+// user other than userName, as a user whose name maps to the same file name.
+// A missing file, comments, aliases and Defaults lines are accepted.
+func checkSudoersFileUser(path, userName string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read sudoers file %s: %v", path, err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], "Defaults") || strings.HasSuffix(fields[0], "_Alias") {
+			continue
+		}
+		if fields[0] != userName {
+			return fmt.Errorf("sudoers file %s holds the rules of %s, refusing to replace it with those of %s: rename one of the users", path, fields[0], userName)
+		}
+	}
 	return nil
 }
 
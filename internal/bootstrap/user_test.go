@@ -1,11 +1,16 @@
 package bootstrap
 
 import (
+	"errors"
 	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -47,7 +52,7 @@ func TestCreateBluebanquiseUser(t *testing.T) {
 				t.Skip("Skipping user creation test - requires root privileges")
 			}
 
-			err := CreateBluebanquiseUser(tt.userName, tt.userHome)
+			err := CreateBluebanquiseUser(tt.userName, tt.userHome, SudoersModeNopasswd, DefaultShell)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -64,6 +69,91 @@ func TestCreateBluebanquiseUser(t *testing.T) {
 	}
 }
 
+func TestCreateBluebanquiseUserRollback(t *testing.T) {
+	originalRun, originalDir := runUserCommand, sudoersDir
+	defer func() { runUserCommand, sudoersDir = originalRun, originalDir }()
+
+	tests := []struct {
+		name          string
+		groupExists   bool
+		failUseradd   bool
+		failSudoers   bool
+		wantRollback  []string
+		wantHomeGone  bool
+		wantNoSudoers bool
+	}{
+		{
+			name:         "useradd fails after groupadd",
+			failUseradd:  true,
+			wantRollback: []string{"groupdel rollbackuser"},
+		},
+		{
+			name:        "useradd fails with an existing group",
+			groupExists: true,
+			failUseradd: true,
+		},
+		{
+			name:          "Sudoers write fails after useradd",
+			failSudoers:   true,
+			wantRollback:  []string{"userdel rollbackuser", "groupdel rollbackuser"},
+			wantHomeGone:  true,
+			wantNoSudoers: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userHome := filepath.Join(t.TempDir(), "rollbackuser")
+			sudoersDir = t.TempDir()
+			if tt.failSudoers {
+				// A file in place of the directory makes the sudoers write fail
+				sudoersDir = filepath.Join(t.TempDir(), "sudoers.d")
+				require.NoError(t, os.WriteFile(sudoersDir, nil, 0644))
+			}
+
+			var rollback []string
+			runUserCommand = func(name string, args ...string) error {
+				switch name {
+				case "getent":
+					if args[0] == "group" && tt.groupExists {
+						return nil
+					}
+					return errors.New("exit status 2")
+				case "useradd":
+					if tt.failUseradd {
+						return errors.New("exit status 4")
+					}
+					return os.MkdirAll(userHome, 0755)
+				case "userdel", "groupdel":
+					rollback = append(rollback, name+" "+strings.Join(args, " "))
+				}
+				return nil
+			}
+
+			err := CreateBluebanquiseUser("rollbackuser", userHome, SudoersModeNopasswd, DefaultShell)
+			require.Error(t, err)
+			assert.Equal(t, tt.wantRollback, rollback)
+			if tt.wantHomeGone {
+				assert.NoDirExists(t, userHome)
+			}
+			if tt.wantNoSudoers {
+				assert.NoFileExists(t, filepath.Join(sudoersDir, "rollbackuser"))
+			}
+		})
+	}
+
+	t.Run("Existing sudoers file is kept", func(t *testing.T) {
+		sudoersDir = t.TempDir()
+		existing := filepath.Join(sudoersDir, "rollbackuser")
+		require.NoError(t, os.WriteFile(existing, []byte("rollbackuser ALL=(ALL) ALL\n"), 0440))
+		runUserCommand = func(name string, args ...string) error { return nil }
+
+		err := CreateBluebanquiseUser("rollbackuser", t.TempDir(), "invalid", DefaultShell)
+		require.Error(t, err)
+		assert.FileExists(t, existing)
+	})
+}
+
 func TestGetUserInfo(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -102,3 +192,297 @@ func TestGetUserInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateSudoersMode(t *testing.T) {
+	for _, mode := range SudoersModes {
+		assert.NoError(t, ValidateSudoersMode(mode))
+	}
+	assert.Error(t, ValidateSudoersMode(""))
+	assert.Error(t, ValidateSudoersMode("invalid"))
+	assert.Error(t, ValidateSudoersMode("scoped"))
+}
+
+func TestSudoersEntry(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "NOPASSWD mode",
+			mode:     SudoersModeNopasswd,
+			expected: "bluebanquise ALL=(ALL:ALL) NOPASSWD:ALL\n",
+		},
+		{
+			name:     "Password mode",
+			mode:     SudoersModePasswd,
+			expected: "bluebanquise ALL=(ALL:ALL) ALL\n",
+		},
+		{
+			name:     "None mode",
+			mode:     SudoersModeNone,
+			expected: "",
+		},
+		{
+			name:        "Invalid mode",
+			mode:        "invalid",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := sudoersEntry("bluebanquise", "/var/lib/bluebanquise", tt.mode)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, entry)
+		})
+	}
+}
+
+func TestWriteSudoersEntry(t *testing.T) {
+	t.Run("Skip when mode is none", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "sudoers.d")
+		err := writeSudoersEntry(dir, "bluebanquise", "/var/lib/bluebanquise", SudoersModeNone)
+		assert.NoError(t, err)
+		assert.NoDirExists(t, dir)
+	})
+
+	t.Run("Write entry for passwd mode", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "sudoers.d")
+		err := writeSudoersEntry(dir, "bluebanquise", "/var/lib/bluebanquise", SudoersModePasswd)
+		require.NoError(t, err)
+		data, err := os.ReadFile(filepath.Join(dir, "bluebanquise"))
+		require.NoError(t, err)
+		assert.Equal(t, "bluebanquise ALL=(ALL:ALL) ALL\n", string(data))
+	})
+
+	t.Run("Sanitize user name with a dot", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "sudoers.d")
+		err := writeSudoersEntry(dir, "blue.banquise", "/var/lib/blue.banquise", SudoersModePasswd)
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(dir, "blue.banquise"))
+		data, err := os.ReadFile(filepath.Join(dir, "blue_banquise"))
+		require.NoError(t, err)
+		assert.Equal(t, "blue.banquise ALL=(ALL:ALL) ALL\n", string(data))
+	})
+
+	t.Run("Refuse the file of another user with the same file name", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "sudoers.d")
+		require.NoError(t, writeSudoersEntry(dir, "john_doe", "/home/john_doe", SudoersModePasswd))
+		err := writeSudoersEntry(dir, "john.doe", "/home/john.doe", SudoersModeNopasswd)
+		assert.ErrorContains(t, err, "holds the rules of john_doe")
+		data, err := os.ReadFile(filepath.Join(dir, "john_doe"))
+		require.NoError(t, err)
+		assert.Equal(t, "john_doe ALL=(ALL:ALL) ALL\n", string(data))
+
+		// The same user replaces its own file
+		require.NoError(t, writeSudoersEntry(dir, "john_doe", "/home/john_doe", SudoersModeNopasswd))
+	})
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "sudoers.d")
+		err := writeSudoersEntry(dir, "bluebanquise", "/var/lib/bluebanquise", "invalid")
+		assert.Error(t, err)
+		assert.NoDirExists(t, dir)
+	})
+}
+
+func TestSudoersFileName(t *testing.T) {
+	assert.Equal(t, "bluebanquise", SudoersFileName("bluebanquise"))
+	assert.Equal(t, "bb-admin_1", SudoersFileName("bb-admin_1"))
+	assert.Equal(t, "john_doe", SudoersFileName("john.doe"))
+	assert.Equal(t, "admin_", SudoersFileName("admin~"))
+	assert.Equal(t, "svc_", SudoersFileName("svc$"))
+}
+
+func TestSudoersIncludesDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{name: "Legacy directive", content: "Defaults env_reset\nroot ALL=(ALL) ALL\n#includedir /etc/sudoers.d\n", expected: true},
+		{name: "Directive from sudo 1.9.1", content: "root ALL=(ALL:ALL) ALL\n@includedir /etc/sudoers.d\n", expected: true},
+		{name: "Trailing slash", content: "#includedir /etc/sudoers.d/\n", expected: true},
+		{name: "Indented directive", content: "  @includedir   /etc/sudoers.d\n", expected: true},
+		{name: "Commented out", content: "# includedir /etc/sudoers.d\n", expected: false},
+		{name: "Commented out directive", content: "# @includedir /etc/sudoers.d\n", expected: false},
+		{name: "Other directory", content: "@includedir /usr/local/etc/sudoers.d\n", expected: false},
+		{name: "Single file include", content: "@include /etc/sudoers.d/bluebanquise\n", expected: false},
+		{name: "Hardened without include", content: "Defaults use_pty\nroot ALL=(ALL) ALL\n", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sudoersIncludesDir(tt.content, "/etc/sudoers.d"))
+		})
+	}
+}
+
+func TestCheckSudoersInclude(t *testing.T) {
+	utils.InitTestLogger()
+
+	dir := t.TempDir()
+	sudoers := filepath.Join(dir, "sudoers")
+	content := "root ALL=(ALL) ALL\n"
+	require.NoError(t, os.WriteFile(sudoers, []byte(content), 0440))
+
+	assert.False(t, checkSudoersInclude(sudoers, "/etc/sudoers.d"))
+	data, err := os.ReadFile(sudoers)
+	require.NoError(t, err)
+	assert.Equal(t, content, string(data), "the sudoers file must not be edited")
+
+	require.NoError(t, os.WriteFile(sudoers, []byte(content+"@includedir /etc/sudoers.d\n"), 0440))
+	assert.True(t, checkSudoersInclude(sudoers, "/etc/sudoers.d"))
+
+	assert.False(t, checkSudoersInclude(filepath.Join(dir, "missing"), "/etc/sudoers.d"))
+}
+
+func TestEnsureSudoersEnvKeepSkipped(t *testing.T) {
+	// With sudoers management disabled nothing must be written to /etc/sudoers.d.
+	assert.NoError(t, ensureSudoersEnvKeep(SudoersModeNone))
+}
+
+func TestValidateTargetUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		userName    string
+		userHome    string
+		allowRoot   bool
+		expectError bool
+	}{
+		{"Regular user", "bluebanquise", "/var/lib/bluebanquise", false, false},
+		{"Root user refused", "root", "/var/lib/bluebanquise", false, true},
+		{"Root home refused", "bluebanquise", "/root", false, true},
+		{"Root home with trailing slash refused", "bluebanquise", "/root/", false, true},
+		{"Root user allowed", "root", "/root", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTargetUser(tt.userName, tt.userHome, tt.allowRoot)
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "--allow-root-user")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckUserHome(t *testing.T) {
+	original := lookupUser
+	defer func() { lookupUser = original }()
+	lookupUser = func(name string) (*user.User, error) {
+		if name == "bluebanquise" {
+			return &user.User{Username: name, HomeDir: "/home/bluebanquise"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+
+	tests := []struct {
+		name     string
+		userName string
+		userHome string
+		wantErr  bool
+	}{
+		{name: "New user", userName: "bbadmin", userHome: "/var/lib/bbadmin"},
+		{name: "Same home", userName: "bluebanquise", userHome: "/home/bluebanquise"},
+		{name: "Same home with trailing slash", userName: "bluebanquise", userHome: "/home/bluebanquise/"},
+		{name: "Different home", userName: "bluebanquise", userHome: "/var/lib/bluebanquise", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUserHome(tt.userName, tt.userHome)
+			if !tt.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "user bluebanquise already exists with home /home/bluebanquise, not /var/lib/bluebanquise")
+			assert.Contains(t, err.Error(), "--home /home/bluebanquise")
+		})
+	}
+}
+
+func TestCreateBluebanquiseUserShell(t *testing.T) {
+	originalRun, originalDir, originalShell := runUserCommand, sudoersDir, userShell
+	defer func() { runUserCommand, sudoersDir, userShell = originalRun, originalDir, originalShell }()
+
+	var useradd []string
+	runUserCommand = func(name string, args ...string) error {
+		switch name {
+		case "getent":
+			return errors.New("exit status 2")
+		case "useradd":
+			useradd = args
+		}
+		return nil
+	}
+	sudoersDir = t.TempDir()
+
+	require.NoError(t, CreateBluebanquiseUser("shelluser", t.TempDir(), SudoersModeNone, "/usr/sbin/nologin"))
+	assert.Contains(t, strings.Join(useradd, " "), "--shell /usr/sbin/nologin")
+
+	// An existing user keeps its shell
+	useradd = nil
+	runUserCommand = func(name string, args ...string) error {
+		if name == "useradd" {
+			useradd = args
+		}
+		return nil
+	}
+	userShell = func(string) (string, error) { return "/bin/bash", nil }
+	require.NoError(t, CreateBluebanquiseUser("shelluser", t.TempDir(), SudoersModeNone, "/usr/sbin/nologin"))
+	assert.Nil(t, useradd)
+}
+
+func TestUserHasPassword(t *testing.T) {
+	shadow := filepath.Join(t.TempDir(), "shadow")
+	content := strings.Join([]string{
+		"root:$6$salt$hash:19000:0:99999:7:::",
+		"created:!!:19000:0:99999:7:::",
+		"locked:!$6$salt$hash:19000:0:99999:7:::",
+		"disabled:*:19000:0:99999:7:::",
+		"empty::19000:0:99999:7:::",
+	}, "\n")
+	require.NoError(t, os.WriteFile(shadow, []byte(content), 0600))
+
+	tests := []struct {
+		userName string
+		expected bool
+	}{
+		{"root", true},
+		{"created", false},
+		{"locked", false},
+		{"disabled", false},
+		{"empty", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userName, func(t *testing.T) {
+			ok, err := userHasPassword(shadow, tt.userName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, ok)
+		})
+	}
+
+	_, err := userHasPassword(shadow, "missing")
+	assert.Error(t, err)
+	_, err = userHasPassword(filepath.Join(t.TempDir(), "none"), "root")
+	assert.Error(t, err)
+}
+
+func TestInteractiveShell(t *testing.T) {
+	assert.True(t, InteractiveShell("/bin/bash"))
+	assert.True(t, InteractiveShell("/bin/zsh"))
+	assert.False(t, InteractiveShell("/usr/sbin/nologin"))
+	assert.False(t, InteractiveShell("/sbin/nologin"))
+	assert.False(t, InteractiveShell("/bin/false"))
+}
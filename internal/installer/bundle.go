@@ -0,0 +1,106 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// detectOS returns the OS the requirements of an offline bundle are picked for,
+// tests replace it.
+var detectOS = system.DetectOS
+
+// offlineBundle holds the paths derived from an offline bundle, empty when the
+// bundle has no such component.
+type offlineBundle struct {
+	collections  string
+	requirements string
+	coreVars     string
+}
+
+// checkCollectionsSource validates that exactly one of --collections-path and
+// --from-bundle is given, none being needed with --skip-collections.
+func checkCollectionsSource(opts OfflineOptions) error {
+	if opts.CollectionsPath != "" && opts.FromBundle != "" {
+		utils.LogError("Conflicting collections sources", nil, "collections_path", opts.CollectionsPath, "from_bundle", opts.FromBundle)
+		return fmt.Errorf("--collections-path and --from-bundle are mutually exclusive")
+	}
+	if opts.CollectionsPath == "" && len(opts.ExtraCollectionsPaths) > 0 {
+		utils.LogError("Extra collections paths without collections path", nil, "extra_collections_paths", opts.ExtraCollectionsPaths)
+		return fmt.Errorf("extra collections paths need --collections-path")
+	}
+	if opts.CollectionsPath == "" && opts.FromBundle == "" && !opts.SkipCollections {
+		utils.LogError("Missing required path", nil, "collections_path", opts.CollectionsPath, "from_bundle", opts.FromBundle)
+		return fmt.Errorf("--collections-path or --from-bundle is required for offline installation (unless --skip-collections is set)")
+	}
+	return nil
+}
+
+// resolveOfflineBundle derives the component paths of a bundle directory laid
+// out by the download command. Requirements downloaded with --target-os are
+// picked from the subdirectory of the detected OS.
+func resolveOfflineBundle(dir string) (offlineBundle, error) {
+	var bundle offlineBundle
+
+	if collections := filepath.Join(dir, "collections"); isDir(collections) {
+		bundle.collections = collections
+	}
+	if coreVars := filepath.Join(dir, "core-vars", "bb_core.yml"); fileExists(coreVars) {
+		bundle.coreVars = coreVars
+	}
+
+	requirements := filepath.Join(dir, "requirements")
+	if isDir(requirements) {
+		targets, err := bundleRequirementsTargets(requirements)
+		if err != nil {
+			return offlineBundle{}, err
+		}
+		bundle.requirements = requirements
+		if len(targets) > 0 {
+			osID, version, err := detectOS()
+			if err != nil {
+				return offlineBundle{}, fmt.Errorf("error detecting OS to pick the bundle requirements: %v", err)
+			}
+			target := system.PythonTarget{OSID: osID, Version: version}.Dir()
+			if !slices.Contains(targets, target) {
+				return offlineBundle{}, fmt.Errorf("bundle has no requirements for %s %s, found: %v", osID, version, targets)
+			}
+			bundle.requirements = filepath.Join(requirements, target)
+		}
+	}
+
+	utils.LogInfo("Offline bundle resolved", "path", dir,
+		"collections", bundle.collections,
+		"requirements", bundle.requirements,
+		"core_vars", bundle.coreVars)
+	return bundle, nil
+}
+
+// bundleRequirementsTargets returns the per-OS subdirectories of a bundle
+// requirements directory, none when the packages are stored directly in it.
+func bundleRequirementsTargets(requirements string) ([]string, error) {
+	entries, err := os.ReadDir(requirements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle requirements: %v", err)
+	}
+	var targets []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			return nil, nil
+		}
+		targets = append(targets, entry.Name())
+	}
+	sort.Strings(targets)
+	return targets, nil
+}
+
+// fileExists reports whether path is a regular file.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
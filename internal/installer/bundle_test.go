@@ -0,0 +1,107 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCollectionsSource(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name    string
+		opts    OfflineOptions
+		wantErr string
+	}{
+		{name: "Collections path", opts: OfflineOptions{CollectionsPath: "/srv/collections"}},
+		{name: "Bundle", opts: OfflineOptions{FromBundle: "/srv/offline.tar.gz"}},
+		{name: "Neither with skipped collections", opts: OfflineOptions{SkipCollections: true}},
+		{
+			name:    "Both",
+			opts:    OfflineOptions{CollectionsPath: "/srv/collections", FromBundle: "/srv/offline.tar.gz"},
+			wantErr: "--collections-path and --from-bundle are mutually exclusive",
+		},
+		{
+			name:    "Both with skipped collections",
+			opts:    OfflineOptions{CollectionsPath: "/srv/collections", FromBundle: "/srv/offline", SkipCollections: true},
+			wantErr: "--collections-path and --from-bundle are mutually exclusive",
+		},
+		{
+			name: "Several collections paths",
+			opts: OfflineOptions{CollectionsPath: "/srv/collections", ExtraCollectionsPaths: []string{"/srv/extra"}},
+		},
+		{
+			name:    "Extra collections paths alone",
+			opts:    OfflineOptions{ExtraCollectionsPaths: []string{"/srv/extra"}},
+			wantErr: "extra collections paths need --collections-path",
+		},
+		{
+			name:    "Neither",
+			opts:    OfflineOptions{},
+			wantErr: "--collections-path or --from-bundle is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCollectionsSource(tt.opts)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestResolveOfflineBundle(t *testing.T) {
+	utils.InitTestLogger()
+
+	oldDetectOS := detectOS
+	defer func() { detectOS = oldDetectOS }()
+	detectOS = func() (string, string, error) { return "rhel", "9", nil }
+
+	t.Run("Flat requirements", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "collections"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "requirements"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements", "ansible-9.2.0.tar.gz"), []byte("x"), 0644))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "core-vars"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "core-vars", "bb_core.yml"), []byte("x"), 0644))
+
+		bundle, err := resolveOfflineBundle(dir)
+		require.NoError(t, err)
+		assert.Equal(t, offlineBundle{
+			collections:  filepath.Join(dir, "collections"),
+			requirements: filepath.Join(dir, "requirements"),
+			coreVars:     filepath.Join(dir, "core-vars", "bb_core.yml"),
+		}, bundle)
+	})
+
+	t.Run("Requirements of the detected OS", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "collections"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "requirements", "rhel-9"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "requirements", "ubuntu-22.04"), 0755))
+
+		bundle, err := resolveOfflineBundle(dir)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "requirements", "rhel-9"), bundle.requirements)
+		assert.Empty(t, bundle.coreVars)
+	})
+
+	t.Run("No requirements for the detected OS", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "requirements", "ubuntu-22.04"), 0755))
+
+		_, err := resolveOfflineBundle(dir)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no requirements for rhel 9")
+	})
+}
@@ -0,0 +1,104 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// CollectionsCheck holds the verdicts on the files of one collections path.
+type CollectionsCheck struct {
+	Path  string                       `json:"path"`
+	Files []utils.CollectionFileReport `json:"files"`
+}
+
+// Failed reports whether a file of the path would make the installation fail,
+// or the path holds no valid collection.
+func (c CollectionsCheck) Failed() bool {
+	valid := false
+	for _, file := range c.Files {
+		if file.Failed() {
+			return true
+		}
+		valid = valid || file.Verdict == utils.FileValidCollection
+	}
+	return !valid
+}
+
+// CheckCollections returns a verdict for each file of the collections paths of
+// opts, or of the collections directory of its bundle, without installing
+// anything. Bundles are extracted and HTTP(S) indexes downloaded as the
+// offline installation does; the files themselves are all read through
+// instead of stopping at the first bad one.
+func (i *Installer) CheckCollections(ctx context.Context, opts OfflineOptions) ([]CollectionsCheck, error) {
+	// The collections are checked whatever --skip-collections says
+	opts.SkipCollections = false
+	if err := checkCollectionsSource(opts); err != nil {
+		return nil, err
+	}
+	paths := append([]string{opts.CollectionsPath}, opts.ExtraCollectionsPaths...)
+	if opts.FromBundle != "" {
+		paths = []string{opts.FromBundle}
+	}
+	for _, path := range paths {
+		if err := validateInstallPaths(map[string]string{"--collections-path": path}); err != nil {
+			return nil, err
+		}
+	}
+	utils.LogInfo("Checking collections", "paths", paths, "from_bundle", opts.FromBundle)
+
+	if opts.FromBundle != "" {
+		dir, cleanup, err := utils.PrepareOfflineBundle(opts.FromBundle, opts.FollowSymlinks)
+		if err != nil {
+			utils.LogError("Offline bundle validation failed", err, "path", opts.FromBundle)
+			return nil, fmt.Errorf("offline bundle validation failed: %w", err)
+		}
+		defer cleanup()
+		collections := filepath.Join(dir, "collections")
+		if !isDir(collections) {
+			return nil, fmt.Errorf("offline bundle %s has no collections directory", opts.FromBundle)
+		}
+		paths = []string{collections}
+	}
+
+	var checks []CollectionsCheck
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("collections check interrupted: %w", err)
+		}
+		if utils.IsGitCollectionSource(path) {
+			utils.LogError("Cannot check git collections source", nil, "path", utils.RedactURL(path))
+			return nil, fmt.Errorf("git+ collections sources cannot be checked, ansible-galaxy clones them at installation")
+		}
+
+		dir := path
+		if utils.IsHTTPCollectionSource(path) {
+			downloaded, cleanup, err := downloadCollectionsIndex(ctx, path, opts.Mirror)
+			if err != nil {
+				return nil, err
+			}
+			defer cleanup()
+			dir = downloaded
+		}
+		dir, cleanup, err := utils.PrepareCollectionsPath(dir, opts.FollowSymlinks)
+		if err != nil {
+			utils.LogError("Collections validation failed", err, "path", path)
+			return nil, fmt.Errorf("collections validation failed: %w", err)
+		}
+		defer cleanup()
+
+		files, err := utils.CheckCollectionFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		check := CollectionsCheck{Path: utils.RedactURL(path), Files: files}
+		if opts.FromBundle != "" {
+			check.Path = opts.FromBundle
+		}
+		utils.LogInfo("Collections path checked", "path", check.Path, "files", len(files), "failed", check.Failed())
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
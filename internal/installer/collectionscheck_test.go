@@ -0,0 +1,49 @@
+package installer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCollections(t *testing.T) {
+	utils.InitTestLogger()
+
+	first, second := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(first, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("<html>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(second, "README.txt"), []byte("notes"), 0644))
+
+	checks, err := New().CheckCollections(context.Background(), OfflineOptions{CollectionsPath: first, ExtraCollectionsPaths: []string{second}})
+	require.NoError(t, err)
+	require.Len(t, checks, 2)
+	assert.Equal(t, first, checks[0].Path)
+	assert.Equal(t, []utils.CollectionFileReport{{
+		File:    "bluebanquise-infrastructure-3.0.0.tar.gz",
+		Verdict: utils.FileWrongFormat,
+		Detail:  "not gzip compressed: unexpected EOF",
+	}}, checks[0].Files)
+	assert.True(t, checks[0].Failed())
+	assert.Equal(t, utils.FileNotArchive, checks[1].Files[0].Verdict)
+	assert.True(t, checks[1].Failed(), "no valid collection")
+
+	// The collections directory of a bundle is checked
+	bundle := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(bundle, "collections"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bundle, "collections", "notes.txt"), []byte("notes"), 0644))
+	checks, err = New().CheckCollections(context.Background(), OfflineOptions{FromBundle: bundle})
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	assert.Equal(t, bundle, checks[0].Path)
+	assert.Equal(t, "notes.txt", checks[0].Files[0].File)
+
+	_, err = New().CheckCollections(context.Background(), OfflineOptions{})
+	assert.ErrorContains(t, err, "--collections-path or --from-bundle is required")
+
+	_, err = New().CheckCollections(context.Background(), OfflineOptions{CollectionsPath: "git+https://git.example.com/bluebanquise.git"})
+	assert.ErrorContains(t, err, "git+ collections sources cannot be checked")
+}
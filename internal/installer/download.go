@@ -0,0 +1,419 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// DownloadOptions selects the components downloaded for an offline installation.
+type DownloadOptions struct {
+	// Path is the base directory, components go to its collections,
+	// requirements and core-vars subdirectories.
+	Path         string
+	Collections  bool
+	Requirements bool
+	CoreVars     bool
+	// AnsibleVersion pins the Ansible release downloaded with Requirements.
+	AnsibleVersion string
+	// TargetOS lists os:version targets, e.g. rhel:9, Requirements are
+	// downloaded for, each into its own requirements subdirectory. The host OS
+	// is used when empty.
+	TargetOS []string
+	// TargetPython and TargetPlatforms override the Python version and wheel
+	// platforms of the TargetOS targets. Without TargetOS, both are required
+	// and describe a single target downloaded to the requirements directory.
+	TargetPython    string
+	TargetPlatforms []string
+	// KeepTemp keeps the temporary virtual environment used to download collections.
+	KeepTemp bool
+	// DryRun prints the planned actions without downloading or writing anything.
+	DryRun bool
+}
+
+// Collections downloaded by Download.
+const (
+	bluebanquiseCollectionSource     = "git+https://github.com/bluebanquise/bluebanquise.git#/collections/infrastructure,master"
+	communityGeneralCollectionSource = "community.general"
+)
+
+// runCommand runs an external command, tests replace it to avoid creating real environments.
+var runCommand = utils.RunCommand
+
+// downloadRequirements runs pip download, tests replace it to avoid network access.
+var downloadRequirements = utils.DownloadRequirementsFor
+
+// downloadFile downloads the core variables, tests replace it.
+var downloadFile = utils.DownloadFileWithRetry
+
+// Download downloads the selected components for an offline installation.
+func (i *Installer) Download(ctx context.Context, opts DownloadOptions) error {
+	if opts.Path == "" {
+		utils.LogError("Missing download path", nil)
+		return fmt.Errorf("--path is required")
+	}
+
+	if !opts.Collections && !opts.Requirements && !opts.CoreVars {
+		utils.LogError("No download type specified", nil)
+		return fmt.Errorf("specify at least one of --collections, --requirements, or --core-vars")
+	}
+
+	if _, err := requirementsTargets(opts); err != nil {
+		utils.LogError("Invalid target OS", err, "target_os", opts.TargetOS)
+		return err
+	}
+
+	utils.LogInfo("Starting BlueBanquise download",
+		"path", opts.Path,
+		"collections", opts.Collections,
+		"requirements", opts.Requirements,
+		"core-vars", opts.CoreVars,
+		"dry_run", opts.DryRun)
+
+	if opts.DryRun {
+		plan, err := downloadPlan(opts)
+		if err != nil {
+			utils.LogError("Error planning download", err)
+			return err
+		}
+		fmt.Println("Dry run, the following actions would be performed:")
+		for _, step := range plan {
+			utils.LogInfo("Planned download action", "action", step)
+			fmt.Printf("  %s\n", step)
+		}
+		return nil
+	}
+
+	// Create base download directory
+	if err := os.MkdirAll(opts.Path, 0755); err != nil {
+		utils.LogError("Error creating download directory", err, "path", opts.Path)
+		return fmt.Errorf("error creating download directory: %v", err)
+	}
+
+	steps := []struct {
+		enabled bool
+		run     func(DownloadOptions) error
+	}{
+		{opts.Collections, downloadCollectionsToPath},
+		{opts.Requirements, downloadRequirementsToPath},
+		{opts.CoreVars, func(opts DownloadOptions) error { return downloadCoreVarsToPath(ctx, opts) }},
+	}
+	for _, step := range steps {
+		if !step.enabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("download interrupted: %w", err)
+		}
+		if err := step.run(opts); err != nil {
+			return err
+		}
+	}
+
+	script, err := writeOfflineScript(opts.Path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Offline installation script written to: %s\n", script)
+	fmt.Println("Transfer the whole directory to your target machine and run it from the directory of bluebanquise-installer:")
+	fmt.Printf("  %s [offline flags...]\n", script)
+	return nil
+}
+
+// ansibleCorePipArgs returns the python3 arguments installing ansible-core in
+// the temporary virtual environment.
+func ansibleCorePipArgs() []string {
+	args := append([]string{"-m", "pip", "install"}, utils.PipTLSArgs()...)
+	return append(args, "ansible-core")
+}
+
+// downloadTempVenv returns the temporary virtual environment used to download
+// collections, under the state directory when one is set.
+func downloadTempVenv() string {
+	return filepath.Join(utils.TempDir(), "bluebanquise_download_venv")
+}
+
+func downloadCollectionsToPath(opts DownloadOptions) error {
+	collectionsPath := filepath.Join(opts.Path, "collections")
+	utils.LogInfo("Downloading collections", "path", collectionsPath)
+
+	// Create temporary Python environment outside download directory
+	tempVenv := downloadTempVenv()
+	if err := downloadCollectionTarballs(collectionsPath, tempVenv, opts.KeepTemp); err != nil {
+		utils.LogError("Error downloading collections", err, "path", collectionsPath)
+		return fmt.Errorf("error downloading collections: %v", err)
+	}
+
+	utils.LogInfo("Collections downloaded successfully", "path", collectionsPath)
+	fmt.Printf("Collections downloaded successfully to: %s\n", collectionsPath)
+	fmt.Println("Transfer this directory to your target machine and use with:")
+	fmt.Printf("  ./bluebanquise-installer offline --collections-path %s\n", collectionsPath)
+	return nil
+}
+
+// downloadCollectionTarballs downloads the collection tarballs into collectionsPath
+// using ansible-galaxy from a temporary virtual environment. The environment is
+// removed afterwards unless keepTemp is set, in which case its path is printed.
+func downloadCollectionTarballs(collectionsPath, tempVenv string, keepTemp bool) error {
+	// Create collections directory
+	if err := os.MkdirAll(collectionsPath, 0755); err != nil {
+		utils.LogError("Error creating collections directory", err, "path", collectionsPath)
+		return fmt.Errorf("error creating collections directory: %v", err)
+	}
+
+	defer func() {
+		if keepTemp {
+			utils.LogInfo("Keeping temporary environment", "path", tempVenv)
+			fmt.Printf("Temporary environment kept at: %s\n", tempVenv)
+			return
+		}
+		// Clean up temp environment
+		if err := os.RemoveAll(tempVenv); err != nil {
+			utils.LogWarning("Could not remove temporary environment", "error", err, "path", tempVenv)
+			fmt.Printf("Warning: could not remove temporary environment: %v\n", err)
+		}
+	}()
+
+	if err := runCommand("/usr/bin/python3", "-m", "venv", tempVenv); err != nil {
+		utils.LogError("Error creating temporary virtual environment", err, "path", tempVenv)
+		return fmt.Errorf("error creating temporary virtual environment: %v", err)
+	}
+
+	// Install ansible-galaxy in temp environment
+	python3 := filepath.Join(tempVenv, "bin", "python3")
+	if err := runCommand(python3, ansibleCorePipArgs()...); err != nil {
+		utils.LogError("Error installing ansible-core", err)
+		return fmt.Errorf("error installing ansible-core: %v", err)
+	}
+
+	// Download tarballs
+	ansibleGalaxy := filepath.Join(tempVenv, "bin", "ansible-galaxy")
+
+	utils.LogInfo("Downloading BlueBanquise collection tarball")
+	fmt.Println("Downloading BlueBanquise collection tarball...")
+	command, args := utils.GalaxyTLSCommand(ansibleGalaxy, "download", bluebanquiseCollectionSource, "-p", collectionsPath)
+	if err := runCommand(command, args...); err != nil {
+		utils.LogError("Error downloading BlueBanquise tarball", err)
+		return fmt.Errorf("error downloading BlueBanquise tarball: %v", err)
+	}
+
+	utils.LogInfo("Downloading community.general collection tarball")
+	fmt.Println("Downloading community.general collection tarball...")
+	command, args = utils.GalaxyTLSCommand(ansibleGalaxy, "download", communityGeneralCollectionSource, "-p", collectionsPath)
+	if err := runCommand(command, args...); err != nil {
+		utils.LogError("Error downloading community.general tarball", err)
+		return fmt.Errorf("error downloading community.general tarball: %v", err)
+	}
+
+	// Record checksums so offline --verify-checksums can detect corrupted archives
+	return utils.WriteChecksumManifest(collectionsPath)
+}
+
+// downloadPlan describes the actions of the selected downloads without running them.
+func downloadPlan(opts DownloadOptions) ([]string, error) {
+	plan := []string{fmt.Sprintf("Create directory %s", opts.Path)}
+
+	if opts.Collections {
+		collectionsPath := filepath.Join(opts.Path, "collections")
+		tempVenv := downloadTempVenv()
+		ansibleGalaxy := filepath.Join(tempVenv, "bin", "ansible-galaxy")
+		plan = append(plan,
+			fmt.Sprintf("Create directory %s", collectionsPath),
+			fmt.Sprintf("Run /usr/bin/python3 -m venv %s", tempVenv),
+			fmt.Sprintf("Run %s %s", filepath.Join(tempVenv, "bin", "python3"), strings.Join(ansibleCorePipArgs(), " ")),
+			fmt.Sprintf("Run %s collection download %s -p %s", ansibleGalaxy, bluebanquiseCollectionSource, collectionsPath),
+			fmt.Sprintf("Run %s collection download %s -p %s", ansibleGalaxy, communityGeneralCollectionSource, collectionsPath),
+			fmt.Sprintf("Write %s", filepath.Join(collectionsPath, utils.ChecksumManifest)),
+		)
+		if opts.KeepTemp {
+			plan = append(plan, fmt.Sprintf("Keep temporary environment %s", tempVenv))
+		} else {
+			plan = append(plan, fmt.Sprintf("Remove temporary environment %s", tempVenv))
+		}
+	}
+
+	if opts.Requirements {
+		requirements, err := utils.PinAnsibleRequirements(system.PythonRequirements, opts.AnsibleVersion)
+		if err != nil {
+			return nil, err
+		}
+		pythonCmd, err := system.GetPythonCommand()
+		if err != nil {
+			pythonCmd = "python3"
+		}
+		targets, err := requirementsTargets(opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			requirementsPath := requirementsTargetPath(opts.Path, target)
+			requirementsFile := filepath.Join(requirementsPath, "requirements.txt")
+			plan = append(plan,
+				fmt.Sprintf("Write %s with: %s", requirementsFile, strings.Join(requirements, " ")),
+				fmt.Sprintf("Run %s %s", pythonCmd, strings.Join(utils.PipDownloadArgs(requirementsFile, requirementsPath, target), " ")),
+				fmt.Sprintf("Pin %s and write %s", requirementsFile, filepath.Join(requirementsPath, utils.ChecksumManifest)),
+			)
+		}
+	}
+
+	if opts.CoreVars {
+		plan = append(plan, fmt.Sprintf("Download %s to %s", bootstrap.DefaultCoreVarsURL, filepath.Join(opts.Path, "core-vars", "bb_core.yml")))
+	}
+
+	plan = append(plan, fmt.Sprintf("Write %s", filepath.Join(opts.Path, OfflineScript)))
+	return plan, nil
+}
+
+// requirementsTargets parses the target OSes of the requirements download. A
+// single nil target stands for the host.
+func requirementsTargets(opts DownloadOptions) ([]*system.PythonTarget, error) {
+	if opts.TargetPython != "" {
+		if err := system.ValidatePythonVersion(opts.TargetPython); err != nil {
+			return nil, err
+		}
+	}
+	for _, platform := range opts.TargetPlatforms {
+		if err := system.ValidatePlatform(platform); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.TargetOS) == 0 {
+		if opts.TargetPython == "" && len(opts.TargetPlatforms) == 0 {
+			return []*system.PythonTarget{nil}, nil
+		}
+		if opts.TargetPython == "" || len(opts.TargetPlatforms) == 0 {
+			return nil, fmt.Errorf("--target-python and --target-platform are both required without --target-os")
+		}
+		return []*system.PythonTarget{{PythonVersion: opts.TargetPython, Platforms: opts.TargetPlatforms}}, nil
+	}
+
+	targets := make([]*system.PythonTarget, 0, len(opts.TargetOS))
+	seen := map[string]bool{}
+	for _, spec := range opts.TargetOS {
+		target, err := system.ParsePythonTarget(spec, system.HostArch())
+		if err != nil {
+			return nil, err
+		}
+		if seen[target.Dir()] {
+			continue
+		}
+		seen[target.Dir()] = true
+		if opts.TargetPython != "" {
+			target.PythonVersion = opts.TargetPython
+		}
+		if len(opts.TargetPlatforms) > 0 {
+			target.Platforms = opts.TargetPlatforms
+		}
+		targets = append(targets, &target)
+	}
+	return targets, nil
+}
+
+// requirementsTargetPath returns the directory the requirements of target are
+// downloaded to: <path>/requirements/<os>-<version> for a target OS, otherwise
+// <path>/requirements.
+func requirementsTargetPath(path string, target *system.PythonTarget) string {
+	requirementsPath := filepath.Join(path, "requirements")
+	if target == nil || target.Dir() == "" {
+		return requirementsPath
+	}
+	return filepath.Join(requirementsPath, target.Dir())
+}
+
+func downloadRequirementsToPath(opts DownloadOptions) error {
+	targets, err := requirementsTargets(opts)
+	if err != nil {
+		utils.LogError("Invalid target OS", err, "target_os", opts.TargetOS)
+		return err
+	}
+
+	requirements, err := utils.PinAnsibleRequirements(system.PythonRequirements, opts.AnsibleVersion)
+	if err != nil {
+		utils.LogError("Invalid ansible version", err, "ansible_version", opts.AnsibleVersion)
+		return err
+	}
+
+	for _, target := range targets {
+		if err := downloadRequirementsForTarget(opts.Path, requirements, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadRequirementsForTarget downloads requirements for target, or the host OS when nil.
+func downloadRequirementsForTarget(path string, requirements []string, target *system.PythonTarget) error {
+	requirementsPath := requirementsTargetPath(path, target)
+	utils.LogInfo("Downloading Python requirements", "path", requirementsPath)
+
+	// Create requirements directory
+	if err := os.MkdirAll(requirementsPath, 0755); err != nil {
+		utils.LogError("Error creating requirements directory", err, "path", requirementsPath)
+		return fmt.Errorf("error creating requirements directory: %v", err)
+	}
+
+	description := ""
+	if target != nil {
+		description = target.String()
+	} else {
+		// Detect OS to get the correct requirements
+		osID, version, err := system.DetectOS()
+		if err != nil {
+			utils.LogError("Error detecting OS", err)
+			return fmt.Errorf("error detecting OS: %v", err)
+		}
+
+		// Requirements are only downloaded for an OS the installer can set up
+		if _, err := system.PackagesFor(osID, version); err != nil {
+			utils.LogError("No requirements found for OS", err, "os", osID, "version", version)
+			return fmt.Errorf("no requirements found for %s %s", osID, version)
+		}
+		description = osID + " " + version
+	}
+
+	utils.LogInfo("Downloading requirements for target", "target", description, "requirements", requirements)
+	fmt.Printf("Downloading Python requirements for %s...\n", description)
+
+	if err := downloadRequirements(requirements, requirementsPath, target); err != nil {
+		utils.LogError("Error downloading requirements", err, "target", description)
+		return fmt.Errorf("error downloading requirements for %s: %v", description, err)
+	}
+
+	utils.LogInfo("Python requirements downloaded successfully", "path", requirementsPath)
+	fmt.Printf("Python requirements downloaded successfully to: %s\n", requirementsPath)
+	fmt.Println("Transfer this directory to your target machine and use with:")
+	fmt.Printf("  ./bluebanquise-installer offline --collections-path <collections-path> --requirements-path %s\n", requirementsPath)
+	return nil
+}
+
+func downloadCoreVarsToPath(ctx context.Context, opts DownloadOptions) error {
+	coreVarsPath := filepath.Join(opts.Path, "core-vars")
+	utils.LogInfo("Downloading core variables", "path", coreVarsPath)
+
+	// Create core-vars directory
+	if err := os.MkdirAll(coreVarsPath, 0755); err != nil {
+		utils.LogError("Error creating core-vars directory", err, "path", coreVarsPath)
+		return fmt.Errorf("error creating core-vars directory: %v", err)
+	}
+
+	// Download core variables from GitHub
+	utils.LogInfo("Downloading core variables from GitHub")
+	fmt.Println("Downloading core variables from GitHub...")
+	if err := downloadFile(ctx, bootstrap.DefaultCoreVarsURL, filepath.Join(coreVarsPath, "bb_core.yml"), utils.DownloadOptions{}); err != nil {
+		utils.LogError("Error downloading core variables", err)
+		return fmt.Errorf("error downloading core variables: %v", err)
+	}
+
+	utils.LogInfo("Core variables downloaded successfully", "path", coreVarsPath)
+	fmt.Printf("Core variables downloaded successfully to: %s\n", coreVarsPath)
+	fmt.Println("Transfer this file to your target machine and use with:")
+	fmt.Printf("  ./bluebanquise-installer offline --collections-path <collections-path> --core-vars-path %s/bb_core.yml\n", coreVarsPath)
+	return nil
+}
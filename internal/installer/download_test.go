@@ -0,0 +1,320 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadCollectionTarballsKeepTemp(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := runCommand
+	defer func() { runCommand = original }()
+
+	tests := []struct {
+		name        string
+		keepTemp    bool
+		failGalaxy  bool
+		expectKept  bool
+		expectError bool
+	}{
+		{name: "Removed on success", keepTemp: false, expectKept: false},
+		{name: "Kept on success", keepTemp: true, expectKept: true},
+		{name: "Removed on failure", keepTemp: false, failGalaxy: true, expectKept: false, expectError: true},
+		{name: "Kept on failure", keepTemp: true, failGalaxy: true, expectKept: true, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempVenv := filepath.Join(t.TempDir(), "bluebanquise_download_venv")
+			collectionsPath := filepath.Join(t.TempDir(), "collections")
+
+			runCommand = func(command string, args ...string) error {
+				if len(args) >= 2 && args[1] == "venv" {
+					return os.MkdirAll(filepath.Join(args[2], "bin"), 0755)
+				}
+				if tt.failGalaxy && filepath.Base(command) == "ansible-galaxy" {
+					return errors.New("exit status 1")
+				}
+				return nil
+			}
+
+			err := downloadCollectionTarballs(collectionsPath, tempVenv, tt.keepTemp)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.expectKept {
+				assert.DirExists(t, tempVenv)
+			} else {
+				assert.NoDirExists(t, tempVenv)
+			}
+		})
+	}
+}
+
+func TestDownloadTempVenvStateDir(t *testing.T) {
+	defer func() { require.NoError(t, utils.SetStateDir("")) }()
+
+	assert.Equal(t, filepath.Join(os.TempDir(), "bluebanquise_download_venv"), downloadTempVenv())
+
+	stateDir := t.TempDir()
+	require.NoError(t, utils.SetStateDir(stateDir))
+	assert.Equal(t, filepath.Join(stateDir, "tmp", "bluebanquise_download_venv"), downloadTempVenv())
+}
+
+func TestDownloadDryRun(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := runCommand
+	defer func() { runCommand = original }()
+
+	var calls []string
+	runCommand = func(command string, args ...string) error {
+		calls = append(calls, command)
+		return nil
+	}
+
+	opts := DownloadOptions{
+		Path:         filepath.Join(t.TempDir(), "offline"),
+		Collections:  true,
+		Requirements: true,
+		CoreVars:     true,
+		DryRun:       true,
+	}
+
+	plan, err := downloadPlan(opts)
+	require.NoError(t, err)
+	assert.Contains(t, plan, "Create directory "+opts.Path)
+	assert.Contains(t, plan, "Download "+bootstrap.DefaultCoreVarsURL+" to "+filepath.Join(opts.Path, "core-vars", "bb_core.yml"))
+	joined := strings.Join(plan, "\n")
+	assert.Contains(t, joined, "collection download "+bluebanquiseCollectionSource)
+	assert.Contains(t, joined, "collection download "+communityGeneralCollectionSource)
+	assert.Contains(t, plan, "Write "+filepath.Join(opts.Path, "collections", utils.ChecksumManifest))
+	assert.Contains(t, joined, "-m pip download -r "+filepath.Join(opts.Path, "requirements", "requirements.txt"))
+	assert.Equal(t, "Write "+filepath.Join(opts.Path, OfflineScript), plan[len(plan)-1])
+
+	require.NoError(t, New().Download(context.Background(), opts))
+	assert.Empty(t, calls)
+	assert.NoDirExists(t, opts.Path)
+}
+
+func TestDownloadOptionsValidation(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name string
+		opts DownloadOptions
+	}{
+		{name: "Missing path", opts: DownloadOptions{Collections: true}},
+		{name: "Missing download type", opts: DownloadOptions{Path: "/tmp/offline"}},
+		{name: "Unknown target OS", opts: DownloadOptions{Path: "/tmp/offline", Requirements: true, TargetOS: []string{"fedora:40"}}},
+		{name: "Invalid target OS", opts: DownloadOptions{Path: "/tmp/offline", Requirements: true, TargetOS: []string{"rhel"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, New().Download(context.Background(), tt.opts))
+		})
+	}
+}
+
+func TestDownloadCancelled(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := runCommand
+	defer func() { runCommand = original }()
+
+	var calls []string
+	runCommand = func(command string, args ...string) error {
+		calls = append(calls, command)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := New().Download(ctx, DownloadOptions{Path: filepath.Join(t.TempDir(), "offline"), Collections: true})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, calls)
+}
+
+func TestDownloadCoreVars(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := downloadFile
+	defer func() { downloadFile = original }()
+
+	var sent []utils.DownloadOptions
+	downloadFile = func(_ context.Context, url, path string, opts utils.DownloadOptions) error {
+		assert.Equal(t, bootstrap.DefaultCoreVarsURL, url)
+		sent = append(sent, opts)
+		return os.WriteFile(path, nil, 0644)
+	}
+
+	require.NoError(t, downloadCoreVarsToPath(context.Background(), DownloadOptions{Path: t.TempDir()}))
+	assert.Equal(t, []utils.DownloadOptions{{}}, sent)
+}
+
+func TestDownloadRequirementsTargetOS(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := downloadRequirements
+	defer func() { downloadRequirements = original }()
+
+	type call struct {
+		path   string
+		target string
+		python string
+	}
+	var calls []call
+	downloadRequirements = func(requirements []string, downloadPath string, target *system.PythonTarget) error {
+		assert.NotEmpty(t, requirements)
+		assert.DirExists(t, downloadPath)
+		calls = append(calls, call{path: downloadPath, target: target.Dir(), python: target.PythonVersion})
+		return nil
+	}
+
+	opts := DownloadOptions{
+		Path:         filepath.Join(t.TempDir(), "offline"),
+		Requirements: true,
+		TargetOS:     []string{"rhel:9", "ubuntu:22.04", "rocky:9.3"},
+	}
+	require.NoError(t, New().Download(context.Background(), opts))
+
+	// rocky:9.3 maps to rhel 9 and is only downloaded once
+	requirementsPath := filepath.Join(opts.Path, "requirements")
+	assert.Equal(t, []call{
+		{path: filepath.Join(requirementsPath, "rhel-9"), target: "rhel-9", python: "3.12"},
+		{path: filepath.Join(requirementsPath, "ubuntu-22.04"), target: "ubuntu-22.04", python: "3.10"},
+	}, calls)
+
+	opts.DryRun = true
+	plan, err := downloadPlan(opts)
+	require.NoError(t, err)
+	joined := strings.Join(plan, "\n")
+	for dir, python := range map[string]string{"rhel-9": "3.12", "ubuntu-22.04": "3.10"} {
+		requirementsFile := filepath.Join(requirementsPath, dir, "requirements.txt")
+		assert.Contains(t, plan, "Pin "+requirementsFile+" and write "+filepath.Join(requirementsPath, dir, utils.ChecksumManifest))
+		assert.Contains(t, joined, "-m pip download -r "+requirementsFile+" -d "+filepath.Join(requirementsPath, dir)+" --only-binary=:all: --python-version "+python)
+	}
+}
+
+func TestRequirementsTargetsOverrides(t *testing.T) {
+	targets, err := requirementsTargets(DownloadOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []*system.PythonTarget{nil}, targets)
+
+	// Python and platforms alone describe one target in the requirements directory
+	targets, err = requirementsTargets(DownloadOptions{TargetPython: "3.11", TargetPlatforms: []string{"manylinux_2_28_aarch64"}})
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "3.11", targets[0].PythonVersion)
+	assert.Equal(t, []string{"manylinux_2_28_aarch64"}, targets[0].Platforms)
+	assert.Equal(t, filepath.Join("/tmp/offline", "requirements"), requirementsTargetPath("/tmp/offline", targets[0]))
+	assert.Equal(t, []string{
+		"-m", "pip", "download", "-r", "/tmp/offline/requirements/requirements.txt", "-d", "/tmp/offline/requirements",
+		"--only-binary=:all:", "--python-version", "3.11", "--platform", "manylinux_2_28_aarch64",
+	}, utils.PipDownloadArgs("/tmp/offline/requirements/requirements.txt", "/tmp/offline/requirements", targets[0]))
+
+	// Overrides replace the values of each target OS
+	targets, err = requirementsTargets(DownloadOptions{TargetOS: []string{"rhel:8", "debian:12"}, TargetPython: "3.11"})
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "3.11", targets[0].PythonVersion)
+	assert.Equal(t, "3.11", targets[1].PythonVersion)
+	assert.Equal(t, "manylinux_2_28_"+system.HostArch(), targets[0].Platforms[0])
+	assert.Equal(t, "manylinux_2_36_"+system.HostArch(), targets[1].Platforms[0])
+
+	for _, invalid := range []DownloadOptions{
+		{TargetPython: "3.11"},
+		{TargetPlatforms: []string{"manylinux_2_28_x86_64"}},
+		{TargetPython: "3", TargetPlatforms: []string{"manylinux_2_28_x86_64"}},
+		{TargetOS: []string{"rhel:9"}, TargetPlatforms: []string{"manylinux 2014"}},
+	} {
+		_, err := requirementsTargets(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestDownloadOfflineScript(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := downloadRequirements
+	defer func() { downloadRequirements = original }()
+	downloadRequirements = func(requirements []string, downloadPath string, target *system.PythonTarget) error {
+		return os.WriteFile(filepath.Join(downloadPath, "ansible-9.2.0.tar.gz"), []byte("x"), 0644)
+	}
+
+	// runOfflineScript runs the script with an installer printing its arguments
+	runOfflineScript := func(t *testing.T, script string) []string {
+		t.Helper()
+		installer := filepath.Join(t.TempDir(), "bluebanquise-installer")
+		require.NoError(t, os.WriteFile(installer, []byte("#!/bin/sh\nfor arg in \"$@\"; do echo \"$arg\"; done\n"), 0755))
+		cmd := exec.Command("sh", script, "--user", "admin")
+		cmd.Env = append(os.Environ(), "BLUEBANQUISE_INSTALLER="+installer)
+		output, err := cmd.Output()
+		require.NoError(t, err)
+		return strings.Fields(string(output))
+	}
+
+	t.Run("Single target", func(t *testing.T) {
+		path := t.TempDir()
+		// Collections and core variables from an earlier download into the same path
+		require.NoError(t, os.MkdirAll(filepath.Join(path, "collections"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(path, "core-vars"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(path, "core-vars", "bb_core.yml"), []byte("x"), 0644))
+
+		opts := DownloadOptions{Path: path, Requirements: true, TargetOS: []string{"rhel:9"}}
+		require.NoError(t, New().Download(context.Background(), opts))
+
+		script := filepath.Join(path, OfflineScript)
+		info, err := os.Stat(script)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+		args := runOfflineScript(t, script)
+		assert.Equal(t, []string{
+			"offline",
+			"--collections-path", filepath.Join(path, "collections"),
+			"--requirements-path", filepath.Join(path, "requirements", "rhel-9"),
+			"--core-vars-path", filepath.Join(path, "core-vars", "bb_core.yml"),
+			"--user", "admin",
+		}, args)
+		assert.DirExists(t, args[2])
+		assert.DirExists(t, args[4])
+		assert.FileExists(t, args[6])
+	})
+
+	t.Run("Several targets", func(t *testing.T) {
+		path := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(path, "collections"), 0755))
+
+		opts := DownloadOptions{Path: path, Requirements: true, TargetOS: []string{"rhel:9", "ubuntu:22.04"}}
+		require.NoError(t, New().Download(context.Background(), opts))
+
+		assert.Equal(t, []string{"offline", "--from-bundle", path, "--user", "admin"}, runOfflineScript(t, filepath.Join(path, OfflineScript)))
+	})
+
+	t.Run("Without collections", func(t *testing.T) {
+		path := t.TempDir()
+		require.NoError(t, New().Download(context.Background(), DownloadOptions{Path: path, Requirements: true, TargetOS: []string{"rhel:9"}}))
+
+		data, err := os.ReadFile(filepath.Join(path, OfflineScript))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "${COLLECTIONS_PATH:?")
+		assert.Contains(t, string(data), `--requirements-path "$DIR/requirements/rhel-9"`)
+	})
+}
@@ -0,0 +1,358 @@
+// Package installer runs the BlueBanquise installation flows independently of
+// the command line, so they can be embedded into other Go programs.
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// DefaultUserName is the BlueBanquise user created when none is given.
+const DefaultUserName = "bluebanquise"
+
+// homeBaseDir is the parent of the default home directory, /var/lib/<user>.
+const homeBaseDir = "/var/lib"
+
+// DefaultUserHome returns the default home directory of userName.
+func DefaultUserHome(userName string) string {
+	return filepath.Join(homeBaseDir, userName)
+}
+
+// TargetUserHome returns the home directory used when none is given: that of
+// the account when userName already exists, DefaultUserHome otherwise.
+func TargetUserHome(userName string) string {
+	home, err := bootstrap.LookupUserHome(userName)
+	if err != nil || home == "" {
+		return DefaultUserHome(userName)
+	}
+	if home != DefaultUserHome(userName) {
+		utils.LogWarning("User already exists with another home, installing in its home", "user", userName, "home", home, "default_home", DefaultUserHome(userName))
+		fmt.Printf("Warning: user %s already exists with home %s, installing there instead of %s\n", userName, home, DefaultUserHome(userName))
+	}
+	return home
+}
+
+// Installation steps picking the source of each component, tests replace them
+// to check the routing without changing the system.
+var (
+	configureEnvironment        = bootstrap.ConfigureEnvironment
+	configureEnvironmentOffline = bootstrap.ConfigureEnvironmentOffline
+	installCollectionsOnline    = bootstrap.InstallCollectionsOnline
+	installCollectionsFromPath  = bootstrap.InstallCollectionsFromPath
+	installCollectionsFromGit   = bootstrap.InstallCollectionsFromGit
+	installAnsibleConfig        = bootstrap.InstallAnsibleConfig
+	verifyCollectionSignatures  = bootstrap.VerifyCollectionSignatures
+	hardenHome                  = bootstrap.HardenHome
+)
+
+// installCollections installs the collections of path: a git+ source cloned by
+// ansible-galaxy, a prepared local directory, or GitHub when path is empty.
+// only restricts a local directory to the collections it names. Retries of
+// the online installs stop once ctx is done.
+func installCollections(ctx context.Context, path, userHome string, force bool, only []string) error {
+	switch {
+	case path == "":
+		return installCollectionsOnline(ctx, userHome)
+	case utils.IsGitCollectionSource(path):
+		return installCollectionsFromGit(ctx, path, userHome)
+	default:
+		return installCollectionsFromPath(path, userHome, force, only)
+	}
+}
+
+// installPackages installs system packages, tests replace it.
+var installPackages = utils.InstallPackagesWithOptions
+
+// installSystemPackages installs the packages of definition. With runHooks,
+// its pre-installation hook runs first, e.g. to enable the repositories
+// providing the packages, and its post-installation hook afterwards.
+func installSystemPackages(ctx context.Context, definition system.PackageDefinition, runHooks bool, packageOpts utils.PackageOptions) error {
+	packages := definition.Packages
+
+	// Run pre-installation hook if exists
+	if runHooks && definition.PreHook != nil {
+		utils.LogInfo("Running pre-installation hook")
+		fmt.Println("Running pre-installation hook...")
+		if err := definition.PreHook(); err != nil {
+			utils.LogError("Error in pre-installation hook", err)
+			return fmt.Errorf("error in pre-installation hook: %v", err)
+		}
+	}
+
+	// Install system packages
+	utils.LogInfo("Installing system packages", "packages", packages)
+	fmt.Println("Installing system packages...")
+	if err := installPackages(ctx, packages, packageOpts); err != nil {
+		utils.LogError("Error installing packages", err, "packages", packages)
+		return fmt.Errorf("error installing packages: %v", err)
+	}
+
+	// Run post-installation hook if exists
+	if runHooks && definition.PostHook != nil {
+		utils.LogInfo("Running post-installation hook")
+		fmt.Println("Running post-installation hook...")
+		if err := definition.PostHook(); err != nil {
+			utils.LogError("Error in post-installation hook", err)
+			return fmt.Errorf("error in post-installation hook: %v", err)
+		}
+	}
+	return nil
+}
+
+// systemCheck verifies the system prerequisites of an online installation.
+var systemCheck = utils.SystemCheck
+
+// Installer runs the online, offline, download and status flows.
+type Installer struct{}
+
+// New returns an Installer.
+func New() *Installer {
+	return &Installer{}
+}
+
+// targetUser holds the user an installation is run for.
+type targetUser struct {
+	name        string
+	home        string
+	sudoersMode string
+	shell       string
+}
+
+// resolveTargetUser fills in the default user, home, sudoers mode and shell,
+// then validates them before any filesystem changes.
+func resolveTargetUser(userName, userHome, sudoersMode, shell string, allowRoot bool) (targetUser, error) {
+	if userName == "" {
+		userName = DefaultUserName
+	}
+	if userHome == "" {
+		userHome = TargetUserHome(userName)
+	}
+	if sudoersMode == "" {
+		sudoersMode = bootstrap.SudoersModeNopasswd
+	}
+	if shell == "" {
+		shell = bootstrap.DefaultShell
+	}
+
+	if err := bootstrap.ValidateTargetUser(userName, userHome, allowRoot); err != nil {
+		return targetUser{}, err
+	}
+	if err := bootstrap.ValidateSudoersMode(sudoersMode); err != nil {
+		utils.LogError("Invalid sudoers configuration", err)
+		return targetUser{}, err
+	}
+	if err := bootstrap.ValidateShell(shell); err != nil {
+		utils.LogError("Invalid shell", err, "shell", shell)
+		return targetUser{}, err
+	}
+	if err := bootstrap.CheckUserHome(userName, userHome); err != nil {
+		utils.LogError("Home directory mismatch", err, "user", userName, "home", userHome)
+		return targetUser{}, err
+	}
+	return targetUser{name: userName, home: userHome, sudoersMode: sudoersMode, shell: shell}, nil
+}
+
+// hardenUserHome restricts the home of user to its owner when harden is set,
+// once the installation wrote the SSH keys in it.
+func hardenUserHome(user targetUser, harden bool) error {
+	if !harden {
+		utils.LogInfo("Leaving home directory permissions", "home", user.home)
+		return nil
+	}
+	return utils.WithPhase("harden-home", func() error {
+		if err := hardenHome(user.name, user.home); err != nil {
+			utils.LogError("Error hardening home directory", err, "user", user.name, "home", user.home)
+			return fmt.Errorf("error hardening home directory: %v", err)
+		}
+		return nil
+	})
+}
+
+// validateInstallPaths validates path flags in flag name order. --home is always
+// required, the other paths are optional and only validated when set.
+func validateInstallPaths(paths map[string]string) error {
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name != "--home" && paths[name] == "" {
+			continue
+		}
+		// Git and HTTP(S) sources are fetched, not read from the filesystem
+		if name == "--collections-path" && (utils.IsGitCollectionSource(paths[name]) || utils.IsHTTPCollectionSource(paths[name])) {
+			continue
+		}
+		if err := utils.ValidateInstallPath(name, paths[name]); err != nil {
+			utils.LogError("Invalid path", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// collectionNamePattern matches a collection name as namespace.name, in the
+// characters ansible-galaxy accepts.
+var collectionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*\.[a-z][a-z0-9_]*$`)
+
+// validateOnlyCollections validates the --only-collections names against
+// collectionsPath, the source they select archives of: the collections
+// installed from GitHub or cloned from git are not filtered.
+func validateOnlyCollections(only []string, collectionsPath string) error {
+	if len(only) == 0 {
+		return nil
+	}
+	var err error
+	switch {
+	case collectionsPath == "":
+		err = fmt.Errorf("--only-collections needs --collections-path, the collections from GitHub are not filtered")
+	case utils.IsGitCollectionSource(collectionsPath):
+		err = fmt.Errorf("--only-collections cannot be used with a git+ --collections-path, ansible-galaxy installs the whole repository")
+	}
+	for _, name := range only {
+		if err == nil && !collectionNamePattern.MatchString(name) {
+			err = fmt.Errorf("invalid collection %q in --only-collections, expected namespace.name", name)
+		}
+	}
+	if err != nil {
+		utils.LogError("Invalid collections filter", err, "only_collections", only)
+	}
+	return err
+}
+
+// collectionSignatures returns the collections to verify after installing
+// those of collectionsPath when verify is set: the collections installed from
+// GitHub when it is empty, the archives of a prepared directory with their
+// signatures otherwise.
+func collectionSignatures(verify bool, collectionsPath string, only []string) ([]bootstrap.CollectionSignature, error) {
+	if !verify {
+		return nil, nil
+	}
+	if collectionsPath == "" {
+		return bootstrap.OnlineCollectionSignatures(), nil
+	}
+	fmt.Println("Checking collection signatures...")
+	signatures, err := bootstrap.CollectionSignatures(collectionsPath, only)
+	if err != nil {
+		utils.LogError("Collection signatures check failed", err, "path", collectionsPath)
+		return nil, fmt.Errorf("collection signatures check failed: %v", err)
+	}
+	return signatures, nil
+}
+
+// verifySignatures verifies the signatures of the installed collections, none
+// when --verify-signatures is not set.
+func verifySignatures(userHome, keyring string, signatures []bootstrap.CollectionSignature) error {
+	if len(signatures) == 0 {
+		return nil
+	}
+	if err := verifyCollectionSignatures(userHome, keyring, signatures); err != nil {
+		utils.LogError("Collection signature verification failed", err, "home", userHome)
+		return fmt.Errorf("collection signature verification failed: %v", err)
+	}
+	return nil
+}
+
+// validateSignatureOptions checks the keyring of --verify-signatures, an
+// absolute path to an existing GnuPG keyring, and that the collections of
+// collectionsPath come with signatures: git+ sources and HTTP(S) indexes have
+// none.
+func validateSignatureOptions(verify bool, keyring, collectionsPath string) error {
+	if !verify {
+		if keyring != "" {
+			return fmt.Errorf("--keyring is only used with --verify-signatures")
+		}
+		return nil
+	}
+	if keyring == "" {
+		return fmt.Errorf("--verify-signatures needs --keyring, the GnuPG keyring of the trusted collection signing keys")
+	}
+	if !filepath.IsAbs(keyring) {
+		return fmt.Errorf("--keyring must be an absolute path: %s", keyring)
+	}
+	if _, err := os.Stat(keyring); err != nil {
+		return fmt.Errorf("keyring %s does not exist", keyring)
+	}
+	if utils.IsGitCollectionSource(collectionsPath) || utils.IsHTTPCollectionSource(collectionsPath) {
+		return fmt.Errorf("--verify-signatures needs collection archives with their %s signatures, %s has none", bootstrap.SignatureSuffix, utils.RedactURL(collectionsPath))
+	}
+	return nil
+}
+
+// validatePackageOptions validates the system package options before any change.
+func validatePackageOptions(opts utils.PackageOptions) error {
+	if err := utils.ValidatePackageOptions(opts); err != nil {
+		utils.LogError("Invalid package options", err)
+		return err
+	}
+	return nil
+}
+
+// validateAnsibleConfigTemplate validates the --ansible-config-template file
+// before any change.
+func validateAnsibleConfigTemplate(templatePath string) error {
+	if templatePath == "" {
+		return nil
+	}
+	if err := bootstrap.ValidateAnsibleConfigTemplate(templatePath); err != nil {
+		utils.LogError("Invalid ansible.cfg template", err, "template", templatePath)
+		return err
+	}
+	return nil
+}
+
+// prepareSystem installs the system packages of the detected OS, runs their
+// pre- and post-installation hooks when runHooks is set and creates the user.
+// Each step is logged under its own phase: detect-os, install-packages and
+// create-user.
+func prepareSystem(ctx context.Context, user targetUser, runHooks bool, packageOpts utils.PackageOptions) error {
+	var definition system.PackageDefinition
+	err := utils.WithPhase("detect-os", func() error {
+		utils.LogInfo("Detecting operating system")
+		osID, version, err := detectOS()
+		if err != nil {
+			utils.LogError("Error detecting OS", err)
+			return fmt.Errorf("error detecting OS: %v", err)
+		}
+		utils.LogInfo("OS detected", "os", osID, "version", version)
+		fmt.Printf("Detected OS: %s %s\n", osID, version)
+
+		// Find packages for this OS
+		definition, err = system.PackagesFor(osID, version)
+		if err != nil {
+			utils.LogError("No package definition found", err, "os", osID, "version", version, "code", utils.CodeUnsupportedOS)
+			return utils.NewValidationError(utils.CodeUnsupportedOS, utils.RemediationUnsupportedOS, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = utils.WithPhase("install-packages", func() error {
+		return installSystemPackages(ctx, definition, runHooks, packageOpts)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Create bluebanquise user
+	return utils.WithPhase("create-user", func() error {
+		utils.LogInfo("Creating BlueBanquise user", "user", user.name, "home", user.home, "shell", user.shell)
+		if err := bootstrap.CreateBluebanquiseUser(user.name, user.home, user.sudoersMode, user.shell); err != nil {
+			utils.LogError("Error creating user", err, "user", user.name, "home", user.home)
+			return fmt.Errorf("error creating user: %v", err)
+		}
+		return nil
+	})
+}
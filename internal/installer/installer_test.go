@@ -0,0 +1,592 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateInstallPaths(t *testing.T) {
+	utils.InitTestLogger()
+
+	assert.NoError(t, validateInstallPaths(map[string]string{
+		"--home":              "/var/lib/bluebanquise",
+		"--collections-path":  "/tmp/offline/collections",
+		"--requirements-path": "",
+	}))
+	assert.Error(t, validateInstallPaths(map[string]string{"--home": ""}))
+	assert.Error(t, validateInstallPaths(map[string]string{"--home": "/"}))
+	assert.Error(t, validateInstallPaths(map[string]string{
+		"--home":             "/var/lib/bluebanquise",
+		"--collections-path": "collections",
+	}))
+	assert.NoError(t, validateInstallPaths(map[string]string{
+		"--home":             "/var/lib/bluebanquise",
+		"--collections-path": "git+https://git.example.com/bluebanquise.git",
+	}))
+	assert.NoError(t, validateInstallPaths(map[string]string{
+		"--home":             "/var/lib/bluebanquise",
+		"--collections-path": "https://mirror.example.com/collections/",
+	}))
+	assert.Error(t, validateInstallPaths(map[string]string{
+		"--home":              "/var/lib/bluebanquise",
+		"--requirements-path": "git+https://git.example.com/requirements.git",
+	}))
+}
+
+func TestValidateOnlyCollections(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name            string
+		only            []string
+		collectionsPath string
+		wantErr         string
+	}{
+		{name: "No filter from GitHub"},
+		{name: "Local path", only: []string{"bluebanquise.infrastructure", "community.general"}, collectionsPath: "/srv/collections"},
+		{name: "HTTP index", only: []string{"bluebanquise.infrastructure"}, collectionsPath: "https://mirror.example.com/collections/"},
+		{name: "GitHub", only: []string{"bluebanquise.infrastructure"}, wantErr: "needs --collections-path"},
+		{name: "Git source", only: []string{"bluebanquise.infrastructure"}, collectionsPath: "git+https://github.com/bluebanquise/bluebanquise.git", wantErr: "git+"},
+		{name: "Archive name", only: []string{"bluebanquise-infrastructure-3.0.0.tar.gz"}, collectionsPath: "/srv/collections", wantErr: "expected namespace.name"},
+		{name: "Name without namespace", only: []string{"infrastructure"}, collectionsPath: "/srv/collections", wantErr: "expected namespace.name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOnlyCollections(tt.only, tt.collectionsPath)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestResolveTargetUser(t *testing.T) {
+	utils.InitTestLogger()
+
+	user, err := resolveTargetUser("", "", "", "", false)
+	require.NoError(t, err)
+	assert.Equal(t, targetUser{name: "bluebanquise", home: "/var/lib/bluebanquise", sudoersMode: bootstrap.SudoersModeNopasswd, shell: bootstrap.DefaultShell}, user)
+
+	user, err = resolveTargetUser("myuser", "", bootstrap.SudoersModeNone, "/bin/sh", false)
+	require.NoError(t, err)
+	assert.Equal(t, targetUser{name: "myuser", home: "/var/lib/myuser", sudoersMode: bootstrap.SudoersModeNone, shell: "/bin/sh"}, user)
+
+	_, err = resolveTargetUser("root", "", "", "", false)
+	assert.Error(t, err)
+
+	_, err = resolveTargetUser("myuser", "", "invalid", "", false)
+	assert.Error(t, err)
+
+	_, err = resolveTargetUser("myuser", "", "", "nologin", false)
+	assert.ErrorContains(t, err, "shell must be an absolute path")
+
+	_, err = resolveTargetUser("myuser", "", "", "/nonexistent/shell", false)
+	assert.ErrorContains(t, err, "shell /nonexistent/shell does not exist")
+}
+
+func TestOnlineInvalidOptions(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name string
+		opts OnlineOptions
+	}{
+		{name: "Relative home", opts: OnlineOptions{UserHome: "bluebanquise"}},
+		{name: "Root user", opts: OnlineOptions{UserName: "root"}},
+		{name: "Invalid sudoers mode", opts: OnlineOptions{SudoersMode: "invalid"}},
+		{name: "Invalid ansible version", opts: OnlineOptions{AnsibleVersion: "latest"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, New().Online(context.Background(), tt.opts))
+		})
+	}
+}
+
+func TestOnlineCancelled(t *testing.T) {
+	utils.InitTestLogger()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := New().Online(ctx, OnlineOptions{UserHome: filepath.Join(t.TempDir(), "bluebanquise")})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOfflineInvalidOptions(t *testing.T) {
+	utils.InitTestLogger()
+
+	notArchive := filepath.Join(t.TempDir(), "collections.txt")
+	require.NoError(t, os.WriteFile(notArchive, []byte("x"), 0644))
+
+	tests := []struct {
+		name string
+		opts OfflineOptions
+	}{
+		{name: "Missing collections path", opts: OfflineOptions{}},
+		{name: "Relative collections path", opts: OfflineOptions{CollectionsPath: "collections"}},
+		{name: "Not a collections archive", opts: OfflineOptions{CollectionsPath: notArchive}},
+		{name: "Empty collections directory", opts: OfflineOptions{CollectionsPath: t.TempDir()}},
+		{name: "Missing core variables", opts: OfflineOptions{SkipCollections: true, CoreVarsPath: filepath.Join(t.TempDir(), "bb_core.yml")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, New().Offline(context.Background(), tt.opts))
+		})
+	}
+}
+
+func TestOfflineCancelledRemovesExtractedBundle(t *testing.T) {
+	utils.InitTestLogger()
+
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("x"), 0644))
+	bundle := filepath.Join(t.TempDir(), "collections.tar.gz")
+	require.NoError(t, exec.Command("tar", "-czf", bundle, "-C", source, ".").Run())
+
+	before, err := filepath.Glob(filepath.Join(os.TempDir(), "bluebanquise-collections-*"))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = New().Offline(ctx, OfflineOptions{CollectionsPath: bundle, UserHome: filepath.Join(t.TempDir(), "bluebanquise")})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	after, err := filepath.Glob(filepath.Join(os.TempDir(), "bluebanquise-collections-*"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, before, after, "extracted bundle must be removed")
+}
+
+func TestStatusUnknownUser(t *testing.T) {
+	utils.InitTestLogger()
+
+	err := New().Status(context.Background(), StatusOptions{UserName: "bluebanquise-missing-user"})
+	assert.Error(t, err)
+
+	err = New().Status(context.Background(), StatusOptions{UserName: "bluebanquise-missing-user", Output: "yaml"})
+	assert.EqualError(t, err, `invalid output "yaml", must be text or json`)
+}
+
+func TestOnlineHybridSteps(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalEnv, originalEnvOffline := configureEnvironment, configureEnvironmentOffline
+	originalOnline, originalFromPath, originalFromGit := installCollectionsOnline, installCollectionsFromPath, installCollectionsFromGit
+	originalAnsibleConfig := installAnsibleConfig
+	defer func() {
+		configureEnvironment, configureEnvironmentOffline = originalEnv, originalEnvOffline
+		installCollectionsOnline, installCollectionsFromPath, installCollectionsFromGit = originalOnline, originalFromPath, originalFromGit
+		installAnsibleConfig = originalAnsibleConfig
+	}()
+
+	var calls []string
+	configureEnvironment = func(_ context.Context, userName, userHome, collectionsPath, sudoersMode, ansibleVersion string) error {
+		calls = append(calls, "environment from network")
+		return nil
+	}
+	configureEnvironmentOffline = func(userName, userHome, requirementsPath, sudoersMode string, verifyChecksums bool) error {
+		calls = append(calls, "environment from "+requirementsPath)
+		return nil
+	}
+	installCollectionsOnline = func(_ context.Context, userHome string) error {
+		calls = append(calls, "collections from network")
+		return nil
+	}
+	installCollectionsFromPath = func(collectionsPath, userHome string, force bool, only []string) error {
+		calls = append(calls, "collections from "+collectionsPath)
+		return nil
+	}
+	installCollectionsFromGit = func(_ context.Context, source, userHome string) error {
+		calls = append(calls, "collections cloned from "+source)
+		return nil
+	}
+	installAnsibleConfig = func(userHome, templatePath string) error {
+		calls = append(calls, "ansible.cfg")
+		return nil
+	}
+
+	tests := []struct {
+		name            string
+		requirements    string
+		collectionsPath string
+		expected        []string
+	}{
+		{name: "Fully online", expected: []string{"environment from network", "ansible.cfg", "collections from network"}},
+		{name: "Local collections", collectionsPath: "/srv/collections", expected: []string{"environment from network", "ansible.cfg", "collections from /srv/collections"}},
+		{name: "Local requirements", requirements: "/srv/requirements", expected: []string{"environment from /srv/requirements", "ansible.cfg", "collections from network"}},
+		{name: "Git collections", collectionsPath: "git+https://git.example.com/bluebanquise.git", expected: []string{"environment from network", "ansible.cfg", "collections cloned from git+https://git.example.com/bluebanquise.git"}},
+		{name: "Local collections and requirements", requirements: "/srv/requirements", collectionsPath: "/srv/collections", expected: []string{"environment from /srv/requirements", "ansible.cfg", "collections from /srv/collections"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls = nil
+			user := targetUser{name: "bluebanquise", home: "/var/lib/bluebanquise", sudoersMode: bootstrap.SudoersModeNone}
+			steps := onlineSteps(context.Background(), user, OnlineOptions{RequirementsPath: tt.requirements}, tt.collectionsPath, nil)
+			require.NoError(t, steps.environment())
+			require.NoError(t, steps.collections())
+			assert.Equal(t, tt.expected, calls)
+		})
+	}
+}
+
+func TestOnlineHybridInvalidOptions(t *testing.T) {
+	utils.InitTestLogger()
+
+	requirements := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(requirements, "requirements.txt"), []byte("ansible\n"), 0644))
+
+	tests := []struct {
+		name string
+		opts OnlineOptions
+	}{
+		{name: "Relative collections path", opts: OnlineOptions{CollectionsPath: "collections"}},
+		{name: "Missing collections path", opts: OnlineOptions{CollectionsPath: filepath.Join(t.TempDir(), "missing")}},
+		{name: "Empty collections directory", opts: OnlineOptions{CollectionsPath: t.TempDir()}},
+		{name: "Relative requirements path", opts: OnlineOptions{RequirementsPath: "requirements"}},
+		{name: "Missing requirements path", opts: OnlineOptions{RequirementsPath: filepath.Join(t.TempDir(), "missing")}},
+		{name: "Ansible version with requirements path", opts: OnlineOptions{RequirementsPath: requirements, AnsibleVersion: "9.2.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, New().Online(context.Background(), tt.opts))
+		})
+	}
+}
+
+func TestOnlineHybridSkippedPathsNotValidated(t *testing.T) {
+	utils.InitTestLogger()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Skipped components do not need valid local paths, the run stops at the cancelled context
+	err := New().Online(ctx, OnlineOptions{
+		UserHome:         filepath.Join(t.TempDir(), "bluebanquise"),
+		CollectionsPath:  t.TempDir(),
+		SkipCollections:  true,
+		RequirementsPath: filepath.Join(t.TempDir(), "missing"),
+		SkipEnvironment:  true,
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOnlineNoConnectivityHint(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := systemCheck
+	defer func() { systemCheck = original }()
+	systemCheck = func() error {
+		return fmt.Errorf("internet connectivity check failed: %w", utils.ErrNoConnectivity)
+	}
+
+	err := New().Online(context.Background(), OnlineOptions{UserHome: filepath.Join(t.TempDir(), "bluebanquise")})
+	require.ErrorIs(t, err, utils.ErrNoConnectivity)
+
+	hint := offlineHint(err)
+	assert.Contains(t, hint, "did you mean the offline mode?")
+	assert.Contains(t, hint, "bluebanquise-installer download --path /tmp/offline --collections --requirements --core-vars")
+	assert.Contains(t, hint, "bluebanquise-installer offline")
+	assert.Contains(t, hint, "--collections-path /tmp/offline/collections")
+
+	assert.Empty(t, offlineHint(errors.New("root access check failed: root access required")))
+}
+
+func TestPrepareLocalCollectionsVerifyChecksums(t *testing.T) {
+	utils.InitTestLogger()
+
+	newCollections := func(t *testing.T, manifest bool) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("infrastructure"), 0644))
+		if manifest {
+			require.NoError(t, utils.WriteChecksumManifest(dir))
+		}
+		return dir
+	}
+
+	// Matching manifest
+	dir, cleanup, err := prepareLocalCollections(newCollections(t, true), true, false)
+	require.NoError(t, err)
+	cleanup()
+	assert.NotEmpty(t, dir)
+
+	// Mismatch aborts before installing
+	corrupted := newCollections(t, true)
+	require.NoError(t, os.WriteFile(filepath.Join(corrupted, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("corrupted"), 0644))
+	_, _, err = prepareLocalCollections(corrupted, true, false)
+	assert.ErrorContains(t, err, "collections checksum verification failed")
+
+	// Without the flag the mismatch is not checked
+	_, cleanup, err = prepareLocalCollections(corrupted, false, false)
+	require.NoError(t, err)
+	cleanup()
+
+	// Missing manifest only warns
+	_, cleanup, err = prepareLocalCollections(newCollections(t, false), true, false)
+	require.NoError(t, err)
+	cleanup()
+}
+
+func TestMergeLocalCollections(t *testing.T) {
+	utils.InitTestLogger()
+
+	first, second := t.TempDir(), t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(first, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("infrastructure"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(second, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("infrastructure"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(second, "community-general-8.0.0.tar.gz"), []byte("general"), 0644))
+
+	dir, cleanup, err := mergeLocalCollections([]string{first, second}, false, false)
+	require.NoError(t, err)
+	archives, err := utils.CollectionArchives(dir)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-8.0.0.tar.gz"}, archives)
+	cleanup()
+	assert.NoDirExists(t, dir)
+
+	// Every path is validated
+	_, _, err = mergeLocalCollections([]string{first, filepath.Join(second, "missing")}, false, false)
+	assert.ErrorContains(t, err, "collections validation failed")
+
+	// Remote sources cannot be merged
+	_, _, err = mergeLocalCollections([]string{first, "https://mirror.example.com/collections/"}, false, false)
+	assert.ErrorContains(t, err, "several --collections-path must be local")
+}
+
+func TestDownloadMissingCollections(t *testing.T) {
+	empty := t.TempDir()
+	populated := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(populated, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("x"), 0644))
+	bundle := filepath.Join(populated, "bluebanquise-infrastructure-3.0.0.tar.gz")
+
+	tests := []struct {
+		name string
+		opts OfflineOptions
+		path string
+		want bool
+	}{
+		{name: "Disabled with empty directory", opts: OfflineOptions{}, path: empty, want: false},
+		{name: "Empty directory", opts: OfflineOptions{DownloadIfMissing: true}, path: empty, want: true},
+		{name: "Missing directory", opts: OfflineOptions{DownloadIfMissing: true}, path: filepath.Join(empty, "missing"), want: true},
+		{name: "No path", opts: OfflineOptions{DownloadIfMissing: true}, path: "", want: true},
+		{name: "Pattern matching nothing", opts: OfflineOptions{DownloadIfMissing: true}, path: filepath.Join(empty, "*.tar.gz"), want: true},
+		{name: "Populated directory", opts: OfflineOptions{DownloadIfMissing: true}, path: populated, want: false},
+		{name: "Bundle", opts: OfflineOptions{DownloadIfMissing: true}, path: bundle, want: false},
+		{name: "Several paths", opts: OfflineOptions{DownloadIfMissing: true, ExtraCollectionsPaths: []string{populated}}, path: empty, want: false},
+		{name: "Pattern matching archives", opts: OfflineOptions{DownloadIfMissing: true}, path: filepath.Join(populated, "*.tar.gz"), want: false},
+		{name: "Skipped collections", opts: OfflineOptions{DownloadIfMissing: true, SkipCollections: true}, path: empty, want: false},
+		{name: "Git source", opts: OfflineOptions{DownloadIfMissing: true}, path: "git+https://git.example.com/bluebanquise.git", want: false},
+		{name: "HTTP index", opts: OfflineOptions{DownloadIfMissing: true}, path: "https://mirror.example.com/collections/", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, downloadMissingCollections(tt.opts, tt.path))
+		})
+	}
+}
+
+func TestValidationRemediations(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalDetect := detectOS
+	defer func() { detectOS = originalDetect }()
+	detectOS = func() (string, string, error) { return "fedora", "40", nil }
+
+	home := filepath.Join(t.TempDir(), "bluebanquise")
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name            string
+		run             func() error
+		wantCode        string
+		wantRemediation string
+	}{
+		{
+			name: "Offline without collections",
+			run: func() error {
+				return New().Offline(context.Background(), OfflineOptions{UserHome: home, CollectionsPath: missing})
+			},
+			wantCode:        utils.CodeCollectionsPathMissing,
+			wantRemediation: "download --collections",
+		},
+		{
+			name: "Offline without requirements",
+			run: func() error {
+				return New().Offline(context.Background(), OfflineOptions{UserHome: home, SkipCollections: true, RequirementsPath: missing})
+			},
+			wantCode:        utils.CodeRequirementsPathMissing,
+			wantRemediation: "download --requirements",
+		},
+		{
+			name: "Offline without bundle",
+			run: func() error {
+				return New().Offline(context.Background(), OfflineOptions{UserHome: home, FromBundle: missing})
+			},
+			wantCode:        utils.CodeOfflineBundleMissing,
+			wantRemediation: "--from-bundle",
+		},
+		{
+			name: "Unsupported OS",
+			run: func() error {
+				return prepareSystem(context.Background(), targetUser{name: "bluebanquise", home: home}, false, utils.PackageOptions{})
+			},
+			wantCode:        utils.CodeUnsupportedOS,
+			wantRemediation: "--allow-unsupported-os",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.run()
+			require.Error(t, err)
+			validationErr, ok := utils.AsValidationError(err)
+			require.True(t, ok, "expected a remediation for %v", err)
+			assert.Equal(t, tt.wantCode, validationErr.Code)
+			assert.Contains(t, validationErr.Remediation, tt.wantRemediation)
+		})
+	}
+}
+
+func TestInstallSystemPackagesHooks(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := installPackages
+	defer func() { installPackages = original }()
+
+	var steps []string
+	installPackages = func(_ context.Context, packages []string, opts utils.PackageOptions) error {
+		steps = append(steps, "install")
+		return nil
+	}
+	definition := system.PackageDefinition{
+		Packages: []string{"rh-python38"},
+		PreHook: func() error {
+			steps = append(steps, "pre-hook")
+			return nil
+		},
+		PostHook: func() error {
+			steps = append(steps, "post-hook")
+			return nil
+		},
+	}
+
+	require.NoError(t, installSystemPackages(context.Background(), definition, true, utils.PackageOptions{}))
+	assert.Equal(t, []string{"pre-hook", "install", "post-hook"}, steps)
+
+	steps = nil
+	require.NoError(t, installSystemPackages(context.Background(), definition, false, utils.PackageOptions{}))
+	assert.Equal(t, []string{"install"}, steps, "hooks only run when enabled")
+
+	steps = nil
+	definition.PreHook = func() error { return errors.New("repository unreachable") }
+	err := installSystemPackages(context.Background(), definition, true, utils.PackageOptions{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error in pre-installation hook: repository unreachable")
+	assert.Empty(t, steps, "packages are not installed when the pre-installation hook fails")
+}
+
+func TestValidateSignatureOptions(t *testing.T) {
+	keyring := filepath.Join(t.TempDir(), "keyring.kbx")
+	require.NoError(t, os.WriteFile(keyring, nil, 0644))
+
+	tests := []struct {
+		name            string
+		verify          bool
+		keyring         string
+		collectionsPath string
+		wantErr         string
+	}{
+		{name: "Disabled"},
+		{name: "GitHub collections", verify: true, keyring: keyring},
+		{name: "Local collections", verify: true, keyring: keyring, collectionsPath: "/srv/collections"},
+		{name: "Keyring without verification", keyring: keyring, wantErr: "--keyring is only used with --verify-signatures"},
+		{name: "Missing keyring", verify: true, wantErr: "--verify-signatures needs --keyring"},
+		{name: "Relative keyring", verify: true, keyring: "keyring.kbx", wantErr: "--keyring must be an absolute path"},
+		{name: "Nonexistent keyring", verify: true, keyring: "/nonexistent/keyring.kbx", wantErr: "keyring /nonexistent/keyring.kbx does not exist"},
+		{name: "Git source", verify: true, keyring: keyring, collectionsPath: "git+https://git.example.com/bluebanquise.git", wantErr: "has none"},
+		{name: "HTTP index", verify: true, keyring: keyring, collectionsPath: "https://mirror.example.com/collections/", wantErr: "has none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSignatureOptions(tt.verify, tt.keyring, tt.collectionsPath)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := verifyCollectionSignatures
+	defer func() { verifyCollectionSignatures = original }()
+
+	var verified []bootstrap.CollectionSignature
+	verifyCollectionSignatures = func(userHome, keyring string, collections []bootstrap.CollectionSignature) error {
+		verified = collections
+		return errors.New("signature verification failed for community.general")
+	}
+
+	// Nothing runs without --verify-signatures
+	assert.NoError(t, verifySignatures("/var/lib/bluebanquise", "", nil))
+	assert.Nil(t, verified)
+
+	err := verifySignatures("/var/lib/bluebanquise", "/etc/ansible/keyring.kbx", bootstrap.OnlineCollectionSignatures())
+	assert.EqualError(t, err, "collection signature verification failed: signature verification failed for community.general")
+	assert.Equal(t, bootstrap.OnlineCollectionSignatures(), verified)
+
+	signatures, err := collectionSignatures(true, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, bootstrap.OnlineCollectionSignatures(), signatures)
+
+	signatures, err = collectionSignatures(false, "/srv/collections", nil)
+	require.NoError(t, err)
+	assert.Nil(t, signatures)
+}
+
+func TestHardenUserHome(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := hardenHome
+	defer func() { hardenHome = original }()
+
+	var hardened []string
+	hardenHome = func(userName, userHome string) error {
+		hardened = append(hardened, userName+" "+userHome)
+		if userName == "broken" {
+			return errors.New("permission denied")
+		}
+		return nil
+	}
+
+	user := targetUser{name: "bluebanquise", home: "/var/lib/bluebanquise"}
+	require.NoError(t, hardenUserHome(user, false))
+	assert.Empty(t, hardened)
+
+	require.NoError(t, hardenUserHome(user, true))
+	assert.Equal(t, []string{"bluebanquise /var/lib/bluebanquise"}, hardened)
+
+	err := hardenUserHome(targetUser{name: "broken", home: "/var/lib/broken"}, true)
+	assert.EqualError(t, err, "error hardening home directory: permission denied")
+}
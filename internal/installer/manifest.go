@@ -0,0 +1,109 @@
+package installer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/lmagdanello/bluebanquise-installer/internal/version"
+)
+
+// Installation modes recorded in the install manifest.
+const (
+	InstallModeOnline  = "online"
+	InstallModeOffline = "offline"
+)
+
+// installManifestName is the file of BluebanquiseDir recording the last
+// installation when no state directory is set.
+const installManifestName = ".install-manifest.json"
+
+// now returns the time recorded in the install manifest, tests replace it.
+var now = time.Now
+
+// InstallManifest records the last online or offline installation of a
+// BlueBanquise home, rewritten by every installation run over it. Phases are
+// the ids of the installation phases that ran, a run skipping most of them is
+// not a full installation. Manifests written before they were recorded have none.
+type InstallManifest struct {
+	Mode      string    `json:"mode"`
+	Version   string    `json:"installer_version"`
+	Installed time.Time `json:"installed_at"`
+	Phases    []string  `json:"phases,omitempty"`
+}
+
+// InstallManifestPath returns the install manifest of a BlueBanquise home: a
+// file of the manifests directory of the state directory named after the
+// escaped home when --state-dir is set, or installManifestName in the home.
+func InstallManifestPath(userHome string) string {
+	if dir := utils.StateDir(); dir != "" {
+		return filepath.Join(dir, "manifests", url.PathEscape(filepath.Clean(userHome))+".json")
+	}
+	return homeInstallManifestPath(userHome)
+}
+
+// homeInstallManifestPath returns the install manifest kept in the home.
+func homeInstallManifestPath(userHome string) string {
+	return filepath.Join(bootstrap.BluebanquiseDir(userHome), installManifestName)
+}
+
+// writeInstallManifest records an installation of userHome in mode running
+// phases. The installation already succeeded, so a failure is only logged.
+func writeInstallManifest(userHome, mode string, phases []string) {
+	manifest := InstallManifest{Mode: mode, Version: version.Release, Installed: now().UTC(), Phases: phases}
+	path := InstallManifestPath(userHome)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0644)
+		}
+	}
+	if err != nil {
+		utils.LogWarning("Could not write install manifest", "error", err, "path", path)
+		return
+	}
+	utils.LogInfo("Install manifest written", "path", path, "mode", mode, "version", manifest.Version, "phases", phases)
+}
+
+// ReadInstallManifest reads the install manifest of userHome, falling back to
+// the one of the home when a state directory is set but holds none, as written
+// by installations made without it. It returns nil and no error when there is
+// none, as installations made before the manifest was introduced.
+func ReadInstallManifest(userHome string) (*InstallManifest, error) {
+	path := InstallManifestPath(userHome)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && path != homeInstallManifestPath(userHome) {
+		path = homeInstallManifestPath(userHome)
+		data, err = os.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest InstallManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid install manifest %s: %v", path, err)
+	}
+	return &manifest, nil
+}
+
+// String formats the manifest for status, e.g.
+// "2026-01-31 10:00:00 UTC (online, installer 3.2.0, phases import-inventory)".
+func (m InstallManifest) String() string {
+	phases := ""
+	if len(m.Phases) > 0 {
+		phases = ", phases " + strings.Join(m.Phases, ", ")
+	}
+	return fmt.Sprintf("%s (%s, installer %s%s)",
+		m.Installed.UTC().Format("2006-01-02 15:04:05 MST"), orUnknown(m.Mode), orUnknown(m.Version), phases)
+}
@@ -0,0 +1,85 @@
+package installer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/lmagdanello/bluebanquise-installer/internal/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallManifest(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := now
+	defer func() { now = original }()
+	installed := time.Date(2026, 1, 31, 11, 0, 0, 0, time.FixedZone("CET", 3600))
+	now = func() time.Time { return installed }
+
+	home := t.TempDir()
+	manifest, err := ReadInstallManifest(home)
+	require.NoError(t, err)
+	assert.Nil(t, manifest)
+
+	// The bluebanquise directory is created when missing
+	writeInstallManifest(home, InstallModeOffline, nil)
+	manifest, err = ReadInstallManifest(home)
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Equal(t, InstallModeOffline, manifest.Mode)
+	assert.Equal(t, version.Release, manifest.Version)
+	assert.True(t, installed.Equal(manifest.Installed))
+	assert.Equal(t, "2026-01-31 10:00:00 UTC (offline, installer "+version.Release+")", manifest.String())
+
+	// A later installation replaces it
+	writeInstallManifest(home, InstallModeOnline, []string{"import-inventory"})
+	manifest, err = ReadInstallManifest(home)
+	require.NoError(t, err)
+	assert.Equal(t, InstallModeOnline, manifest.Mode)
+	assert.Equal(t, []string{"import-inventory"}, manifest.Phases)
+	assert.Equal(t, "2026-01-31 10:00:00 UTC (online, installer "+version.Release+", phases import-inventory)", manifest.String())
+
+	require.NoError(t, os.WriteFile(InstallManifestPath(home), []byte("not json"), 0644))
+	_, err = ReadInstallManifest(home)
+	assert.ErrorContains(t, err, "invalid install manifest")
+}
+
+func TestInstallManifestStateDir(t *testing.T) {
+	utils.InitTestLogger()
+	defer func() { require.NoError(t, utils.SetStateDir("")) }()
+
+	home := t.TempDir()
+	writeInstallManifest(home, InstallModeOffline, nil)
+
+	// An installation made without --state-dir is still found
+	stateDir := t.TempDir()
+	require.NoError(t, utils.SetStateDir(stateDir))
+	path := InstallManifestPath(home)
+	assert.True(t, strings.HasPrefix(path, filepath.Join(stateDir, "manifests")+string(filepath.Separator)), path)
+	manifest, err := ReadInstallManifest(home)
+	require.NoError(t, err)
+	require.NotNil(t, manifest)
+	assert.Equal(t, InstallModeOffline, manifest.Mode)
+
+	// The installations run with it write to the state directory only
+	writeInstallManifest(home, InstallModeOnline, []string{"configure-environment", "install-collections"})
+	assert.FileExists(t, path)
+	manifest, err = ReadInstallManifest(home)
+	require.NoError(t, err)
+	assert.Equal(t, InstallModeOnline, manifest.Mode)
+	assert.Equal(t, []string{"configure-environment", "install-collections"}, manifest.Phases)
+
+	require.NoError(t, utils.SetStateDir(""))
+	manifest, err = ReadInstallManifest(home)
+	require.NoError(t, err)
+	assert.Equal(t, InstallModeOffline, manifest.Mode, "the manifest of the home is left as is")
+
+	// Homes sharing the state directory have their own manifest
+	require.NoError(t, utils.SetStateDir(stateDir))
+	assert.NotEqual(t, InstallManifestPath("/home/a_b"), InstallManifestPath("/home/a/b"))
+}
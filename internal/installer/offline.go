@@ -0,0 +1,437 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// OfflineOptions configures an offline installation. Empty UserName, UserHome,
+// SudoersMode and Shell default to bluebanquise, /var/lib/<user>, nopasswd
+// and /bin/bash.
+type OfflineOptions struct {
+	UserName    string
+	UserHome    string
+	SudoersMode string
+	// Shell is the login shell of a created user. With nologin or false, the
+	// environment is run through bootstrap.EnvWrapperPath instead of .bashrc.
+	Shell string
+	// HardenHome sets the home to 0700 and gives .ssh back to the user at
+	// 0700 after the installation. The commands enable it by default.
+	HardenHome    bool
+	AllowRootUser bool
+	// CollectionsPath is a directory of collection archives, an installed
+	// collections tree, a .tar.gz bundle, a git+ URL of a reachable git server
+	// or the URL of an HTTP(S) index of archives, downloaded first. Required
+	// unless FromBundle or SkipCollections is set.
+	CollectionsPath string
+	// ExtraCollectionsPaths are more local directories, bundles or globs of
+	// archives installed together with those of CollectionsPath, each
+	// collection version once.
+	ExtraCollectionsPaths []string
+	// FromBundle is a directory or .tar.gz bundle laid out by the download
+	// command, the collections, requirements and core variables are taken from
+	// it. RequirementsPath and CoreVarsPath override those of the bundle.
+	FromBundle string
+	// DownloadIfMissing installs the collections online, as the online command
+	// does, when CollectionsPath is missing or empty instead of failing.
+	DownloadIfMissing bool
+	// FollowSymlinks accepts symlinks of CollectionsPath or FromBundle resolving outside of it.
+	FollowSymlinks bool
+	// Force replaces installed collections with the archives of CollectionsPath
+	// whatever their version, an installed collection is kept otherwise.
+	Force bool
+	// OnlyCollections installs only the archives of CollectionsPath of these
+	// namespace.name collections, every archive is installed when empty.
+	OnlyCollections []string
+	// VerifySignatures runs ansible-galaxy collection verify on the installed
+	// collections with the keys of Keyring, the installation fails when one
+	// does not verify. The archives of CollectionsPath need a detached
+	// signature, see bootstrap.SignatureSuffix.
+	VerifySignatures bool
+	Keyring          string
+	// RequirementsPath holds Python packages for an offline environment.
+	RequirementsPath string
+	// CoreVarsPath is a core variables file, they are not installed when empty.
+	CoreVarsPath string
+	// VerifyChecksums checks the requirements against their checksum manifest,
+	// which must exist, and the collection archives against theirs when present.
+	VerifyChecksums bool
+	SkipEnvironment bool
+	SkipCollections bool
+	SkipCoreVars    bool
+	// AnsibleConfigTemplate is rendered to ansible.cfg instead of the built-in
+	// default, with $HOME replaced by the user home.
+	AnsibleConfigTemplate string
+	// InventoryURL is a Git repository or .tar.gz URL of an inventory to import.
+	InventoryURL string
+	// Mirror holds the credentials and headers sent with HTTP downloads.
+	Mirror utils.DownloadOptions
+	// Packages configures the installation of system packages.
+	Packages utils.PackageOptions
+	Verbose  bool
+	Strict   bool
+	Debug    bool
+}
+
+// Offline installs BlueBanquise from local collections, requirements and core variables.
+func (i *Installer) Offline(ctx context.Context, opts OfflineOptions) error {
+	if err := checkCollectionsSource(opts); err != nil {
+		return err
+	}
+
+	// Validate options before any filesystem changes
+	user, err := resolveTargetUser(opts.UserName, opts.UserHome, opts.SudoersMode, opts.Shell, opts.AllowRootUser)
+	if err != nil {
+		return err
+	}
+	if err := validateInstallPaths(map[string]string{
+		"--home":              user.home,
+		"--collections-path":  opts.CollectionsPath,
+		"--from-bundle":       opts.FromBundle,
+		"--requirements-path": opts.RequirementsPath,
+	}); err != nil {
+		return err
+	}
+	for _, path := range opts.ExtraCollectionsPaths {
+		if err := validateInstallPaths(map[string]string{"--collections-path": path}); err != nil {
+			return err
+		}
+	}
+	if err := validatePackageOptions(opts.Packages); err != nil {
+		return err
+	}
+	if err := validateAnsibleConfigTemplate(opts.AnsibleConfigTemplate); err != nil {
+		return err
+	}
+	if opts.FromBundle != "" && opts.CollectionsPath == "" {
+		err = validateOnlyCollections(opts.OnlyCollections, opts.FromBundle)
+	} else {
+		err = validateOnlyCollections(opts.OnlyCollections, opts.CollectionsPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err := validateSignatureOptions(opts.VerifySignatures, opts.Keyring, opts.CollectionsPath); err != nil {
+		return err
+	}
+
+	utils.LogInfo("Starting BlueBanquise offline installation",
+		"collections_path", opts.CollectionsPath,
+		"extra_collections_paths", opts.ExtraCollectionsPaths,
+		"only_collections", opts.OnlyCollections,
+		"verify_signatures", opts.VerifySignatures,
+		"keyring", opts.Keyring,
+		"from_bundle", opts.FromBundle,
+		"requirements_path", opts.RequirementsPath,
+		"user", user.name,
+		"home", user.home,
+		"skip_environment", opts.SkipEnvironment,
+		"skip_collections", opts.SkipCollections,
+		"skip_core_vars", opts.SkipCoreVars,
+		"download_if_missing", opts.DownloadIfMissing,
+		"inventory_url", utils.RedactURL(opts.InventoryURL),
+		"sudoers_mode", user.sudoersMode,
+		"shell", user.shell,
+		"harden_home", opts.HardenHome,
+		"download_first", opts.Packages.DownloadFirst,
+		"verbose", opts.Verbose,
+		"strict", opts.Strict,
+		"debug", opts.Debug)
+
+	utils.SetVerbose(opts.Verbose)
+	utils.SetStrict(opts.Strict)
+
+	// Take the paths not given explicitly from the offline bundle
+	if opts.FromBundle != "" {
+		dir, cleanup, err := utils.PrepareOfflineBundle(opts.FromBundle, opts.FollowSymlinks)
+		if err != nil {
+			utils.LogError("Offline bundle validation failed", err, "path", opts.FromBundle)
+			return fmt.Errorf("offline bundle validation failed: %w", err)
+		}
+		defer cleanup()
+		bundle, err := resolveOfflineBundle(dir)
+		if err != nil {
+			utils.LogError("Offline bundle validation failed", err, "path", opts.FromBundle)
+			return fmt.Errorf("offline bundle validation failed: %v", err)
+		}
+		if bundle.collections == "" && !opts.SkipCollections && !opts.DownloadIfMissing {
+			return fmt.Errorf("offline bundle %s has no collections directory", opts.FromBundle)
+		}
+		opts.CollectionsPath = bundle.collections
+		if opts.RequirementsPath == "" {
+			opts.RequirementsPath = bundle.requirements
+		}
+		if opts.CoreVarsPath == "" {
+			opts.CoreVarsPath = bundle.coreVars
+		}
+	}
+
+	// Validate collections path unless collections are skipped or downloaded,
+	// extracting a bundle first
+	collectionsPath := opts.CollectionsPath
+	collectionsOnline := downloadMissingCollections(opts, collectionsPath)
+	if collectionsOnline {
+		utils.LogWarning("Collections path is missing or empty, installing collections online", "path", collectionsPath)
+		fmt.Printf("Collections path %q is missing or empty, collections will be downloaded (--download-if-missing)\n", collectionsPath)
+	}
+	if !opts.SkipCollections && !collectionsOnline && utils.IsHTTPCollectionSource(collectionsPath) {
+		dir, cleanup, err := downloadCollectionsIndex(ctx, collectionsPath, opts.Mirror)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		collectionsPath = dir
+	}
+	if !opts.SkipCollections && len(opts.ExtraCollectionsPaths) > 0 {
+		dir, cleanup, err := mergeLocalCollections(append([]string{collectionsPath}, opts.ExtraCollectionsPaths...), opts.VerifyChecksums, opts.FollowSymlinks)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		collectionsPath = dir
+	} else if !opts.SkipCollections && !collectionsOnline && !utils.IsGitCollectionSource(collectionsPath) {
+		dir, cleanup, err := prepareLocalCollections(collectionsPath, opts.VerifyChecksums, opts.FollowSymlinks)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		collectionsPath = dir
+	}
+
+	var signatures []bootstrap.CollectionSignature
+	if opts.VerifySignatures && !opts.SkipCollections {
+		if collectionsOnline {
+			signatures = bootstrap.OnlineCollectionSignatures()
+		} else if signatures, err = collectionSignatures(true, collectionsPath, opts.OnlyCollections); err != nil {
+			return err
+		}
+	}
+
+	// Validate requirements path if provided
+	if opts.RequirementsPath != "" {
+		if err := checkLocalRequirements(opts.RequirementsPath, opts.VerifyChecksums); err != nil {
+			return err
+		}
+	}
+
+	// Validate core vars path if provided
+	if opts.CoreVarsPath != "" {
+		utils.LogInfo("Validating core variables path", "path", opts.CoreVarsPath)
+		fmt.Println("Validating core variables path...")
+		if _, err := os.Stat(opts.CoreVarsPath); err != nil {
+			utils.LogError("Core variables path validation failed", err, "path", opts.CoreVarsPath)
+			return fmt.Errorf("core variables path validation failed: %v", err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("installation interrupted: %w", err)
+	}
+
+	if err := prepareSystem(ctx, user, false, opts.Packages); err != nil {
+		return err
+	}
+
+	// Configure environment, collections and core variables (unless skipped)
+	if opts.CoreVarsPath == "" && !opts.SkipCoreVars {
+		utils.LogInfo("No core variables path provided, skipping core variables installation")
+	}
+	skips := installSkips{
+		environment: opts.SkipEnvironment,
+		collections: opts.SkipCollections,
+		inventory:   opts.InventoryURL == "",
+		coreVars:    opts.SkipCoreVars || opts.CoreVarsPath == "",
+	}
+	phases := newInstallPhases(skips, installSteps{
+		environment: func() error {
+			if err := configureEnvironmentOffline(user.name, user.home, opts.RequirementsPath, user.sudoersMode, opts.VerifyChecksums); err != nil {
+				return err
+			}
+			return installAnsibleConfig(user.home, opts.AnsibleConfigTemplate)
+		},
+		venvCheck: func() error {
+			return bootstrap.CheckVirtualEnvironment(user.home)
+		},
+		collections: func() error {
+			var err error
+			if collectionsOnline {
+				err = installCollectionsOnline(ctx, user.home)
+			} else {
+				err = installCollections(ctx, collectionsPath, user.home, opts.Force, opts.OnlyCollections)
+			}
+			if err != nil {
+				return err
+			}
+			return verifySignatures(user.home, opts.Keyring, signatures)
+		},
+		inventory: func() error {
+			return bootstrap.InstallInventoryFromURL(ctx, opts.InventoryURL, user.home, opts.Mirror)
+		},
+		coreVars: coreVarsStep(user.home, opts.InventoryURL, func() error {
+			return bootstrap.InstallCoreVariablesOffline(opts.CoreVarsPath, user.home)
+		}),
+		inventoryDir: func() error {
+			return bootstrap.EnsureInventoryDir(user.home)
+		},
+	})
+	if err := runInstallPhases(ctx, phases); err != nil {
+		return err
+	}
+	if err := hardenUserHome(user, opts.HardenHome); err != nil {
+		return err
+	}
+
+	writeInstallManifest(user.home, InstallModeOffline, ranPhases(phases))
+
+	// Fix SELinux labels on the home tree, collections live outside standard paths
+	utils.EnsureSELinuxContext(user.home)
+
+	printSummary(user.home)
+
+	utils.LogInfo("Offline installation completed successfully")
+	return nil
+}
+
+// downloadMissingCollections reports whether the collections of an offline
+// installation are installed online: DownloadIfMissing is set and path is
+// missing, an empty directory or a pattern matching nothing.
+func downloadMissingCollections(opts OfflineOptions, path string) bool {
+	if !opts.DownloadIfMissing || opts.SkipCollections || len(opts.ExtraCollectionsPaths) > 0 ||
+		utils.IsGitCollectionSource(path) || utils.IsHTTPCollectionSource(path) {
+		return false
+	}
+	if path == "" {
+		return true
+	}
+	if utils.IsGlobPattern(path) {
+		matches, err := filepath.Glob(path)
+		return err == nil && len(matches) == 0
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	entries, err := os.ReadDir(path)
+	return err == nil && len(entries) == 0
+}
+
+// downloadCollectionsIndex downloads the archives listed by the HTTP(S) index
+// at indexURL, validated and installed afterwards like a local directory. The
+// returned cleanup removes them.
+func downloadCollectionsIndex(ctx context.Context, indexURL string, mirror utils.DownloadOptions) (string, func(), error) {
+	dir, cleanup, err := utils.DownloadCollectionsIndex(ctx, indexURL, mirror)
+	if err != nil {
+		utils.LogError("Collections download failed", err, "url", utils.RedactURL(indexURL))
+		return "", func() {}, fmt.Errorf("collections download failed: %w", err)
+	}
+	return dir, cleanup, nil
+}
+
+// prepareLocalCollections validates a local collections path, extracting a
+// bundle first, and lists the collections found. With verifyChecksums, the
+// archives are checked against the checksum manifest of the directory when it
+// has one. The returned cleanup removes the extracted bundle and must be called
+// once the collections are installed.
+func prepareLocalCollections(path string, verifyChecksums, followSymlinks bool) (string, func(), error) {
+	dir, cleanup, err := utils.PrepareCollectionsPath(path, followSymlinks)
+	if err != nil {
+		utils.LogError("Collections validation failed", err, "path", path)
+		return "", func() {}, fmt.Errorf("collections validation failed: %w", err)
+	}
+
+	utils.LogInfo("Validating collections path", "path", dir)
+	fmt.Println("Validating collections path...")
+	collections, err := utils.CheckCollectionsPrerequisites(dir)
+	if err != nil {
+		cleanup()
+		utils.LogError("Collections validation failed", err, "path", dir)
+		return "", func() {}, fmt.Errorf("collections validation failed: %w", err)
+	}
+	fmt.Printf("Found %d collection(s) in %s:\n", len(collections), dir)
+	for _, collection := range collections {
+		fmt.Printf("  - %s\n", collection)
+	}
+
+	if verifyChecksums {
+		if err := verifyCollectionsChecksums(dir); err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+	}
+	return dir, cleanup, nil
+}
+
+// mergeLocalCollections validates each of several local collections paths as
+// prepareLocalCollections does, then links their archives into one directory,
+// each collection version once. The returned cleanup removes the extracted
+// bundles and the merged directory.
+func mergeLocalCollections(paths []string, verifyChecksums, followSymlinks bool) (string, func(), error) {
+	var cleanups []func()
+	cleanup := func() {
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}
+
+	var dirs []string
+	for _, path := range paths {
+		if utils.IsGitCollectionSource(path) || utils.IsHTTPCollectionSource(path) {
+			utils.LogError("Remote source among several collections paths", nil, "path", utils.RedactURL(path))
+			return "", func() {}, fmt.Errorf("several --collections-path must be local directories, bundles or globs, got %s", utils.RedactURL(path))
+		}
+		dir, prepared, err := prepareLocalCollections(path, verifyChecksums, followSymlinks)
+		if err != nil {
+			cleanup()
+			return "", func() {}, err
+		}
+		cleanups = append(cleanups, prepared)
+		dirs = append(dirs, dir)
+	}
+
+	dir, merged, err := utils.MergeCollectionsDirs(dirs)
+	if err != nil {
+		cleanup()
+		utils.LogError("Collections merge failed", err, "paths", paths)
+		return "", func() {}, fmt.Errorf("collections merge failed: %w", err)
+	}
+	cleanups = append(cleanups, merged)
+	return dir, cleanup, nil
+}
+
+// verifyCollectionsChecksums checks the collection archives of dir against its
+// checksum manifest. A directory without manifest is only reported.
+func verifyCollectionsChecksums(dir string) error {
+	utils.LogInfo("Verifying collections checksums", "path", dir)
+	fmt.Println("Verifying collections checksums...")
+	err := utils.VerifyCollectionsChecksums(dir)
+	if errors.Is(err, utils.ErrNoChecksumManifest) {
+		utils.LogWarning("No checksum manifest for collections, skipping verification", "path", dir)
+		fmt.Printf("Warning: no SHA256SUMS or checksums.txt in %s, collections checksums not verified\n", dir)
+		return nil
+	}
+	if err != nil {
+		utils.LogError("Collections checksum verification failed", err, "path", dir)
+		return fmt.Errorf("collections checksum verification failed: %v", err)
+	}
+	return nil
+}
+
+// checkLocalRequirements validates a local Python requirements path.
+func checkLocalRequirements(path string, verifyChecksums bool) error {
+	utils.LogInfo("Validating requirements path", "path", path)
+	fmt.Println("Validating requirements path...")
+	if err := utils.CheckRequirementsPrerequisites(path, verifyChecksums); err != nil {
+		utils.LogError("Requirements validation failed", err, "path", path)
+		return fmt.Errorf("requirements validation failed: %w", err)
+	}
+	return nil
+}
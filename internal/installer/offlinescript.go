@@ -0,0 +1,89 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// OfflineScript is the script written by Download into its path, running the
+// offline installation of the components found there.
+const OfflineScript = "install-offline.sh"
+
+// offlineScriptHeader starts the offline script. The components are taken
+// relative to the script, so the directory can be moved to the target host.
+const offlineScriptHeader = `#!/bin/sh
+# Written by bluebanquise-installer download: installs BlueBanquise offline from
+# the components of this directory. Extra arguments are passed to the offline
+# command, e.g. --user or --home. Set BLUEBANQUISE_INSTALLER to the installer
+# binary when it is not ./bluebanquise-installer.
+set -e
+DIR="$(cd "$(dirname "$0")" && pwd)"
+INSTALLER="${BLUEBANQUISE_INSTALLER:-./bluebanquise-installer}"
+`
+
+// writeOfflineScript writes the offline script into path, with the offline
+// arguments of the components path holds, and returns its path.
+func writeOfflineScript(path string) (string, error) {
+	args, err := offlineScriptArgs(path)
+	if err != nil {
+		return "", err
+	}
+
+	var script strings.Builder
+	script.WriteString(offlineScriptHeader)
+	script.WriteString(`exec "$INSTALLER" offline`)
+	for _, arg := range args {
+		script.WriteString(" \\\n  " + arg)
+	}
+	script.WriteString(" \\\n  \"$@\"\n")
+
+	file := filepath.Join(path, OfflineScript)
+	if err := os.WriteFile(file, []byte(script.String()), 0755); err != nil {
+		utils.LogError("Error writing offline installation script", err, "path", file)
+		return "", fmt.Errorf("error writing offline installation script: %v", err)
+	}
+	utils.LogInfo("Offline installation script written", "path", file, "args", args)
+	return file, nil
+}
+
+// offlineScriptArgs returns the offline arguments of the components of path,
+// relative to $DIR. Requirements downloaded for several target OSes are picked
+// by --from-bundle on the target host, which then provides every component.
+// Without collections, the script requires COLLECTIONS_PATH.
+func offlineScriptArgs(path string) ([]string, error) {
+	requirements := filepath.Join(path, "requirements")
+	if isDir(requirements) {
+		targets, err := bundleRequirementsTargets(requirements)
+		if err != nil {
+			return nil, err
+		}
+		if len(targets) > 1 {
+			return []string{`--from-bundle "$DIR"`}, nil
+		}
+		if len(targets) == 1 {
+			requirements = filepath.Join(requirements, targets[0])
+		}
+	}
+
+	var args []string
+	if isDir(filepath.Join(path, "collections")) {
+		args = append(args, `--collections-path "$DIR/collections"`)
+	} else {
+		args = append(args, `--collections-path "${COLLECTIONS_PATH:?set COLLECTIONS_PATH to the collections directory}"`)
+	}
+	if isDir(requirements) {
+		rel, err := filepath.Rel(path, requirements)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf(`--requirements-path "$DIR/%s"`, filepath.ToSlash(rel)))
+	}
+	if fileExists(filepath.Join(path, "core-vars", "bb_core.yml")) {
+		args = append(args, `--core-vars-path "$DIR/core-vars/bb_core.yml"`)
+	}
+	return args, nil
+}
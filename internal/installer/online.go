@@ -0,0 +1,260 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// OnlineOptions configures an online installation. Empty UserName, UserHome,
+// SudoersMode and Shell default to bluebanquise, /var/lib/<user>, nopasswd
+// and /bin/bash.
+type OnlineOptions struct {
+	UserName    string
+	UserHome    string
+	SudoersMode string
+	// Shell is the login shell of a created user. With nologin or false, the
+	// environment is run through bootstrap.EnvWrapperPath instead of .bashrc.
+	Shell string
+	// HardenHome sets the home to 0700 and gives .ssh back to the user at
+	// 0700 after the installation. The commands enable it by default.
+	HardenHome      bool
+	AllowRootUser   bool
+	SkipEnvironment bool
+	SkipCollections bool
+	SkipCoreVars    bool
+	// CollectionsPath installs collections from a local directory, .tar.gz
+	// bundle, git+ URL or HTTP(S) index of archives instead of GitHub.
+	CollectionsPath string
+	// FollowSymlinks accepts symlinks of CollectionsPath resolving outside of it.
+	FollowSymlinks bool
+	// Force replaces installed collections with the archives of CollectionsPath
+	// whatever their version, an installed collection is kept otherwise.
+	Force bool
+	// OnlyCollections installs only the archives of CollectionsPath of these
+	// namespace.name collections, every archive is installed when empty.
+	OnlyCollections []string
+	// VerifySignatures runs ansible-galaxy collection verify on the installed
+	// collections with the keys of Keyring, the installation fails when one
+	// does not verify. The archives of CollectionsPath need a detached
+	// signature, see bootstrap.SignatureSuffix.
+	VerifySignatures bool
+	Keyring          string
+	// RequirementsPath installs Python packages from a local directory instead
+	// of the network.
+	RequirementsPath string
+	// InventoryURL is a Git repository or .tar.gz URL of an inventory to import.
+	InventoryURL string
+	// CoreVarsURLs default to bb_core.yml from GitHub.
+	CoreVarsURLs []string
+	// AnsibleVersion pins the Ansible release, the latest one is installed when empty.
+	AnsibleVersion string
+	// AnsibleConfigTemplate is rendered to ansible.cfg instead of the built-in
+	// default, with $HOME replaced by the user home.
+	AnsibleConfigTemplate string
+	// Mirror holds the credentials and headers sent with HTTP downloads.
+	Mirror utils.DownloadOptions
+	// Packages configures the installation of system packages.
+	Packages utils.PackageOptions
+	Verbose  bool
+	Strict   bool
+	Debug    bool
+}
+
+// Online installs BlueBanquise, downloading collections and core variables from GitHub.
+// Collections and Python packages come from local paths instead when
+// CollectionsPath or RequirementsPath are set.
+func (i *Installer) Online(ctx context.Context, opts OnlineOptions) error {
+	// Validate options before any filesystem changes
+	user, err := resolveTargetUser(opts.UserName, opts.UserHome, opts.SudoersMode, opts.Shell, opts.AllowRootUser)
+	if err != nil {
+		return err
+	}
+	if err := validateInstallPaths(map[string]string{
+		"--home":              user.home,
+		"--collections-path":  opts.CollectionsPath,
+		"--requirements-path": opts.RequirementsPath,
+	}); err != nil {
+		return err
+	}
+	if err := validateAnsibleConfigTemplate(opts.AnsibleConfigTemplate); err != nil {
+		return err
+	}
+	if err := validateOnlyCollections(opts.OnlyCollections, opts.CollectionsPath); err != nil {
+		return err
+	}
+	if err := validateSignatureOptions(opts.VerifySignatures, opts.Keyring, opts.CollectionsPath); err != nil {
+		return err
+	}
+
+	if opts.AnsibleVersion != "" && opts.RequirementsPath != "" {
+		utils.LogError("Conflicting options", nil, "ansible_version", opts.AnsibleVersion, "requirements_path", opts.RequirementsPath)
+		return fmt.Errorf("--ansible-version cannot be used with --requirements-path, the local requirements are already pinned")
+	}
+	if err := validatePackageOptions(opts.Packages); err != nil {
+		return err
+	}
+	if opts.AnsibleVersion != "" {
+		if err := utils.ValidateAnsibleVersion(opts.AnsibleVersion); err != nil {
+			utils.LogError("Invalid ansible version", err)
+			return err
+		}
+	}
+
+	utils.LogInfo("Starting BlueBanquise online installation",
+		"user", user.name,
+		"home", user.home,
+		"skip_environment", opts.SkipEnvironment,
+		"skip_collections", opts.SkipCollections,
+		"skip_core_vars", opts.SkipCoreVars,
+		"collections_path", opts.CollectionsPath,
+		"only_collections", opts.OnlyCollections,
+		"verify_signatures", opts.VerifySignatures,
+		"keyring", opts.Keyring,
+		"requirements_path", opts.RequirementsPath,
+		"inventory_url", utils.RedactURL(opts.InventoryURL),
+		"sudoers_mode", user.sudoersMode,
+		"shell", user.shell,
+		"harden_home", opts.HardenHome,
+		"ansible_version", opts.AnsibleVersion,
+		"download_first", opts.Packages.DownloadFirst,
+		"verbose", opts.Verbose,
+		"strict", opts.Strict,
+		"debug", opts.Debug)
+
+	utils.SetVerbose(opts.Verbose)
+	utils.SetStrict(opts.Strict)
+
+	// Validate local collections and requirements used instead of the network
+	collectionsPath := opts.CollectionsPath
+	if collectionsPath != "" && !opts.SkipCollections && utils.IsHTTPCollectionSource(collectionsPath) {
+		dir, cleanup, err := downloadCollectionsIndex(ctx, collectionsPath, opts.Mirror)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		collectionsPath = dir
+	}
+	if collectionsPath != "" && !opts.SkipCollections && !utils.IsGitCollectionSource(collectionsPath) {
+		dir, cleanup, err := prepareLocalCollections(collectionsPath, false, opts.FollowSymlinks)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		collectionsPath = dir
+	}
+	signatures, err := collectionSignatures(opts.VerifySignatures && !opts.SkipCollections, collectionsPath, opts.OnlyCollections)
+	if err != nil {
+		return err
+	}
+	if opts.RequirementsPath != "" && !opts.SkipEnvironment {
+		if err := checkLocalRequirements(opts.RequirementsPath, false); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("installation interrupted: %w", err)
+	}
+
+	// Check system prerequisites
+	utils.LogInfo("Checking system prerequisites")
+	fmt.Println("Checking system prerequisites...")
+	if err := systemCheck(); err != nil {
+		utils.LogError("System check failed", err)
+		if hint := offlineHint(err); hint != "" {
+			fmt.Print(hint)
+		}
+		return fmt.Errorf("system check failed: %w", err)
+	}
+
+	if err := prepareSystem(ctx, user, true, opts.Packages); err != nil {
+		return err
+	}
+
+	// Configure environment, collections and core variables (unless skipped)
+	skips := installSkips{
+		environment: opts.SkipEnvironment,
+		collections: opts.SkipCollections,
+		inventory:   opts.InventoryURL == "",
+		coreVars:    opts.SkipCoreVars,
+	}
+	phases := newInstallPhases(skips, onlineSteps(ctx, user, opts, collectionsPath, signatures))
+	if err := runInstallPhases(ctx, phases); err != nil {
+		return err
+	}
+	if err := hardenUserHome(user, opts.HardenHome); err != nil {
+		return err
+	}
+
+	writeInstallManifest(user.home, InstallModeOnline, ranPhases(phases))
+
+	// Fix SELinux labels on the home tree, collections live outside standard paths
+	utils.EnsureSELinuxContext(user.home)
+
+	printSummary(user.home)
+
+	utils.LogInfo("Online installation completed successfully")
+	return nil
+}
+
+// onlineSteps returns the installation steps of an online installation, taking
+// collections from collectionsPath and Python packages from opts.RequirementsPath
+// when they are set.
+func onlineSteps(ctx context.Context, user targetUser, opts OnlineOptions, collectionsPath string, signatures []bootstrap.CollectionSignature) installSteps {
+	return installSteps{
+		environment: func() error {
+			var err error
+			if opts.RequirementsPath != "" {
+				err = configureEnvironmentOffline(user.name, user.home, opts.RequirementsPath, user.sudoersMode, false)
+			} else {
+				err = configureEnvironment(ctx, user.name, user.home, "", user.sudoersMode, opts.AnsibleVersion)
+			}
+			if err != nil {
+				return err
+			}
+			return installAnsibleConfig(user.home, opts.AnsibleConfigTemplate)
+		},
+		venvCheck: func() error {
+			return bootstrap.CheckVirtualEnvironment(user.home)
+		},
+		collections: func() error {
+			if err := installCollections(ctx, collectionsPath, user.home, opts.Force, opts.OnlyCollections); err != nil {
+				return err
+			}
+			return verifySignatures(user.home, opts.Keyring, signatures)
+		},
+		inventory: func() error {
+			return bootstrap.InstallInventoryFromURL(ctx, opts.InventoryURL, user.home, opts.Mirror)
+		},
+		coreVars: coreVarsStep(user.home, opts.InventoryURL, func() error {
+			return bootstrap.InstallCoreVariablesOnline(ctx, user.home, opts.CoreVarsURLs, opts.Mirror)
+		}),
+		inventoryDir: func() error {
+			return bootstrap.EnsureInventoryDir(user.home)
+		},
+	}
+}
+
+// offlineHint points to the download and offline workflow when err comes from
+// a failed connectivity check, and returns an empty string otherwise.
+func offlineHint(err error) string {
+	if !errors.Is(err, utils.ErrNoConnectivity) {
+		return ""
+	}
+	utils.LogWarning("No connectivity detected, suggesting offline installation")
+	return `
+No internet connectivity detected, did you mean the offline mode?
+On a machine with internet access, download the installation files:
+  ./bluebanquise-installer download --path /tmp/offline --collections --requirements --core-vars
+Then transfer /tmp/offline to this machine and run:
+  sudo ./bluebanquise-installer offline \
+    --collections-path /tmp/offline/collections \
+    --requirements-path /tmp/offline/requirements \
+    --core-vars-path /tmp/offline/core-vars/bb_core.yml
+
+`
+}
@@ -0,0 +1,113 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// installSkips holds the --skip-* flags shared by the online and offline commands.
+type installSkips struct {
+	environment bool
+	collections bool
+	inventory   bool
+	coreVars    bool
+}
+
+// installSteps holds the functions run for each optional installation phase.
+type installSteps struct {
+	environment func() error
+	venvCheck   func() error
+	collections func() error
+	inventory   func() error
+	coreVars    func() error
+	// inventoryDir creates the inventory directory when core variables are
+	// skipped, so Ansible still finds one.
+	inventoryDir func() error
+}
+
+// installPhase is one optional step of an online or offline installation.
+// Its id is the phase field of the lines logged while it runs.
+type installPhase struct {
+	id   string
+	name string
+	skip bool
+	run  func() error
+	// skipped, when set, runs instead of run when the phase is skipped.
+	skipped func() error
+}
+
+// newInstallPhases orders the optional phases and marks the skipped ones.
+// When the environment is skipped but collections are not, the existing
+// virtual environment is validated before installing collections.
+func newInstallPhases(skips installSkips, steps installSteps) []installPhase {
+	return []installPhase{
+		{id: "configure-environment", name: "environment configuration", skip: skips.environment, run: steps.environment},
+		{id: "check-venv", name: "virtual environment check", skip: !skips.environment || skips.collections, run: steps.venvCheck},
+		{id: "install-collections", name: "collections installation", skip: skips.collections, run: steps.collections},
+		{id: "import-inventory", name: "inventory import", skip: skips.inventory, run: steps.inventory},
+		{id: "install-core-vars", name: "core variables installation", skip: skips.coreVars, run: steps.coreVars, skipped: steps.inventoryDir},
+	}
+}
+
+// ranPhases returns the ids of the phases runInstallPhases runs, the skipped
+// ones left out.
+func ranPhases(phases []installPhase) []string {
+	var ids []string
+	for _, phase := range phases {
+		if !phase.skip {
+			ids = append(ids, phase.id)
+		}
+	}
+	return ids
+}
+
+// runInstallPhases runs every phase that is not skipped, or the skip step of
+// a skipped one, in order, and stops before the next phase once ctx is done.
+func runInstallPhases(ctx context.Context, phases []installPhase) error {
+	for _, phase := range phases {
+		if err := ctx.Err(); err != nil {
+			utils.LogError("Installation interrupted", err, "phase", phase.id)
+			return fmt.Errorf("installation interrupted before %s: %w", phase.name, err)
+		}
+		run, message := phase.run, "Running installation phase"
+		if phase.skip {
+			if phase.skipped == nil {
+				utils.LogInfo("Skipping installation phase", "phase", phase.id)
+				continue
+			}
+			run, message = phase.skipped, "Skipping installation phase, running its skip step"
+		}
+		err := utils.WithPhase(phase.id, func() error {
+			utils.LogInfo(message, "name", phase.name)
+			if err := run(); err != nil {
+				utils.LogError("Installation phase failed", err, "name", phase.name)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error in %s: %v", phase.name, err)
+		}
+	}
+	return nil
+}
+
+// coreVarsStep returns install, unless an inventory was imported from
+// inventoryURL with its own bb_core.yml, which is then kept instead of being
+// overwritten by the core variables.
+func coreVarsStep(userHome, inventoryURL string, install func() error) func() error {
+	return func() error {
+		if inventoryURL != "" {
+			if _, err := os.Stat(bootstrap.CoreVarsFile(userHome)); err == nil {
+				utils.LogInfo("Imported inventory provides core variables, keeping them", "path", bootstrap.CoreVarsFile(userHome))
+				fmt.Println("Keeping the core variables of the imported inventory.")
+				return nil
+			}
+		}
+		return install()
+	}
+}
@@ -0,0 +1,187 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunInstallPhases(t *testing.T) {
+	utils.InitTestLogger()
+
+	tests := []struct {
+		name     string
+		skips    installSkips
+		expected []string
+	}{
+		{
+			name:     "No skips",
+			skips:    installSkips{},
+			expected: []string{"environment", "collections", "inventory", "core-vars"},
+		},
+		{
+			name:     "No inventory URL",
+			skips:    installSkips{inventory: true},
+			expected: []string{"environment", "collections", "core-vars"},
+		},
+		{
+			name:     "Skip environment",
+			skips:    installSkips{environment: true, inventory: true},
+			expected: []string{"venv-check", "collections", "core-vars"},
+		},
+		{
+			name:     "Skip collections",
+			skips:    installSkips{collections: true, inventory: true},
+			expected: []string{"environment", "core-vars"},
+		},
+		{
+			name:     "Skip core variables",
+			skips:    installSkips{coreVars: true, inventory: true},
+			expected: []string{"environment", "collections", "inventory-dir"},
+		},
+		{
+			name:     "Environment only",
+			skips:    installSkips{collections: true, inventory: true, coreVars: true},
+			expected: []string{"environment", "inventory-dir"},
+		},
+		{
+			name:     "Collections only",
+			skips:    installSkips{environment: true, inventory: true, coreVars: true},
+			expected: []string{"venv-check", "collections", "inventory-dir"},
+		},
+		{
+			name:     "Core variables only",
+			skips:    installSkips{environment: true, collections: true, inventory: true},
+			expected: []string{"core-vars"},
+		},
+		{
+			name:     "Skip everything",
+			skips:    installSkips{environment: true, collections: true, inventory: true, coreVars: true},
+			expected: []string{"inventory-dir"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran []string
+			record := func(name string) func() error {
+				return func() error {
+					ran = append(ran, name)
+					return nil
+				}
+			}
+
+			phases := newInstallPhases(tt.skips, installSteps{
+				environment:  record("environment"),
+				venvCheck:    record("venv-check"),
+				collections:  record("collections"),
+				inventory:    record("inventory"),
+				coreVars:     record("core-vars"),
+				inventoryDir: record("inventory-dir"),
+			})
+			assert.NoError(t, runInstallPhases(context.Background(), phases))
+			assert.Equal(t, tt.expected, ran)
+		})
+	}
+}
+
+func TestRunInstallPhasesStopsOnError(t *testing.T) {
+	utils.InitTestLogger()
+
+	collectionsRan := false
+	phases := newInstallPhases(installSkips{environment: true, inventory: true}, installSteps{
+		venvCheck: func() error { return errors.New("venv missing") },
+		collections: func() error {
+			collectionsRan = true
+			return nil
+		},
+		coreVars: func() error { return nil },
+	})
+
+	err := runInstallPhases(context.Background(), phases)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "virtual environment check")
+	assert.False(t, collectionsRan)
+}
+
+func TestRunInstallPhasesCancelled(t *testing.T) {
+	utils.InitTestLogger()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran []string
+	phases := newInstallPhases(installSkips{inventory: true}, installSteps{
+		environment: func() error {
+			ran = append(ran, "environment")
+			cancel()
+			return nil
+		},
+		collections: func() error {
+			ran = append(ran, "collections")
+			return nil
+		},
+		coreVars: func() error {
+			ran = append(ran, "core-vars")
+			return nil
+		},
+	})
+
+	err := runInstallPhases(ctx, phases)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"environment"}, ran)
+}
+
+func TestRunInstallPhasesSkipStepError(t *testing.T) {
+	utils.InitTestLogger()
+
+	coreVarsRan := false
+	phases := newInstallPhases(installSkips{inventory: true, coreVars: true}, installSteps{
+		environment: func() error { return nil },
+		collections: func() error { return nil },
+		coreVars: func() error {
+			coreVarsRan = true
+			return nil
+		},
+		inventoryDir: func() error { return errors.New("read-only file system") },
+	})
+
+	err := runInstallPhases(context.Background(), phases)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "core variables installation")
+	assert.False(t, coreVarsRan)
+}
+
+func TestCoreVarsStep(t *testing.T) {
+	utils.InitTestLogger()
+
+	userHome := t.TempDir()
+	installed := 0
+	install := func() error {
+		installed++
+		return nil
+	}
+
+	require.NoError(t, coreVarsStep(userHome, "https://example.com/inventory.tar.gz", install)())
+	assert.Equal(t, 1, installed, "imported inventory without bb_core.yml")
+
+	require.NoError(t, os.MkdirAll(bootstrap.GroupVarsAllDir(userHome), 0755))
+	require.NoError(t, os.WriteFile(bootstrap.CoreVarsFile(userHome), []byte("imported: true\n"), 0644))
+	require.NoError(t, coreVarsStep(userHome, "https://example.com/inventory.tar.gz", install)())
+	assert.Equal(t, 1, installed, "bb_core.yml of the imported inventory is kept")
+
+	require.NoError(t, coreVarsStep(userHome, "", install)())
+	assert.Equal(t, 2, installed, "no imported inventory")
+}
+
+func TestRanPhases(t *testing.T) {
+	steps := installSteps{}
+	assert.Equal(t, []string{"configure-environment", "install-collections", "install-core-vars"},
+		ranPhases(newInstallPhases(installSkips{inventory: true}, steps)))
+	assert.Equal(t, []string{"import-inventory"},
+		ranPhases(newInstallPhases(installSkips{environment: true, collections: true, coreVars: true}, steps)))
+}
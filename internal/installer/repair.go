@@ -0,0 +1,203 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// RepairOptions configures the repair of an existing installation. An empty
+// UserName defaults to bluebanquise, an empty UserHome to the home of the
+// user database.
+type RepairOptions struct {
+	UserName string
+	UserHome string
+	// RebuildVenv recreates the virtual environment when its python no longer
+	// runs, e.g. once a distribution upgrade removed its base interpreter.
+	RebuildVenv bool
+	// ReinstallBroken reinstalls the Python packages pip check reports with
+	// missing or incompatible requirements, as left by an interrupted install.
+	ReinstallBroken bool
+	// RequirementsPath installs the Python packages of the rebuilt virtual
+	// environment, or the reinstalled ones, from a local directory instead of
+	// the network.
+	RequirementsPath string
+	// AnsibleVersion pins the Ansible release installed from the network.
+	AnsibleVersion string
+}
+
+// States of a virtual environment checked before a rebuild.
+const (
+	venvHealthy = "healthy"
+	venvBroken  = "broken"
+	venvMissing = "missing"
+)
+
+// Repair steps changing the virtual environment, tests replace them.
+var (
+	rebuildVirtualEnvironment = bootstrap.RebuildVirtualEnvironment
+	reinstallPackages         = utils.ReinstallPackages
+)
+
+// Repair fixes the parts of an installation selected by opts, leaving the
+// collections, core variables and configuration files untouched.
+func (i *Installer) Repair(ctx context.Context, opts RepairOptions) error {
+	if !opts.RebuildVenv && !opts.ReinstallBroken {
+		return errors.New("no repair action given, use --rebuild-venv or --reinstall-broken")
+	}
+	if opts.AnsibleVersion != "" && opts.RequirementsPath != "" {
+		utils.LogError("Conflicting options", nil, "ansible_version", opts.AnsibleVersion, "requirements_path", opts.RequirementsPath)
+		return fmt.Errorf("--ansible-version cannot be used with --requirements-path, the local requirements are already pinned")
+	}
+	if opts.AnsibleVersion != "" {
+		if err := utils.ValidateAnsibleVersion(opts.AnsibleVersion); err != nil {
+			utils.LogError("Invalid ansible version", err)
+			return err
+		}
+	}
+
+	userName := opts.UserName
+	if userName == "" {
+		userName = DefaultUserName
+	}
+	userHome := opts.UserHome
+	if userHome == "" {
+		home, err := bootstrap.LookupUserHome(userName)
+		if err != nil {
+			utils.LogError("Error resolving user home", err, "user", userName)
+			return err
+		}
+		userHome = home
+	}
+	if err := validateInstallPaths(map[string]string{
+		"--home":              userHome,
+		"--requirements-path": opts.RequirementsPath,
+	}); err != nil {
+		return err
+	}
+	if opts.RequirementsPath != "" {
+		if err := checkLocalRequirements(opts.RequirementsPath, false); err != nil {
+			return err
+		}
+	}
+
+	utils.LogInfo("Starting BlueBanquise repair", "user", userName, "home", userHome,
+		"rebuild_venv", opts.RebuildVenv, "reinstall_broken", opts.ReinstallBroken, "requirements_path", opts.RequirementsPath, "ansible_version", opts.AnsibleVersion)
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("repair interrupted: %w", err)
+	}
+	if opts.RebuildVenv {
+		if err := rebuildBrokenVenv(ctx, userHome, opts); err != nil {
+			return err
+		}
+	}
+	if opts.ReinstallBroken {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("repair interrupted: %w", err)
+		}
+		return reinstallBrokenRequirements(ctx, userHome, opts)
+	}
+	return nil
+}
+
+// rebuildBrokenVenv recreates the virtual environment of userHome when its
+// python does not run, and checks it runs once rebuilt. A healthy virtual
+// environment is left as is, a missing one is an installation to run instead.
+func rebuildBrokenVenv(ctx context.Context, userHome string, opts RepairOptions) error {
+	venvDir := bootstrap.VenvDir(userHome)
+	state, reason := checkVenvPython(venvDir)
+	utils.LogInfo("Virtual environment checked", "path", venvDir, "state", state, "reason", reason)
+
+	switch state {
+	case venvMissing:
+		return fmt.Errorf("no virtual environment in %s, install it with the online or offline command", venvDir)
+	case venvHealthy:
+		fmt.Printf("%s Virtual environment %s runs, nothing to rebuild\n", utils.ColorPass("✓"), venvDir)
+		return nil
+	}
+
+	fmt.Printf("%s Virtual environment %s is broken: %v\n", utils.ColorWarn("⚠"), venvDir, reason)
+	if err := rebuildVirtualEnvironment(ctx, userHome, opts.RequirementsPath, opts.AnsibleVersion); err != nil {
+		utils.LogError("Failed to rebuild virtual environment", err, "path", venvDir)
+		return fmt.Errorf("failed to rebuild virtual environment: %v", err)
+	}
+	if state, reason := checkVenvPython(venvDir); state != venvHealthy {
+		utils.LogError("Rebuilt virtual environment does not run", reason, "path", venvDir)
+		return fmt.Errorf("rebuilt virtual environment %s still does not run: %v", venvDir, reason)
+	}
+
+	utils.LogInfo("Virtual environment rebuilt", "path", venvDir)
+	fmt.Printf("%s Virtual environment %s rebuilt\n", utils.ColorPass("✓"), venvDir)
+	return nil
+}
+
+// reinstallBrokenRequirements reinstalls the packages pip check reports with
+// missing or incompatible requirements in the virtual environment of userHome,
+// then checks them again. Consistent requirements are left as is.
+func reinstallBrokenRequirements(ctx context.Context, userHome string, opts RepairOptions) error {
+	venvDir := bootstrap.VenvDir(userHome)
+	switch state, reason := checkVenvPython(venvDir); state {
+	case venvMissing:
+		return fmt.Errorf("no virtual environment in %s, install it with the online or offline command", venvDir)
+	case venvBroken:
+		return fmt.Errorf("virtual environment %s is broken: %v, use --rebuild-venv", venvDir, reason)
+	}
+
+	broken, err := checkVenvRequirements(venvDir)
+	if err != nil {
+		return err
+	}
+	if len(broken) == 0 {
+		fmt.Printf("%s Python requirements of %s are consistent, nothing to reinstall\n", utils.ColorPass("✓"), venvDir)
+		return nil
+	}
+	fmt.Printf("%s Broken Python requirements in %s:\n", utils.ColorWarn("⚠"), venvDir)
+	for _, requirement := range broken {
+		fmt.Printf("  - %s\n", requirement.Line)
+	}
+
+	packages := utils.BrokenPackages(broken)
+	if err := reinstallPackages(ctx, venvDir, packages, opts.RequirementsPath); err != nil {
+		return err
+	}
+	broken, err = checkVenvRequirements(venvDir)
+	if err != nil {
+		return err
+	}
+	if len(broken) > 0 {
+		var lines []string
+		for _, requirement := range broken {
+			lines = append(lines, requirement.Line)
+		}
+		utils.LogError("Python requirements still broken after reinstall", nil, "venv", venvDir, "broken", lines)
+		return fmt.Errorf("python requirements of %s still broken after reinstalling %s, use --rebuild-venv:\n  %s", venvDir, strings.Join(packages, " "), strings.Join(lines, "\n  "))
+	}
+
+	utils.LogInfo("Broken Python packages reinstalled", "venv", venvDir, "packages", packages)
+	fmt.Printf("%s Python packages reinstalled: %s\n", utils.ColorPass("✓"), strings.Join(packages, " "))
+	return nil
+}
+
+// checkVenvPython returns the state of the virtual environment venvDir, with
+// the reason it is broken: its python3 does not resolve or fails to run.
+func checkVenvPython(venvDir string) (string, error) {
+	if !isDir(venvDir) {
+		return venvMissing, nil
+	}
+	python := filepath.Join(venvDir, "bin", "python3")
+	if _, err := os.Stat(python); err != nil {
+		return venvBroken, fmt.Errorf("%s not found, its base interpreter may have been removed", python)
+	}
+	if output, err := runVersionCheck(python); err != nil {
+		utils.LogError("python3 --version failed", err, "path", python, "output", output)
+		return venvBroken, fmt.Errorf("%s fails to run: %v", python, err)
+	}
+	return venvHealthy, nil
+}
@@ -0,0 +1,206 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairRebuildVenv(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalRebuild, originalVersion := rebuildVirtualEnvironment, runVersionCheck
+	defer func() { rebuildVirtualEnvironment, runVersionCheck = originalRebuild, originalVersion }()
+
+	tests := []struct {
+		name string
+		// venv creates the virtual environment of home before the repair
+		venv        func(t *testing.T, python string)
+		pythonFails bool
+		rebuildErr  error
+		wantRebuild bool
+		wantErr     string
+	}{
+		{
+			name:        "Healthy",
+			venv:        func(t *testing.T, python string) { require.NoError(t, os.WriteFile(python, nil, 0755)) },
+			wantRebuild: false,
+		},
+		{
+			name: "Base python removed",
+			venv: func(t *testing.T, python string) {
+				require.NoError(t, os.Symlink(filepath.Join(t.TempDir(), "python3.11"), python))
+			},
+			wantRebuild: true,
+		},
+		{
+			name:        "Python fails to run",
+			venv:        func(t *testing.T, python string) { require.NoError(t, os.WriteFile(python, nil, 0755)) },
+			pythonFails: true,
+			wantRebuild: true,
+		},
+		{
+			name: "Rebuild fails",
+			venv: func(t *testing.T, python string) {
+				require.NoError(t, os.Symlink(filepath.Join(t.TempDir(), "python3.11"), python))
+			},
+			rebuildErr:  errors.New("python3 cannot create virtual environments"),
+			wantRebuild: true,
+			wantErr:     "failed to rebuild virtual environment: python3 cannot create virtual environments",
+		},
+		{
+			name:    "Missing",
+			wantErr: "no virtual environment in",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			python := filepath.Join(bootstrap.VenvDir(home), "bin", "python3")
+			if tt.venv != nil {
+				require.NoError(t, os.MkdirAll(filepath.Dir(python), 0755))
+				tt.venv(t, python)
+			}
+
+			broken := tt.pythonFails
+			runVersionCheck = func(binary string) (string, error) {
+				if broken {
+					return "", errors.New("exit status 127")
+				}
+				return "Python 3.12.3", nil
+			}
+			rebuilt := false
+			rebuildVirtualEnvironment = func(_ context.Context, userHome, requirementsPath, ansibleVersion string) error {
+				rebuilt = true
+				assert.Equal(t, home, userHome)
+				if tt.rebuildErr != nil {
+					return tt.rebuildErr
+				}
+				broken = false
+				require.NoError(t, os.Remove(python))
+				return os.WriteFile(python, nil, 0755)
+			}
+
+			err := New().Repair(context.Background(), RepairOptions{UserHome: home, RebuildVenv: true})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantRebuild, rebuilt)
+		})
+	}
+}
+
+func TestRepairInvalidOptions(t *testing.T) {
+	utils.InitTestLogger()
+
+	home := t.TempDir()
+	tests := []struct {
+		name string
+		opts RepairOptions
+	}{
+		{name: "No action", opts: RepairOptions{UserHome: home}},
+		{name: "Ansible version with requirements path", opts: RepairOptions{UserHome: home, RebuildVenv: true, RequirementsPath: home, AnsibleVersion: "9.2.0"}},
+		{name: "Relative home", opts: RepairOptions{UserHome: "bluebanquise", RebuildVenv: true}},
+		{name: "Missing requirements path", opts: RepairOptions{UserHome: home, RebuildVenv: true, RequirementsPath: filepath.Join(home, "missing")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, New().Repair(context.Background(), tt.opts))
+		})
+	}
+}
+
+func TestRepairReinstallBroken(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalCheck, originalReinstall, originalVersion := checkVenvRequirements, reinstallPackages, runVersionCheck
+	defer func() {
+		checkVenvRequirements, reinstallPackages, runVersionCheck = originalCheck, originalReinstall, originalVersion
+	}()
+	runVersionCheck = func(binary string) (string, error) { return "Python 3.12.3", nil }
+
+	broken := []utils.BrokenRequirement{
+		{Package: "ansible-core", Version: "2.16.6", Requirement: "jinja2", Line: "ansible-core 2.16.6 requires jinja2, which is not installed."},
+		{Package: "ansible", Version: "9.5.1", Requirement: "ansible-core", Line: "ansible 9.5.1 requires ansible-core, which is not installed."},
+	}
+
+	tests := []struct {
+		name string
+		// checks are the results of the successive pip checks
+		checks        [][]utils.BrokenRequirement
+		reinstallErr  error
+		wantReinstall []string
+		wantErr       string
+	}{
+		{
+			name:   "Consistent",
+			checks: [][]utils.BrokenRequirement{nil},
+		},
+		{
+			name:          "Broken then fixed",
+			checks:        [][]utils.BrokenRequirement{broken, nil},
+			wantReinstall: []string{"ansible-core==2.16.6", "ansible==9.5.1"},
+		},
+		{
+			name:          "Still broken",
+			checks:        [][]utils.BrokenRequirement{broken, broken[:1]},
+			wantReinstall: []string{"ansible-core==2.16.6", "ansible==9.5.1"},
+			wantErr:       "still broken after reinstalling ansible-core==2.16.6 ansible==9.5.1",
+		},
+		{
+			name:          "Reinstall fails",
+			checks:        [][]utils.BrokenRequirement{broken},
+			reinstallErr:  errors.New("failed to reinstall python packages: exit status 1"),
+			wantReinstall: []string{"ansible-core==2.16.6", "ansible==9.5.1"},
+			wantErr:       "failed to reinstall python packages",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			python := filepath.Join(bootstrap.VenvDir(home), "bin", "python3")
+			require.NoError(t, os.MkdirAll(filepath.Dir(python), 0755))
+			require.NoError(t, os.WriteFile(python, nil, 0755))
+
+			checks := 0
+			checkVenvRequirements = func(venvPath string) ([]utils.BrokenRequirement, error) {
+				assert.Equal(t, bootstrap.VenvDir(home), venvPath)
+				result := tt.checks[checks]
+				checks++
+				return result, nil
+			}
+			var reinstalled []string
+			reinstallPackages = func(_ context.Context, venvPath string, packages []string, requirementsPath string) error {
+				reinstalled = packages
+				assert.Equal(t, "/srv/requirements", requirementsPath)
+				return tt.reinstallErr
+			}
+
+			err := reinstallBrokenRequirements(context.Background(), home, RepairOptions{ReinstallBroken: true, RequirementsPath: "/srv/requirements"})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantReinstall, reinstalled)
+			assert.Equal(t, len(tt.checks), checks)
+		})
+	}
+
+	t.Run("Missing virtual environment", func(t *testing.T) {
+		err := New().Repair(context.Background(), RepairOptions{UserHome: t.TempDir(), ReinstallBroken: true})
+		assert.ErrorContains(t, err, "no virtual environment in")
+	})
+}
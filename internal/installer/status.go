@@ -0,0 +1,486 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// Status output formats.
+const (
+	StatusOutputText = "text"
+	StatusOutputJSON = "json"
+)
+
+// StatusAllUsers as StatusOptions.UserName checks every installation found.
+const StatusAllUsers = "all"
+
+// passwdFile lists the users searched for installations, tests replace it.
+var passwdFile = "/etc/passwd"
+
+// sudoersDir holds the sudoers drop-ins checked by status, tests replace it.
+var sudoersDir = "/etc/sudoers.d"
+
+// StatusOptions configures an installation status check.
+type StatusOptions struct {
+	// UserName defaults to bluebanquise, StatusAllUsers checks every user
+	// whose home has a virtual environment or collections.
+	UserName string
+	// Verbose lists the versions of the key Python packages.
+	Verbose bool
+	// Output is text or json, text when empty.
+	Output string
+	// Deep adds the checks running subprocesses: python3 --version, ansible --version, pip check,
+	// pip list, visudo -c and the SELinux contexts. Without it status only reads files.
+	Deep bool
+}
+
+// Status checks running subprocesses, only used with StatusOptions.Deep.
+// Tests replace them to make sure the default status never runs them.
+var (
+	checkSELinuxContext   = utils.CheckSELinuxContext
+	checkVenvRequirements = utils.CheckVenvRequirements
+	runVersionCheck       = func(binary string) (string, error) {
+		output, err := exec.Command(binary, "--version").CombinedOutput()
+		return string(output), err
+	}
+	runVisudoCheck = func(path string) (string, error) {
+		visudo, err := exec.LookPath("visudo")
+		if err != nil {
+			return "", errVisudoNotFound
+		}
+		output, err := exec.Command(visudo, "-c", "-f", path).CombinedOutput()
+		return string(output), err
+	}
+)
+
+// errVisudoNotFound is returned by runVisudoCheck when visudo is not installed,
+// the sudoers files are then only checked for presence.
+var errVisudoNotFound = errors.New("visudo not found")
+
+// StatusReport is the result of a status check. Paths are only set once their
+// check passed, Error holds the first failed check.
+type StatusReport struct {
+	User               string                    `json:"user"`
+	Home               string                    `json:"home,omitempty"`
+	LastInstall        *InstallManifest          `json:"last_install"`
+	Venv               string                    `json:"venv,omitempty"`
+	Python             string                    `json:"python,omitempty"`
+	Ansible            string                    `json:"ansible,omitempty"`
+	AnsibleGalaxy      string                    `json:"ansible_galaxy,omitempty"`
+	Packages           []utils.PackageStatus     `json:"packages,omitempty"`
+	BrokenRequirements []utils.BrokenRequirement `json:"broken_requirements,omitempty"`
+	CollectionsDir     string                    `json:"collections_dir,omitempty"`
+	Collections        []CollectionVersion       `json:"collections"`
+	Infrastructure     string                    `json:"infrastructure_collection,omitempty"`
+	CoreVars           string                    `json:"core_vars,omitempty"`
+	Sudoers            string                    `json:"sudoers,omitempty"`
+	SudoersEnvKeep     string                    `json:"sudoers_env_keep,omitempty"`
+	Warnings           []string                  `json:"warnings,omitempty"`
+	Ready              bool                      `json:"ready"`
+	Error              string                    `json:"error,omitempty"`
+}
+
+// Status checks the installation of a BlueBanquise user and prints each check.
+func (i *Installer) Status(ctx context.Context, opts StatusOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	output := opts.Output
+	if output == "" {
+		output = StatusOutputText
+	}
+	if output != StatusOutputText && output != StatusOutputJSON {
+		return fmt.Errorf("invalid output %q, must be %s or %s", opts.Output, StatusOutputText, StatusOutputJSON)
+	}
+
+	utils.LogInfo("Checking BlueBanquise installation status", "user", opts.UserName, "output", output, "deep", opts.Deep)
+
+	if opts.UserName == StatusAllUsers {
+		return statusAllUsers(opts, output)
+	}
+
+	report := StatusReport{User: opts.UserName, Collections: []CollectionVersion{}}
+	var err error
+	if userHome, homeErr := getUserHome(opts.UserName); homeErr != nil {
+		err = fmt.Errorf("%s user home directory not found", opts.UserName)
+	} else {
+		report, err = checkInstallation(opts.UserName, userHome, opts)
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	if output == StatusOutputJSON {
+		if encodeErr := encodeStatus(report); encodeErr != nil {
+			return encodeErr
+		}
+	} else {
+		for _, line := range report.Lines() {
+			fmt.Println(line)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	utils.LogInfo("BlueBanquise installation status check completed successfully", "user", opts.UserName)
+	return nil
+}
+
+// statusAllUsers checks the installation of every user found in passwdFile,
+// printing one report per user, and fails when any of them is not ready.
+func statusAllUsers(opts StatusOptions, output string) error {
+	users, err := findInstallations(passwdFile)
+	if err != nil {
+		utils.LogError("Failed to list users", err, "file", passwdFile)
+		return fmt.Errorf("failed to list users: %v", err)
+	}
+	if len(users) == 0 {
+		return fmt.Errorf("no BlueBanquise installation found in the home directories of %s", passwdFile)
+	}
+
+	reports := make([]StatusReport, 0, len(users))
+	failed := 0
+	for _, user := range users {
+		report, err := checkInstallation(user.name, user.home, opts)
+		if err != nil {
+			report.Error = err.Error()
+			failed++
+		}
+		reports = append(reports, report)
+	}
+
+	if output == StatusOutputJSON {
+		if err := encodeStatus(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, report := range reports {
+			fmt.Printf("== %s (%s) ==\n", report.User, report.Home)
+			for _, line := range report.Lines() {
+				fmt.Println(line)
+			}
+			if report.Error != "" {
+				fmt.Printf("%s %s\n", utils.ColorFail("✗"), report.Error)
+			}
+			fmt.Println()
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d installations are not ready", failed, len(reports))
+	}
+	utils.LogInfo("BlueBanquise installation status check completed successfully", "users", len(reports))
+	return nil
+}
+
+// encodeStatus prints v as indented JSON on stdout.
+func encodeStatus(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode status: %v", err)
+	}
+	return nil
+}
+
+// statusUser is a user whose home holds a BlueBanquise installation.
+type statusUser struct {
+	name string
+	home string
+}
+
+// findInstallations returns the users of a passwd file whose home has a
+// virtual environment or collections, in file order.
+func findInstallations(passwd string) ([]statusUser, error) {
+	data, err := os.ReadFile(passwd)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []statusUser
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") || fields[5] == "" || fields[5] == "/" {
+			continue
+		}
+		name, home := fields[0], fields[5]
+		if seen[home] {
+			continue
+		}
+		if !isDir(bootstrap.VenvDir(home)) && !isDir(bootstrap.CollectionsDir(home)) {
+			continue
+		}
+		seen[home] = true
+		users = append(users, statusUser{name: name, home: home})
+	}
+	return users, nil
+}
+
+// isDir reports whether path is an existing directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// checkInstallation checks the installation of userName in userHome and stops
+// at the first missing component, returning the report filled so far.
+func checkInstallation(userName, userHome string, opts StatusOptions) (StatusReport, error) {
+	report := StatusReport{User: userName, Home: userHome, Collections: []CollectionVersion{}}
+
+	// The manifest is written by the installations, older ones have none
+	manifest, err := ReadInstallManifest(userHome)
+	if err != nil {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Unable to read install manifest: %v", err))
+	}
+	report.LastInstall = manifest
+
+	// Check Python virtual environment
+	venvDir := bootstrap.VenvDir(userHome)
+	if _, err := os.Stat(venvDir); os.IsNotExist(err) {
+		return report, fmt.Errorf("python virtual environment not found")
+	}
+	report.Venv = venvDir
+
+	// Check if activate script exists
+	activateScript := filepath.Join(venvDir, "bin", "activate")
+	if _, err := os.Stat(activateScript); os.IsNotExist(err) {
+		return report, fmt.Errorf("virtual environment activate script not found")
+	}
+
+	// Check the interpreter, a virtual environment whose base python was
+	// removed, e.g. by an OS upgrade, keeps its files but no longer runs
+	pythonPath := filepath.Join(venvDir, "bin", "python3")
+	if _, err := os.Stat(pythonPath); err != nil {
+		utils.LogError("Virtual environment python not found", err, "path", pythonPath)
+		return report, fmt.Errorf("virtual environment python %s not found, its base interpreter may have been removed: recreate the virtual environment", pythonPath)
+	}
+	if opts.Deep {
+		if output, err := runVersionCheck(pythonPath); err != nil {
+			utils.LogError("python3 --version failed", err, "path", pythonPath, "output", output)
+			return report, fmt.Errorf("virtual environment python fails to run: %v, recreate the virtual environment", err)
+		}
+	}
+	report.Python = pythonPath
+
+	// Check Ansible installation
+	ansiblePath := filepath.Join(venvDir, "bin", "ansible")
+	if _, err := os.Stat(ansiblePath); os.IsNotExist(err) {
+		return report, fmt.Errorf("ansible not found in virtual environment")
+	}
+	if opts.Deep {
+		if output, err := runVersionCheck(ansiblePath); err != nil {
+			utils.LogError("ansible --version failed", err, "path", ansiblePath, "output", output)
+			return report, fmt.Errorf("ansible is installed but fails to run: %v", err)
+		}
+	}
+	report.Ansible = ansiblePath
+
+	ansibleGalaxyPath := filepath.Join(venvDir, "bin", "ansible-galaxy")
+	if _, err := os.Stat(ansibleGalaxyPath); os.IsNotExist(err) {
+		return report, fmt.Errorf("ansible-galaxy not found in virtual environment")
+	}
+	report.AnsibleGalaxy = ansibleGalaxyPath
+
+	// An interrupted pip install leaves packages without their requirements,
+	// their files are there but they break at runtime
+	if opts.Deep {
+		broken, err := checkVenvRequirements(venvDir)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Unable to check Python requirements: %v", err))
+		} else if len(broken) > 0 {
+			report.BrokenRequirements = broken
+			return report, fmt.Errorf("virtual environment has %d broken Python requirements, run bluebanquise-installer repair --reinstall-broken", len(broken))
+		}
+	}
+
+	if opts.Verbose {
+		// pip is only run in deep mode, the default reads the package metadata
+		readPackages := utils.ReadVenvPackages
+		if opts.Deep {
+			readPackages = listVenvPackages
+		}
+		installed, err := readPackages(venvDir)
+		if err != nil {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("Unable to list Python packages: %v", err))
+		} else {
+			report.Packages = utils.CheckPackageVersions(installed, system.KeyPythonPackages)
+		}
+	}
+
+	// Check BlueBanquise collections
+	collectionsDir := bootstrap.CollectionsDir(userHome)
+	if _, err := os.Stat(collectionsDir); os.IsNotExist(err) {
+		return report, fmt.Errorf("bluebanquise collections not found")
+	}
+	report.CollectionsDir = collectionsDir
+	report.Collections = readInstalledCollections(collectionsDir)
+
+	// Check if infrastructure collection exists
+	infraCollectionDir := filepath.Join(collectionsDir, "ansible_collections", "bluebanquise", "infrastructure")
+	if _, err := os.Stat(infraCollectionDir); os.IsNotExist(err) {
+		return report, fmt.Errorf("bluebanquise infrastructure collection not found")
+	}
+	report.Infrastructure = infraCollectionDir
+
+	// Check core variables
+	coreVarsPath := filepath.Join(bootstrap.GroupVarsAllDir(userHome), "bb_core.yml")
+	if _, err := os.Stat(coreVarsPath); os.IsNotExist(err) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("Core variables not found: %s", coreVarsPath))
+	} else {
+		report.CoreVars = coreVarsPath
+	}
+
+	// Check the sudoers drop-ins, a missing one only makes ansible fail later
+	report.Sudoers, report.Warnings = checkSudoersFile(report.Warnings,
+		filepath.Join(sudoersDir, bootstrap.SudoersFileName(userName)), "", opts.Deep)
+	report.SudoersEnvKeep, report.Warnings = checkSudoersFile(report.Warnings,
+		filepath.Join(sudoersDir, bootstrap.EnvKeepSudoersFile), bootstrap.EnvKeepLine, opts.Deep)
+
+	// Check SELinux labels of the home tree, getenforce and restorecon are subprocesses
+	if opts.Deep {
+		if err := checkSELinuxContext(userHome); err != nil {
+			report.Warnings = append(report.Warnings, err.Error())
+		}
+	}
+
+	report.Ready = true
+	return report, nil
+}
+
+// checkSudoersFile checks that the sudoers drop-in path exists and, when line
+// is set, holds it. In deep mode the file is also validated with visudo -c when
+// visudo is installed. It returns path when the checks passed, and warnings
+// with the failed check appended once, as the user drop-in of the bluebanquise
+// user is also the env_keep one.
+func checkSudoersFile(warnings []string, path, line string, deep bool) (string, []string) {
+	warn := func(warning string) (string, []string) {
+		if slices.Contains(warnings, warning) {
+			return "", warnings
+		}
+		return "", append(warnings, warning)
+	}
+
+	content, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return warn(fmt.Sprintf("Sudoers file not found: %s", path))
+	case err != nil:
+		return warn(fmt.Sprintf("Unable to read sudoers file %s: %v", path, err))
+	}
+	if line != "" && !strings.Contains(string(content), line) {
+		return warn(fmt.Sprintf("Sudoers file %s does not contain %s", path, line))
+	}
+
+	if deep {
+		output, err := runVisudoCheck(path)
+		if errors.Is(err, errVisudoNotFound) {
+			utils.LogInfo("visudo not found, skipping sudoers syntax check", "path", path)
+		} else if err != nil {
+			utils.LogError("visudo -c failed", err, "path", path, "output", output)
+			return warn(fmt.Sprintf("Sudoers file %s is invalid: %s", path, strings.TrimSpace(output)))
+		}
+	}
+	return path, warnings
+}
+
+// Lines formats the report as the text output of status, one line per check.
+func (r StatusReport) Lines() []string {
+	pass, warn := utils.ColorPass("✓"), utils.ColorWarn("⚠")
+	var lines []string
+	if r.Home != "" {
+		lines = append(lines, fmt.Sprintf("%s User %s home directory: %s", pass, r.User, r.Home))
+		if r.LastInstall != nil {
+			lines = append(lines, fmt.Sprintf("%s Last install: %s", pass, r.LastInstall))
+		} else {
+			lines = append(lines, warn+" Last install: unknown (no manifest)")
+		}
+	}
+	if r.Venv != "" {
+		lines = append(lines, fmt.Sprintf("%s Python virtual environment: %s", pass, r.Venv))
+	}
+	if r.Python != "" {
+		lines = append(lines, fmt.Sprintf("%s Python interpreter: %s", pass, r.Python))
+	}
+	if r.Ansible != "" {
+		lines = append(lines, fmt.Sprintf("%s Ansible: %s", pass, r.Ansible))
+	}
+	if r.AnsibleGalaxy != "" {
+		lines = append(lines, fmt.Sprintf("%s Ansible Galaxy: %s", pass, r.AnsibleGalaxy))
+	}
+	if len(r.BrokenRequirements) > 0 {
+		lines = append(lines, fmt.Sprintf("%s Broken Python requirements (%d):", warn, len(r.BrokenRequirements)))
+		for _, requirement := range r.BrokenRequirements {
+			lines = append(lines, "  - "+requirement.Line)
+		}
+	}
+	lines = append(lines, packageInventoryLines(r.Packages)...)
+	if r.CollectionsDir != "" {
+		lines = append(lines, fmt.Sprintf("%s Collections directory: %s", pass, r.CollectionsDir))
+		if len(r.Collections) == 0 {
+			lines = append(lines, warn+" No installed collections found")
+		} else {
+			lines = append(lines, fmt.Sprintf("%s Installed collections (%d):", pass, len(r.Collections)))
+			for _, collection := range r.Collections {
+				lines = append(lines, fmt.Sprintf("  - %s %s", collection.Name, orUnknown(collection.Version)))
+			}
+		}
+	}
+	if r.Infrastructure != "" {
+		lines = append(lines, fmt.Sprintf("%s BlueBanquise infrastructure collection: %s", pass, r.Infrastructure))
+	}
+	if r.CoreVars != "" {
+		lines = append(lines, fmt.Sprintf("%s Core variables: %s", pass, r.CoreVars))
+	}
+	if r.Sudoers != "" {
+		lines = append(lines, fmt.Sprintf("%s Sudoers entry: %s", pass, r.Sudoers))
+	}
+	if r.SudoersEnvKeep != "" {
+		lines = append(lines, fmt.Sprintf("%s Sudoers PYTHONPATH env_keep: %s", pass, r.SudoersEnvKeep))
+	}
+	for _, warning := range r.Warnings {
+		lines = append(lines, warn+" "+warning)
+	}
+	if r.Ready {
+		lines = append(lines, "", pass+" BlueBanquise installation is ready!")
+	}
+	return lines
+}
+
+// packageInventoryLines formats one status line per package.
+func packageInventoryLines(statuses []utils.PackageStatus) []string {
+	pass, warn := utils.ColorPass("✓"), utils.ColorWarn("⚠")
+	lines := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		switch {
+		case status.Missing:
+			lines = append(lines, fmt.Sprintf("  %s %s: not installed (minimum %s)", warn, status.Name, status.Minimum))
+		case status.Outdated:
+			lines = append(lines, fmt.Sprintf("  %s %s: %s (below minimum %s)", warn, status.Name, status.Installed, status.Minimum))
+		default:
+			lines = append(lines, fmt.Sprintf("  %s %s: %s", pass, status.Name, status.Installed))
+		}
+	}
+	return lines
+}
+
+// getUserHome returns the home of userName, or of DefaultUserName when it is
+// empty, from the user database, as status --user all does.
+func getUserHome(userName string) (string, error) {
+	if userName == "" {
+		userName = DefaultUserName
+	}
+	return bootstrap.LookupUserHome(userName)
+}
@@ -0,0 +1,461 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/lmagdanello/bluebanquise-installer/internal/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageInventoryLines(t *testing.T) {
+	lines := packageInventoryLines([]utils.PackageStatus{
+		{Name: "ansible", Installed: "9.5.1", Minimum: "7.0.0"},
+		{Name: "netaddr", Installed: "0.7.19", Minimum: "0.8.0", Outdated: true},
+		{Name: "clustershell", Minimum: "1.8", Missing: true},
+	})
+
+	assert.Equal(t, []string{
+		"  ✓ ansible: 9.5.1",
+		"  ⚠ netaddr: 0.7.19 (below minimum 0.8.0)",
+		"  ⚠ clustershell: not installed (minimum 1.8)",
+	}, lines)
+}
+
+// writeStatusFixture creates an installation in home with the infrastructure
+// and community.general collections.
+func writeStatusFixture(t *testing.T, home string) {
+	t.Helper()
+
+	bin := filepath.Join(bootstrap.VenvDir(home), "bin")
+	require.NoError(t, os.MkdirAll(bin, 0755))
+	for _, name := range []string{"activate", "python3", "ansible", "ansible-galaxy"} {
+		require.NoError(t, os.WriteFile(filepath.Join(bin, name), nil, 0755))
+	}
+
+	root := filepath.Join(bootstrap.CollectionsDir(home), "ansible_collections")
+	infrastructure := filepath.Join(root, "bluebanquise", "infrastructure")
+	general := filepath.Join(root, "community", "general")
+	require.NoError(t, os.MkdirAll(infrastructure, 0755))
+	require.NoError(t, os.MkdirAll(general, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(infrastructure, "MANIFEST.json"), []byte(infrastructureManifest), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(general, "MANIFEST.json"),
+		[]byte(`{"collection_info": {"namespace": "community", "name": "general", "version": "9.0.0"}}`), 0644))
+}
+
+// writeSudoersFixture points sudoersDir to a temporary directory holding files,
+// restored when the test ends.
+func writeSudoersFixture(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0440))
+	}
+	original := sudoersDir
+	sudoersDir = dir
+	t.Cleanup(func() { sudoersDir = original })
+	return dir
+}
+
+func TestCheckInstallationCollections(t *testing.T) {
+	utils.InitTestLogger()
+
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+	writeSudoersFixture(t, nil)
+
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{})
+	require.NoError(t, err)
+	assert.True(t, report.Ready)
+
+	root := filepath.Join(bootstrap.CollectionsDir(home), "ansible_collections")
+	assert.Equal(t, []CollectionVersion{
+		{Namespace: "bluebanquise", Name: "bluebanquise.infrastructure", Version: "3.0.0", Path: filepath.Join(root, "bluebanquise", "infrastructure")},
+		{Namespace: "community", Name: "community.general", Version: "9.0.0", Path: filepath.Join(root, "community", "general")},
+	}, report.Collections)
+
+	lines := report.Lines()
+	assert.Contains(t, lines, "✓ Installed collections (2):")
+	assert.Contains(t, lines, "  - bluebanquise.infrastructure 3.0.0")
+	assert.Contains(t, lines, "  - community.general 9.0.0")
+	assert.Contains(t, lines, "⚠ Core variables not found: "+filepath.Join(bootstrap.GroupVarsAllDir(home), "bb_core.yml"))
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+	var decoded struct {
+		Ready       bool `json:"ready"`
+		Collections []struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+			Version   string `json:"version"`
+		} `json:"collections"`
+	}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.True(t, decoded.Ready)
+	require.Len(t, decoded.Collections, 2)
+	assert.Equal(t, "community", decoded.Collections[1].Namespace)
+	assert.Equal(t, "community.general", decoded.Collections[1].Name)
+	assert.Equal(t, "9.0.0", decoded.Collections[1].Version)
+}
+
+func TestCheckInstallationMissingInfrastructure(t *testing.T) {
+	utils.InitTestLogger()
+
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+	writeSudoersFixture(t, nil)
+	require.NoError(t, os.RemoveAll(filepath.Join(bootstrap.CollectionsDir(home), "ansible_collections", "bluebanquise")))
+
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{})
+	assert.EqualError(t, err, "bluebanquise infrastructure collection not found")
+	assert.False(t, report.Ready)
+	require.Len(t, report.Collections, 1)
+	assert.Equal(t, "community.general", report.Collections[0].Name)
+}
+
+func TestCheckInstallationDefaultRunsNoCommands(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalList, originalSELinux, originalVersion, originalVisudo, originalCheck := listVenvPackages, checkSELinuxContext, runVersionCheck, runVisudoCheck, checkVenvRequirements
+	defer func() {
+		listVenvPackages, checkSELinuxContext, runVersionCheck, runVisudoCheck, checkVenvRequirements = originalList, originalSELinux, originalVersion, originalVisudo, originalCheck
+	}()
+
+	var commands []string
+	checkVenvRequirements = func(venvPath string) ([]utils.BrokenRequirement, error) {
+		commands = append(commands, "pip check")
+		return nil, nil
+	}
+	listVenvPackages = func(venvPath string) (map[string]string, error) {
+		commands = append(commands, "pip list")
+		return map[string]string{"ansible": "9.5.1"}, nil
+	}
+	checkSELinuxContext = func(path string) error {
+		commands = append(commands, "restorecon")
+		return nil
+	}
+	runVersionCheck = func(binary string) (string, error) {
+		commands = append(commands, filepath.Base(binary)+" --version")
+		return "", nil
+	}
+	runVisudoCheck = func(path string) (string, error) {
+		commands = append(commands, "visudo -c -f "+filepath.Base(path))
+		return "", nil
+	}
+
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+	writeSudoersFixture(t, map[string]string{
+		"bluebanquise": "bluebanquise ALL=(ALL:ALL) NOPASSWD:ALL\n" + bootstrap.EnvKeepLine + "\n",
+	})
+	sitePackages := filepath.Join(bootstrap.VenvDir(home), "lib", "python3.12", "site-packages")
+	require.NoError(t, os.MkdirAll(filepath.Join(sitePackages, "ansible-9.5.1.dist-info"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(sitePackages, "ansible_core-2.16.6.dist-info"), 0755))
+
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{Verbose: true})
+	require.NoError(t, err)
+	assert.Empty(t, commands, "default status must not run any command")
+	require.NotEmpty(t, report.Packages)
+	assert.Equal(t, "ansible", report.Packages[0].Name)
+	assert.Equal(t, "9.5.1", report.Packages[0].Installed)
+
+	_, err = checkInstallation("bluebanquise", home, StatusOptions{Verbose: true, Deep: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"python3 --version", "ansible --version", "pip check", "pip list", "visudo -c -f bluebanquise", "visudo -c -f bluebanquise", "restorecon"}, commands)
+}
+
+func TestCheckInstallationSudoers(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalVisudo, originalVersion, originalSELinux, originalCheck := runVisudoCheck, runVersionCheck, checkSELinuxContext, checkVenvRequirements
+	defer func() {
+		runVisudoCheck, runVersionCheck, checkSELinuxContext, checkVenvRequirements = originalVisudo, originalVersion, originalSELinux, originalCheck
+	}()
+	runVersionCheck = func(binary string) (string, error) { return "", nil }
+	checkSELinuxContext = func(path string) error { return nil }
+	checkVenvRequirements = func(venvPath string) ([]utils.BrokenRequirement, error) { return nil, nil }
+
+	entry := "admin ALL=(ALL:ALL) NOPASSWD:ALL\n"
+	envKeep := bootstrap.EnvKeepLine + "\n"
+
+	tests := []struct {
+		name         string
+		files        map[string]string
+		deep         bool
+		visudoErr    error
+		wantSudoers  bool
+		wantEnvKeep  bool
+		wantWarnings []string
+	}{
+		{
+			name:        "Both drop-ins present",
+			files:       map[string]string{"admin": entry, "bluebanquise": envKeep},
+			wantSudoers: true,
+			wantEnvKeep: true,
+		},
+		{
+			name:         "Both drop-ins absent",
+			wantWarnings: []string{"Sudoers file not found: %s/admin", "Sudoers file not found: %s/bluebanquise"},
+		},
+		{
+			name:         "Env keep line missing",
+			files:        map[string]string{"admin": entry, "bluebanquise": "# empty\n"},
+			wantSudoers:  true,
+			wantWarnings: []string{"Sudoers file %s/bluebanquise does not contain " + bootstrap.EnvKeepLine},
+		},
+		{
+			name:         "Deep with invalid drop-ins",
+			files:        map[string]string{"admin": entry, "bluebanquise": envKeep},
+			deep:         true,
+			visudoErr:    errors.New("exit status 1"),
+			wantWarnings: []string{"Sudoers file %s/admin is invalid: syntax error", "Sudoers file %s/bluebanquise is invalid: syntax error"},
+		},
+		{
+			name:        "Deep without visudo",
+			files:       map[string]string{"admin": entry, "bluebanquise": envKeep},
+			deep:        true,
+			visudoErr:   errVisudoNotFound,
+			wantSudoers: true,
+			wantEnvKeep: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runVisudoCheck = func(path string) (string, error) {
+				if tt.visudoErr == nil {
+					return "", nil
+				}
+				return "syntax error\n", tt.visudoErr
+			}
+			home := t.TempDir()
+			writeStatusFixture(t, home)
+			require.NoError(t, os.MkdirAll(bootstrap.GroupVarsAllDir(home), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(bootstrap.GroupVarsAllDir(home), "bb_core.yml"), nil, 0644))
+			dir := writeSudoersFixture(t, tt.files)
+
+			report, err := checkInstallation("admin", home, StatusOptions{Deep: tt.deep})
+			require.NoError(t, err)
+			assert.True(t, report.Ready, "sudoers checks only warn")
+
+			if tt.wantSudoers {
+				assert.Equal(t, filepath.Join(dir, "admin"), report.Sudoers)
+				assert.Contains(t, report.Lines(), "✓ Sudoers entry: "+filepath.Join(dir, "admin"))
+			} else {
+				assert.Empty(t, report.Sudoers)
+			}
+			if tt.wantEnvKeep {
+				assert.Equal(t, filepath.Join(dir, "bluebanquise"), report.SudoersEnvKeep)
+				assert.Contains(t, report.Lines(), "✓ Sudoers PYTHONPATH env_keep: "+filepath.Join(dir, "bluebanquise"))
+			} else {
+				assert.Empty(t, report.SudoersEnvKeep)
+			}
+			var want []string
+			for _, warning := range tt.wantWarnings {
+				want = append(want, fmt.Sprintf(warning, dir))
+			}
+			assert.Equal(t, want, report.Warnings)
+		})
+	}
+}
+
+func TestCheckInstallationDeepBrokenAnsible(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := runVersionCheck
+	defer func() { runVersionCheck = original }()
+	runVersionCheck = func(binary string) (string, error) {
+		if filepath.Base(binary) != "ansible" {
+			return "", nil
+		}
+		return "ModuleNotFoundError: No module named 'ansible'", errors.New("exit status 1")
+	}
+
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{Deep: true})
+	assert.EqualError(t, err, "ansible is installed but fails to run: exit status 1")
+	assert.Empty(t, report.Ansible)
+}
+
+func TestCheckInstallationDeepBrokenRequirements(t *testing.T) {
+	utils.InitTestLogger()
+
+	originalVersion, originalCheck := runVersionCheck, checkVenvRequirements
+	defer func() { runVersionCheck, checkVenvRequirements = originalVersion, originalCheck }()
+	runVersionCheck = func(binary string) (string, error) { return "", nil }
+	broken := []utils.BrokenRequirement{{
+		Package:     "ansible-core",
+		Version:     "2.16.6",
+		Requirement: "jinja2",
+		Line:        "ansible-core 2.16.6 requires jinja2, which is not installed.",
+	}}
+	checkVenvRequirements = func(venvPath string) ([]utils.BrokenRequirement, error) { return broken, nil }
+
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+
+	// The default status reads files only and cannot see it
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, report.BrokenRequirements)
+
+	report, err = checkInstallation("bluebanquise", home, StatusOptions{Deep: true})
+	assert.EqualError(t, err, "virtual environment has 1 broken Python requirements, run bluebanquise-installer repair --reinstall-broken")
+	assert.False(t, report.Ready)
+	assert.Equal(t, broken, report.BrokenRequirements)
+	assert.Contains(t, report.Lines(), "  - ansible-core 2.16.6 requires jinja2, which is not installed.")
+}
+
+func TestCheckInstallationBrokenPython(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := runVersionCheck
+	defer func() { runVersionCheck = original }()
+	var commands []string
+	runVersionCheck = func(binary string) (string, error) {
+		commands = append(commands, filepath.Base(binary)+" --version")
+		return "", errors.New("fork/exec " + binary + ": no such file or directory")
+	}
+
+	// Deep mode runs the interpreter and stops before ansible
+	home := t.TempDir()
+	writeStatusFixture(t, home)
+	python := filepath.Join(bootstrap.VenvDir(home), "bin", "python3")
+	report, err := checkInstallation("bluebanquise", home, StatusOptions{Deep: true})
+	assert.EqualError(t, err, "virtual environment python fails to run: fork/exec "+python+": no such file or directory, recreate the virtual environment")
+	assert.Equal(t, []string{"python3 --version"}, commands)
+	assert.Empty(t, report.Python)
+	assert.Empty(t, report.Ansible)
+	assert.False(t, report.Ready)
+
+	// A symlink to a removed base interpreter is found without running it
+	commands = nil
+	require.NoError(t, os.Remove(python))
+	require.NoError(t, os.Symlink(filepath.Join(t.TempDir(), "python3.11"), python))
+	report, err = checkInstallation("bluebanquise", home, StatusOptions{})
+	assert.ErrorContains(t, err, "its base interpreter may have been removed")
+	assert.Empty(t, commands)
+	assert.Equal(t, bootstrap.VenvDir(home), report.Venv)
+	assert.Empty(t, report.Python)
+}
+
+func TestStatusAllUsers(t *testing.T) {
+	utils.InitTestLogger()
+	writeSudoersFixture(t, nil)
+
+	original := passwdFile
+	defer func() { passwdFile = original }()
+
+	// Two installations, one of them broken, and users without any
+	dir := t.TempDir()
+	ready, broken, other := filepath.Join(dir, "bluebanquise"), filepath.Join(dir, "bbtest"), filepath.Join(dir, "alice")
+	writeStatusFixture(t, ready)
+	writeStatusFixture(t, broken)
+	require.NoError(t, os.RemoveAll(filepath.Join(bootstrap.CollectionsDir(broken), "ansible_collections", "bluebanquise")))
+	require.NoError(t, os.MkdirAll(other, 0755))
+
+	passwdFile = filepath.Join(dir, "passwd")
+	require.NoError(t, os.WriteFile(passwdFile, []byte(
+		"root:x:0:0:root:/root:/bin/bash\n"+
+			"bluebanquise:x:1000:1000::"+ready+":/bin/bash\n"+
+			"alice:x:1001:1001::"+other+":/bin/bash\n"+
+			"nobody:x:65534:65534:nobody:/:/usr/sbin/nologin\n"+
+			"bbtest:x:1002:1002::"+broken+":/bin/bash\n"), 0644))
+
+	users, err := findInstallations(passwdFile)
+	require.NoError(t, err)
+	assert.Equal(t, []statusUser{{name: "bluebanquise", home: ready}, {name: "bbtest", home: broken}}, users)
+
+	err = New().Status(context.Background(), StatusOptions{UserName: StatusAllUsers})
+	assert.EqualError(t, err, "1 of 2 installations are not ready")
+
+	// Only the ready installation left
+	require.NoError(t, os.WriteFile(passwdFile, []byte("bluebanquise:x:1000:1000::"+ready+":/bin/bash\n"), 0644))
+	assert.NoError(t, New().Status(context.Background(), StatusOptions{UserName: StatusAllUsers, Output: StatusOutputJSON}))
+
+	require.NoError(t, os.WriteFile(passwdFile, []byte("alice:x:1001:1001::"+other+":/bin/bash\n"), 0644))
+	assert.Error(t, New().Status(context.Background(), StatusOptions{UserName: StatusAllUsers}))
+}
+
+func TestGetUserHome(t *testing.T) {
+	// The home of the user database, wherever it is, not a guessed /home/<user>
+	current, err := user.Current()
+	require.NoError(t, err)
+	home, err := getUserHome(current.Username)
+	require.NoError(t, err)
+	assert.Equal(t, current.HomeDir, home)
+
+	_, err = getUserHome("bb-no-such-user")
+	assert.Error(t, err)
+}
+
+func TestCheckInstallationLastInstall(t *testing.T) {
+	utils.InitTestLogger()
+	writeSudoersFixture(t, nil)
+
+	original := now
+	defer func() { now = original }()
+	now = func() time.Time { return time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name     string
+		manifest string
+		line     string
+		warning  string
+	}{
+		{name: "no manifest", line: "⚠ Last install: unknown (no manifest)"},
+		{name: "online", manifest: InstallModeOnline, line: "✓ Last install: 2026-01-31 10:00:00 UTC (online, installer " + version.Release + ")"},
+		{name: "offline", manifest: InstallModeOffline, line: "✓ Last install: 2026-01-31 10:00:00 UTC (offline, installer " + version.Release + ")"},
+		{name: "invalid manifest", manifest: "invalid", line: "⚠ Last install: unknown (no manifest)", warning: "Unable to read install manifest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			writeStatusFixture(t, home)
+			switch tt.manifest {
+			case "":
+			case "invalid":
+				require.NoError(t, os.MkdirAll(bootstrap.BluebanquiseDir(home), 0755))
+				require.NoError(t, os.WriteFile(InstallManifestPath(home), []byte("{"), 0644))
+			default:
+				writeInstallManifest(home, tt.manifest, nil)
+			}
+
+			report, err := checkInstallation("bluebanquise", home, StatusOptions{})
+			require.NoError(t, err)
+			lines := report.Lines()
+			assert.Equal(t, tt.line, lines[1])
+			if tt.warning != "" {
+				require.NotEmpty(t, report.Warnings)
+				assert.Contains(t, report.Warnings[0], tt.warning)
+			}
+
+			data, err := json.Marshal(report)
+			require.NoError(t, err)
+			var decoded struct {
+				LastInstall *InstallManifest `json:"last_install"`
+			}
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			if tt.manifest == "" || tt.manifest == "invalid" {
+				assert.Nil(t, decoded.LastInstall)
+			} else {
+				require.NotNil(t, decoded.LastInstall)
+				assert.Equal(t, tt.manifest, decoded.LastInstall.Mode)
+			}
+		})
+	}
+}
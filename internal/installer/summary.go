@@ -0,0 +1,146 @@
+package installer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+)
+
+// listVenvPackages lists the packages of a virtual environment, tests replace it to avoid running pip.
+var listVenvPackages = utils.ListVenvPackages
+
+// CollectionVersion is an installed collection and its version. Name is the
+// fully qualified namespace.name of the collection.
+type CollectionVersion struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Path      string `json:"path"`
+}
+
+// Summary records what an installation left in a BlueBanquise home.
+// Versions that could not be read are left empty.
+type Summary struct {
+	AnsibleVersion     string
+	AnsibleCoreVersion string
+	Collections        []CollectionVersion
+	VenvDir            string
+	CollectionsDir     string
+	CoreVarsDir        string
+	CoreVarsFiles      []string
+}
+
+// ReadSummary collects the installed versions and paths of userHome from pip
+// and the collection manifests.
+func ReadSummary(userHome string) Summary {
+	summary := Summary{
+		VenvDir:        bootstrap.VenvDir(userHome),
+		CollectionsDir: bootstrap.CollectionsDir(userHome),
+		CoreVarsDir:    bootstrap.GroupVarsAllDir(userHome),
+	}
+
+	if packages, err := listVenvPackages(summary.VenvDir); err != nil {
+		utils.LogWarning("Could not read installed Python packages", "error", err, "venv", summary.VenvDir)
+	} else {
+		summary.AnsibleVersion = packages["ansible"]
+		summary.AnsibleCoreVersion = packages["ansible-core"]
+	}
+
+	summary.Collections = readInstalledCollections(summary.CollectionsDir)
+
+	if entries, err := os.ReadDir(summary.CoreVarsDir); err == nil {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				summary.CoreVarsFiles = append(summary.CoreVarsFiles, entry.Name())
+			}
+		}
+		sort.Strings(summary.CoreVarsFiles)
+	}
+
+	return summary
+}
+
+// readInstalledCollections lists the collections installed under collectionsDir
+// with the versions of their MANIFEST.json or galaxy.yml.
+func readInstalledCollections(collectionsDir string) []CollectionVersion {
+	root := filepath.Join(collectionsDir, "ansible_collections")
+	collections, err := utils.InstalledCollections(root)
+	if err != nil {
+		return nil
+	}
+
+	installed := make([]CollectionVersion, 0, len(collections))
+	for _, collection := range collections {
+		path := filepath.Join(root, collection)
+		version, err := utils.InstalledCollectionVersion(path)
+		if err != nil {
+			utils.LogWarning("Could not read collection version", "error", err, "collection", collection)
+		}
+		installed = append(installed, CollectionVersion{
+			Namespace: filepath.Dir(collection),
+			Name:      strings.ReplaceAll(collection, string(filepath.Separator), "."),
+			Version:   version,
+			Path:      path,
+		})
+	}
+	return installed
+}
+
+// Lines formats the summary for the end of an installation.
+func (s Summary) Lines() []string {
+	collections := make([]string, 0, len(s.Collections))
+	for _, collection := range s.Collections {
+		collections = append(collections, collection.Name+" "+orUnknown(collection.Version))
+	}
+	coreVars := s.CoreVarsDir
+	if len(s.CoreVarsFiles) > 0 {
+		coreVars = fmt.Sprintf("%s (%s)", s.CoreVarsDir, strings.Join(s.CoreVarsFiles, ", "))
+	}
+
+	return []string{
+		"Installation summary:",
+		fmt.Sprintf("  ansible:          %s", orUnknown(s.AnsibleVersion)),
+		fmt.Sprintf("  ansible-core:     %s", orUnknown(s.AnsibleCoreVersion)),
+		fmt.Sprintf("  collections:      %s", orNone(strings.Join(collections, ", "))),
+		fmt.Sprintf("  virtualenv:       %s", s.VenvDir),
+		fmt.Sprintf("  collections path: %s", s.CollectionsDir),
+		fmt.Sprintf("  core variables:   %s", coreVars),
+	}
+}
+
+// printSummary logs and prints the summary of the installation in userHome.
+func printSummary(userHome string) {
+	summary := ReadSummary(userHome)
+	utils.LogInfo("Installation summary",
+		"ansible", summary.AnsibleVersion,
+		"ansible_core", summary.AnsibleCoreVersion,
+		"collections", summary.Collections,
+		"venv", summary.VenvDir,
+		"collections_path", summary.CollectionsDir,
+		"core_vars_path", summary.CoreVarsDir,
+		"core_vars_files", summary.CoreVarsFiles)
+
+	fmt.Println()
+	for _, line := range summary.Lines() {
+		fmt.Println(line)
+	}
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
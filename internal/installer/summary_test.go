@@ -0,0 +1,71 @@
+package installer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/bootstrap"
+	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const infrastructureManifest = `{"collection_info": {"namespace": "bluebanquise", "name": "infrastructure", "version": "3.0.0"}, "format": 1}`
+
+func TestReadSummary(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := listVenvPackages
+	defer func() { listVenvPackages = original }()
+	listVenvPackages = func(venvPath string) (map[string]string, error) {
+		return map[string]string{"ansible": "9.5.1", "ansible-core": "2.16.6", "jinja2": "3.1.4"}, nil
+	}
+
+	home := t.TempDir()
+	root := filepath.Join(bootstrap.CollectionsDir(home), "ansible_collections")
+	infrastructure := filepath.Join(root, "bluebanquise", "infrastructure")
+	general := filepath.Join(root, "community", "general")
+	require.NoError(t, os.MkdirAll(infrastructure, 0755))
+	require.NoError(t, os.MkdirAll(general, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(infrastructure, "MANIFEST.json"), []byte(infrastructureManifest), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(general, "galaxy.yml"), []byte("namespace: community\nname: general\nversion: 9.0.0\n"), 0644))
+	require.NoError(t, os.MkdirAll(bootstrap.GroupVarsAllDir(home), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(bootstrap.GroupVarsAllDir(home), "bb_core.yml"), []byte("---\n"), 0644))
+
+	summary := ReadSummary(home)
+	assert.Equal(t, "9.5.1", summary.AnsibleVersion)
+	assert.Equal(t, "2.16.6", summary.AnsibleCoreVersion)
+	assert.Equal(t, []CollectionVersion{
+		{Namespace: "bluebanquise", Name: "bluebanquise.infrastructure", Version: "3.0.0", Path: infrastructure},
+		{Namespace: "community", Name: "community.general", Version: "9.0.0", Path: general},
+	}, summary.Collections)
+	assert.Equal(t, []string{"bb_core.yml"}, summary.CoreVarsFiles)
+
+	assert.Equal(t, []string{
+		"Installation summary:",
+		"  ansible:          9.5.1",
+		"  ansible-core:     2.16.6",
+		"  collections:      bluebanquise.infrastructure 3.0.0, community.general 9.0.0",
+		"  virtualenv:       " + bootstrap.VenvDir(home),
+		"  collections path: " + bootstrap.CollectionsDir(home),
+		"  core variables:   " + bootstrap.GroupVarsAllDir(home) + " (bb_core.yml)",
+	}, summary.Lines())
+}
+
+func TestReadSummaryEmptyHome(t *testing.T) {
+	utils.InitTestLogger()
+
+	original := listVenvPackages
+	defer func() { listVenvPackages = original }()
+	listVenvPackages = func(venvPath string) (map[string]string, error) {
+		return nil, errors.New("pip not found")
+	}
+
+	home := t.TempDir()
+	lines := ReadSummary(home).Lines()
+	assert.Contains(t, lines, "  ansible:          unknown")
+	assert.Contains(t, lines, "  collections:      none")
+	assert.Contains(t, lines, "  core variables:   "+bootstrap.GroupVarsAllDir(home))
+}
@@ -1,5 +1,11 @@
 package system
 
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+)
+
 var PythonRequirements = []string{
 	"ansible",
 	"ansible-core",
@@ -12,33 +18,66 @@ var PythonRequirements = []string{
 	"wheel",
 }
 
+// PythonPackageMinimum is the lowest supported version of a key Python package.
+type PythonPackageMinimum struct {
+	Name    string
+	Version string
+}
+
+// KeyPythonPackages are the packages reported by status --verbose, with their minimum versions.
+var KeyPythonPackages = []PythonPackageMinimum{
+	{Name: "ansible", Version: "7.0.0"},
+	{Name: "ansible-core", Version: "2.14.0"},
+	{Name: "jinja2", Version: "3.0.0"},
+	{Name: "netaddr", Version: "0.8.0"},
+	{Name: "clustershell", Version: "1.8"},
+}
+
 type PackageDefinition struct {
 	OSID     string
 	Version  string
 	Packages []string
+	// PreHook runs before Packages are installed, e.g. to enable the
+	// repositories providing them, and PostHook afterwards. Both need the
+	// network and only run for online installations.
+	PreHook  func() error
 	PostHook func() error
+	// PythonVersion and GlibcVersion describe the interpreter of the OS, used
+	// to download Python requirements for it from another host.
+	PythonVersion string
+	GlibcVersion  string
 }
 
+// DependenciePackages lists the packages of each supported OS. Debian and
+// Ubuntu use the python3 of the release from the stock archive, a versioned
+// python3.X-pip only exists in third-party repositories such as the
+// deadsnakes PPA, which the installer does not add.
 var DependenciePackages = []PackageDefinition{
 	{
-		OSID:    "ubuntu",
-		Version: "24.04",
+		OSID:          "ubuntu",
+		Version:       "24.04",
+		PythonVersion: "3.12",
+		GlibcVersion:  "2.39",
 		Packages: []string{
-			"python3.12", "python3.12-pip", "python3.12-venv",
+			"python3", "python3-pip", "python3-venv",
 			"ssh", "curl", "git",
 		},
 	},
 	{
-		OSID:    "ubuntu",
-		Version: "22.04",
+		OSID:          "ubuntu",
+		Version:       "22.04",
+		PythonVersion: "3.10",
+		GlibcVersion:  "2.35",
 		Packages: []string{
-			"python3.12", "python3.12-pip", "python3.12-venv",
+			"python3", "python3-pip", "python3-venv",
 			"ssh", "curl", "git",
 		},
 	},
 	{
-		OSID:    "ubuntu",
-		Version: "20.04",
+		OSID:          "ubuntu",
+		Version:       "20.04",
+		PythonVersion: "3.11",
+		GlibcVersion:  "2.31",
 		Packages: []string{
 			"build-essential", "zlib1g-dev", "libncurses5-dev", "libgdbm-dev",
 			"libnss3-dev", "libssl-dev", "libreadline-dev", "libffi-dev",
@@ -48,55 +87,122 @@ var DependenciePackages = []PackageDefinition{
 		PostHook: BuildPython311FromSource,
 	},
 	{
-		OSID:    "rhel",
-		Version: "7",
+		OSID:          "rhel",
+		Version:       "7",
+		PythonVersion: "3.8",
+		GlibcVersion:  "2.17",
 		Packages: []string{
 			"epel-release", "openssh",
 			"centos-release-scl-rh", "centos-release-scl", "rh-python38",
 		},
+		PreHook: EnableSCLRepositories,
 	},
 	{
-		OSID:    "rhel",
-		Version: "8",
+		OSID:          "rhel",
+		Version:       "8",
+		PythonVersion: "3.9",
+		GlibcVersion:  "2.28",
 		Packages: []string{
 			"git", "python39", "python3-pip", "python3-policycoreutils", "openssh-clients",
 		},
+		PreHook: EnableEPELRepositories("8"),
 	},
 	{
-		OSID:    "rhel",
-		Version: "9",
+		OSID:          "rhel",
+		Version:       "9",
+		PythonVersion: "3.12",
+		GlibcVersion:  "2.34",
 		Packages: []string{
 			"git", "python3.12", "python3.12-pip", "python3-policycoreutils", "openssh-clients",
 		},
+		PreHook: EnableEPELRepositories("9"),
 	},
 	{
-		OSID:    "debian",
-		Version: "11",
+		OSID:          "debian",
+		Version:       "11",
+		PythonVersion: "3.9",
+		GlibcVersion:  "2.31",
 		Packages: []string{
 			"python3", "python3-pip", "python3-venv", "git", "ssh", "curl",
 		},
 	},
 	{
-		OSID:    "debian",
-		Version: "12",
+		OSID:          "debian",
+		Version:       "12",
+		PythonVersion: "3.11",
+		GlibcVersion:  "2.36",
 		Packages: []string{
-			"python3.12", "python3.12-pip", "python3.12-venv", "git", "ssh", "curl",
+			"python3", "python3-pip", "python3-venv", "git", "ssh", "curl",
 		},
 	},
 	{
-		OSID:    "opensuse-leap",
-		Version: "15.5",
+		OSID:          "opensuse-leap",
+		Version:       "15.5",
+		PythonVersion: "3.11",
+		GlibcVersion:  "2.31",
 		Packages: []string{
 			"python3", "python3-pip", "python311", "python311-pip", "git", "openssh", "curl",
 		},
 		PostHook: LinkPython311AsDefault,
 	},
 	{
-		OSID:    "opensuse-leap",
-		Version: "15.6",
+		OSID:          "opensuse-leap",
+		Version:       "15.6",
+		PythonVersion: "3.11",
+		GlibcVersion:  "2.31",
 		Packages: []string{
 			"python3", "python3-pip", "python311", "python311-pip", "git", "openssh", "curl",
 		},
 		PostHook: LinkPython311AsDefault,
 	},
 }
+
+// unsupportedOSOverride replaces the package definition of an OS missing from
+// DependenciePackages, nil unless AllowUnsupportedOS was called.
+var unsupportedOSOverride *PackageDefinition
+
+// unsupportedOSPython is the interpreter used on an OS without package definition.
+var unsupportedOSPython string
+
+// AllowUnsupportedOS lets the installation proceed on an OS missing from
+// DependenciePackages, installing packages and creating the virtual
+// environment with python instead of the missing definition.
+func AllowUnsupportedOS(python string, packages []string) error {
+	if !filepath.IsAbs(python) {
+		return fmt.Errorf("python interpreter must be an absolute path, got %q", python)
+	}
+	if len(packages) == 0 {
+		return fmt.Errorf("at least one package is required on an unsupported OS")
+	}
+	unsupportedOSOverride = &PackageDefinition{Packages: packages}
+	unsupportedOSPython = python
+	return nil
+}
+
+// findPackageDefinition returns the package definition of an OS, if any.
+func findPackageDefinition(osID, version string) (PackageDefinition, bool) {
+	for _, pkg := range DependenciePackages {
+		if pkg.OSID == osID && pkg.Version == version {
+			return pkg, true
+		}
+	}
+	return PackageDefinition{}, false
+}
+
+// PackagesFor returns the package definition of an OS. An OS missing from
+// DependenciePackages fails unless AllowUnsupportedOS was called, in which
+// case its packages are returned, without post-installation hook.
+func PackagesFor(osID, version string) (PackageDefinition, error) {
+	if pkg, found := findPackageDefinition(osID, version); found {
+		return pkg, nil
+	}
+	if unsupportedOSOverride == nil {
+		return PackageDefinition{}, fmt.Errorf("no package definition found for %s %s", osID, version)
+	}
+
+	slog.Warn("Installing on an unsupported OS at your own risk", "os", osID, "version", version,
+		"packages", unsupportedOSOverride.Packages, "python", unsupportedOSPython)
+	fmt.Printf("WARNING: %s %s is not supported, continuing at your own risk with packages %v and %s\n",
+		osID, version, unsupportedOSOverride.Packages, unsupportedOSPython)
+	return PackageDefinition{OSID: osID, Version: version, Packages: unsupportedOSOverride.Packages}, nil
+}
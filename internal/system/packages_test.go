@@ -2,6 +2,8 @@ package system
 
 import (
 	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -95,6 +97,89 @@ VERSION_ID="1.0"`,
 	}
 }
 
+func TestDetectOSFromFile(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		expectedOS      string
+		expectedVersion string
+	}{
+		{
+			name:            "Rocky 9 with CRLF",
+			content:         "NAME=\"Rocky Linux\"\r\nID=\"rocky\"\r\nVERSION_ID=\"9.3\"\r\n",
+			expectedOS:      "rhel",
+			expectedVersion: "9",
+		},
+		{
+			name:            "Ubuntu with CRLF and unquoted ID",
+			content:         "NAME=\"Ubuntu\"\r\nID=ubuntu\r\nVERSION_ID=\"22.04\"\r\n",
+			expectedOS:      "ubuntu",
+			expectedVersion: "22.04",
+		},
+		{
+			name:            "Trailing comments and whitespace",
+			content:         "ID=debian   # vendor image\nVERSION_ID=\"12\" # bookworm\n",
+			expectedOS:      "debian",
+			expectedVersion: "12",
+		},
+		{
+			name:            "BOM and single quotes",
+			content:         "\ufeffID='opensuse-leap'\nVERSION_ID='15.5'\n",
+			expectedOS:      "opensuse-leap",
+			expectedVersion: "15.5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "os-release")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			osID, version, err := detectOSFromFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedOS, osID)
+			assert.Equal(t, tt.expectedVersion, version)
+		})
+	}
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, _, err := detectOSFromFile(filepath.Join(t.TempDir(), "missing"))
+		assert.Error(t, err)
+	})
+}
+
+func TestDetectOSFromPaths(t *testing.T) {
+	dir := t.TempDir()
+	etc := filepath.Join(dir, "etc", "os-release")
+	usrLib := filepath.Join(dir, "usr", "lib", "os-release")
+	paths := []string{etc, usrLib}
+	require.NoError(t, os.MkdirAll(filepath.Dir(etc), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Dir(usrLib), 0755))
+
+	t.Run("No os-release", func(t *testing.T) {
+		_, _, err := detectOSFromPaths(paths)
+		assert.Error(t, err)
+	})
+
+	t.Run("Only /usr/lib/os-release", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(usrLib, []byte("ID=debian\nVERSION_ID=\"12\"\n"), 0644))
+
+		osID, version, err := detectOSFromPaths(paths)
+		require.NoError(t, err)
+		assert.Equal(t, "debian", osID)
+		assert.Equal(t, "12", version)
+	})
+
+	t.Run("/etc/os-release takes precedence", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(etc, []byte("ID=ubuntu\nVERSION_ID=\"22.04\"\n"), 0644))
+
+		osID, version, err := detectOSFromPaths(paths)
+		require.NoError(t, err)
+		assert.Equal(t, "ubuntu", osID)
+		assert.Equal(t, "22.04", version)
+	})
+}
+
 func TestOSMapping(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -180,6 +265,31 @@ func TestFindPackagesForOS(t *testing.T) {
 	}
 }
 
+func TestDebianFamilyStockPackages(t *testing.T) {
+	// Vanilla releases without the deadsnakes PPA: the python3 of the release
+	// and its pip and venv packages
+	tests := []struct {
+		osID          string
+		version       string
+		pythonVersion string
+	}{
+		{osID: "ubuntu", version: "22.04", pythonVersion: "3.10"},
+		{osID: "ubuntu", version: "24.04", pythonVersion: "3.12"},
+		{osID: "debian", version: "12", pythonVersion: "3.11"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.osID+" "+tt.version, func(t *testing.T) {
+			definition, found := findPackageDefinition(tt.osID, tt.version)
+			require.True(t, found)
+			assert.ElementsMatch(t, []string{"python3", "python3-pip", "python3-venv", "ssh", "curl", "git"}, definition.Packages)
+			assert.Equal(t, tt.pythonVersion, definition.PythonVersion)
+			assert.Nil(t, definition.PostHook)
+			assert.Equal(t, []string{defaultPythonCmd}, PythonCandidates(tt.osID, tt.version))
+		})
+	}
+}
+
 func TestPythonRequirements(t *testing.T) {
 	// Test that PythonRequirements contains expected packages
 	expectedPackages := []string{
@@ -207,3 +317,121 @@ func TestPythonRequirements(t *testing.T) {
 		assert.True(t, found, "Expected package %s not found in PythonRequirements", expectedPkg)
 	}
 }
+
+func TestPackagesForUnsupportedOS(t *testing.T) {
+	defer func() { unsupportedOSOverride, unsupportedOSPython = nil, "" }()
+
+	_, err := PackagesFor("fedora", "40")
+	require.Error(t, err)
+
+	ubuntu, err := PackagesFor("ubuntu", "24.04")
+	require.NoError(t, err)
+
+	assert.Error(t, AllowUnsupportedOS("python3.12", []string{"git"}))
+	assert.Error(t, AllowUnsupportedOS("/usr/bin/python3.12", nil))
+	require.NoError(t, AllowUnsupportedOS("/usr/bin/python3.12", []string{"python3.12", "git"}))
+
+	definition, err := PackagesFor("fedora", "40")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"python3.12", "git"}, definition.Packages)
+	assert.Nil(t, definition.PostHook)
+	assert.Equal(t, []string{"/usr/bin/python3.12"}, PythonCandidates("fedora", "40"))
+
+	// Listed distributions keep their own definition and interpreters
+	definition, err = PackagesFor("ubuntu", "24.04")
+	require.NoError(t, err)
+	assert.Equal(t, ubuntu.Packages, definition.Packages)
+	assert.Equal(t, []string{"/usr/bin/python3"}, PythonCandidates("ubuntu", "24.04"))
+}
+
+func TestRHELRepositoryCommands(t *testing.T) {
+	original := rpmInstalled
+	defer func() { rpmInstalled = original }()
+
+	tests := []struct {
+		name         string
+		distribution string
+		version      string
+		installed    []string
+		expected     [][]string
+		expectErr    bool
+	}{
+		{
+			name:         "Rocky 8 without EPEL",
+			distribution: "rocky",
+			version:      "8",
+			expected: [][]string{
+				{"dnf", "install", "-y", "dnf-plugins-core", "epel-release"},
+				{"dnf", "config-manager", "--set-enabled", "powertools"},
+			},
+		},
+		{
+			name:         "AlmaLinux 9 without EPEL",
+			distribution: "almalinux",
+			version:      "9",
+			installed:    []string{"dnf-plugins-core"},
+			expected: [][]string{
+				{"dnf", "install", "-y", "epel-release"},
+				{"dnf", "config-manager", "--set-enabled", "crb"},
+			},
+		},
+		{
+			name:         "Rocky 9 with EPEL",
+			distribution: "rocky",
+			version:      "9",
+			installed:    []string{"dnf-plugins-core", "epel-release"},
+			expected: [][]string{
+				{"dnf", "config-manager", "--set-enabled", "crb"},
+			},
+		},
+		{
+			name:         "RHEL 9 without EPEL",
+			distribution: "rhel",
+			version:      "9",
+			expected: [][]string{
+				{"dnf", "install", "-y", "https://dl.fedoraproject.org/pub/epel/epel-release-latest-9.noarch.rpm"},
+				{"subscription-manager", "repos", "--enable", "codeready-builder-for-rhel-9-x86_64-rpms"},
+			},
+		},
+		{
+			name:         "RHEL 8 with EPEL",
+			distribution: "rhel",
+			version:      "8",
+			installed:    []string{"epel-release"},
+			expected: [][]string{
+				{"subscription-manager", "repos", "--enable", "codeready-builder-for-rhel-8-x86_64-rpms"},
+			},
+		},
+		{
+			name:         "Unknown version",
+			distribution: "rhel",
+			version:      "7",
+			expectErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rpmInstalled = func(name string) bool { return slices.Contains(tt.installed, name) }
+
+			cmds, err := rhelRepositoryCommands(tt.distribution, tt.version, "x86_64")
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, cmds)
+		})
+	}
+}
+
+func TestEnableEPELRepositoriesNotFatal(t *testing.T) {
+	originalID := osReleaseID
+	defer func() { osReleaseID = originalID }()
+	osReleaseID = func() string { return "rhel" }
+
+	// An unknown version, or commands failing on a host without dnf, only warn
+	assert.NoError(t, EnableEPELRepositories("7")())
+	t.Setenv("PATH", t.TempDir())
+	assert.NoError(t, EnableEPELRepositories("9")())
+}
@@ -0,0 +1,257 @@
+package system
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// pythonBinDir holds the versioned interpreters, tests replace it.
+var pythonBinDir = "/usr/bin"
+
+// pythonPreference lists the interpreters tried before the OS defaults, set
+// from --python-version.
+var pythonPreference []string
+
+var pythonVersionPattern = regexp.MustCompile(`^3\.\d+$`)
+
+// ValidatePythonVersion checks version is a Python 3 major.minor version, e.g. 3.11.
+func ValidatePythonVersion(version string) error {
+	if !pythonVersionPattern.MatchString(version) {
+		return fmt.Errorf("invalid Python version %q, expected e.g. 3.11", version)
+	}
+	return nil
+}
+
+// SetPythonPreference makes the given Python versions, e.g. 3.11, preferred over
+// the interpreters of the OS, in order.
+func SetPythonPreference(versions []string) error {
+	preference := make([]string, 0, len(versions))
+	for _, version := range versions {
+		if err := ValidatePythonVersion(version); err != nil {
+			return err
+		}
+		preference = append(preference, filepath.Join(pythonBinDir, "python"+version))
+	}
+	pythonPreference = preference
+	return nil
+}
+
+// PythonCandidates returns the interpreters tried for an OS, in order of
+// preference: those of SetPythonPreference, then the ones known to ship with
+// it, or the interpreter of AllowUnsupportedOS for an OS without definition.
+func PythonCandidates(osID, version string) []string {
+	defaults := osPythonCandidates(osID, version)
+	if _, supported := findPackageDefinition(osID, version); !supported && unsupportedOSPython != "" {
+		defaults = []string{unsupportedOSPython}
+	}
+
+	candidates := make([]string, 0, len(pythonPreference)+len(defaults))
+	seen := map[string]bool{}
+	for _, candidate := range append(append([]string{}, pythonPreference...), defaults...) {
+		if !seen[candidate] {
+			seen[candidate] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// osPythonCandidates returns the interpreters known to ship with an OS.
+func osPythonCandidates(osID, version string) []string {
+	switch osID {
+	case "rhel":
+		switch version {
+		case "7":
+			return []string{"/opt/rh/rh-python38/root/usr/bin/python3"}
+		case "8":
+			return []string{filepath.Join(pythonBinDir, "python3.9")}
+		case "9":
+			return []string{
+				filepath.Join(pythonBinDir, "python3.12"),
+				filepath.Join(pythonBinDir, "python3.11"),
+				filepath.Join(pythonBinDir, "python3.10"),
+				filepath.Join(pythonBinDir, "python3.9"),
+				filepath.Join(pythonBinDir, "python3"),
+			}
+		}
+	case "opensuse-leap":
+		return []string{filepath.Join(pythonBinDir, "python3.11")}
+	}
+	return []string{defaultPythonCmd}
+}
+
+// PythonCommandFor returns the first existing interpreter of PythonCandidates,
+// or the first candidate when none exists so the error names the expected one.
+func PythonCommandFor(osID, version string) string {
+	candidates := PythonCandidates(osID, version)
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	slog.Warn("No Python interpreter found", "candidates", candidates, "os", osID, "version", version)
+	return candidates[0]
+}
+
+// minPythonVersion is the oldest Python the Ansible releases installed by
+// BlueBanquise run on.
+var minPythonVersion = [2]int{3, 9}
+
+// MinPythonVersionFor returns the oldest Python accepted on an OS. RHEL 7 only
+// ships rh-python38, installations there rely on Ansible releases still
+// supporting Python 3.8.
+func MinPythonVersionFor(osID, version string) [2]int {
+	if osID == "rhel" && version == "7" {
+		return [2]int{3, 8}
+	}
+	return minPythonVersion
+}
+
+// pythonVersionOutput runs python --version, tests replace it.
+var pythonVersionOutput = func(pythonCmd string) (string, error) {
+	output, err := exec.Command(pythonCmd, "--version").CombinedOutput()
+	return string(output), err
+}
+
+var pythonVersionOutputPattern = regexp.MustCompile(`Python (\d+)\.(\d+)`)
+
+// parsePythonVersion returns the major and minor version of python --version
+// output such as "Python 3.12.1".
+func parsePythonVersion(output string) ([2]int, error) {
+	match := pythonVersionOutputPattern.FindStringSubmatch(output)
+	if match == nil {
+		return [2]int{}, fmt.Errorf("unexpected python --version output %q", strings.TrimSpace(output))
+	}
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return [2]int{major, minor}, nil
+}
+
+// checkPythonVersion fails when pythonCmd is older than min.
+func checkPythonVersion(pythonCmd string, min [2]int) error {
+	output, err := pythonVersionOutput(pythonCmd)
+	if err != nil {
+		slog.Error("Failed to get Python version", "error", err, "python_cmd", pythonCmd, "output", output)
+		return fmt.Errorf("failed to get the version of %s: %v", pythonCmd, err)
+	}
+	found, err := parsePythonVersion(output)
+	if err != nil {
+		slog.Error("Failed to parse Python version", "error", err, "python_cmd", pythonCmd)
+		return err
+	}
+	if found[0] < min[0] || (found[0] == min[0] && found[1] < min[1]) {
+		slog.Error("Python version too old", "python_cmd", pythonCmd, "version", found, "minimum", min)
+		return fmt.Errorf("%s is Python %d.%d, BlueBanquise requires Python %d.%d or newer (install a newer Python or select it with --python-version)",
+			pythonCmd, found[0], found[1], min[0], min[1])
+	}
+	slog.Info("Python version supported", "python_cmd", pythonCmd, "version", found, "minimum", min)
+	return nil
+}
+
+// CheckPythonVersion fails when pythonCmd is older than the minimum Python of
+// the OS.
+func CheckPythonVersion(pythonCmd, osID, version string) error {
+	return checkPythonVersion(pythonCmd, MinPythonVersionFor(osID, version))
+}
+
+// minManylinuxGlibc is the glibc version of manylinux2014, the oldest wheel
+// platform downloaded for a target.
+const minManylinuxGlibc = 17
+
+// PythonTarget is an OS Python requirements are downloaded for, which may
+// differ from the host running pip download.
+type PythonTarget struct {
+	OSID    string
+	Version string
+	// PythonVersion is passed to pip download --python-version, e.g. 3.12.
+	PythonVersion string
+	// Platforms are passed to pip download --platform, newest first.
+	Platforms []string
+}
+
+// Dir names the requirements subdirectory of the target, e.g. rhel-9, or is
+// empty for a target given only by its Python version and platforms.
+func (t PythonTarget) Dir() string {
+	if t.OSID == "" {
+		return ""
+	}
+	return t.OSID + "-" + t.Version
+}
+
+// String describes the target in messages, e.g. rhel 9 (Python 3.12).
+func (t PythonTarget) String() string {
+	if t.OSID == "" {
+		return fmt.Sprintf("Python %s on %s", t.PythonVersion, strings.Join(t.Platforms, ", "))
+	}
+	return fmt.Sprintf("%s %s (Python %s)", t.OSID, t.Version, t.PythonVersion)
+}
+
+// platformPattern matches pip wheel platform tags such as manylinux_2_28_x86_64.
+var platformPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// ValidatePlatform checks platform looks like a pip wheel platform tag.
+func ValidatePlatform(platform string) error {
+	if !platformPattern.MatchString(platform) {
+		return fmt.Errorf("invalid platform %q, expected a wheel platform tag such as manylinux_2_28_x86_64", platform)
+	}
+	return nil
+}
+
+// ParsePythonTarget parses an os:version target such as rhel:9, rocky:9.3 or
+// ubuntu:22.04 into the Python and wheel platforms of that OS on arch, the
+// machine name as printed by uname -m.
+func ParsePythonTarget(spec, arch string) (PythonTarget, error) {
+	name, version, found := strings.Cut(spec, ":")
+	if !found || name == "" || version == "" {
+		return PythonTarget{}, fmt.Errorf("invalid target OS %q, expected os:version such as rhel:9", spec)
+	}
+
+	osID, version := mapOSRelease(name, version)
+	definition, found := findPackageDefinition(osID, version)
+	if !found || definition.PythonVersion == "" || definition.GlibcVersion == "" {
+		return PythonTarget{}, fmt.Errorf("unsupported target OS %s %s", osID, version)
+	}
+
+	_, minor, _ := strings.Cut(definition.GlibcVersion, ".")
+	glibcMinor, err := strconv.Atoi(minor)
+	if err != nil {
+		return PythonTarget{}, fmt.Errorf("invalid glibc version %q for %s %s", definition.GlibcVersion, osID, version)
+	}
+
+	// pip only matches the exact platforms given, so list every manylinux
+	// glibc version the target can run
+	var platforms []string
+	for v := glibcMinor; v >= minManylinuxGlibc; v-- {
+		platforms = append(platforms, fmt.Sprintf("manylinux_2_%d_%s", v, arch))
+	}
+	platforms = append(platforms, "manylinux2014_"+arch)
+
+	return PythonTarget{
+		OSID:          osID,
+		Version:       version,
+		PythonVersion: definition.PythonVersion,
+		Platforms:     platforms,
+	}, nil
+}
+
+// HostArch returns the uname -m machine name of the running binary, e.g. x86_64.
+func HostArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "ppc64le":
+		return "ppc64le"
+	case "s390x":
+		return "s390x"
+	}
+	return runtime.GOARCH
+}
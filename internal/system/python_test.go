@@ -0,0 +1,146 @@
+package system
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPythonCandidates(t *testing.T) {
+	tests := []struct {
+		osID     string
+		version  string
+		expected string
+	}{
+		{"rhel", "7", "/opt/rh/rh-python38/root/usr/bin/python3"},
+		{"rhel", "8", "/usr/bin/python3.9"},
+		{"rhel", "9", "/usr/bin/python3.12"},
+		{"rhel", "10", "/usr/bin/python3"},
+		{"opensuse-leap", "15", "/usr/bin/python3.11"},
+		{"ubuntu", "24.04", "/usr/bin/python3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.osID+" "+tt.version, func(t *testing.T) {
+			assert.Equal(t, tt.expected, PythonCandidates(tt.osID, tt.version)[0])
+		})
+	}
+}
+
+func TestPythonCommandForFirstExisting(t *testing.T) {
+	originalDir, originalPreference := pythonBinDir, pythonPreference
+	defer func() { pythonBinDir, pythonPreference = originalDir, originalPreference }()
+
+	pythonBinDir = t.TempDir()
+	pythonPreference = nil
+
+	// Without any interpreter the first candidate is returned
+	assert.Equal(t, filepath.Join(pythonBinDir, "python3.12"), PythonCommandFor("rhel", "9"))
+
+	// RHEL 9 host with 3.11 and 3.9 but no 3.12
+	for _, name := range []string{"python3.11", "python3.9", "python3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(pythonBinDir, name), nil, 0755))
+	}
+	assert.Equal(t, filepath.Join(pythonBinDir, "python3.11"), PythonCommandFor("rhel", "9"))
+
+	// The preference list comes first, falling back to the OS list
+	require.NoError(t, SetPythonPreference([]string{"3.13", "3.9"}))
+	assert.Equal(t, filepath.Join(pythonBinDir, "python3.9"), PythonCommandFor("rhel", "9"))
+	assert.Equal(t, []string{
+		filepath.Join(pythonBinDir, "python3.13"),
+		filepath.Join(pythonBinDir, "python3.9"),
+		filepath.Join(pythonBinDir, "python3.12"),
+		filepath.Join(pythonBinDir, "python3.11"),
+		filepath.Join(pythonBinDir, "python3.10"),
+		filepath.Join(pythonBinDir, "python3"),
+	}, PythonCandidates("rhel", "9"))
+
+	require.NoError(t, SetPythonPreference([]string{"3.13"}))
+	assert.Equal(t, filepath.Join(pythonBinDir, "python3.11"), PythonCommandFor("rhel", "9"))
+}
+
+func TestSetPythonPreferenceInvalid(t *testing.T) {
+	original := pythonPreference
+	defer func() { pythonPreference = original }()
+
+	for _, version := range []string{"3", "python3.11", "2.7", "3.11.4", ""} {
+		assert.Error(t, SetPythonPreference([]string{version}), version)
+	}
+	assert.Equal(t, original, pythonPreference)
+}
+
+func TestParsePythonTarget(t *testing.T) {
+	target, err := ParsePythonTarget("rocky:9.3", "x86_64")
+	require.NoError(t, err)
+	assert.Equal(t, "rhel", target.OSID)
+	assert.Equal(t, "9", target.Version)
+	assert.Equal(t, "rhel-9", target.Dir())
+	assert.Equal(t, "3.12", target.PythonVersion)
+	assert.Equal(t, "manylinux_2_34_x86_64", target.Platforms[0])
+	assert.Contains(t, target.Platforms, "manylinux_2_28_x86_64")
+	assert.Equal(t, "manylinux_2_17_x86_64", target.Platforms[len(target.Platforms)-2])
+	assert.Equal(t, "manylinux2014_x86_64", target.Platforms[len(target.Platforms)-1])
+
+	target, err = ParsePythonTarget("rhel:7", "aarch64")
+	require.NoError(t, err)
+	assert.Equal(t, "3.8", target.PythonVersion)
+	assert.Equal(t, []string{"manylinux_2_17_aarch64", "manylinux2014_aarch64"}, target.Platforms)
+
+	// Every package definition can be targeted
+	for _, pkg := range DependenciePackages {
+		_, err := ParsePythonTarget(pkg.OSID+":"+pkg.Version, "x86_64")
+		assert.NoError(t, err, pkg.OSID+" "+pkg.Version)
+	}
+
+	for _, invalid := range []string{"rhel", "rhel:", ":9", "fedora:40", "ubuntu:18.04"} {
+		_, err := ParsePythonTarget(invalid, "x86_64")
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCheckPythonVersion(t *testing.T) {
+	original := pythonVersionOutput
+	defer func() { pythonVersionOutput = original }()
+
+	tests := []struct {
+		name    string
+		output  string
+		min     [2]int
+		want    [2]int
+		wantErr string
+	}{
+		{name: "Too old", output: "Python 3.8.10\n", min: [2]int{3, 9}, want: [2]int{3, 8}, wantErr: "is Python 3.8, BlueBanquise requires Python 3.9 or newer"},
+		{name: "Recent", output: "Python 3.12.1\n", min: [2]int{3, 9}, want: [2]int{3, 12}},
+		{name: "Minimum", output: "Python 3.9.18\n", min: [2]int{3, 9}, want: [2]int{3, 9}},
+		{name: "RHEL 7 minimum", output: "Python 3.8.13\n", min: MinPythonVersionFor("rhel", "7"), want: [2]int{3, 8}},
+		{name: "Python 2", output: "Python 2.7.18\n", min: [2]int{3, 9}, want: [2]int{2, 7}, wantErr: "is Python 2.7"},
+		{name: "Unexpected output", output: "command not found\n", min: [2]int{3, 9}, wantErr: "unexpected python --version output"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.want != [2]int{} {
+				version, err := parsePythonVersion(tt.output)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, version)
+			}
+
+			pythonVersionOutput = func(pythonCmd string) (string, error) {
+				assert.Equal(t, "/usr/bin/python3", pythonCmd)
+				return tt.output, nil
+			}
+			err := checkPythonVersion("/usr/bin/python3", tt.min)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	assert.Equal(t, [2]int{3, 9}, MinPythonVersionFor("ubuntu", "24.04"))
+}
@@ -22,25 +22,86 @@ var OSMapping = map[string]string{
 	"sles":          "opensuse-leap",
 }
 
+// osReleasePaths are tried in order, /usr/lib/os-release is the vendor
+// default shipped alone by some minimal and container images.
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
 func DetectOS() (string, string, error) {
-	data, err := os.ReadFile("/etc/os-release")
+	return detectOSFromPaths(osReleasePaths)
+}
+
+// detectOSFromPaths detects the OS from the first os-release file of paths that exists.
+func detectOSFromPaths(paths []string) (string, string, error) {
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		return detectOSFromFile(path)
+	}
+
+	err := fmt.Errorf("no os-release file found in %s", strings.Join(paths, ", "))
+	slog.Error("Error detecting OS", "error", err)
+	return "", "", err
+}
+
+// detectOSFromFile detects the OS ID and version from an os-release file.
+func detectOSFromFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		slog.Error("Error detecting OS", "error", err)
 		return "", "", err
 	}
 
+	name, version := parseOSRelease(string(data))
+	name, version = mapOSRelease(name, version)
+	return name, version, nil
+}
+
+// parseOSRelease extracts ID and VERSION_ID from os-release content, tolerating
+// CRLF line endings, a UTF-8 BOM, surrounding whitespace and trailing comments.
+func parseOSRelease(data string) (string, string) {
+	data = strings.TrimPrefix(data, "\ufeff")
+
 	var name, version string
-	lines := strings.Split(string(data), "\n")
+	lines := strings.Split(data, "\n")
 	for _, line := range lines {
-		if strings.HasPrefix(line, "ID=") {
-			name = strings.TrimPrefix(line, "ID=")
-			name = strings.Trim(name, "\"")
-		} else if strings.HasPrefix(line, "VERSION_ID=") {
-			version = strings.TrimPrefix(line, "VERSION_ID=")
-			version = strings.Trim(version, "\"")
+		line = strings.TrimSpace(line)
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "ID":
+			name = parseOSReleaseValue(value)
+		case "VERSION_ID":
+			version = parseOSReleaseValue(value)
+		}
+	}
+	return name, version
+}
+
+// parseOSReleaseValue unquotes an os-release value and drops any trailing comment.
+func parseOSReleaseValue(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ""
+	}
+
+	if quote := value[0]; quote == '"' || quote == '\'' {
+		if end := strings.IndexByte(value[1:], quote); end >= 0 {
+			return strings.TrimSpace(value[1 : end+1])
 		}
+		return strings.TrimSpace(strings.Trim(value, string(quote)))
 	}
 
+	if i := strings.Index(value, "#"); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// mapOSRelease maps an os-release ID and VERSION_ID to BlueBanquise compatible values.
+func mapOSRelease(name, version string) (string, string) {
 	// Map OS ID to BlueBanquise compatible name
 	if mappedName, exists := OSMapping[name]; exists {
 		name = mappedName
@@ -64,7 +125,7 @@ func DetectOS() (string, string, error) {
 		}
 	}
 
-	return name, version, nil
+	return name, version
 }
 
 // GetPythonCommand determines the correct Python command based on the operating system.
@@ -76,47 +137,16 @@ func GetPythonCommand() (string, error) {
 		return "", err
 	}
 
-	// Determine Python command based on OS
-	var pythonCmd string
-	switch osID {
-	case "rhel":
-		switch version {
-		case "7":
-			pythonCmd = "/opt/rh/rh-python38/root/usr/bin/python3"
-		case "8":
-			pythonCmd = "/usr/bin/python3.9"
-		case "9":
-			// Try multiple Python versions for RHEL9
-			pythonVersions := []string{
-				"/usr/bin/python3.12",
-				"/usr/bin/python3.11",
-				"/usr/bin/python3.10",
-				"/usr/bin/python3.9",
-				"/usr/bin/python3",
-			}
-			for _, version := range pythonVersions {
-				if _, err := os.Stat(version); err == nil {
-					pythonCmd = version
-					break
-				}
-			}
-			if pythonCmd == "" {
-				pythonCmd = defaultPythonCmd
-			}
-		default:
-			pythonCmd = defaultPythonCmd
-		}
-	case "opensuse-leap":
-		pythonCmd = "/usr/bin/python3.11"
-	default:
-		pythonCmd = defaultPythonCmd
-	}
+	pythonCmd := PythonCommandFor(osID, version)
 
 	// Verify the Python command exists
 	if _, err := os.Stat(pythonCmd); os.IsNotExist(err) {
 		slog.Error("Python command not found", "error", err, "python_cmd", pythonCmd)
 		return "", err
 	}
+	if err := CheckPythonVersion(pythonCmd, osID, version); err != nil {
+		return "", err
+	}
 
 	slog.Info("Using Python command", "python_cmd", pythonCmd, "os", osID, "version", version)
 	return pythonCmd, nil
@@ -155,6 +185,123 @@ func BuildPython311FromSource() error {
 	return nil
 }
 
+// sclReleasePackages enable the EPEL and Software Collections repositories on
+// RHEL 7, rh-python38 is only found once they are installed.
+var sclReleasePackages = []string{"epel-release", "centos-release-scl-rh", "centos-release-scl"}
+
+// EnableSCLRepositories installs the release packages of the EPEL and Software
+// Collections repositories on RHEL 7, before the packages they provide.
+func EnableSCLRepositories() error {
+	slog.Info("Enabling EPEL and Software Collections repositories", "packages", sclReleasePackages)
+	fmt.Println("Enabling EPEL and Software Collections repositories...")
+
+	args := append([]string{"install", "-y"}, sclReleasePackages...)
+	output, err := exec.Command("yum", args...).CombinedOutput()
+	if err != nil {
+		slog.Error("Failed to enable repositories", "error", err, "packages", sclReleasePackages, "output", string(output))
+		return fmt.Errorf("failed to enable EPEL and Software Collections repositories: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	slog.Info("EPEL and Software Collections repositories enabled")
+	return nil
+}
+
+// epelReleaseURL is the release package of EPEL for a RHEL major version,
+// installed from its URL on RHEL, which does not ship it.
+const epelReleaseURL = "https://dl.fedoraproject.org/pub/epel/epel-release-latest-%s.noarch.rpm"
+
+// crbRepositories are the CodeReady Builder repositories EPEL packages depend
+// on in the derivatives of RHEL, named powertools on 8.
+var crbRepositories = map[string]string{
+	"8": "powertools",
+	"9": "crb",
+}
+
+// rhelCRBRepository is the CodeReady Builder repository of RHEL itself,
+// enabled with subscription-manager.
+const rhelCRBRepository = "codeready-builder-for-rhel-%s-%s-rpms"
+
+// rpmInstalled reports whether an RPM package is installed, replaced by tests.
+var rpmInstalled = func(name string) bool {
+	return exec.Command("rpm", "-q", name).Run() == nil
+}
+
+// osReleaseID returns the unmapped ID of os-release, e.g. rocky where DetectOS
+// returns rhel, replaced by tests.
+var osReleaseID = func() string {
+	for _, path := range osReleasePaths {
+		if data, err := os.ReadFile(path); err == nil {
+			id, _ := parseOSRelease(string(data))
+			return id
+		}
+	}
+	return ""
+}
+
+// rhelRepositoryCommands returns the commands enabling EPEL and CodeReady
+// Builder on a RHEL major version of distribution, the os-release ID. RHEL
+// enables CodeReady Builder through its subscription and gets EPEL from
+// Fedora, the derivatives ship epel-release and enable their repository with
+// dnf config-manager. Packages already installed are left out, enabling a
+// repository twice changes nothing.
+func rhelRepositoryCommands(distribution, version, arch string) ([][]string, error) {
+	repository, found := crbRepositories[version]
+	if !found {
+		return nil, fmt.Errorf("no CodeReady Builder repository known for RHEL %s", version)
+	}
+
+	install := []string{"dnf", "install", "-y"}
+	if distribution != "rhel" && !rpmInstalled("dnf-plugins-core") {
+		install = append(install, "dnf-plugins-core")
+	}
+	if !rpmInstalled("epel-release") {
+		if distribution == "rhel" {
+			install = append(install, fmt.Sprintf(epelReleaseURL, version))
+		} else {
+			install = append(install, "epel-release")
+		}
+	}
+
+	var cmds [][]string
+	if len(install) > 3 {
+		cmds = append(cmds, install)
+	}
+	if distribution == "rhel" {
+		return append(cmds, []string{"subscription-manager", "repos", "--enable", fmt.Sprintf(rhelCRBRepository, version, arch)}), nil
+	}
+	return append(cmds, []string{"dnf", "config-manager", "--set-enabled", repository}), nil
+}
+
+// EnableEPELRepositories returns the pre-installation hook enabling the EPEL
+// and CodeReady Builder repositories on a RHEL major version. The packages of
+// RHEL 8 and 9 come from AppStream, so a failure, e.g. on a host only reaching
+// a local mirror, is reported as a warning and the installation goes on.
+func EnableEPELRepositories(version string) func() error {
+	return func() error {
+		distribution := osReleaseID()
+		cmds, err := rhelRepositoryCommands(distribution, version, HostArch())
+		if err != nil {
+			slog.Warn("Not enabling EPEL and CodeReady Builder repositories", "error", err, "os", distribution, "version", version)
+			return nil
+		}
+
+		slog.Info("Enabling EPEL and CodeReady Builder repositories", "os", distribution, "version", version)
+		fmt.Println("Enabling EPEL and CodeReady Builder repositories...")
+		for i, args := range cmds {
+			slog.Info("Executing repository command", "step", i+1, "command", args)
+			output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+			if err != nil {
+				slog.Warn("Failed to enable repositories, continuing without them", "error", err, "step", i+1, "command", args, "output", string(output))
+				fmt.Printf("Warning: could not enable EPEL and CodeReady Builder repositories (%s: %v), continuing without them\n", strings.Join(args, " "), err)
+				return nil
+			}
+		}
+
+		slog.Info("EPEL and CodeReady Builder repositories enabled", "os", distribution, "version", version)
+		return nil
+	}
+}
+
 // LinkPython311AsDefault links python3.11 as default in OpenSUSE.
 func LinkPython311AsDefault() error {
 	slog.Info("Linking python3.11 as default in OpenSUSE")
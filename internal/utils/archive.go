@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PrepareCollectionsPath returns a collections directory for collectionsPath. A
+// directory is returned as is; a .tar.gz/.tgz bundle of the collections directory
+// is extracted to a temporary directory, and the archives matched by a glob
+// pattern are linked into one. The returned cleanup removes the temporary directory.
+//
+// Unless followSymlinks is set, symlinks resolving outside the directory, the
+// extracted bundle or the base directory of the pattern are rejected, so only
+// archives of the given tree are installed. Bundle members with an absolute
+// path or a .. component, and links pointing outside the bundle, are always
+// rejected.
+func PrepareCollectionsPath(collectionsPath string, followSymlinks bool) (string, func(), error) {
+	if IsGlobPattern(collectionsPath) {
+		return linkCollectionsGlob(collectionsPath, followSymlinks)
+	}
+
+	return prepareTree(collectionsPath, "collections", followSymlinks)
+}
+
+// PrepareOfflineBundle returns the directory of an offline bundle, the tree
+// written by the download command with its collections, requirements and
+// core-vars subdirectories. A .tar.gz/.tgz bundle is extracted like a
+// collections bundle, with the same symlink and member checks.
+func PrepareOfflineBundle(bundlePath string, followSymlinks bool) (string, func(), error) {
+	return prepareTree(bundlePath, "offline", followSymlinks)
+}
+
+// prepareTree returns path when it is a directory, or extracts it to a
+// temporary directory when it is a .tar.gz/.tgz bundle. kind names the bundle
+// in messages.
+func prepareTree(path, kind string, followSymlinks bool) (string, func(), error) {
+	noop := func() {}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		err = fmt.Errorf("%s path does not exist: %s", kind, path)
+		if kind == "offline" {
+			return "", noop, NewValidationError(CodeOfflineBundleMissing, remediationDownloadBundle, err)
+		}
+		return "", noop, NewValidationError(CodeCollectionsPathMissing, remediationDownloadCollections, err)
+	}
+	if info.IsDir() {
+		if !followSymlinks {
+			if err := CheckSymlinksWithin(path); err != nil {
+				return "", noop, err
+			}
+		}
+		return path, noop, nil
+	}
+	if !IsCollectionArchive(path) {
+		return "", noop, fmt.Errorf("%s path must be a directory or a .tar.gz/.tgz bundle: %s", kind, path)
+	}
+
+	tempDir, err := makeCollectionsTempDir()
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			LogWarning("Could not remove temporary directory", "error", err, "path", tempDir)
+		}
+	}
+
+	if err := CheckArchiveMembers(path); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+
+	LogInfo("Extracting bundle", "kind", kind, "archive", path, "dest", tempDir)
+	fmt.Printf("Extracting %s bundle %s...\n", kind, path)
+	if output, err := commandOutput("tar", "-xzf", path, "-C", tempDir); err != nil {
+		cleanup()
+		LogError("Failed to extract bundle", err, "kind", kind, "archive", path, "output", output)
+		return "", noop, fmt.Errorf("failed to extract %s bundle %s: %v", kind, path, err)
+	}
+	if !followSymlinks {
+		if err := CheckSymlinksWithin(tempDir); err != nil {
+			cleanup()
+			return "", noop, fmt.Errorf("%s bundle %s: %v", kind, path, err)
+		}
+	}
+
+	return singleSubdirectory(tempDir), cleanup, nil
+}
+
+// CheckArchiveIntegrity reads the gzip stream and every tar entry of archive,
+// so a truncated or corrupt download fails with "corrupt archive" before it
+// reaches a tool with a less helpful error.
+func CheckArchiveIntegrity(archive string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %v", archive, err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("corrupt archive: %s: %v", archive, err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	entries := 0
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			_, err = io.Copy(io.Discard, reader)
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt archive: %s: %v", archive, err)
+		}
+		entries++
+	}
+	if entries == 0 {
+		return fmt.Errorf("corrupt archive: %s: no entries", archive)
+	}
+	return nil
+}
+
+// unsafeArchiveMember reports whether an archive member name is absolute or
+// has a .. component.
+func unsafeArchiveMember(name string) bool {
+	if strings.HasPrefix(name, "/") {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckArchiveMembers rejects an archive with a member that would be
+// extracted outside the destination: an absolute path or a path with a ..
+// component, or a symlink or hard link pointing outside the archive tree.
+func CheckArchiveMembers(archive string) error {
+	file, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive %s: %v", archive, err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("corrupt archive: %s: %v", archive, err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("corrupt archive: %s: %v", archive, err)
+		}
+		if unsafeArchiveMember(header.Name) || unsafeArchiveLink(header) {
+			LogError("Unsafe member in archive", nil, "archive", archive, "member", header.Name, "link", header.Linkname)
+			return fmt.Errorf("archive %s has an unsafe member %q escaping the extraction directory", archive, header.Name)
+		}
+	}
+}
+
+// unsafeArchiveLink reports whether a symlink or hard link member points to
+// an absolute path or outside the archive tree. A symlink target is relative
+// to the directory of the member, a hard link target to the archive root.
+func unsafeArchiveLink(header *tar.Header) bool {
+	var target string
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if strings.HasPrefix(header.Linkname, "/") {
+			return true
+		}
+		target = path.Join(path.Dir(header.Name), header.Linkname)
+	case tar.TypeLink:
+		target = path.Clean(header.Linkname)
+	default:
+		return false
+	}
+	return strings.HasPrefix(target, "/") || target == ".." || strings.HasPrefix(target, "../")
+}
+
+// CheckSymlinksWithin fails when a symlink under root resolves outside root,
+// or cannot be resolved. Symlinks staying inside the tree are accepted.
+func CheckSymlinksWithin(root string) error {
+	resolvedRoot, err := resolvePath(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", root, err)
+	}
+
+	return filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := resolvePath(path)
+		if err != nil {
+			LogError("Broken symlink in collections path", err, "path", path)
+			return fmt.Errorf("broken symlink %s: %v", path, err)
+		}
+		if !isWithin(resolvedRoot, target) {
+			LogError("Symlink points outside collections path", nil, "path", path, "target", target, "root", root)
+			return fmt.Errorf("symlink %s points to %s outside %s (use --follow-symlinks to allow it)", path, target, root)
+		}
+		return nil
+	})
+}
+
+// resolvePath returns the absolute path of path with every symlink resolved.
+func resolvePath(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// isWithin reports whether path is root or inside it, both being clean absolute paths.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// globBase returns the directory of pattern before its first element holding
+// glob metacharacters.
+func globBase(pattern string) string {
+	base := filepath.Dir(pattern)
+	for IsGlobPattern(base) {
+		base = filepath.Dir(base)
+	}
+	return base
+}
+
+// singleSubdirectory returns the only entry of dir when it is a directory, so a
+// bundle made of one top-level folder is used from inside that folder.
+func singleSubdirectory(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// linkCollectionsGlob links the collection archives matched by pattern into a
+// temporary directory removed by the returned cleanup. Unless followSymlinks is
+// set, every archive must resolve inside the base directory of pattern.
+func linkCollectionsGlob(pattern string, followSymlinks bool) (string, func(), error) {
+	noop := func() {}
+
+	archives, err := CollectionsGlob(pattern)
+	if err != nil {
+		return "", noop, err
+	}
+	if !followSymlinks {
+		if err := checkGlobMatchesWithin(pattern, archives); err != nil {
+			return "", noop, err
+		}
+	}
+
+	tempDir, err := makeCollectionsTempDir()
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			LogWarning("Could not remove temporary directory", "error", err, "path", tempDir)
+		}
+	}
+
+	LogInfo("Linking collections matched by pattern", "pattern", pattern, "archives", archives, "dest", tempDir)
+	for _, archive := range archives {
+		link := filepath.Join(tempDir, filepath.Base(archive))
+		if _, err := os.Lstat(link); err == nil {
+			cleanup()
+			return "", noop, fmt.Errorf("several archives named %s match %s", filepath.Base(archive), pattern)
+		}
+		target, err := filepath.Abs(archive)
+		if err == nil {
+			err = os.Symlink(target, link)
+		}
+		if err != nil {
+			cleanup()
+			LogError("Failed to link collection archive", err, "archive", archive)
+			return "", noop, fmt.Errorf("failed to link collection archive %s: %v", archive, err)
+		}
+	}
+
+	return tempDir, cleanup, nil
+}
+
+// checkGlobMatchesWithin fails when an archive matched by pattern resolves
+// outside the base directory of pattern.
+func checkGlobMatchesWithin(pattern string, archives []string) error {
+	base, err := resolvePath(globBase(pattern))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %v", globBase(pattern), err)
+	}
+	for _, archive := range archives {
+		target, err := resolvePath(archive)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %v", archive, err)
+		}
+		if !isWithin(base, target) {
+			LogError("Collection archive outside pattern directory", nil, "archive", archive, "target", target, "base", base)
+			return fmt.Errorf("%s points to %s outside %s (use --follow-symlinks to allow it)", archive, target, base)
+		}
+	}
+	return nil
+}
+
+// makeCollectionsTempDir creates a temporary directory for collection archives,
+// readable by the target user ansible-galaxy runs as.
+func makeCollectionsTempDir() (string, error) {
+	tempDir, err := os.MkdirTemp(TempDir(), "bluebanquise-collections-")
+	if err != nil {
+		LogError("Failed to create temporary directory", err)
+		return "", fmt.Errorf("failed to create temporary directory: %v", err)
+	}
+	if err := os.Chmod(tempDir, 0755); err != nil {
+		os.RemoveAll(tempDir)
+		LogError("Failed to set temporary directory permissions", err, "path", tempDir)
+		return "", fmt.Errorf("failed to set temporary directory permissions: %v", err)
+	}
+	return tempDir, nil
+}
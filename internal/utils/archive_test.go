@@ -0,0 +1,308 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTarGz writes files, keyed by path inside the archive, into a .tar.gz at path.
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestPrepareCollectionsPath(t *testing.T) {
+	InitTestLogger()
+
+	t.Run("Directory is used as is", func(t *testing.T) {
+		dir := t.TempDir()
+		path, cleanup, err := PrepareCollectionsPath(dir, false)
+		require.NoError(t, err)
+		defer cleanup()
+		assert.Equal(t, dir, path)
+	})
+
+	t.Run("Bundle with a top-level directory is extracted", func(t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "offline-collections.tar.gz")
+		writeTarGz(t, bundle, map[string]string{
+			"collections/bluebanquise-infrastructure-3.0.0.tar.gz": "x",
+			"collections/community-general-9.0.0.tar.gz":           "x",
+		})
+
+		path, cleanup, err := PrepareCollectionsPath(bundle, false)
+		require.NoError(t, err)
+		assert.Equal(t, "collections", filepath.Base(path))
+
+		collections, err := CheckCollectionsPrerequisites(path)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-9.0.0.tar.gz"}, collections)
+
+		cleanup()
+		assert.NoDirExists(t, filepath.Dir(path))
+	})
+
+	t.Run("Flat bundle is extracted", func(t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "offline-collections.tgz")
+		writeTarGz(t, bundle, map[string]string{
+			"bluebanquise-infrastructure-3.0.0.tar.gz": "x",
+			"community-general-9.0.0.tar.gz":           "x",
+		})
+
+		path, cleanup, err := PrepareCollectionsPath(bundle, false)
+		require.NoError(t, err)
+		defer cleanup()
+		assert.FileExists(t, filepath.Join(path, "community-general-9.0.0.tar.gz"))
+	})
+
+	t.Run("Corrupted bundle", func(t *testing.T) {
+		bundle := filepath.Join(t.TempDir(), "broken.tar.gz")
+		require.NoError(t, os.WriteFile(bundle, []byte("not an archive"), 0644))
+
+		_, cleanup, err := PrepareCollectionsPath(bundle, false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+
+	t.Run("Other file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "collections.txt")
+		require.NoError(t, os.WriteFile(file, []byte("x"), 0644))
+
+		_, cleanup, err := PrepareCollectionsPath(file, false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing path", func(t *testing.T) {
+		_, cleanup, err := PrepareCollectionsPath(filepath.Join(t.TempDir(), "missing"), false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+}
+
+func TestPrepareCollectionsPathGlob(t *testing.T) {
+	InitTestLogger()
+
+	bundles := t.TempDir()
+	for _, name := range []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-9.0.0.tar.gz", "README.md", "checksums.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(bundles, name), []byte("x"), 0644))
+	}
+
+	t.Run("Matches are linked into a directory", func(t *testing.T) {
+		dir, cleanup, err := PrepareCollectionsPath(filepath.Join(bundles, "*"), false)
+		require.NoError(t, err)
+
+		collections, err := CheckCollectionsPrerequisites(dir)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-9.0.0.tar.gz"}, collections)
+
+		cleanup()
+		assert.NoDirExists(t, dir)
+		assert.FileExists(t, filepath.Join(bundles, "community-general-9.0.0.tar.gz"), "cleanup must not remove the matched archives")
+	})
+
+	t.Run("No match", func(t *testing.T) {
+		_, cleanup, err := PrepareCollectionsPath(filepath.Join(bundles, "*.tgz"), false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+
+	t.Run("Duplicate archive names", func(t *testing.T) {
+		root := t.TempDir()
+		for _, dir := range []string{"site-a", "site-b"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(root, dir, "community-general-9.0.0.tar.gz"), []byte("x"), 0644))
+		}
+
+		_, cleanup, err := PrepareCollectionsPath(filepath.Join(root, "*", "*.tar.gz"), false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+}
+
+func TestPrepareCollectionsPathSymlinks(t *testing.T) {
+	InitTestLogger()
+
+	outside := t.TempDir()
+	evil := filepath.Join(outside, "evil-collection-1.0.0.tar.gz")
+	require.NoError(t, os.WriteFile(evil, []byte("x"), 0644))
+
+	t.Run("Symlink outside the tree is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "community-general-9.0.0.tar.gz"), []byte("x"), 0644))
+		require.NoError(t, os.Symlink(evil, filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz")))
+
+		_, cleanup, err := PrepareCollectionsPath(dir, false)
+		defer cleanup()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside")
+
+		// Explicitly allowed
+		path, cleanup, err := PrepareCollectionsPath(dir, true)
+		defer cleanup()
+		require.NoError(t, err)
+		assert.Equal(t, dir, path)
+	})
+
+	t.Run("Symlinked directory outside the tree is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.Symlink(outside, filepath.Join(dir, "site")))
+
+		_, cleanup, err := PrepareCollectionsPath(dir, false)
+		defer cleanup()
+		assert.Error(t, err)
+	})
+
+	t.Run("Symlink inside the tree is accepted", func(t *testing.T) {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, "community-general-9.0.0.tar.gz")
+		require.NoError(t, os.WriteFile(archive, []byte("x"), 0644))
+		require.NoError(t, os.Symlink(archive, filepath.Join(dir, "community-general-latest.tar.gz")))
+
+		_, cleanup, err := PrepareCollectionsPath(dir, false)
+		defer cleanup()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Glob match symlinked outside the pattern directory is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.Symlink(evil, filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz")))
+
+		_, cleanup, err := PrepareCollectionsPath(filepath.Join(dir, "*.tar.gz"), false)
+		defer cleanup()
+		assert.Error(t, err)
+
+		_, cleanup, err = PrepareCollectionsPath(filepath.Join(dir, "*.tar.gz"), true)
+		defer cleanup()
+		assert.NoError(t, err)
+	})
+
+	t.Run("Bundle member traversing out of the extraction directory is rejected", func(t *testing.T) {
+		for _, member := range []string{"../evil-collection-1.0.0.tar.gz", "collections/../../evil-collection-1.0.0.tar.gz", "/tmp/evil-collection-1.0.0.tar.gz"} {
+			bundle := filepath.Join(t.TempDir(), "offline-collections.tar.gz")
+			writeTarGz(t, bundle, map[string]string{
+				"collections/community-general-9.0.0.tar.gz": "x",
+				member: "x",
+			})
+
+			_, cleanup, err := PrepareCollectionsPath(bundle, true)
+			cleanup()
+			require.Error(t, err, member)
+			assert.Contains(t, err.Error(), "unsafe member", member)
+		}
+	})
+
+	t.Run("Bundle symlink member pointing outside is rejected", func(t *testing.T) {
+		// Rejected before extraction, even with followSymlinks
+		for _, target := range []string{evil, "../../evil-collection-1.0.0.tar.gz"} {
+			bundle := filepath.Join(t.TempDir(), "offline-collections.tar.gz")
+			f, err := os.Create(bundle)
+			require.NoError(t, err)
+			gz := gzip.NewWriter(f)
+			tw := tar.NewWriter(gz)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: "collections/bluebanquise-infrastructure-3.0.0.tar.gz", Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0777}))
+			require.NoError(t, tw.Close())
+			require.NoError(t, gz.Close())
+			require.NoError(t, f.Close())
+
+			_, cleanup, err := PrepareCollectionsPath(bundle, true)
+			cleanup()
+			require.Error(t, err, target)
+			assert.Contains(t, err.Error(), "unsafe member", target)
+
+			_, cleanup, err = PrepareOfflineBundle(bundle, true)
+			cleanup()
+			assert.ErrorContains(t, err, "unsafe member", target)
+		}
+	})
+}
+
+func TestUnsafeArchiveMember(t *testing.T) {
+	for _, safe := range []string{"collections/", "collections/a-b-1.0.0.tar.gz", "./a..b.tar.gz", "a/..b/c"} {
+		assert.False(t, unsafeArchiveMember(safe), safe)
+	}
+	for _, unsafe := range []string{"/etc/passwd", "..", "../a.tar.gz", "a/../../b", "a/.."} {
+		assert.True(t, unsafeArchiveMember(unsafe), unsafe)
+	}
+}
+
+func TestCheckArchiveMembers(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  tar.Header
+		wantErr bool
+	}{
+		{name: "file", header: tar.Header{Name: "inventory/hosts.yml", Typeflag: tar.TypeReg}},
+		{name: "symlink inside", header: tar.Header{Name: "inventory/group_vars/all/link.yml", Typeflag: tar.TypeSymlink, Linkname: "../../hosts.yml"}},
+		{name: "hard link inside", header: tar.Header{Name: "inventory/copy.yml", Typeflag: tar.TypeLink, Linkname: "inventory/hosts.yml"}},
+		{name: "absolute", header: tar.Header{Name: "/etc/cron.d/job", Typeflag: tar.TypeReg}, wantErr: true},
+		{name: "dot dot", header: tar.Header{Name: "inventory/../../evil", Typeflag: tar.TypeReg}, wantErr: true},
+		{name: "absolute symlink", header: tar.Header{Name: "inventory/hosts.yml", Typeflag: tar.TypeSymlink, Linkname: "/etc/shadow"}, wantErr: true},
+		{name: "symlink outside", header: tar.Header{Name: "inventory/hosts.yml", Typeflag: tar.TypeSymlink, Linkname: "../../etc"}, wantErr: true},
+		{name: "hard link outside", header: tar.Header{Name: "inventory/hosts.yml", Typeflag: tar.TypeLink, Linkname: "../etc/shadow"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "inventory.tar.gz")
+			f, err := os.Create(archive)
+			require.NoError(t, err)
+			gz := gzip.NewWriter(f)
+			tw := tar.NewWriter(gz)
+			require.NoError(t, tw.WriteHeader(&tt.header))
+			require.NoError(t, tw.Close())
+			require.NoError(t, gz.Close())
+			require.NoError(t, f.Close())
+
+			err = CheckArchiveMembers(archive)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "unsafe member")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckArchiveIntegrity(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "valid.tar.gz")
+	writeTarGz(t, valid, map[string]string{"MANIFEST.json": strings.Repeat("{}", 4096)})
+	assert.NoError(t, CheckArchiveIntegrity(valid))
+
+	data, err := os.ReadFile(valid)
+	require.NoError(t, err)
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	require.NoError(t, os.WriteFile(truncated, data[:len(data)/2], 0644))
+
+	notGzip := filepath.Join(dir, "not-gzip.tar.gz")
+	require.NoError(t, os.WriteFile(notGzip, []byte("<html>rate limited</html>"), 0644))
+
+	empty := filepath.Join(dir, "empty.tar.gz")
+	writeTarGz(t, empty, nil)
+
+	for _, archive := range []string{truncated, notGzip, empty} {
+		err := CheckArchiveIntegrity(archive)
+		require.Error(t, err, archive)
+		assert.Contains(t, err.Error(), "corrupt archive: "+archive)
+	}
+}
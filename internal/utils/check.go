@@ -1,15 +1,28 @@
 package utils
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"gopkg.in/yaml.v3"
 )
 
+// ErrNoConnectivity is returned by SystemCheck when the internet cannot be reached.
+var ErrNoConnectivity = errors.New("no internet connectivity detected")
+
 // SystemCheck verifies if the system has the necessary prerequisites.
 func SystemCheck() error {
 	LogInfo("Starting system prerequisites check")
@@ -29,11 +42,11 @@ func SystemCheck() error {
 		fmt.Printf("Checking %s... ", c.name)
 		if err := c.check(); err != nil {
 			LogError(fmt.Sprintf("%s check failed", c.name), err)
-			fmt.Printf("FAILED: %v\n", err)
-			return fmt.Errorf("%s check failed: %v", c.name, err)
+			fmt.Printf("%s: %v\n", ColorFail("FAILED"), err)
+			return fmt.Errorf("%s check failed: %w", c.name, err)
 		}
 		LogInfo(fmt.Sprintf("%s check passed", c.name))
-		fmt.Println("OK")
+		fmt.Println(ColorPass("OK"))
 	}
 
 	LogInfo("All system checks passed")
@@ -79,7 +92,7 @@ func checkInternetConnectivity() error {
 	conn, err := net.DialTimeout("tcp", "8.8.8.8:53", 5*time.Second)
 	if err != nil {
 		LogError("No internet connectivity detected", err)
-		return fmt.Errorf("no internet connectivity detected")
+		return ErrNoConnectivity
 	}
 	defer func() {
 		if closeErr := conn.Close(); closeErr != nil {
@@ -90,51 +103,347 @@ func checkInternetConnectivity() error {
 	return nil
 }
 
-// CheckCollectionsPrerequisites validate the collections directory offline.
-func CheckCollectionsPrerequisites(collectionsPath string) error {
+// CheckCollectionsPrerequisites validate the collections directory offline and
+// returns the collections found: tarball paths relative to collectionsPath, or
+// <namespace>/<name> directories for an installed tree.
+func CheckCollectionsPrerequisites(collectionsPath string) ([]string, error) {
 	LogInfo("Checking collections prerequisites", "path", collectionsPath)
+	if IsGlobPattern(collectionsPath) {
+		return CollectionsGlob(collectionsPath)
+	}
 	if _, err := os.Stat(collectionsPath); os.IsNotExist(err) {
 		LogError("Collections path does not exist", err, "path", collectionsPath)
-		return fmt.Errorf("collections path does not exist: %s", collectionsPath)
+		return nil, NewValidationError(CodeCollectionsPathMissing, remediationDownloadCollections,
+			fmt.Errorf("collections path does not exist: %s", collectionsPath))
 	}
 	info, err := os.Stat(collectionsPath)
 	if err != nil {
 		LogError("Cannot stat collections path", err, "path", collectionsPath)
-		return err
+		return nil, err
 	}
 	if !info.IsDir() {
 		LogError("Collections path is not a directory", nil, "path", collectionsPath)
-		return fmt.Errorf("collections path is not a directory: %s", collectionsPath)
+		return nil, fmt.Errorf("collections path is not a directory: %s", collectionsPath)
 	}
 	entries, err := os.ReadDir(collectionsPath)
 	if err != nil {
 		LogError("Cannot read collections directory", err, "path", collectionsPath)
-		return err
+		return nil, err
 	}
 	if len(entries) == 0 {
 		LogError("No collection files found in directory", nil, "path", collectionsPath)
-		return fmt.Errorf("no collection files found in directory: %s", collectionsPath)
+		return nil, NewValidationError(CodeCollectionsNotFound, remediationDownloadCollections,
+			fmt.Errorf("no collection files found in directory: %s", collectionsPath))
 	}
-	LogInfo("Collections directory check passed", "path", collectionsPath)
-	return nil
+	layout, root, err := DetectCollectionsLayout(collectionsPath)
+	if err != nil {
+		LogError("Unsupported collections layout", err, "path", collectionsPath)
+		return nil, err
+	}
+
+	var collections []string
+	if layout == CollectionsLayoutInstalled {
+		collections, err = InstalledCollections(root)
+	} else {
+		collections, err = CollectionArchives(root)
+	}
+	if err != nil {
+		LogError("Cannot list collections", err, "path", collectionsPath)
+		return nil, fmt.Errorf("cannot list collections: %v", err)
+	}
+
+	LogInfo("Collections directory check passed", "path", collectionsPath, "layout", layout, "count", len(collections), "collections", collections)
+	return collections, nil
+}
+
+// Layouts accepted for an offline collections path.
+const (
+	// CollectionsLayoutArchives is a directory of collection tarballs, as written by download --collections.
+	CollectionsLayoutArchives = "archives"
+	// CollectionsLayoutInstalled is an installed ansible_collections/<namespace>/<name> tree.
+	CollectionsLayoutInstalled = "installed"
+)
+
+// DetectCollectionsLayout returns the layout of a collections directory. For an
+// installed tree, root is its ansible_collections directory.
+func DetectCollectionsLayout(collectionsPath string) (string, string, error) {
+	archives, err := CollectionArchives(collectionsPath)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot read collections directory: %v", err)
+	}
+	if len(archives) > 0 {
+		return CollectionsLayoutArchives, discoverArchivesDir(collectionsPath), nil
+	}
+
+	root := collectionsPath
+	if filepath.Base(filepath.Clean(collectionsPath)) != "ansible_collections" {
+		root = filepath.Join(collectionsPath, "ansible_collections")
+	}
+	if collections, _ := InstalledCollections(root); len(collections) > 0 {
+		return CollectionsLayoutInstalled, root, nil
+	}
+
+	return "", "", NewValidationError(CodeCollectionsNotFound, remediationDownloadCollections,
+		fmt.Errorf("no collection found in %s: expected .tar.gz/.tgz collection archives "+
+			"(as created by download --collections) or an installed ansible_collections/<namespace>/<name> tree", collectionsPath))
+}
+
+// GalaxyRequirementsFile is the manifest ansible-galaxy collection download
+// writes next to the archives it downloads.
+const GalaxyRequirementsFile = "requirements.yml"
+
+// archivesSearchDepth is how many levels below a collections path the
+// directory holding the archives is searched for.
+const archivesSearchDepth = 2
+
+// discoverArchivesDir returns the directory of a collections tree the archives
+// are installed from: collectionsPath itself when it directly holds archives
+// or a requirements.yml, else the only directory one or two levels below that
+// does, a requirements.yml winning over bare archives, so a tarred download
+// tree can be given by its root. It falls back to collectionsPath, whose
+// archives are then searched recursively.
+func discoverArchivesDir(collectionsPath string) string {
+	level := []string{collectionsPath}
+	for depth := 0; depth <= archivesSearchDepth && len(level) > 0; depth++ {
+		var manifests, archives, next []string
+		for _, dir := range level {
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			hasArchives := false
+			for _, entry := range entries {
+				switch {
+				case entry.IsDir():
+					next = append(next, filepath.Join(dir, entry.Name()))
+				case entry.Name() == GalaxyRequirementsFile:
+					manifests = append(manifests, dir)
+				case IsCollectionArchive(entry.Name()):
+					hasArchives = true
+				}
+			}
+			if hasArchives {
+				archives = append(archives, dir)
+			}
+		}
+
+		candidates := manifests
+		if len(candidates) == 0 {
+			candidates = archives
+		}
+		switch {
+		case len(candidates) == 1:
+			if candidates[0] != collectionsPath {
+				LogInfo("Collection archives found below collections path", "path", collectionsPath, "dir", candidates[0])
+			}
+			return candidates[0]
+		case len(candidates) > 1:
+			LogInfo("Collection archives found in several directories, searching the whole collections path",
+				"path", collectionsPath, "dirs", candidates)
+			return collectionsPath
+		}
+		level = next
+	}
+	return collectionsPath
+}
+
+// CollectionArchives returns the collection tarballs found under dir, recursively,
+// as sorted paths relative to dir.
+func CollectionArchives(dir string) ([]string, error) {
+	var archives []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !IsCollectionArchive(entry.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// IsGlobPattern reports whether path holds glob metacharacters.
+func IsGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// gitSourcePrefix marks a collections source ansible-galaxy clones with git.
+const gitSourcePrefix = "git+"
+
+// IsGitCollectionSource reports whether a collections path is a git+ URL
+// installed by ansible-galaxy, e.g.
+// git+https://git.example.com/bluebanquise.git#/collections/infrastructure,master,
+// rather than a local path.
+func IsGitCollectionSource(path string) bool {
+	return strings.HasPrefix(path, gitSourcePrefix) && len(path) > len(gitSourcePrefix)
+}
+
+// CollectionsGlob expands pattern and returns the sorted collection archives
+// it matches. Other matches are skipped, no archive match is an error.
+func CollectionsGlob(pattern string) ([]string, error) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		LogError("Invalid collections pattern", err, "pattern", pattern)
+		return nil, fmt.Errorf("invalid collections pattern %s: %v", pattern, err)
+	}
+
+	var archives []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() || !IsCollectionArchive(match) {
+			LogWarning("Skipping file matched by collections pattern", "path", match)
+			continue
+		}
+		archives = append(archives, match)
+	}
+	if len(archives) == 0 {
+		LogError("No collection archive matches pattern", nil, "pattern", pattern)
+		return nil, NewValidationError(CodeCollectionsNotFound, remediationDownloadCollections,
+			fmt.Errorf("no collection archive matches %s", pattern))
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// IsCollectionArchive reports whether name is a collection tarball.
+func IsCollectionArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// InstalledCollections returns the <namespace>/<name> directories of an
+// ansible_collections tree holding a MANIFEST.json or galaxy.yml.
+func InstalledCollections(root string) ([]string, error) {
+	namespaces, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+		names, err := os.ReadDir(filepath.Join(root, namespace.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !name.IsDir() {
+				continue
+			}
+			dir := filepath.Join(namespace.Name(), name.Name())
+			for _, marker := range []string{"MANIFEST.json", "galaxy.yml"} {
+				if _, err := os.Stat(filepath.Join(root, dir, marker)); err == nil {
+					collections = append(collections, dir)
+					break
+				}
+			}
+		}
+	}
+	return collections, nil
+}
+
+// ArchiveCollectionInfo returns the namespace, name and version of a collection
+// archive, read from the MANIFEST.json at its root.
+func ArchiveCollectionInfo(archive string) (string, string, string, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to read %s: %v", archive, err)
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return "", "", "", fmt.Errorf("no MANIFEST.json in %s", archive)
+		}
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to read %s: %v", archive, err)
+		}
+		if path.Clean(header.Name) != "MANIFEST.json" {
+			continue
+		}
+
+		var manifest struct {
+			CollectionInfo struct {
+				Namespace string `json:"namespace"`
+				Name      string `json:"name"`
+				Version   string `json:"version"`
+			} `json:"collection_info"`
+		}
+		if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+			return "", "", "", fmt.Errorf("failed to parse MANIFEST.json of %s: %v", archive, err)
+		}
+		info := manifest.CollectionInfo
+		if info.Namespace == "" || info.Name == "" {
+			return "", "", "", fmt.Errorf("MANIFEST.json of %s has no collection namespace and name", archive)
+		}
+		return info.Namespace, info.Name, info.Version, nil
+	}
+}
+
+// InstalledCollectionVersion returns the version of an installed collection
+// directory, read from its MANIFEST.json or, for collections installed from
+// source, its galaxy.yml.
+func InstalledCollectionVersion(dir string) (string, error) {
+	if data, err := os.ReadFile(filepath.Join(dir, "MANIFEST.json")); err == nil {
+		var manifest struct {
+			CollectionInfo struct {
+				Version string `json:"version"`
+			} `json:"collection_info"`
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return "", fmt.Errorf("failed to parse %s: %v", filepath.Join(dir, "MANIFEST.json"), err)
+		}
+		return manifest.CollectionInfo.Version, nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "galaxy.yml"))
+	if err != nil {
+		return "", fmt.Errorf("no MANIFEST.json or galaxy.yml in %s", dir)
+	}
+	var galaxy struct {
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &galaxy); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %v", filepath.Join(dir, "galaxy.yml"), err)
+	}
+	return galaxy.Version, nil
 }
 
 // CheckRequirementsPrerequisites verifies prerequisites for requirements offline installation.
-func CheckRequirementsPrerequisites(requirementsPath string) error {
+// When verifyChecksums is set, the SHA256SUMS manifest must be present and match.
+func CheckRequirementsPrerequisites(requirementsPath string, verifyChecksums bool) error {
 	LogInfo("Checking requirements prerequisites", "path", requirementsPath)
 
 	// Check if directory exists
 	if _, err := os.Stat(requirementsPath); os.IsNotExist(err) {
 		LogError("Requirements path does not exist", err, "path", requirementsPath)
-		return fmt.Errorf("requirements path does not exist: %s", requirementsPath)
+		return NewValidationError(CodeRequirementsPathMissing, remediationDownloadRequirements,
+			fmt.Errorf("requirements path does not exist: %s", requirementsPath))
 	}
 
-	// Check if requirements.txt exists
+	// Without requirements.txt, the directory is a wheelhouse of the built-in requirements
 	requirementsFile := filepath.Join(requirementsPath, "requirements.txt")
-	if _, err := os.Stat(requirementsFile); os.IsNotExist(err) {
-		LogError("requirements.txt not found", err, "file", requirementsFile)
-		return fmt.Errorf("requirements.txt not found: %s", requirementsFile)
-	}
+	_, err := os.Stat(requirementsFile)
+	wheelhouse := os.IsNotExist(err)
 
 	// Check if directory contains Python packages
 	entries, err := os.ReadDir(requirementsPath)
@@ -156,7 +465,35 @@ func CheckRequirementsPrerequisites(requirementsPath string) error {
 
 	if !packageFound {
 		LogError("No Python packages found in requirements directory", nil, "path", requirementsPath)
-		return fmt.Errorf("no Python packages found in requirements directory: %s", requirementsPath)
+		return NewValidationError(CodeRequirementsNotFound, remediationDownloadRequirements,
+			fmt.Errorf("no Python packages found in requirements directory: %s", requirementsPath))
+	}
+
+	if wheelhouse {
+		// pip install --no-index fails late on requirements without a local package
+		missing, err := missingArtifacts(system.PythonRequirements, requirementsPath)
+		if err != nil {
+			LogError("Cannot check the wheelhouse against the built-in requirements", err, "path", requirementsPath)
+			return err
+		}
+		if len(missing) > 0 {
+			LogError("Wheelhouse cannot satisfy the built-in requirements", nil, "path", requirementsPath, "missing", missing)
+			return fmt.Errorf("no requirements.txt in %s and no package for built-in requirement(s): %s", requirementsPath, strings.Join(missing, ", "))
+		}
+		LogInfo("No requirements.txt, the built-in requirements are installed from the wheelhouse", "path", requirementsPath)
+		fmt.Printf("No requirements.txt in %s, installing the built-in requirements from its packages\n", requirementsPath)
+	} else if missing, err := MissingRequirementArtifacts(requirementsFile, requirementsPath); err != nil {
+		LogWarning("Could not cross-check requirements against local packages", "error", err, "file", requirementsFile)
+	} else if len(missing) > 0 {
+		LogWarning("Requirements without a matching local package", "path", requirementsPath, "requirements", missing)
+		fmt.Printf("Warning: no package in %s for requirement(s): %s\n", requirementsPath, strings.Join(missing, ", "))
+		fmt.Println("The offline pip install will fail unless they are already installed, download them again with: download --requirements")
+	}
+
+	if verifyChecksums {
+		if err := VerifyChecksumManifest(requirementsPath); err != nil {
+			return err
+		}
 	}
 
 	LogInfo("Requirements prerequisites check passed", "path", requirementsPath, "entries", len(entries))
@@ -175,3 +512,30 @@ func ValidatePath(path string) error {
 
 	return nil
 }
+
+// protectedPaths are system directories that must never be used as install paths.
+var protectedPaths = []string{
+	"/", "/bin", "/boot", "/dev", "/etc", "/lib", "/lib64",
+	"/proc", "/sbin", "/sys", "/usr", "/var",
+}
+
+// ValidateInstallPath checks that a path given on the command line is absolute
+// and is not a protected system directory.
+func ValidateInstallPath(name, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s cannot be empty", name)
+	}
+
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("%s must be an absolute path: %s", name, path)
+	}
+
+	cleaned := filepath.Clean(path)
+	for _, protected := range protectedPaths {
+		if cleaned == protected {
+			return fmt.Errorf("%s cannot be the system directory %s", name, cleaned)
+		}
+	}
+
+	return nil
+}
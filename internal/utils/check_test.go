@@ -3,8 +3,11 @@ package utils
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -42,7 +45,7 @@ func TestCheckCollectionsPrerequisites(t *testing.T) {
 				err := os.MkdirAll(collectionsDir, 0755)
 				require.NoError(t, err)
 				// Create a dummy collection file
-				collectionFile := filepath.Join(collectionsDir, "test_collection")
+				collectionFile := filepath.Join(collectionsDir, "test_collection.tar.gz")
 				err = os.WriteFile(collectionFile, []byte("test"), 0644)
 				require.NoError(t, err)
 				return collectionsDir
@@ -51,6 +54,19 @@ func TestCheckCollectionsPrerequisites(t *testing.T) {
 				// Cleanup handled by t.TempDir()
 			},
 		},
+		{
+			name:        "Requirement without local package only warns",
+			expectError: false,
+			setup: func() string {
+				tempDir := t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(tempDir, "ansible-1.0.0.tar.gz"), []byte("test"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(tempDir, "requirements.txt"), []byte("ansible>=1.0.0\nnetaddr\n"), 0644))
+				return tempDir
+			},
+			cleanup: func(path string) {
+				// Cleanup handled by t.TempDir()
+			},
+		},
 		{
 			name:        "Non-existent path",
 			expectError: true,
@@ -82,7 +98,7 @@ func TestCheckCollectionsPrerequisites(t *testing.T) {
 			path := tt.setup()
 			defer tt.cleanup(path)
 
-			err := CheckCollectionsPrerequisites(path)
+			_, err := CheckCollectionsPrerequisites(path)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -94,11 +110,12 @@ func TestCheckCollectionsPrerequisites(t *testing.T) {
 
 func TestCheckRequirementsPrerequisites(t *testing.T) {
 	tests := []struct {
-		name        string
-		path        string
-		expectError bool
-		setup       func() string
-		cleanup     func(string)
+		name            string
+		path            string
+		verifyChecksums bool
+		expectError     bool
+		setup           func() string
+		cleanup         func(string)
 	}{
 		{
 			name:        "Valid requirements directory",
@@ -125,6 +142,54 @@ func TestCheckRequirementsPrerequisites(t *testing.T) {
 				// Cleanup handled by t.TempDir()
 			},
 		},
+		{
+			name:            "Valid requirements directory with checksums",
+			verifyChecksums: true,
+			expectError:     false,
+			setup: func() string {
+				tempDir := t.TempDir()
+				err := os.WriteFile(filepath.Join(tempDir, "ansible-1.0.0.tar.gz"), []byte("test"), 0644)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(tempDir, "requirements.txt"), []byte("ansible\n"), 0644)
+				require.NoError(t, err)
+				require.NoError(t, WriteChecksumManifest(tempDir))
+				return tempDir
+			},
+			cleanup: func(path string) {
+				// Cleanup handled by t.TempDir()
+			},
+		},
+		{
+			name:            "Missing checksum manifest",
+			verifyChecksums: true,
+			expectError:     true,
+			setup: func() string {
+				tempDir := t.TempDir()
+				err := os.WriteFile(filepath.Join(tempDir, "ansible-1.0.0.tar.gz"), []byte("test"), 0644)
+				require.NoError(t, err)
+				err = os.WriteFile(filepath.Join(tempDir, "requirements.txt"), []byte("ansible\n"), 0644)
+				require.NoError(t, err)
+				return tempDir
+			},
+			cleanup: func(path string) {
+				// Cleanup handled by t.TempDir()
+			},
+		},
+		{
+			name: "Wheelhouse without requirements.txt",
+			setup: func() string {
+				return writeWheelhouse(t, system.PythonRequirements)
+			},
+			cleanup: func(path string) {},
+		},
+		{
+			name:        "Wheelhouse missing a built-in requirement",
+			expectError: true,
+			setup: func() string {
+				return writeWheelhouse(t, system.PythonRequirements[1:])
+			},
+			cleanup: func(path string) {},
+		},
 		{
 			name:        "Empty directory",
 			expectError: true,
@@ -153,7 +218,7 @@ func TestCheckRequirementsPrerequisites(t *testing.T) {
 			path := tt.setup()
 			defer tt.cleanup(path)
 
-			err := CheckRequirementsPrerequisites(path)
+			err := CheckRequirementsPrerequisites(path, tt.verifyChecksums)
 			if tt.expectError {
 				assert.Error(t, err)
 			} else {
@@ -162,3 +227,279 @@ func TestCheckRequirementsPrerequisites(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateInstallPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		expectError bool
+	}{
+		{"/var/lib/bluebanquise", false},
+		{"/opt/bluebanquise", false},
+		{"/tmp/offline/collections/", false},
+		{"", true},
+		{"relative/path", true},
+		{"./bluebanquise", true},
+		{"/", true},
+		{"/etc", true},
+		{"/usr/", true},
+		{"/bin", true},
+		{"/var/lib/..", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := ValidateInstallPath("--home", tt.path)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDetectCollectionsLayout(t *testing.T) {
+	installedTree := func(t *testing.T, base string) {
+		collection := filepath.Join(base, "ansible_collections", "bluebanquise", "infrastructure")
+		require.NoError(t, os.MkdirAll(collection, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(collection, "MANIFEST.json"), []byte("{}"), 0644))
+	}
+
+	tests := []struct {
+		name           string
+		setup          func(t *testing.T) (path, root string)
+		expectedLayout string
+		expectError    bool
+	}{
+		{
+			name: "Tarball directory",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("x"), 0644))
+				return dir, dir
+			},
+			expectedLayout: CollectionsLayoutArchives,
+		},
+		{
+			name: "Flat galaxy download",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "extra"), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, GalaxyRequirementsFile), []byte("collections: []\n"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "extra", "community-general-9.0.0.tar.gz"), []byte("x"), 0644))
+				return dir, dir
+			},
+			expectedLayout: CollectionsLayoutArchives,
+		},
+		{
+			name: "Galaxy download nested one level",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				nested := filepath.Join(dir, "collections")
+				require.NoError(t, os.MkdirAll(nested, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(nested, GalaxyRequirementsFile), []byte("collections: []\n"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(nested, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("x"), 0644))
+				return dir, nested
+			},
+			expectedLayout: CollectionsLayoutArchives,
+		},
+		{
+			name: "Download tree with Python sdists",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				for _, sub := range []string{"collections", "requirements"} {
+					require.NoError(t, os.MkdirAll(filepath.Join(dir, "offline", sub), 0755))
+				}
+				collections := filepath.Join(dir, "offline", "collections")
+				require.NoError(t, os.WriteFile(filepath.Join(collections, GalaxyRequirementsFile), []byte("collections: []\n"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(collections, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("x"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "offline", "requirements", "netaddr-1.3.0.tar.gz"), []byte("x"), 0644))
+				return dir, collections
+			},
+			expectedLayout: CollectionsLayoutArchives,
+		},
+		{
+			name: "Archives in several subdirectories",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				for _, sub := range []string{"a", "b"} {
+					require.NoError(t, os.MkdirAll(filepath.Join(dir, sub), 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(dir, sub, sub+"-collection-1.0.0.tar.gz"), []byte("x"), 0644))
+				}
+				return dir, dir
+			},
+			expectedLayout: CollectionsLayoutArchives,
+		},
+		{
+			name: "Installed tree parent",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				installedTree(t, dir)
+				return dir, filepath.Join(dir, "ansible_collections")
+			},
+			expectedLayout: CollectionsLayoutInstalled,
+		},
+		{
+			name: "Installed tree root",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				installedTree(t, dir)
+				root := filepath.Join(dir, "ansible_collections")
+				return root, root
+			},
+			expectedLayout: CollectionsLayoutInstalled,
+		},
+		{
+			name: "Namespace directories without collections",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				require.NoError(t, os.MkdirAll(filepath.Join(dir, "ansible_collections", "bluebanquise", "infrastructure"), 0755))
+				return dir, ""
+			},
+			expectError: true,
+		},
+		{
+			name: "Unrelated files",
+			setup: func(t *testing.T) (string, string) {
+				dir := t.TempDir()
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("x"), 0644))
+				return dir, ""
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, expectedRoot := tt.setup(t)
+			layout, root, err := DetectCollectionsLayout(path)
+			if tt.expectError {
+				assert.Error(t, err)
+				_, err = CheckCollectionsPrerequisites(path)
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLayout, layout)
+			assert.Equal(t, expectedRoot, root)
+			_, err = CheckCollectionsPrerequisites(path)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestCheckCollectionsPrerequisitesCount(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "extra"), 0755))
+	for _, name := range []string{
+		"bluebanquise-infrastructure-3.0.0.tar.gz",
+		"community-general-9.0.0.tar.gz",
+		filepath.Join("extra", "ansible-posix-1.5.4.tgz"),
+		"README.md",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+
+	collections, err := CheckCollectionsPrerequisites(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"bluebanquise-infrastructure-3.0.0.tar.gz",
+		"community-general-9.0.0.tar.gz",
+		filepath.Join("extra", "ansible-posix-1.5.4.tgz"),
+	}, collections)
+
+	tree := t.TempDir()
+	for _, collection := range []string{"bluebanquise/infrastructure", "community/general"} {
+		collectionDir := filepath.Join(tree, "ansible_collections", collection)
+		require.NoError(t, os.MkdirAll(collectionDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(collectionDir, "MANIFEST.json"), []byte("{}"), 0644))
+	}
+
+	collections, err = CheckCollectionsPrerequisites(tree)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bluebanquise/infrastructure", "community/general"}, collections)
+}
+
+func TestInstalledCollectionVersion(t *testing.T) {
+	manifest := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(manifest, "MANIFEST.json"), []byte(`{"collection_info": {"version": "3.0.0"}}`), 0644))
+	version, err := InstalledCollectionVersion(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.0", version)
+
+	source := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(source, "galaxy.yml"), []byte("version: 9.0.0\n"), 0644))
+	version, err = InstalledCollectionVersion(source)
+	require.NoError(t, err)
+	assert.Equal(t, "9.0.0", version)
+
+	broken := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(broken, "MANIFEST.json"), []byte("{"), 0644))
+	_, err = InstalledCollectionVersion(broken)
+	assert.Error(t, err)
+
+	_, err = InstalledCollectionVersion(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestCollectionsGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b-collection-1.0.0.tar.gz", "a-collection-2.0.0.tgz", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "old.tar.gz"), 0755))
+
+	archives, err := CheckCollectionsPrerequisites(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a-collection-2.0.0.tgz"),
+		filepath.Join(dir, "b-collection-1.0.0.tar.gz"),
+	}, archives)
+
+	archives, err = CollectionsGlob(filepath.Join(dir, "b-*.tar.gz"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "b-collection-1.0.0.tar.gz")}, archives)
+
+	_, err = CollectionsGlob(filepath.Join(dir, "*.zip"))
+	assert.Error(t, err)
+
+	_, err = CollectionsGlob(filepath.Join(dir, "[*"))
+	assert.Error(t, err)
+
+	assert.True(t, IsGlobPattern("/bundles/*.tar.gz"))
+	assert.False(t, IsGlobPattern("/bundles/collections"))
+}
+
+func TestIsGitCollectionSource(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "git+https://git.example.com/bluebanquise.git#/collections/infrastructure,master", want: true},
+		{path: "git+ssh://git@git.example.com/bluebanquise.git", want: true},
+		{path: "git+file:///srv/git/bluebanquise.git", want: true},
+		{path: "git+", want: false},
+		{path: "/srv/git+collections", want: false},
+		{path: "/srv/collections", want: false},
+		{path: "https://git.example.com/bluebanquise.git", want: false},
+		{path: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsGitCollectionSource(tt.path))
+		})
+	}
+}
+
+// writeWheelhouse writes a wheel of each project into a directory without
+// requirements.txt.
+func writeWheelhouse(t *testing.T, projects []string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, project := range projects {
+		wheel := strings.ReplaceAll(project, "-", "_") + "-1.0.0-py3-none-any.whl"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, wheel), []byte("test"), 0644))
+	}
+	return dir
+}
@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumManifest is the sha256sum-compatible file listing downloaded artifacts.
+const ChecksumManifest = "SHA256SUMS"
+
+// FileSHA256 returns the hex encoded SHA-256 digest of a file.
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			LogWarning("Failed to close file", "error", closeErr, "path", path)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WriteChecksumManifest writes a SHA256SUMS file in dir listing every regular file of dir.
+func WriteChecksumManifest(dir string) error {
+	LogInfo("Writing checksum manifest", "path", dir)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		LogError("Failed to read directory", err, "path", dir)
+		return fmt.Errorf("failed to read directory: %v", err)
+	}
+
+	var lines []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == ChecksumManifest {
+			continue
+		}
+		sum, err := FileSHA256(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			LogError("Failed to compute checksum", err, "file", entry.Name())
+			return fmt.Errorf("failed to compute checksum of %s: %v", entry.Name(), err)
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s\n", sum, entry.Name()))
+	}
+	sort.Strings(lines)
+
+	manifest := filepath.Join(dir, ChecksumManifest)
+	if err := os.WriteFile(manifest, []byte(strings.Join(lines, "")), 0644); err != nil {
+		LogError("Failed to write checksum manifest", err, "file", manifest)
+		return fmt.Errorf("failed to write checksum manifest: %v", err)
+	}
+
+	LogInfo("Checksum manifest written", "file", manifest, "files", len(lines))
+	return nil
+}
+
+// checksumManifests are the manifest names looked up in a directory, in order.
+var checksumManifests = []string{ChecksumManifest, "checksums.txt"}
+
+// ErrNoChecksumManifest is returned when a directory has no checksum manifest.
+var ErrNoChecksumManifest = errors.New("checksum manifest not found")
+
+// findChecksumManifest returns the path of the SHA256SUMS or checksums.txt file of dir.
+func findChecksumManifest(dir string) (string, error) {
+	for _, name := range checksumManifests {
+		manifest := filepath.Join(dir, name)
+		if info, err := os.Stat(manifest); err == nil && info.Mode().IsRegular() {
+			return manifest, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoChecksumManifest, filepath.Join(dir, ChecksumManifest))
+}
+
+// VerifyChecksumManifest checks every file listed in the SHA256SUMS or
+// checksums.txt file of dir.
+func VerifyChecksumManifest(dir string) error {
+	_, err := verifyChecksumManifest(dir)
+	return err
+}
+
+// verifyChecksumManifest checks every file listed in the manifest of dir and
+// returns the names listed.
+func verifyChecksumManifest(dir string) ([]string, error) {
+	manifest, err := findChecksumManifest(dir)
+	if err != nil {
+		LogError("Failed to find checksum manifest", err, "path", dir)
+		return nil, err
+	}
+	LogInfo("Verifying checksum manifest", "file", manifest)
+
+	file, err := os.Open(manifest)
+	if err != nil {
+		LogError("Failed to open checksum manifest", err, "file", manifest)
+		return nil, fmt.Errorf("failed to open checksum manifest %s: %v", manifest, err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			LogWarning("Failed to close file", "error", closeErr, "file", manifest)
+		}
+	}()
+
+	var names, mismatches []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed line in %s: %q", manifest, line)
+		}
+		expected, name := fields[0], strings.TrimPrefix(fields[1], "*")
+		names = append(names, filepath.Clean(name))
+
+		sum, err := FileSHA256(filepath.Join(dir, name))
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%v)", name, err))
+			continue
+		}
+		if sum != expected {
+			mismatches = append(mismatches, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", manifest, err)
+	}
+
+	if len(mismatches) > 0 {
+		LogError("Checksum verification failed", nil, "file", manifest, "mismatches", mismatches)
+		return nil, fmt.Errorf("checksum mismatch for: %s", strings.Join(mismatches, ", "))
+	}
+
+	LogInfo("Checksum manifest verified", "file", manifest, "files", len(names))
+	return names, nil
+}
+
+// VerifyCollectionsChecksums checks the collection archives of dir against its
+// SHA256SUMS or checksums.txt manifest. Every archive must be listed, so none is
+// installed unverified. ErrNoChecksumManifest is returned when dir has no manifest.
+func VerifyCollectionsChecksums(dir string) error {
+	names, err := verifyChecksumManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+	archives, err := CollectionArchives(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read collections directory: %v", err)
+	}
+	var unlisted []string
+	for _, archive := range archives {
+		if !listed[filepath.Clean(archive)] {
+			unlisted = append(unlisted, archive)
+		}
+	}
+	if len(unlisted) > 0 {
+		LogError("Collection archives missing from checksum manifest", nil, "path", dir, "archives", unlisted)
+		return fmt.Errorf("collection archives not listed in the checksum manifest: %s", strings.Join(unlisted, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteChecksumManifest(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("ansible\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ansible-1.0.0.tar.gz"), []byte("package"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0755))
+
+	require.NoError(t, WriteChecksumManifest(dir))
+
+	data, err := os.ReadFile(filepath.Join(dir, ChecksumManifest))
+	require.NoError(t, err)
+	// sha256("package")
+	assert.Contains(t, string(data), "bc4a71180870f7945155fbb02f4b0a2e3faa2a62d6d31b7039013055ed19869a  ansible-1.0.0.tar.gz\n")
+	assert.Contains(t, string(data), "  requirements.txt\n")
+	assert.NotContains(t, string(data), "subdir")
+	assert.NotContains(t, string(data), ChecksumManifest)
+
+	assert.NoError(t, VerifyChecksumManifest(dir))
+}
+
+func TestVerifyChecksumManifestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	wheel := filepath.Join(dir, "jinja2-3.0.0-py3-none-any.whl")
+	require.NoError(t, os.WriteFile(wheel, []byte("original"), 0644))
+	require.NoError(t, WriteChecksumManifest(dir))
+
+	// Corrupt the wheel after the manifest was written
+	require.NoError(t, os.WriteFile(wheel, []byte("corrupted"), 0644))
+
+	err := VerifyChecksumManifest(dir)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "jinja2-3.0.0-py3-none-any.whl")
+}
+
+func TestVerifyChecksumManifestMissing(t *testing.T) {
+	err := VerifyChecksumManifest(t.TempDir())
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrNoChecksumManifest)
+}
+
+func TestVerifyChecksumManifestChecksumsTxt(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ansible-1.0.0.tar.gz"), []byte("package"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "checksums.txt"),
+		[]byte("bc4a71180870f7945155fbb02f4b0a2e3faa2a62d6d31b7039013055ed19869a *ansible-1.0.0.tar.gz\n"), 0644))
+
+	assert.NoError(t, VerifyChecksumManifest(dir))
+}
+
+func TestVerifyCollectionsChecksums(t *testing.T) {
+	InitTestLogger()
+
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, dir string)
+		expectError string
+	}{
+		{
+			name: "Matching archives",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, WriteChecksumManifest(dir))
+			},
+		},
+		{
+			name: "Corrupted archive",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, WriteChecksumManifest(dir))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "community-general-9.0.0.tar.gz"), []byte("corrupted"), 0644))
+			},
+			expectError: "checksum mismatch for: community-general-9.0.0.tar.gz",
+		},
+		{
+			name: "Archive missing from manifest",
+			setup: func(t *testing.T, dir string) {
+				require.NoError(t, WriteChecksumManifest(dir))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "extra-collection-1.0.0.tar.gz"), []byte("extra"), 0644))
+			},
+			expectError: "collection archives not listed in the checksum manifest: extra-collection-1.0.0.tar.gz",
+		},
+		{
+			name:        "Missing manifest",
+			setup:       func(t *testing.T, dir string) {},
+			expectError: "checksum manifest not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "bluebanquise-infrastructure-3.0.0.tar.gz"), []byte("infrastructure"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "community-general-9.0.0.tar.gz"), []byte("general"), 0644))
+			tt.setup(t, dir)
+
+			err := VerifyCollectionsChecksums(dir)
+			if tt.expectError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.expectError)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// indexLinkPattern matches the links of an HTML directory index, as served by
+// the autoindex of Apache or nginx.
+var indexLinkPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+// IsHTTPCollectionSource reports whether a collections path is the URL of an
+// HTTP(S) directory index of collection archives rather than a local path.
+func IsHTTPCollectionSource(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// DownloadCollectionsIndex downloads the collection archives listed by the
+// directory index at indexURL into a temporary directory, removed by the
+// returned cleanup. The index is an HTML page linking the archives, or a JSON
+// array of their names or URLs. The requirements.yml and checksum manifest
+// the index lists are downloaded too, so the directory is installed and
+// verified like a local one. Retries stop once ctx is done.
+func DownloadCollectionsIndex(ctx context.Context, indexURL string, opts DownloadOptions) (string, func(), error) {
+	noop := func() {}
+
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return "", noop, fmt.Errorf("invalid collections index URL %s: %v", RedactURL(indexURL), err)
+	}
+	// A directory URL without trailing slash would resolve its links in its parent
+	if !strings.HasSuffix(base.Path, "/") && path.Ext(base.Path) == "" {
+		base.Path += "/"
+	}
+
+	tempDir, err := makeCollectionsTempDir()
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			LogWarning("Could not remove temporary directory", "error", err, "path", tempDir)
+		}
+	}
+
+	LogInfo("Reading collections index", "url", RedactURL(indexURL))
+	fmt.Printf("Reading collections index %s...\n", RedactURL(indexURL))
+	indexFile := filepath.Join(tempDir, ".index")
+	if err := DownloadFileWithRetry(ctx, base.String(), indexFile, opts); err != nil {
+		cleanup()
+		LogError("Failed to download collections index", err, "url", RedactURL(indexURL))
+		return "", noop, fmt.Errorf("failed to download collections index %s: %v", RedactURL(indexURL), err)
+	}
+	data, err := os.ReadFile(indexFile)
+	if err == nil {
+		err = os.Remove(indexFile)
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("failed to read collections index: %v", err)
+	}
+
+	files, err := parseCollectionsIndex(base, data)
+	if err != nil {
+		cleanup()
+		LogError("Invalid collections index", err, "url", RedactURL(indexURL))
+		return "", noop, fmt.Errorf("invalid collections index %s: %v", RedactURL(indexURL), err)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !slices.ContainsFunc(names, IsCollectionArchive) {
+		cleanup()
+		return "", noop, NewValidationError(CodeCollectionsNotFound, remediationDownloadCollections,
+			fmt.Errorf("no collection archive listed by the index %s", RedactURL(indexURL)))
+	}
+
+	LogInfo("Downloading collections from index", "url", RedactURL(indexURL), "files", names, "dest", tempDir)
+	for _, name := range names {
+		fmt.Printf("Downloading %s...\n", name)
+		if err := DownloadFileWithRetry(ctx, files[name], filepath.Join(tempDir, name), opts); err != nil {
+			cleanup()
+			LogError("Failed to download collection from index", err, "file", name, "url", RedactURL(files[name]))
+			return "", noop, fmt.Errorf("failed to download %s from the collections index: %v", name, err)
+		}
+	}
+
+	return tempDir, cleanup, nil
+}
+
+// parseCollectionsIndex returns the URLs of the files to download from the
+// index at base, by file name. Links leaving the directory of the index, e.g.
+// to its parent, and files other than collection archives, requirements.yml
+// and checksum manifests are ignored.
+func parseCollectionsIndex(base *url.URL, data []byte) (map[string]string, error) {
+	dir := base.Path[:strings.LastIndex(base.Path, "/")+1]
+
+	var links []string
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &links); err != nil {
+			return nil, fmt.Errorf("JSON index must be an array of file names or URLs: %v", err)
+		}
+	} else {
+		for _, match := range indexLinkPattern.FindAllStringSubmatch(string(data), -1) {
+			links = append(links, match[1])
+		}
+	}
+
+	files := map[string]string{}
+	for _, link := range links {
+		ref, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		target := base.ResolveReference(ref)
+		if target.Host != base.Host || !strings.HasPrefix(target.Path, dir) {
+			continue
+		}
+		name := path.Base(target.Path)
+		if name != GalaxyRequirementsFile && !slices.Contains(checksumManifests, name) && !IsCollectionArchive(name) {
+			continue
+		}
+		target.RawQuery, target.Fragment = "", ""
+		files[name] = target.String()
+	}
+	return files, nil
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectionsIndexHTML is an nginx autoindex listing two collection archives,
+// a checksum manifest and files the download ignores.
+const collectionsIndexHTML = `<html><head><title>Index of /collections/</title></head><body>
+<h1>Index of /collections/</h1><hr><pre><a href="../">../</a>
+<a href="bluebanquise-infrastructure-3.0.0.tar.gz">bluebanquise-infrastructure-3.0.0.tar.gz</a>
+<a href="community-general-9.0.0.tar.gz">community-general-9.0.0.tar.gz</a>
+<a href="SHA256SUMS">SHA256SUMS</a>
+<a href="README.txt">README.txt</a>
+<a href="?C=N;O=D">Name</a>
+<a href="/other/secret-1.0.0.tar.gz">secret-1.0.0.tar.gz</a>
+</pre><hr></body></html>`
+
+func TestDownloadCollectionsIndex(t *testing.T) {
+	InitTestLogger()
+
+	files := map[string]string{
+		"/collections/":           collectionsIndexHTML,
+		"/collections/index.json": `["bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-9.0.0.tar.gz"]`,
+		"/collections/bluebanquise-infrastructure-3.0.0.tar.gz": "infrastructure",
+		"/collections/community-general-9.0.0.tar.gz":           "general",
+		"/collections/SHA256SUMS":                               "sums",
+		"/empty/":                                               `<a href="README.txt">README.txt</a>`,
+	}
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		content, found := files[r.URL.Path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		url       string
+		wantFiles map[string]string
+		wantErr   string
+	}{
+		{
+			name: "HTML index",
+			url:  server.URL + "/collections",
+			wantFiles: map[string]string{
+				"bluebanquise-infrastructure-3.0.0.tar.gz": "infrastructure",
+				"community-general-9.0.0.tar.gz":           "general",
+				"SHA256SUMS":                               "sums",
+			},
+		},
+		{
+			name: "JSON manifest",
+			url:  server.URL + "/collections/index.json",
+			wantFiles: map[string]string{
+				"bluebanquise-infrastructure-3.0.0.tar.gz": "infrastructure",
+				"community-general-9.0.0.tar.gz":           "general",
+			},
+		},
+		{
+			name:    "No archive listed",
+			url:     server.URL + "/empty/",
+			wantErr: "no collection archive listed by the index",
+		},
+		{
+			name:    "Missing index",
+			url:     server.URL + "/missing/",
+			wantErr: "failed to download collections index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requested = nil
+			dir, cleanup, err := DownloadCollectionsIndex(context.Background(), tt.url, DownloadOptions{})
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			got := map[string]string{}
+			for _, entry := range entries {
+				content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+				require.NoError(t, err)
+				got[entry.Name()] = string(content)
+			}
+			assert.Equal(t, tt.wantFiles, got)
+			assert.NotContains(t, requested, "/other/secret-1.0.0.tar.gz")
+
+			archives, err := CheckCollectionsPrerequisites(dir)
+			require.NoError(t, err)
+			assert.Len(t, archives, 2)
+
+			cleanup()
+			assert.NoDirExists(t, dir)
+		})
+	}
+}
+
+func TestIsHTTPCollectionSource(t *testing.T) {
+	assert.True(t, IsHTTPCollectionSource("https://mirror.example.com/collections/"))
+	assert.True(t, IsHTTPCollectionSource("http://mirror.example.com/collections/"))
+	assert.False(t, IsHTTPCollectionSource("/srv/collections"))
+	assert.False(t, IsHTTPCollectionSource("git+https://git.example.com/bluebanquise.git"))
+}
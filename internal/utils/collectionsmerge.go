@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergedArchive is an archive selected by MergeCollectionsDirs.
+type mergedArchive struct {
+	path string
+	// collection is namespace.name from MANIFEST.json, empty when unreadable
+	collection string
+	version    string
+}
+
+// MergeCollectionsDirs links the collection archives of several collections
+// directories, as prepared by PrepareCollectionsPath, into a temporary
+// directory removed by the returned cleanup, so a bundle split across
+// directories installs together. Archives are deduplicated by the namespace,
+// name and version of their MANIFEST.json: the same collection version found
+// twice is linked once and, when two versions of a collection are found, the
+// highest one is kept. Archives whose manifest cannot be read are deduplicated
+// by file name. When one of the directories has a requirements.yml, one
+// listing every linked archive is written, so ansible-galaxy still orders
+// their dependencies.
+func MergeCollectionsDirs(dirs []string) (string, func(), error) {
+	noop := func() {}
+
+	var selected []mergedArchive
+	hasRequirements := false
+	for _, dir := range dirs {
+		layout, root, err := DetectCollectionsLayout(dir)
+		if err != nil {
+			return "", noop, err
+		}
+		if layout == CollectionsLayoutInstalled {
+			return "", noop, fmt.Errorf("installed collections tree %s cannot be merged with other collections paths, give its archives instead", dir)
+		}
+		if _, err := os.Stat(filepath.Join(root, GalaxyRequirementsFile)); err == nil {
+			hasRequirements = true
+		}
+		archives, err := CollectionArchives(root)
+		if err != nil {
+			LogError("Failed to read directory", err, "path", root)
+			return "", noop, fmt.Errorf("failed to read directory: %v", err)
+		}
+		for _, name := range archives {
+			selected = selectMergedArchive(selected, filepath.Join(root, name))
+		}
+	}
+
+	tempDir, err := makeCollectionsTempDir()
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			LogWarning("Could not remove temporary directory", "error", err, "path", tempDir)
+		}
+	}
+
+	var names []string
+	for _, archive := range selected {
+		name := filepath.Base(archive.path)
+		link := filepath.Join(tempDir, name)
+		if _, err := os.Lstat(link); err == nil {
+			cleanup()
+			return "", noop, fmt.Errorf("several collection archives named %s in %s", name, strings.Join(dirs, ", "))
+		}
+		target, err := filepath.Abs(archive.path)
+		if err == nil {
+			err = os.Symlink(target, link)
+		}
+		if err != nil {
+			cleanup()
+			LogError("Failed to link collection archive", err, "archive", archive.path)
+			return "", noop, fmt.Errorf("failed to link collection archive %s: %v", archive.path, err)
+		}
+		names = append(names, name)
+	}
+
+	if hasRequirements {
+		if err := writeMergedRequirements(tempDir, names); err != nil {
+			cleanup()
+			return "", noop, err
+		}
+	}
+	LogInfo("Collections paths merged", "dirs", dirs, "archives", names, "dest", tempDir)
+	return tempDir, cleanup, nil
+}
+
+// selectMergedArchive adds archive to selected unless an archive of the same
+// collection version is there already. Of two versions of a collection, the
+// highest one is kept.
+func selectMergedArchive(selected []mergedArchive, archive string) []mergedArchive {
+	candidate := mergedArchive{path: archive}
+	namespace, name, version, err := ArchiveCollectionInfo(archive)
+	if err == nil {
+		candidate.collection, candidate.version = namespace+"."+name, version
+	} else {
+		LogWarning("Could not read collection manifest, deduplicating it by file name", "archive", archive, "error", err)
+	}
+
+	for i, existing := range selected {
+		if candidate.collection == "" || existing.collection == "" {
+			if candidate.collection == existing.collection && filepath.Base(existing.path) == filepath.Base(archive) {
+				LogInfo("Skipping duplicate collection archive", "archive", archive, "kept", existing.path)
+				return selected
+			}
+			continue
+		}
+		if existing.collection != candidate.collection {
+			continue
+		}
+		switch CompareVersions(candidate.version, existing.version) {
+		case 0:
+			LogInfo("Skipping duplicate collection archive", "collection", candidate.collection, "version", candidate.version, "archive", archive, "kept", existing.path)
+		case 1:
+			LogWarning("Collection found at several versions, keeping the highest", "collection", candidate.collection, "kept", candidate.version, "dropped", existing.version)
+			fmt.Printf("Warning: collection %s found at versions %s and %s, installing %s\n", candidate.collection, existing.version, candidate.version, candidate.version)
+			selected[i] = candidate
+		default:
+			LogWarning("Collection found at several versions, keeping the highest", "collection", candidate.collection, "kept", existing.version, "dropped", candidate.version)
+			fmt.Printf("Warning: collection %s found at versions %s and %s, installing %s\n", candidate.collection, existing.version, candidate.version, existing.version)
+		}
+		return selected
+	}
+	return append(selected, candidate)
+}
+
+// writeMergedRequirements writes the requirements.yml of dir listing the
+// archives names.
+func writeMergedRequirements(dir string, names []string) error {
+	data, err := yaml.Marshal(map[string][]string{"collections": names})
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, GalaxyRequirementsFile), data, 0644)
+	}
+	if err != nil {
+		LogError("Failed to write merged collections requirements", err, "path", dir)
+		return fmt.Errorf("failed to write merged collections requirements: %v", err)
+	}
+	return nil
+}
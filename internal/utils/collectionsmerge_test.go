@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// writeCollectionArchive writes a collection archive named file in dir whose
+// MANIFEST.json describes namespace.name at version.
+func writeCollectionArchive(t *testing.T, dir, file, namespace, name, version string) string {
+	t.Helper()
+	path := filepath.Join(dir, file)
+	manifest := fmt.Sprintf(`{"collection_info":{"namespace":%q,"name":%q,"version":%q}}`, namespace, name, version)
+	writeTarGz(t, path, map[string]string{"MANIFEST.json": manifest})
+	return path
+}
+
+// mergedLinks returns the archive names linked into dir with their targets.
+func mergedLinks(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	links := map[string]string{}
+	for _, entry := range entries {
+		if entry.Name() == GalaxyRequirementsFile {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		require.NoError(t, err)
+		links[entry.Name()] = target
+	}
+	return links
+}
+
+func TestMergeCollectionsDirs(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup writes the collections directories and returns them with the
+		// expected links, keyed by archive name, relative to the first one.
+		setup   func(t *testing.T, first, second string) map[string]string
+		wantErr string
+	}{
+		{
+			name: "distinct collections are all linked",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeCollectionArchive(t, first, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				writeCollectionArchive(t, second, "community-general-8.0.0.tar.gz", "community", "general", "8.0.0")
+				return map[string]string{
+					"bluebanquise-infrastructure-3.0.0.tar.gz": filepath.Join(first, "bluebanquise-infrastructure-3.0.0.tar.gz"),
+					"community-general-8.0.0.tar.gz":           filepath.Join(second, "community-general-8.0.0.tar.gz"),
+				}
+			},
+		},
+		{
+			name: "same collection version is linked once",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeCollectionArchive(t, first, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				writeCollectionArchive(t, second, "infrastructure.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				return map[string]string{
+					"bluebanquise-infrastructure-3.0.0.tar.gz": filepath.Join(first, "bluebanquise-infrastructure-3.0.0.tar.gz"),
+				}
+			},
+		},
+		{
+			name: "highest version of a collection is kept",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeCollectionArchive(t, first, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				writeCollectionArchive(t, second, "bluebanquise-infrastructure-3.1.0.tar.gz", "bluebanquise", "infrastructure", "3.1.0")
+				writeCollectionArchive(t, second, "community-general-8.0.0.tar.gz", "community", "general", "8.0.0")
+				writeCollectionArchive(t, first, "community-general-7.5.0.tar.gz", "community", "general", "7.5.0")
+				return map[string]string{
+					"bluebanquise-infrastructure-3.1.0.tar.gz": filepath.Join(second, "bluebanquise-infrastructure-3.1.0.tar.gz"),
+					"community-general-8.0.0.tar.gz":           filepath.Join(second, "community-general-8.0.0.tar.gz"),
+				}
+			},
+		},
+		{
+			name: "archives without manifest are deduplicated by name",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeTarGz(t, filepath.Join(first, "custom.tar.gz"), map[string]string{"README.md": "first"})
+				writeTarGz(t, filepath.Join(second, "custom.tar.gz"), map[string]string{"README.md": "second"})
+				return map[string]string{"custom.tar.gz": filepath.Join(first, "custom.tar.gz")}
+			},
+		},
+		{
+			name: "distinct collections archived under the same name",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeCollectionArchive(t, first, "collection.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				writeCollectionArchive(t, second, "collection.tar.gz", "community", "general", "8.0.0")
+				return nil
+			},
+			wantErr: "several collection archives named collection.tar.gz",
+		},
+		{
+			name: "installed tree cannot be merged",
+			setup: func(t *testing.T, first, second string) map[string]string {
+				writeCollectionArchive(t, first, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+				installed := filepath.Join(second, "ansible_collections", "community", "general")
+				require.NoError(t, os.MkdirAll(installed, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(installed, "MANIFEST.json"), []byte("{}"), 0644))
+				return nil
+			},
+			wantErr: "installed collections tree",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, second := t.TempDir(), t.TempDir()
+			want := tt.setup(t, first, second)
+
+			dir, cleanup, err := MergeCollectionsDirs([]string{first, second})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer cleanup()
+
+			assert.Equal(t, want, mergedLinks(t, dir))
+			assert.NoFileExists(t, filepath.Join(dir, GalaxyRequirementsFile))
+
+			cleanup()
+			assert.NoDirExists(t, dir)
+		})
+	}
+}
+
+func TestMergeCollectionsDirsRequirements(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	writeCollectionArchive(t, first, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+	require.NoError(t, os.WriteFile(filepath.Join(first, GalaxyRequirementsFile),
+		[]byte("collections:\n- bluebanquise-infrastructure-3.0.0.tar.gz\n"), 0644))
+	writeCollectionArchive(t, second, "community-general-8.0.0.tar.gz", "community", "general", "8.0.0")
+
+	dir, cleanup, err := MergeCollectionsDirs([]string{first, second})
+	require.NoError(t, err)
+	defer cleanup()
+
+	data, err := os.ReadFile(filepath.Join(dir, GalaxyRequirementsFile))
+	require.NoError(t, err)
+	var requirements struct {
+		Collections []string `yaml:"collections"`
+	}
+	require.NoError(t, yaml.Unmarshal(data, &requirements))
+	sort.Strings(requirements.Collections)
+	assert.Equal(t, []string{"bluebanquise-infrastructure-3.0.0.tar.gz", "community-general-8.0.0.tar.gz"}, requirements.Collections)
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Verdicts of CheckCollectionFiles on a file of a collections directory.
+const (
+	// FileValidCollection is a collection archive with a complete MANIFEST.json.
+	FileValidCollection = "valid collection"
+	// FileNotArchive is a file without a .tar.gz/.tgz extension, not installed.
+	FileNotArchive = "not an archive"
+	// FileUnreadable is an archive that cannot be opened or is truncated.
+	FileUnreadable = "unreadable"
+	// FileWrongFormat is a .tar.gz/.tgz file that is not a gzip compressed tar
+	// archive, or an archive that is not a collection.
+	FileWrongFormat = "wrong format"
+)
+
+// CollectionFileReport is the verdict of CheckCollectionFiles on one file.
+type CollectionFileReport struct {
+	// File is the path of the file relative to the checked directory.
+	File    string `json:"file"`
+	Verdict string `json:"verdict"`
+	// Collection and Version are read from the MANIFEST.json of a valid
+	// collection archive.
+	Collection string `json:"collection,omitempty"`
+	Version    string `json:"version,omitempty"`
+	// Detail explains a verdict other than FileValidCollection.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Failed reports whether the file would make the installation fail.
+func (r CollectionFileReport) Failed() bool {
+	return r.Verdict == FileUnreadable || r.Verdict == FileWrongFormat
+}
+
+// CheckCollectionFiles returns a verdict for every file of the collections
+// directory dir, as prepared by PrepareCollectionsPath, subdirectories
+// included, instead of failing on the first bad one. The requirements.yml and
+// checksum manifests next to the archives are left out. For an installed
+// ansible_collections tree, a verdict is returned for each collection
+// directory.
+func CheckCollectionFiles(dir string) ([]CollectionFileReport, error) {
+	LogInfo("Checking collection files", "path", dir)
+	if layout, root, err := DetectCollectionsLayout(dir); err == nil && layout == CollectionsLayoutInstalled {
+		return checkInstalledCollections(root)
+	}
+
+	var reports []CollectionFileReport
+	err := filepath.WalkDir(dir, func(file string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		name := entry.Name()
+		if name == GalaxyRequirementsFile || slices.Contains(checksumManifests, name) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		report := CollectionFileReport{File: rel, Verdict: FileNotArchive, Detail: "no .tar.gz/.tgz extension, not installed"}
+		if IsCollectionArchive(name) {
+			report = checkCollectionFile(file)
+			report.File = rel
+		}
+		LogInfo("Collection file checked", "file", file, "verdict", report.Verdict, "collection", report.Collection, "detail", report.Detail)
+		reports = append(reports, report)
+		return nil
+	})
+	if err != nil {
+		LogError("Failed to read collections directory", err, "path", dir)
+		return nil, fmt.Errorf("failed to read collections directory: %v", err)
+	}
+	return reports, nil
+}
+
+// checkInstalledCollections returns a verdict for each collection of an
+// installed ansible_collections tree.
+func checkInstalledCollections(root string) ([]CollectionFileReport, error) {
+	collections, err := InstalledCollections(root)
+	if err != nil {
+		LogError("Failed to read installed collections", err, "path", root)
+		return nil, fmt.Errorf("failed to read installed collections: %v", err)
+	}
+	var reports []CollectionFileReport
+	for _, collection := range collections {
+		report := CollectionFileReport{
+			File:       collection,
+			Verdict:    FileValidCollection,
+			Collection: strings.ReplaceAll(collection, string(filepath.Separator), "."),
+		}
+		version, err := InstalledCollectionVersion(filepath.Join(root, collection))
+		if err != nil {
+			report.Verdict, report.Detail = FileWrongFormat, err.Error()
+		}
+		report.Version = version
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// checkCollectionFile reads the collection archive file through and returns
+// its verdict.
+func checkCollectionFile(file string) CollectionFileReport {
+	report := CollectionFileReport{File: file}
+	f, err := os.Open(file)
+	if err != nil {
+		report.Verdict, report.Detail = FileUnreadable, err.Error()
+		return report
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		report.Verdict, report.Detail = FileWrongFormat, fmt.Sprintf("not gzip compressed: %v", err)
+		return report
+	}
+	defer gz.Close()
+
+	var manifest struct {
+		CollectionInfo struct {
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+			Version   string `json:"version"`
+		} `json:"collection_info"`
+	}
+	foundManifest := false
+	var manifestErr error
+	reader := tar.NewReader(gz)
+	for entries := 0; ; entries++ {
+		header, err := reader.Next()
+		if err == io.EOF {
+			if entries == 0 {
+				report.Verdict, report.Detail = FileWrongFormat, "empty archive"
+				return report
+			}
+			break
+		}
+		if err != nil {
+			if entries == 0 {
+				report.Verdict, report.Detail = FileWrongFormat, fmt.Sprintf("not a tar archive: %v", err)
+			} else {
+				report.Verdict, report.Detail = FileUnreadable, fmt.Sprintf("truncated or corrupt: %v", err)
+			}
+			return report
+		}
+		if path.Clean(header.Name) == "MANIFEST.json" && !foundManifest {
+			foundManifest = true
+			manifestErr = json.NewDecoder(reader).Decode(&manifest)
+		}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			report.Verdict, report.Detail = FileUnreadable, fmt.Sprintf("truncated or corrupt: %v", err)
+			return report
+		}
+	}
+
+	info := manifest.CollectionInfo
+	switch {
+	case !foundManifest:
+		report.Verdict, report.Detail = FileWrongFormat, "no MANIFEST.json, not a collection archive"
+	case manifestErr != nil:
+		report.Verdict, report.Detail = FileWrongFormat, fmt.Sprintf("invalid MANIFEST.json: %v", manifestErr)
+	case info.Namespace == "" || info.Name == "":
+		report.Verdict, report.Detail = FileWrongFormat, "MANIFEST.json has no collection namespace and name"
+	default:
+		report.Verdict = FileValidCollection
+		report.Collection, report.Version = info.Namespace+"."+info.Name, info.Version
+	}
+	return report
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCollectionFiles(t *testing.T) {
+	InitTestLogger()
+
+	dir := t.TempDir()
+	writeCollectionArchive(t, dir, "bluebanquise-infrastructure-3.0.0.tar.gz", "bluebanquise", "infrastructure", "3.0.0")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "extra"), 0755))
+	writeCollectionArchive(t, filepath.Join(dir, "extra"), "community-general-8.0.0.tgz", "community", "general", "8.0.0")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.txt"), []byte("notes"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "index.tar.gz"), []byte("<html>Not Found</html>"), 0644))
+	writeTarGz(t, filepath.Join(dir, "roles.tar.gz"), map[string]string{"roles/main.yml": "---\n"})
+	writeTarGz(t, filepath.Join(dir, "broken-manifest.tar.gz"), map[string]string{"MANIFEST.json": "{"})
+	writeTarGz(t, filepath.Join(dir, "anonymous.tar.gz"), map[string]string{"MANIFEST.json": `{"collection_info":{"version":"1.0.0"}}`})
+
+	// A download cut in the middle of the tar stream
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	writeTarGz(t, truncated, map[string]string{"MANIFEST.json": `{"collection_info":{"namespace":"a","name":"b","version":"1"}}`, "plugins/big.py": string(make([]byte, 64*1024))})
+	data, err := os.ReadFile(truncated)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(truncated, data[:len(data)/2], 0644))
+
+	// Files next to the archives are not reported
+	require.NoError(t, os.WriteFile(filepath.Join(dir, GalaxyRequirementsFile), []byte("collections: []\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ChecksumManifest), []byte(""), 0644))
+
+	reports, err := CheckCollectionFiles(dir)
+	require.NoError(t, err)
+
+	verdicts := map[string]string{}
+	for _, report := range reports {
+		verdicts[report.File] = report.Verdict
+		if report.Verdict != FileValidCollection {
+			assert.NotEmpty(t, report.Detail, report.File)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"bluebanquise-infrastructure-3.0.0.tar.gz": FileValidCollection,
+		"extra/community-general-8.0.0.tgz":        FileValidCollection,
+		"README.txt":                               FileNotArchive,
+		"index.tar.gz":                             FileWrongFormat,
+		"roles.tar.gz":                             FileWrongFormat,
+		"broken-manifest.tar.gz":                   FileWrongFormat,
+		"anonymous.tar.gz":                         FileWrongFormat,
+		"truncated.tar.gz":                         FileUnreadable,
+	}, verdicts)
+
+	for _, report := range reports {
+		switch report.File {
+		case "bluebanquise-infrastructure-3.0.0.tar.gz":
+			assert.Equal(t, "bluebanquise.infrastructure", report.Collection)
+			assert.Equal(t, "3.0.0", report.Version)
+			assert.False(t, report.Failed())
+		case "README.txt":
+			assert.False(t, report.Failed())
+		case "truncated.tar.gz", "index.tar.gz":
+			assert.True(t, report.Failed())
+		}
+	}
+}
+
+func TestCheckCollectionFilesInstalled(t *testing.T) {
+	InitTestLogger()
+
+	root := filepath.Join(t.TempDir(), "ansible_collections")
+	infrastructure := filepath.Join(root, "bluebanquise", "infrastructure")
+	require.NoError(t, os.MkdirAll(infrastructure, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(infrastructure, "MANIFEST.json"),
+		[]byte(`{"collection_info":{"namespace":"bluebanquise","name":"infrastructure","version":"3.0.0"}}`), 0644))
+	general := filepath.Join(root, "community", "general")
+	require.NoError(t, os.MkdirAll(general, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(general, "MANIFEST.json"), []byte("{"), 0644))
+
+	reports, err := CheckCollectionFiles(root)
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, CollectionFileReport{
+		File:       filepath.Join("bluebanquise", "infrastructure"),
+		Verdict:    FileValidCollection,
+		Collection: "bluebanquise.infrastructure",
+		Version:    "3.0.0",
+	}, reports[0])
+	assert.Equal(t, "community.general", reports[1].Collection)
+	assert.Equal(t, FileWrongFormat, reports[1].Verdict)
+}
@@ -3,13 +3,20 @@ package utils
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/version"
 )
 
 func detectPackageManager() (string, error) {
@@ -26,8 +33,49 @@ func detectPackageManager() (string, error) {
 	return "", fmt.Errorf("no supported package manager found")
 }
 
-func InstallPackages(pkgs []string) error {
-	LogInfo("Installing packages", "packages", pkgs)
+// PackageOptions configures the installation of system packages.
+type PackageOptions struct {
+	// DownloadFirst downloads the packages in a first pass, then installs them
+	// from the cache, on managers supporting download-only installs.
+	DownloadFirst bool
+	// DownloadConcurrency sets the number of parallel downloads of dnf, the
+	// dnf default is used when zero. Other managers ignore it.
+	DownloadConcurrency int
+	// ExtraPackages are installed along with the packages of the OS.
+	ExtraPackages []string
+}
+
+// maxDownloadConcurrency is the highest max_parallel_downloads dnf accepts.
+const maxDownloadConcurrency = 20
+
+// packageNamePattern accepts the package names of apt, dnf and zypper, with
+// an optional apt architecture or dnf version suffix, but no option or shell syntax.
+var packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9+._:~-]*$`)
+
+// ValidatePackageOptions checks the download concurrency is within the range
+// dnf accepts and the extra packages look like package names.
+func ValidatePackageOptions(opts PackageOptions) error {
+	if opts.DownloadConcurrency < 0 || opts.DownloadConcurrency > maxDownloadConcurrency {
+		return fmt.Errorf("download concurrency must be 0 (default) or between 1 and %d, got %d", maxDownloadConcurrency, opts.DownloadConcurrency)
+	}
+	for _, pkg := range opts.ExtraPackages {
+		if !packageNamePattern.MatchString(pkg) {
+			return fmt.Errorf("invalid extra package name %q", pkg)
+		}
+	}
+	return nil
+}
+
+// InstallPackages installs the packages of pkgs that are not installed yet, so a
+// run following a partial failure only installs the missing ones.
+func InstallPackages(ctx context.Context, pkgs []string) error {
+	return InstallPackagesWithOptions(ctx, pkgs, PackageOptions{})
+}
+
+// InstallPackagesWithOptions installs pkgs with the detected package manager.
+// Retries stop once ctx is done.
+func InstallPackagesWithOptions(ctx context.Context, pkgs []string, opts PackageOptions) error {
+	LogInfo("Installing packages", "packages", pkgs, "extra_packages", opts.ExtraPackages, "download_first", opts.DownloadFirst, "download_concurrency", opts.DownloadConcurrency)
 
 	manager, err := detectPackageManager()
 	if err != nil {
@@ -35,32 +83,145 @@ func InstallPackages(pkgs []string) error {
 		return err
 	}
 
-	var args []string
+	return installPackagesWith(ctx, manager, pkgs, opts)
+}
+
+// withExtraPackages appends the extra packages not already in pkgs.
+func withExtraPackages(pkgs, extra []string) []string {
+	all := append([]string{}, pkgs...)
+	for _, pkg := range extra {
+		if !slices.Contains(all, pkg) {
+			all = append(all, pkg)
+		}
+	}
+	return all
+}
+
+// installPackagesWith installs the missing packages of pkgs with manager.
+func installPackagesWith(ctx context.Context, manager string, pkgs []string, opts PackageOptions) error {
+	if _, err := packageCommands(manager, nil, PackageOptions{}); err != nil {
+		LogError("Unsupported package manager", nil, "manager", manager)
+		return err
+	}
+
+	missing := missingPackages(manager, withExtraPackages(pkgs, opts.ExtraPackages))
+	if len(missing) == 0 {
+		LogInfo("All packages already installed", "manager", manager, "packages", pkgs)
+		fmt.Println("All packages already installed")
+		return nil
+	}
+	if len(missing) < len(pkgs) {
+		LogInfo("Some packages already installed", "manager", manager, "missing", missing)
+	}
+
+	commands, err := packageCommands(manager, missing, opts)
+	if err != nil {
+		return err
+	}
+	if len(commands) > 1 {
+		fmt.Printf("Downloading packages with %s: %s\n", manager, strings.Join(missing, " "))
+		if output, err := runPackageCommand(ctx, "Package download", manager, commands[0]); err != nil {
+			LogError("Failed to download packages", err, "manager", manager, "packages", missing, "output", output)
+			return fmt.Errorf("failed to download packages: %v", err)
+		}
+		LogInfo("Packages downloaded", "manager", manager, "packages", missing)
+	}
+
+	fmt.Printf("Installing packages with %s: %s\n", manager, strings.Join(missing, " "))
+	if output, err := runPackageCommand(ctx, "Package installation", manager, commands[len(commands)-1]); err != nil {
+		LogError("Failed to install packages", err, "manager", manager, "packages", missing, "output", output)
+		return fmt.Errorf("failed to install packages: %v", err)
+	}
+
+	LogInfo("Packages installed successfully", "manager", manager, "packages", missing)
+	return nil
+}
+
+// packageRetryPolicy retries package manager runs failing on the network,
+// tests shorten it.
+var packageRetryPolicy = RetryPolicy{Retries: 2, Delay: 5 * time.Second}
+
+// runPackageCommand runs manager with args, retrying when its output shows a
+// network failure, and returns the output of the last attempt.
+func runPackageCommand(ctx context.Context, what, manager string, args []string) (string, error) {
+	var output string
+	err := Retry(ctx, what, packageRetryPolicy,
+		func(error) bool { return IsTransientCommandOutput(output) },
+		func() error {
+			var err error
+			output, err = commandOutput(manager, args...)
+			return err
+		})
+	return output, err
+}
+
+// packageCommands returns the arguments of the manager invocations installing
+// pkgs: a single install, or with opts.DownloadFirst a download-only pass
+// followed by the install from the cache.
+func packageCommands(manager string, pkgs []string, opts PackageOptions) ([][]string, error) {
+	var install, download []string
 	switch manager {
+	case "dnf":
+		install = []string{"install", "-y"}
+		download = []string{"install", "-y", "--downloadonly"}
+		if opts.DownloadConcurrency > 0 {
+			download = append(download, fmt.Sprintf("--setopt=max_parallel_downloads=%d", opts.DownloadConcurrency))
+		}
+	case "yum":
+		install = []string{"install", "-y"}
+		download = []string{"install", "-y", "--downloadonly"}
 	case "apt-get":
-		args = append([]string{"install", "-y"}, pkgs...)
-	case "dnf", "yum":
-		args = append([]string{"install", "-y"}, pkgs...)
+		install = []string{"install", "-y"}
+		download = []string{"install", "-y", "--download-only"}
 	case "zypper":
-		args = append([]string{"--non-interactive", "install"}, pkgs...)
+		// --non-interactive answers the prompts with their default, which
+		// declines the license agreement some SLES packages require
+		install = []string{"--non-interactive", "install", "--auto-agree-with-licenses"}
+		download = []string{"--non-interactive", "install", "--auto-agree-with-licenses", "--download-only"}
 	default:
-		LogError("Unsupported package manager", nil, "manager", manager)
-		return fmt.Errorf("unsupported package manager: %s", manager)
+		return nil, fmt.Errorf("unsupported package manager: %s", manager)
 	}
 
-	LogCommand(manager, args...)
-	cmd := exec.Command(manager, args...)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	install = append(install, pkgs...)
+	if !opts.DownloadFirst {
+		return [][]string{install}, nil
+	}
+	if opts.DownloadConcurrency > 0 && manager != "dnf" {
+		LogInfo("Download concurrency is only supported by dnf, using the manager default", "manager", manager)
+	}
+	download = append(download, pkgs...)
+	return [][]string{download, install}, nil
+}
 
-	fmt.Printf("Installing packages with %s: %s\n", manager, strings.Join(pkgs, " "))
-	if err := cmd.Run(); err != nil {
-		LogError("Failed to install packages", err, "manager", manager, "packages", pkgs)
-		return fmt.Errorf("failed to install packages: %v", err)
+// missingPackages returns the packages of pkgs that the package database of
+// manager does not report as installed.
+func missingPackages(manager string, pkgs []string) []string {
+	var missing []string
+	for _, pkg := range pkgs {
+		if !packageInstalled(manager, pkg) {
+			missing = append(missing, pkg)
+		}
 	}
+	return missing
+}
 
-	LogInfo("Packages installed successfully", "manager", manager, "packages", pkgs)
-	return nil
+// packageInstalled queries dpkg for apt-get and rpm for the other managers.
+// rpm is queried by capability so virtual package names are matched too.
+func packageInstalled(manager, pkg string) bool {
+	if manager == "apt-get" {
+		output, err := commandOutput("dpkg-query", "-W", "-f=${Status}", pkg)
+		return err == nil && strings.Contains(output, "install ok installed")
+	}
+	_, err := commandOutput("rpm", "-q", "--whatprovides", pkg)
+	return err == nil
+}
+
+// commandOutput runs a command and returns its combined output. Tests replace it
+// to simulate system tools.
+var commandOutput = func(command string, args ...string) (string, error) {
+	LogCommand(command, args...)
+	output, err := exec.Command(command, args...).CombinedOutput()
+	return string(output), err
 }
 
 func RunCommand(command string, args ...string) error {
@@ -171,33 +332,148 @@ func EnsureLineInSudoers(line string) error {
 	return err
 }
 
-func DownloadFile(url, filepath string) error {
-	LogInfo("Downloading file", "url", url, "path", filepath)
+// DownloadOptions holds the credentials and extra headers sent with a download,
+// e.g. for internal mirrors behind basic auth or requiring an artifact token.
+type DownloadOptions struct {
+	Username string
+	Password string
+	Headers  map[string]string
+}
+
+// ParseHeaders parses "Name: value" header flags into a header map.
+func ParseHeaders(headers []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(headers))
+	for _, header := range headers {
+		name, value, found := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (expected \"Name: value\")", RedactHeader(header))
+		}
+		parsed[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return parsed, nil
+}
+
+// RedactHeader hides the value of a "Name: value" header for logs and errors.
+func RedactHeader(header string) string {
+	name, _, found := strings.Cut(header, ":")
+	if !found {
+		return "xxxxx"
+	}
+	return name + ": xxxxx"
+}
+
+// RedactURL hides the password of a URL containing credentials for logs and errors.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// DownloadError is a failed HTTP request of DownloadFile, either a network
+// error or an unexpected HTTP status.
+type DownloadError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *DownloadError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed to download file: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to download file: HTTP %d", e.StatusCode)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// IsTransientDownloadError reports whether err is a download failure worth
+// retrying: a network error, a timeout, HTTP 408, 429 or a 5xx status.
+func IsTransientDownloadError(err error) bool {
+	var downloadErr *DownloadError
+	if !errors.As(err, &downloadErr) {
+		return false
+	}
+	if downloadErr.Err != nil {
+		return true
+	}
+	return downloadErr.StatusCode == http.StatusRequestTimeout ||
+		downloadErr.StatusCode == http.StatusTooManyRequests ||
+		downloadErr.StatusCode >= 500
+}
+
+// downloadRetryPolicy retries downloads on transient failures, tests shorten it.
+var downloadRetryPolicy = RetryPolicy{Retries: 2, Delay: 2 * time.Second}
+
+// DownloadFileWithRetry downloads url to filepath like DownloadFile, retrying
+// transient failures until ctx is done.
+func DownloadFileWithRetry(ctx context.Context, url, filepath string, opts DownloadOptions) error {
+	return Retry(ctx, "Download of "+RedactURL(url), downloadRetryPolicy, IsTransientDownloadError, func() error {
+		return DownloadFile(url, filepath, opts)
+	})
+}
+
+// userAgent is the User-Agent header of downloads, set by SetUserAgent.
+var userAgent = DefaultUserAgent()
+
+// DefaultUserAgent returns the User-Agent header sent with downloads unless
+// SetUserAgent replaces it, bluebanquise-installer/<release>.
+func DefaultUserAgent() string {
+	return "bluebanquise-installer/" + version.Release
+}
+
+// SetUserAgent makes downloads send agent as their User-Agent header.
+func SetUserAgent(agent string) error {
+	if strings.TrimSpace(agent) == "" || strings.ContainsAny(agent, "\r\n") {
+		return fmt.Errorf("invalid user agent %q", agent)
+	}
+	userAgent = agent
+	return nil
+}
+
+// DownloadFile downloads url to filepath, sending the User-Agent and the
+// credentials and headers of opts, which may replace it.
+func DownloadFile(url, filepath string, opts DownloadOptions) error {
+	headerNames := make([]string, 0, len(opts.Headers))
+	for name := range opts.Headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	LogInfo("Downloading file", "url", RedactURL(url), "path", filepath, "basic_auth", opts.Username != "", "headers", headerNames)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, http.NoBody)
 	if err != nil {
-		LogError("Failed to create request", err, "url", url)
+		LogError("Failed to create request", err, "url", RedactURL(url))
 		return fmt.Errorf("failed to create request: %v", err)
 	}
+	req.Header.Set("User-Agent", userAgent)
+	if opts.Username != "" {
+		req.SetBasicAuth(opts.Username, opts.Password)
+	}
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		LogError("Failed to download file", err, "url", url)
-		return fmt.Errorf("failed to download file: %v", err)
+		LogError("Failed to download file", err, "url", RedactURL(url))
+		return &DownloadError{Err: err}
 	}
 	defer func() {
 		if closeErr := resp.Body.Close(); closeErr != nil {
-			LogWarning("Failed to close response body", "error", closeErr, "url", url)
+			LogWarning("Failed to close response body", "error", closeErr, "url", RedactURL(url))
 		}
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		LogError("Failed to download file", nil, "status", resp.StatusCode, "url", url)
-		return fmt.Errorf("failed to download file: HTTP %d", resp.StatusCode)
+		LogError("Failed to download file", nil, "status", resp.StatusCode, "url", RedactURL(url))
+		return &DownloadError{StatusCode: resp.StatusCode}
 	}
 
 	file, err := os.Create(filepath)
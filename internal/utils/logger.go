@@ -1,41 +1,197 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/version"
+)
+
+const defaultLogDir = "/var/log/bluebanquise"
+
+// Default permissions of the log directory and file, the log is not world-readable
+// since it records commands and URLs.
+const (
+	defaultLogDirMode  os.FileMode = 0750
+	defaultLogFileMode os.FileMode = 0640
 )
 
-var Logger *slog.Logger
+// Logger writes to the console only until InitLogger opens the log file, so
+// the errors of an invalid command line are still reported.
+var Logger = newConsoleLogger()
+
+// newConsoleLogger returns a logger writing to the console only.
+func newConsoleLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(console, &slog.HandlerOptions{Level: slog.LevelInfo}))
+}
+
+// logFilePath is the log file resolved by InitLogger.
+var logFilePath string
 
-// InitLogger initializes the logger for BlueBanquise installer.
-func InitLogger() error {
-	// Try to use LOG_DIR environment variable first
-	logDir := os.Getenv("LOG_DIR")
-	if logDir == "" {
-		logDir = "/var/log/bluebanquise"
+// logFile is the open log file, closed when InitLogger runs again or by
+// CloseLogger.
+var logFile *os.File
+
+// consoleWriter forwards log output to a console target that can be changed or
+// disabled after InitLogger.
+type consoleWriter struct {
+	target   io.Writer
+	disabled bool
+}
+
+func (c *consoleWriter) Write(p []byte) (int, error) {
+	if c.disabled {
+		return len(p), nil
 	}
+	return c.target.Write(p)
+}
 
-	// Try to create log directory
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		// If we can't create /var/log/bluebanquise, try a temporary directory
-		if logDir == "/var/log/bluebanquise" {
-			logDir = os.TempDir()
-		} else {
-			return err
-		}
+var console = &consoleWriter{target: os.Stdout}
+
+// SetConsoleOutput redirects the console copy of the logs, e.g. to os.Stderr when
+// stdout must only carry command output.
+func SetConsoleOutput(w io.Writer) {
+	console.target = w
+}
+
+// SetLogToConsole enables or disables the console copy of the logs. When disabled,
+// the console only shows progress messages and the full detail goes to the log file.
+func SetLogToConsole(enabled bool) {
+	console.disabled = !enabled
+}
+
+var verbose bool
+
+// SetVerbose enables printing the output of successful external commands.
+func SetVerbose(v bool) {
+	verbose = v
+}
+
+// Verbose reports whether the output of successful external commands is printed.
+func Verbose() bool {
+	return verbose
+}
+
+var strict bool
+
+// SetStrict turns warnings that may leave a broken installation, such as pip
+// dependency conflicts, into errors.
+func SetStrict(s bool) {
+	strict = s
+}
+
+// Strict reports whether such warnings are errors.
+func Strict() bool {
+	return strict
+}
+
+// logDirMode and logFileMode are the octal permissions set by SetLogModes,
+// empty to read LOG_DIR_MODE and LOG_FILE_MODE.
+var logDirMode, logFileMode string
+
+// SetLogModes sets the octal permissions of the log directory and file applied
+// by InitLogger, over LOG_DIR_MODE and LOG_FILE_MODE. An empty value keeps the
+// one of the environment, or the default.
+func SetLogModes(dirMode, fileMode string) error {
+	if _, err := parseLogMode("--log-dir-mode", dirMode, defaultLogDirMode); err != nil {
+		return err
 	}
+	if _, err := parseLogMode("--log-file-mode", fileMode, defaultLogFileMode); err != nil {
+		return err
+	}
+	logDirMode, logFileMode = dirMode, fileMode
+	return nil
+}
 
-	// Create log file
-	logFile := filepath.Join(logDir, "bluebanquise-installer.log")
-	file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+// InitLogger initializes the logger for BlueBanquise installer and returns the resolved log file path.
+// SetLogModes, or else LOG_DIR_MODE and LOG_FILE_MODE, override the octal permissions of the log
+// directory and file.
+func InitLogger() (string, error) {
+	logDir, fallback := resolveLogDir()
+
+	dirMode, err := logMode(logDirMode, "--log-dir-mode", "LOG_DIR_MODE", defaultLogDirMode)
 	if err != nil {
-		return err
+		return "", err
+	}
+	fileMode, err := logMode(logFileMode, "--log-file-mode", "LOG_FILE_MODE", defaultLogFileMode)
+	if err != nil {
+		return "", err
+	}
+
+	return initLogger(logDir, fallback, dirMode, fileMode, os.Stderr)
+}
+
+// resolveLogDir returns the log directory: LOG_DIR, the logs directory of the
+// state directory or the default one. Only the default directory falls back to
+// a temporary one.
+func resolveLogDir() (string, bool) {
+	if logDir := os.Getenv("LOG_DIR"); logDir != "" {
+		return logDir, false
+	}
+	if stateDir != "" {
+		return filepath.Join(stateDir, "logs"), false
+	}
+	return defaultLogDir, true
+}
+
+// logMode parses the permissions set by SetLogModes, or else those of the
+// environment variable envName.
+func logMode(value, flagName, envName string, defaultMode os.FileMode) (os.FileMode, error) {
+	if value != "" {
+		return parseLogMode(flagName, value, defaultMode)
+	}
+	return logModeFromEnv(envName, defaultMode)
+}
+
+// logModeFromEnv parses the octal permissions in the environment variable name.
+func logModeFromEnv(name string, defaultMode os.FileMode) (os.FileMode, error) {
+	return parseLogMode(name, os.Getenv(name), defaultMode)
+}
+
+// parseLogMode parses the octal permissions value of the option name,
+// defaultMode when it is empty.
+func parseLogMode(name, value string, defaultMode os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return defaultMode, nil
 	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid %s %q: expected octal permissions such as 0640", name, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// initLogger opens the log file in logDir, falling back to TempDir() when allowed,
+// and reports the resolved path to notice. A log file opened by a previous call
+// is closed.
+func initLogger(logDir string, fallback bool, dirMode, fileMode os.FileMode, notice io.Writer) (string, error) {
+	file, path, err := openLogFile(logDir, dirMode, fileMode)
+	if err != nil {
+		if !fallback {
+			return "", err
+		}
+		// If we can't write to the default directory, try a temporary directory
+		file, path, err = openLogFile(TempDir(), dirMode, fileMode)
+		if err != nil {
+			return "", err
+		}
+	}
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logFile, logFilePath = file, path
+
+	// Report where the log goes once, so operators can find it on errors
+	fmt.Fprintf(notice, "Logging to %s\n", path)
 
 	// Create multi-writer for both file and console
-	multiWriter := io.MultiWriter(file, os.Stdout)
+	multiWriter := io.MultiWriter(file, console)
 
 	// Create logger with multi-writer
 	handler := slog.NewTextHandler(multiWriter, &slog.HandlerOptions{
@@ -46,14 +202,125 @@ func InitLogger() error {
 	// Set as default logger
 	slog.SetDefault(Logger)
 
-	// Log startup
-	Logger.Info("BlueBanquise installer started",
-		"version", "3.2.0",
-		"log_file", logFile)
+	// Log startup to the file only, the console already got the notice above
+	slog.New(slog.NewTextHandler(file, nil)).Info("BlueBanquise installer started",
+		"version", version.Version(),
+		"log_file", path)
+
+	return path, nil
+}
+
+// openLogFile creates logDir if needed and opens the installer log file in it.
+// The modes are enforced on the file and on the directory when it is created
+// here, regardless of the umask. Both are owned by root when running as root.
+// The file and the created directory are secured through their descriptor, so a
+// symlink planted in a shared directory such as the /tmp fallback cannot make
+// root change the mode or owner of another file.
+func openLogFile(logDir string, dirMode, fileMode os.FileMode) (*os.File, string, error) {
+	_, statErr := os.Lstat(logDir)
+	created := os.IsNotExist(statErr)
+	if err := os.MkdirAll(logDir, dirMode); err != nil {
+		return nil, "", err
+	}
+	if created {
+		if err := secureLogDir(logDir, dirMode); err != nil {
+			return nil, "", err
+		}
+	}
+
+	logFile := filepath.Join(logDir, "bluebanquise-installer.log")
+	file, err := openNoFollow(logFile, fileMode)
+	if err != nil {
+		return nil, "", err
+	}
+	// Also tighten log files created by older versions
+	if err := secureLogFile(file, fileMode); err != nil {
+		_ = file.Close()
+		return nil, "", err
+	}
+	return file, logFile, nil
+}
+
+// openNoFollow opens path for appending without following a symlink, or creates
+// it with mode when it does not exist, failing if it appeared meanwhile.
+// O_NONBLOCK keeps a FIFO planted there from blocking the open, it is then
+// refused here or by secureLogFile.
+func openNoFollow(path string, mode os.FileMode) (*os.File, error) {
+	const flags = os.O_WRONLY | os.O_APPEND | syscall.O_NOFOLLOW | syscall.O_NONBLOCK
+	file, err := os.OpenFile(path, flags, 0)
+	if os.IsNotExist(err) {
+		file, err = os.OpenFile(path, flags|os.O_CREATE|os.O_EXCL, mode)
+	}
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, fmt.Errorf("log file %s is a symlink, refusing to use it", path)
+	}
+	if errors.Is(err, syscall.ENXIO) {
+		// A FIFO without reader
+		return nil, fmt.Errorf("log file %s is not a regular file, refusing to use it", path)
+	}
+	return file, err
+}
 
+// secureLogFile refuses a log file that is not a regular file or has other
+// hard links, then sets mode on it and, when running as root, makes root its owner.
+func secureLogFile(file *os.File, mode os.FileMode) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("log file %s is not a regular file, refusing to use it", file.Name())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+		return fmt.Errorf("log file %s has other hard links, refusing to use it", file.Name())
+	}
+	return secureLogDescriptor(file, mode)
+}
+
+// secureLogDir sets mode on the log directory created at path and, when running
+// as root, makes root its owner, refusing a symlink put in its place.
+func secureLogDir(path string, mode os.FileMode) error {
+	dir, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open log directory %s: %v", path, err)
+	}
+	defer dir.Close()
+	return secureLogDescriptor(dir, mode)
+}
+
+// secureLogDescriptor sets mode on file and, when running as root, makes root its owner.
+func secureLogDescriptor(file *os.File, mode os.FileMode) error {
+	if err := file.Chmod(mode); err != nil {
+		return err
+	}
+	if os.Geteuid() == 0 {
+		return file.Chown(0, 0)
+	}
 	return nil
 }
 
+// CloseLogger flushes and closes the log file opened by InitLogger, the
+// following lines only go to the console. LogFilePath still names the file.
+func CloseLogger() {
+	if logFile == nil {
+		return
+	}
+	Logger = newConsoleLogger()
+	slog.SetDefault(Logger)
+	if err := logFile.Sync(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to flush log file %s: %v\n", logFilePath, err)
+	}
+	if err := logFile.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to close log file %s: %v\n", logFilePath, err)
+	}
+	logFile = nil
+}
+
+// LogFilePath returns the log file resolved by InitLogger, or an empty string before it ran.
+func LogFilePath() string {
+	return logFilePath
+}
+
 // InitTestLogger initializes the logger for testing.
 func InitTestLogger() {
 	// Create logger that writes to io.Discard for tests
@@ -64,6 +331,21 @@ func InitTestLogger() {
 	slog.SetDefault(Logger)
 }
 
+// WithPhase runs fn with a logger carrying phase as its "phase" field, so every
+// line logged during a step, including by the system package through the
+// default logger, can be filtered by phase. The previous logger is restored
+// when fn returns.
+func WithPhase(phase string, fn func() error) error {
+	prev, prevDefault := Logger, slog.Default()
+	Logger = prev.With("phase", phase)
+	slog.SetDefault(Logger)
+	defer func() {
+		Logger = prev
+		slog.SetDefault(prevDefault)
+	}()
+	return fn()
+}
+
 // LogCommand logs a command execution.
 func LogCommand(command string, args ...string) {
 	Logger.Info("Executing command",
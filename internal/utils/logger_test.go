@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitLoggerFallback(t *testing.T) {
+	defer InitTestLogger()
+
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+
+	// A directory below a regular file can never be created
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, os.WriteFile(blocker, []byte("file"), 0644))
+	primaryDir := filepath.Join(blocker, "logs")
+
+	notice := new(bytes.Buffer)
+	logFile, err := initLogger(primaryDir, true, defaultLogDirMode, defaultLogFileMode, notice)
+	require.NoError(t, err)
+
+	expected := filepath.Join(tempDir, "bluebanquise-installer.log")
+	assert.Equal(t, expected, logFile)
+	assert.Equal(t, expected, LogFilePath())
+	assert.Contains(t, notice.String(), expected)
+	assert.FileExists(t, expected)
+}
+
+func TestInitLoggerNoFallback(t *testing.T) {
+	defer InitTestLogger()
+
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, os.WriteFile(blocker, []byte("file"), 0644))
+
+	notice := new(bytes.Buffer)
+	_, err := initLogger(filepath.Join(blocker, "logs"), false, defaultLogDirMode, defaultLogFileMode, notice)
+	assert.Error(t, err)
+	assert.Empty(t, notice.String())
+}
+
+func TestInitLoggerPermissions(t *testing.T) {
+	defer InitTestLogger()
+
+	tests := []struct {
+		name     string
+		dirMode  os.FileMode
+		fileMode os.FileMode
+	}{
+		{name: "Secure defaults", dirMode: defaultLogDirMode, fileMode: defaultLogFileMode},
+		{name: "Custom modes", dirMode: 0700, fileMode: 0600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logDir := filepath.Join(t.TempDir(), "logs")
+			logFile, err := initLogger(logDir, false, tt.dirMode, tt.fileMode, new(bytes.Buffer))
+			require.NoError(t, err)
+
+			dirInfo, err := os.Stat(logDir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.dirMode, dirInfo.Mode().Perm())
+
+			fileInfo, err := os.Stat(logFile)
+			require.NoError(t, err)
+			assert.Equal(t, tt.fileMode, fileInfo.Mode().Perm())
+		})
+	}
+
+	t.Run("Existing world-readable file is tightened", func(t *testing.T) {
+		logDir := t.TempDir()
+		logFile := filepath.Join(logDir, "bluebanquise-installer.log")
+		require.NoError(t, os.WriteFile(logFile, nil, 0644))
+
+		_, err := initLogger(logDir, false, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+		require.NoError(t, err)
+
+		fileInfo, err := os.Stat(logFile)
+		require.NoError(t, err)
+		assert.Equal(t, defaultLogFileMode, fileInfo.Mode().Perm())
+	})
+}
+
+func TestLogModeFromEnv(t *testing.T) {
+	mode, err := logModeFromEnv("BB_TEST_LOG_MODE", defaultLogFileMode)
+	require.NoError(t, err)
+	assert.Equal(t, defaultLogFileMode, mode)
+
+	t.Setenv("BB_TEST_LOG_MODE", "0600")
+	mode, err = logModeFromEnv("BB_TEST_LOG_MODE", defaultLogFileMode)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), mode)
+
+	for _, invalid := range []string{"rw-r-----", "0999", "01777"} {
+		t.Setenv("BB_TEST_LOG_MODE", invalid)
+		_, err = logModeFromEnv("BB_TEST_LOG_MODE", defaultLogFileMode)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSetLogToConsole(t *testing.T) {
+	defer InitTestLogger()
+	defer SetConsoleOutput(os.Stdout)
+	defer SetLogToConsole(true)
+
+	stdout := new(bytes.Buffer)
+	SetConsoleOutput(stdout)
+
+	logDir := t.TempDir()
+	logFile, err := initLogger(logDir, false, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+	require.NoError(t, err)
+
+	LogInfo("shown on console")
+	assert.Contains(t, stdout.String(), "shown on console")
+
+	SetLogToConsole(false)
+	LogInfo("file only")
+	assert.NotContains(t, stdout.String(), "file only")
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "shown on console")
+	assert.Contains(t, string(data), "file only")
+}
+
+func TestWithPhase(t *testing.T) {
+	defer InitTestLogger()
+
+	buf := new(bytes.Buffer)
+	Logger = slog.New(slog.NewTextHandler(buf, nil))
+	slog.SetDefault(Logger)
+
+	err := WithPhase("install-packages", func() error {
+		LogInfo("inside phase")
+		slog.Info("inside phase from default logger")
+		return errors.New("failed")
+	})
+	assert.EqualError(t, err, "failed")
+	LogInfo("after phase")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "phase=install-packages")
+	assert.Contains(t, lines[1], "phase=install-packages")
+	assert.NotContains(t, lines[2], "phase=")
+}
+
+func TestInitLoggerRefusesUnsafeFile(t *testing.T) {
+	defer InitTestLogger()
+
+	target := filepath.Join(t.TempDir(), "shadow")
+	require.NoError(t, os.WriteFile(target, []byte("secret"), 0600))
+
+	tests := []struct {
+		name    string
+		plant   func(path string) error
+		wantErr string
+	}{
+		{name: "Symlink", plant: func(path string) error { return os.Symlink(target, path) }, wantErr: "symlink"},
+		{name: "Hard link", plant: func(path string) error { return os.Link(target, path) }, wantErr: "hard links"},
+		{name: "FIFO", plant: func(path string) error { return syscall.Mkfifo(path, 0644) }, wantErr: "not a regular file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logDir := t.TempDir()
+			require.NoError(t, tt.plant(filepath.Join(logDir, "bluebanquise-installer.log")))
+
+			_, err := initLogger(logDir, false, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+			assert.ErrorContains(t, err, tt.wantErr)
+
+			// The target keeps its mode
+			info, err := os.Stat(target)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		})
+	}
+}
+
+func TestSetLogModes(t *testing.T) {
+	defer func() { logDirMode, logFileMode = "", "" }()
+
+	t.Setenv("LOG_FILE_MODE", "0644")
+	require.NoError(t, SetLogModes("", "0600"))
+	mode, err := logMode(logFileMode, "--log-file-mode", "LOG_FILE_MODE", defaultLogFileMode)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), mode)
+
+	// Unset options keep the environment
+	require.NoError(t, SetLogModes("", ""))
+	mode, err = logMode(logFileMode, "--log-file-mode", "LOG_FILE_MODE", defaultLogFileMode)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), mode)
+
+	assert.ErrorContains(t, SetLogModes("0999", ""), "--log-dir-mode")
+	assert.ErrorContains(t, SetLogModes("", "rw-r-----"), "--log-file-mode")
+}
+
+func TestCloseLogger(t *testing.T) {
+	defer InitTestLogger()
+	defer SetLogToConsole(true)
+	SetLogToConsole(false)
+
+	// A second InitLogger closes the first file, CloseLogger the last one
+	_, err := initLogger(t.TempDir(), false, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+	require.NoError(t, err)
+	first := logFile
+	path, err := initLogger(t.TempDir(), false, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.ErrorIs(t, first.Sync(), os.ErrClosed)
+
+	LogInfo("Before close")
+	last := logFile
+	CloseLogger()
+	assert.Nil(t, logFile)
+	assert.ErrorIs(t, last.Sync(), os.ErrClosed)
+	assert.Equal(t, path, LogFilePath())
+
+	// Later lines no longer reach the closed file
+	LogInfo("After close")
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "Before close")
+	assert.NotContains(t, string(content), "After close")
+
+	CloseLogger()
+}
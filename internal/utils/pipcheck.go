@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// BrokenRequirement is a requirement of an installed package that pip check
+// found missing or at an incompatible version, as left by an interrupted pip
+// install.
+type BrokenRequirement struct {
+	// Package and Version name the installed package requiring Requirement.
+	Package     string `json:"package"`
+	Version     string `json:"version"`
+	Requirement string `json:"requirement"`
+	// Line is the pip check line reporting it.
+	Line string `json:"line"`
+}
+
+// pipCheckPattern matches a pip check line, e.g. "ansible-core 2.16.6 requires
+// jinja2, which is not installed." or "ansible-core 2.16.6 has requirement
+// jinja2>=3.0.0, but you have jinja2 2.11.3.".
+var pipCheckPattern = regexp.MustCompile(`^(\S+) (\S+) (?:requires|has requirement) ([A-Za-z0-9._-]+)`)
+
+// CheckVenvRequirements runs pip check with the python of the virtual
+// environment venvPath and returns the broken requirements it reports, none
+// when every installed package has its requirements.
+func CheckVenvRequirements(venvPath string) ([]BrokenRequirement, error) {
+	python3 := filepath.Join(venvPath, "bin", "python3")
+	output, err := commandOutput(python3, "-m", "pip", "check", "--disable-pip-version-check")
+	if err == nil {
+		LogInfo("pip check found no broken requirements", "venv", venvPath)
+		return nil, nil
+	}
+
+	broken := parsePipCheck(output)
+	if len(broken) == 0 {
+		LogError("pip check failed", err, "venv", venvPath, "output", output)
+		return nil, fmt.Errorf("pip check failed: %v, output: %s", err, strings.TrimSpace(output))
+	}
+	LogWarning("pip check found broken requirements", "venv", venvPath, "broken", len(broken), "output", output)
+	return broken, nil
+}
+
+// parsePipCheck returns the broken requirements of pip check output, other
+// lines such as unsupported platform notices are ignored.
+func parsePipCheck(output string) []BrokenRequirement {
+	var broken []BrokenRequirement
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		match := pipCheckPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		broken = append(broken, BrokenRequirement{
+			Package:     match[1],
+			Version:     match[2],
+			Requirement: normalizeProjectName(match[3]),
+			Line:        line,
+		})
+	}
+	return broken
+}
+
+// BrokenPackages returns the packages of broken pinned to their installed
+// version, e.g. ansible-core==2.16.6, once each, as given to ReinstallPackages.
+func BrokenPackages(broken []BrokenRequirement) []string {
+	var packages []string
+	for _, requirement := range broken {
+		pin := requirement.Package + "==" + requirement.Version
+		if !slices.Contains(packages, pin) {
+			packages = append(packages, pin)
+		}
+	}
+	return packages
+}
+
+// ReinstallPackages reinstalls packages in the virtual environment venvPath,
+// from the wheels of requirementsPath when it is set or from the network
+// otherwise, retrying network failures until ctx is done. The packages are
+// reinstalled with --no-deps, then installed again without --force-reinstall so
+// pip only installs the requirements that are missing or at an incompatible
+// version, leaving the consistent ones at their installed version.
+func ReinstallPackages(ctx context.Context, venvPath string, packages []string, requirementsPath string) error {
+	LogInfo("Reinstalling Python packages", "venv", venvPath, "packages", packages, "requirements_path", requirementsPath)
+	python3 := filepath.Join(venvPath, "bin", "python3")
+
+	var source []string
+	if requirementsPath != "" {
+		source = []string{"--no-index", "--find-links", requirementsPath}
+	} else {
+		source = PipTLSArgs()
+	}
+	passes := [][]string{
+		{"-m", "pip", "install", "--force-reinstall", "--no-deps"},
+		{"-m", "pip", "install"},
+	}
+
+	fmt.Printf("Reinstalling Python packages: %s\n", strings.Join(packages, " "))
+	for _, args := range passes {
+		args = append(append(args, source...), packages...)
+		var output string
+		err := Retry(ctx, "pip install", pipRetryPolicy,
+			func(error) bool { return requirementsPath == "" && isTransientPipFailure(output) },
+			func() error {
+				var err error
+				output, err = commandOutput(python3, args...)
+				return err
+			})
+		if err != nil {
+			LogError("Failed to reinstall python packages", err, "venv", venvPath, "packages", packages, "output", output)
+			return fmt.Errorf("failed to reinstall python packages: %v, output: %s", err, strings.TrimSpace(output))
+		}
+	}
+	LogInfo("Python packages reinstalled", "venv", venvPath, "packages", packages)
+	return nil
+}
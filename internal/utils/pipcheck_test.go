@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pipCheckFixture is the pip check output of a virtual environment whose pip
+// install was killed after removing jinja2 and before installing resolvelib.
+const pipCheckFixture = `ansible-core 2.16.6 requires jinja2, which is not installed.
+ansible-core 2.16.6 has requirement resolvelib<1.1.0,>=0.5.3, but you have resolvelib 1.1.0.
+ansible 9.5.1 requires ansible-core, which is not installed.
+`
+
+func TestCheckVenvRequirements(t *testing.T) {
+	InitTestLogger()
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	tests := []struct {
+		name       string
+		output     string
+		err        error
+		wantBroken []BrokenRequirement
+		wantErr    string
+	}{
+		{
+			name:   "Consistent",
+			output: "No broken requirements found.\n",
+		},
+		{
+			name:   "Broken dependencies",
+			output: pipCheckFixture,
+			err:    errors.New("exit status 1"),
+			wantBroken: []BrokenRequirement{
+				{Package: "ansible-core", Version: "2.16.6", Requirement: "jinja2", Line: "ansible-core 2.16.6 requires jinja2, which is not installed."},
+				{Package: "ansible-core", Version: "2.16.6", Requirement: "resolvelib", Line: "ansible-core 2.16.6 has requirement resolvelib<1.1.0,>=0.5.3, but you have resolvelib 1.1.0."},
+				{Package: "ansible", Version: "9.5.1", Requirement: "ansible-core", Line: "ansible 9.5.1 requires ansible-core, which is not installed."},
+			},
+		},
+		{
+			name:    "pip fails to run",
+			output:  "/venv/bin/python3: No module named pip\n",
+			err:     errors.New("exit status 1"),
+			wantErr: "pip check failed: exit status 1, output: /venv/bin/python3: No module named pip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commandOutput = func(command string, args ...string) (string, error) {
+				assert.Equal(t, filepath.Join("/venv", "bin", "python3"), command)
+				assert.Equal(t, []string{"-m", "pip", "check", "--disable-pip-version-check"}, args)
+				return tt.output, tt.err
+			}
+
+			broken, err := CheckVenvRequirements("/venv")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBroken, broken)
+		})
+	}
+}
+
+func TestBrokenPackages(t *testing.T) {
+	broken := parsePipCheck(pipCheckFixture)
+	assert.Equal(t, []string{"ansible-core==2.16.6", "ansible==9.5.1"}, BrokenPackages(broken))
+	assert.Empty(t, BrokenPackages(nil))
+}
+
+func TestReinstallPackages(t *testing.T) {
+	InitTestLogger()
+
+	original := commandOutput
+	defer func() { commandOutput = original }()
+
+	var calls [][]string
+	commandOutput = func(command string, args ...string) (string, error) {
+		calls = append(calls, args)
+		return "Successfully installed ansible-core-2.16.6 jinja2-3.1.4\n", nil
+	}
+
+	require.NoError(t, ReinstallPackages(context.Background(), "/venv", []string{"ansible-core==2.16.6"}, "/srv/requirements"))
+	require.NoError(t, ReinstallPackages(context.Background(), "/venv", []string{"ansible-core==2.16.6"}, ""))
+	assert.Equal(t, [][]string{
+		{"-m", "pip", "install", "--force-reinstall", "--no-deps", "--no-index", "--find-links", "/srv/requirements", "ansible-core==2.16.6"},
+		{"-m", "pip", "install", "--no-index", "--find-links", "/srv/requirements", "ansible-core==2.16.6"},
+		{"-m", "pip", "install", "--force-reinstall", "--no-deps", "ansible-core==2.16.6"},
+		{"-m", "pip", "install", "ansible-core==2.16.6"},
+	}, calls)
+
+	commandOutput = func(command string, args ...string) (string, error) {
+		return "ERROR: No matching distribution found for ansible-core==2.16.6", errors.New("exit status 1")
+	}
+	err := ReinstallPackages(context.Background(), "/venv", []string{"ansible-core==2.16.6"}, "/srv/requirements")
+	assert.ErrorContains(t, err, "No matching distribution found")
+}
@@ -1,18 +1,31 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lmagdanello/bluebanquise-installer/internal/system"
 )
 
-// DownloadRequirements downloads Python packages without installing them.
+// DownloadRequirements downloads Python packages for the host without installing them.
 func DownloadRequirements(requirements []string, downloadPath string) error {
-	LogInfo("Downloading Python requirements", "requirements", requirements, "path", downloadPath)
+	return DownloadRequirementsFor(requirements, downloadPath, nil)
+}
+
+// DownloadRequirementsFor downloads Python packages without installing them,
+// for target when set instead of the host.
+func DownloadRequirementsFor(requirements []string, downloadPath string, target *system.PythonTarget) error {
+	LogInfo("Downloading Python requirements", "requirements", requirements, "path", downloadPath, "target", target)
 
 	if len(requirements) == 0 {
 		LogError("No requirements provided", nil)
@@ -43,13 +56,17 @@ func DownloadRequirements(requirements []string, downloadPath string) error {
 	}
 
 	// Download packages using the OS-specific Python
-	LogCommand(pythonCmd, "-m", "pip", "download", "-r", requirementsFile, "-d", downloadPath)
-	cmd := exec.Command(pythonCmd, "-m", "pip", "download", "-r", requirementsFile, "-d", downloadPath)
+	args := PipDownloadArgs(requirementsFile, downloadPath, target)
+	LogCommand(pythonCmd, args...)
+	cmd := exec.Command(pythonCmd, args...)
 
 	// Capture output for debugging
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		LogError("Failed to download requirements", err, "requirements", requirements, "path", downloadPath, "output", string(output))
+		if project := missingWheelProject(string(output)); target != nil && project != "" {
+			return fmt.Errorf("no wheel of %s for %s, it may only be published as a source archive, which cannot be downloaded for another platform: download on a host of the target OS instead: %v", project, target, err)
+		}
 		return fmt.Errorf("failed to download requirements: %v, output: %s", err, string(output))
 	}
 
@@ -78,25 +95,170 @@ func DownloadRequirements(requirements []string, downloadPath string) error {
 		return fmt.Errorf("no packages were downloaded to %s", downloadPath)
 	}
 
+	// Pin requirements.txt to exactly what was downloaded so the offline install is deterministic
+	if err := writePinnedRequirements(downloadPath, requirementsFile); err != nil {
+		return err
+	}
+
+	// Record checksums so the offline side can detect corrupted artifacts
+	if err := WriteChecksumManifest(downloadPath); err != nil {
+		return err
+	}
+
 	LogInfo("Requirements downloaded successfully", "path", downloadPath, "requirements", requirements, "packages", packageCount)
 	return nil
 }
 
+// PipDownloadArgs returns the python arguments downloading requirementsFile to
+// downloadPath. For a target, pip only accepts wheels, of the target Python
+// and platforms.
+func PipDownloadArgs(requirementsFile, downloadPath string, target *system.PythonTarget) []string {
+	args := append([]string{"-m", "pip", "download", "-r", requirementsFile, "-d", downloadPath}, PipTLSArgs()...)
+	if target == nil {
+		return args
+	}
+
+	args = append(args, "--only-binary=:all:", "--python-version", target.PythonVersion)
+	for _, platform := range target.Platforms {
+		args = append(args, "--platform", platform)
+	}
+	return args
+}
+
+// missingDistributionPattern matches the pip error naming a requirement without
+// matching distribution.
+var missingDistributionPattern = regexp.MustCompile(`(?:No matching distribution found for|Could not find a version that satisfies the requirement) ([A-Za-z0-9._-]+)`)
+
+// missingWheelProject returns the requirement pip download found no
+// distribution for, or an empty string. With --only-binary=:all: this is a
+// project without wheel for the target.
+func missingWheelProject(output string) string {
+	if match := missingDistributionPattern.FindStringSubmatch(output); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// parseArtifactName extracts the normalized project name and version from a wheel or sdist file name.
+func parseArtifactName(fileName string) (string, string, bool) {
+	var base string
+	switch {
+	case strings.HasSuffix(fileName, ".whl"):
+		// name-version(-build)?-python-abi-platform.whl, name never contains dashes
+		parts := strings.Split(strings.TrimSuffix(fileName, ".whl"), "-")
+		if len(parts) < 5 {
+			return "", "", false
+		}
+		return normalizeProjectName(parts[0]), parts[1], true
+	case strings.HasSuffix(fileName, ".tar.gz"):
+		base = strings.TrimSuffix(fileName, ".tar.gz")
+	case strings.HasSuffix(fileName, ".tgz"):
+		base = strings.TrimSuffix(fileName, ".tgz")
+	case strings.HasSuffix(fileName, ".zip"):
+		base = strings.TrimSuffix(fileName, ".zip")
+	default:
+		return "", "", false
+	}
+
+	// name-version, older sdists may have dashes in the name so split on the
+	// last dash followed by a digit
+	for i := len(base) - 1; i > 0; i-- {
+		if base[i] == '-' && i+1 < len(base) && base[i+1] >= '0' && base[i+1] <= '9' {
+			return normalizeProjectName(base[:i]), base[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeProjectName normalizes a Python project name as pip does (PEP 503).
+func normalizeProjectName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "_", "-")
+	return strings.ReplaceAll(name, ".", "-")
+}
+
+// PinnedRequirements returns name==version lines for every package artifact in dir, sorted by name.
+func PinnedRequirements(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read download directory: %v", err)
+	}
+
+	pins := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name, version, ok := parseArtifactName(entry.Name())
+		if !ok {
+			continue
+		}
+		if existing, found := pins[name]; found && existing != version {
+			return nil, fmt.Errorf("several versions of %s downloaded: %s and %s", name, existing, version)
+		}
+		pins[name] = version
+	}
+
+	names := make([]string, 0, len(pins))
+	for name := range pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s==%s", name, pins[name]))
+	}
+	return lines, nil
+}
+
+// writePinnedRequirements rewrites requirementsFile with the pinned set of artifacts found in dir.
+func writePinnedRequirements(dir, requirementsFile string) error {
+	pins, err := PinnedRequirements(dir)
+	if err != nil {
+		LogError("Failed to compute pinned requirements", err, "path", dir)
+		return err
+	}
+
+	content := strings.Join(pins, "\n") + "\n"
+	if err := os.WriteFile(requirementsFile, []byte(content), 0644); err != nil {
+		LogError("Failed to write pinned requirements.txt", err, "file", requirementsFile)
+		return fmt.Errorf("failed to write pinned requirements.txt: %v", err)
+	}
+
+	LogInfo("Pinned requirements.txt written", "file", requirementsFile, "packages", len(pins))
+	return nil
+}
+
 // InstallRequirementsOffline installs Python packages from local directory.
-func InstallRequirementsOffline(venvPath, requirementsPath string) error {
-	LogInfo("Installing Python requirements offline", "venv", venvPath, "requirements_path", requirementsPath)
+// With verifyChecksums, the packages are first checked against the checksum
+// manifest of the directory, which must exist, right before pip reads them.
+func InstallRequirementsOffline(venvPath, requirementsPath string, verifyChecksums bool) error {
+	LogInfo("Installing Python requirements offline", "venv", venvPath, "requirements_path", requirementsPath, "verify_checksums", verifyChecksums)
 
 	if _, err := os.Stat(requirementsPath); os.IsNotExist(err) {
 		LogError("Requirements path does not exist", err, "path", requirementsPath)
 		return fmt.Errorf("requirements path does not exist: %s", requirementsPath)
 	}
 
+	if verifyChecksums {
+		if err := VerifyChecksumManifest(requirementsPath); err != nil {
+			LogError("Requirements checksum verification failed", err, "path", requirementsPath)
+			return fmt.Errorf("requirements checksum verification failed: %w", err)
+		}
+	}
+
 	requirementsFile := filepath.Join(requirementsPath, "requirements.txt")
 
-	// Check if requirements.txt exists
+	// A wheelhouse without requirements.txt provides the built-in requirements
 	if _, err := os.Stat(requirementsFile); os.IsNotExist(err) {
-		LogError("requirements.txt not found", err, "file", requirementsFile)
-		return fmt.Errorf("requirements.txt not found: %s", requirementsFile)
+		builtin, err := writeBuiltinRequirements()
+		if err != nil {
+			return err
+		}
+		defer os.Remove(builtin)
+		LogInfo("No requirements.txt, installing the built-in requirements from the wheelhouse", "path", requirementsPath, "requirements", system.PythonRequirements)
+		requirementsFile = builtin
 	}
 
 	// List contents of requirements directory for debug
@@ -113,33 +275,54 @@ func InstallRequirementsOffline(venvPath, requirementsPath string) error {
 		}
 	}
 
-	// Install packages from local directory using the OS-specific Python
-	pythonCmd, err := system.GetPythonCommand()
-	if err != nil {
-		LogError("Failed to get Python command", err)
-		return fmt.Errorf("failed to get Python command: %v", err)
+	// Install packages with the interpreter of the virtual environment, a
+	// separately resolved system Python may differ from the one that created it
+	python3 := filepath.Join(venvPath, "bin", "python3")
+	if _, err := os.Stat(python3); err != nil {
+		LogError("Virtual environment Python not found", err, "venv", venvPath)
+		return fmt.Errorf("virtual environment Python not found: %s", python3)
 	}
 
 	args := []string{"-m", "pip", "install", "--no-index", "--find-links", requirementsPath, "-r", requirementsFile}
 
 	fmt.Printf("Installing Python packages from local directory: %s\n", requirementsPath)
-	LogCommand(pythonCmd, args...)
-	cmd := exec.Command(pythonCmd, args...)
-
-	// Capture output for debugging
-	output, err := cmd.CombinedOutput()
+	output, err := commandOutput(python3, args...)
 	if err != nil {
-		LogError("Failed to install requirements offline", err, "venv", venvPath, "requirements_path", requirementsPath, "output", string(output))
-		return fmt.Errorf("failed to install requirements offline: %v, output: %s", err, string(output))
+		LogError("Failed to install requirements offline", err, "venv", venvPath, "requirements_path", requirementsPath, "output", output)
+		return fmt.Errorf("failed to install requirements offline: %v, output: %s", err, output)
 	}
 
-	LogInfo("pip install completed", "output", string(output))
+	LogInfo("pip install completed", "output", output)
+	if err := checkPipConflicts(output); err != nil {
+		return err
+	}
 	LogInfo("Requirements installed offline successfully", "venv", venvPath, "requirements_path", requirementsPath)
 	return nil
 }
 
-// InstallRequirements installs Python packages in a virtual environment.
-func InstallRequirements(venvPath string, requirements []string) error {
+// writeBuiltinRequirements writes the built-in requirements to a temporary
+// requirements file, removed by the caller.
+func writeBuiltinRequirements() (string, error) {
+	file, err := os.CreateTemp(TempDir(), "bluebanquise-requirements-*.txt")
+	if err != nil {
+		LogError("Failed to create temporary requirements file", err)
+		return "", fmt.Errorf("failed to create temporary requirements file: %v", err)
+	}
+	_, err = file.WriteString(strings.Join(system.PythonRequirements, "\n") + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		LogError("Failed to write temporary requirements file", err, "path", file.Name())
+		return "", fmt.Errorf("failed to write temporary requirements file: %v", err)
+	}
+	return file.Name(), nil
+}
+
+// InstallRequirements installs Python packages in a virtual environment,
+// retrying network failures until ctx is done.
+func InstallRequirements(ctx context.Context, venvPath string, requirements []string) error {
 	LogInfo("Installing Python requirements", "venv", venvPath, "requirements", requirements)
 
 	if len(requirements) == 0 {
@@ -149,23 +332,210 @@ func InstallRequirements(venvPath string, requirements []string) error {
 
 	python3 := filepath.Join(venvPath, "bin", "python3")
 
-	args := append([]string{"-m", "pip", "install", "--upgrade", "pip"}, requirements...)
+	args := pipInstallArgs(requirements)
 
 	fmt.Printf("Installing Python packages: %s\n", strings.Join(requirements, " "))
-	LogCommand(python3, args...)
-	cmd := exec.Command(python3, args...)
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	if err := cmd.Run(); err != nil {
-		LogError("Failed to install python packages", err, "venv", venvPath, "requirements", requirements)
-		return fmt.Errorf("failed to install python packages: %v", err)
+	var output string
+	err := Retry(ctx, "pip install", pipRetryPolicy,
+		func(error) bool { return isTransientPipFailure(output) },
+		func() error {
+			var err error
+			output, err = commandOutput(python3, args...)
+			return err
+		})
+	if err != nil {
+		LogError("Failed to install python packages", err, "venv", venvPath, "requirements", requirements, "output", output)
+		return fmt.Errorf("failed to install python packages: %v, output: %s", err, strings.TrimSpace(output))
+	}
+	LogInfo("pip install completed", "output", output)
+	if err := checkPipConflicts(output); err != nil {
+		return err
 	}
 
 	LogInfo("Python requirements installed successfully", "venv", venvPath, "requirements", requirements)
 	return nil
 }
 
+// pipConflictMarker starts the dependency conflicts pip reports while still exiting 0.
+const pipConflictMarker = "pip's dependency resolver does not currently take into account"
+
+// PipDependencyConflicts returns the dependency conflicts reported in pip output,
+// e.g. "foo 1.0 requires bar>=2, but you have bar 1.0 which is incompatible.".
+func PipDependencyConflicts(output string) []string {
+	index := strings.Index(output, pipConflictMarker)
+	if index < 0 {
+		return nil
+	}
+
+	var conflicts []string
+	for _, line := range strings.Split(output[index:], "\n")[1:] {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, " requires ") &&
+			(strings.Contains(line, "but you have") || strings.Contains(line, "which is not installed")) {
+			conflicts = append(conflicts, line)
+		}
+	}
+	return conflicts
+}
+
+// checkPipConflicts warns about the dependency conflicts in pip output, or fails in strict mode.
+func checkPipConflicts(output string) error {
+	conflicts := PipDependencyConflicts(output)
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	if Strict() {
+		LogError("pip reported dependency conflicts", nil, "conflicts", conflicts)
+		return fmt.Errorf("pip reported dependency conflicts:\n  %s", strings.Join(conflicts, "\n  "))
+	}
+
+	LogWarning("pip reported dependency conflicts", "conflicts", conflicts)
+	fmt.Println("Warning: pip reported dependency conflicts, the environment may be broken:")
+	for _, conflict := range conflicts {
+		fmt.Printf("  %s\n", conflict)
+	}
+	return nil
+}
+
+// pipRetryPolicy retries pip install on network failures, tests shorten it.
+var pipRetryPolicy = RetryPolicy{Retries: 2, Delay: 5 * time.Second}
+
+// transientPipErrors are pip output fragments of network failures worth retrying.
+// Resolution errors such as ResolutionImpossible are not retried.
+var transientPipErrors = []string{
+	"Read timed out",
+	"ReadTimeoutError",
+	"ConnectTimeoutError",
+	"NewConnectionError",
+	"Max retries exceeded",
+	"Connection reset by peer",
+	"Connection aborted",
+	"Connection refused",
+	"Temporary failure in name resolution",
+	"IncompleteRead",
+	"ProtocolError",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Time-out",
+}
+
+// isTransientPipFailure reports whether pip output shows a network failure.
+func isTransientPipFailure(output string) bool {
+	for _, fragment := range transientPipErrors {
+		if strings.Contains(output, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// pipInstallArgs returns the python3 arguments upgrading pip and installing requirements.
+func pipInstallArgs(requirements []string) []string {
+	args := append([]string{"-m", "pip", "install"}, PipTLSArgs()...)
+	args = append(args, "--upgrade", "pip")
+	return append(args, requirements...)
+}
+
+// ansibleVersionPattern matches a release version such as 9.2.0.
+var ansibleVersionPattern = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+$`)
+
+// ValidateAnsibleVersion checks that version is a supported ansible release version.
+func ValidateAnsibleVersion(version string) error {
+	if !ansibleVersionPattern.MatchString(version) {
+		return fmt.Errorf("invalid ansible version %q (expected MAJOR.MINOR.PATCH, e.g. 9.2.0)", version)
+	}
+	if major, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0]); major < 4 {
+		return fmt.Errorf("ansible version %s is not supported, 4.0.0 or later is required", version)
+	}
+	return nil
+}
+
+// PinAnsibleRequirements rewrites the ansible and ansible-core entries of
+// requirements for the given ansible release. ansible is pinned exactly and
+// ansible-core to the matching minor series (ansible N ships ansible-core
+// 2.(N+7)), pip then resolves the exact ansible-core required by ansible.
+// An empty version returns the requirements unchanged.
+func PinAnsibleRequirements(requirements []string, version string) ([]string, error) {
+	pinned := append([]string(nil), requirements...)
+	if version == "" {
+		return pinned, nil
+	}
+	if err := ValidateAnsibleVersion(version); err != nil {
+		return nil, err
+	}
+
+	major, _ := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	for i, requirement := range pinned {
+		switch normalizeProjectName(requirementName(requirement)) {
+		case "ansible":
+			pinned[i] = "ansible==" + version
+		case "ansible-core":
+			pinned[i] = fmt.Sprintf("ansible-core~=2.%d.0", major+7)
+		}
+	}
+	return pinned, nil
+}
+
+// MissingRequirementArtifacts returns the requirements of requirementsFile that
+// have no package artifact in dir, comparing normalized project names. Comments,
+// blank lines and pip options such as -r or --hash are skipped.
+func MissingRequirementArtifacts(requirementsFile, dir string) ([]string, error) {
+	content, err := os.ReadFile(requirementsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", requirementsFile, err)
+	}
+	var requirements []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "\\"))
+		if line == "" || strings.HasPrefix(line, "-") {
+			continue
+		}
+		requirements = append(requirements, line)
+	}
+	return missingArtifacts(requirements, dir)
+}
+
+// missingArtifacts returns the project names of requirements that have no
+// package artifact in dir.
+func missingArtifacts(requirements []string, dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read requirements directory: %v", err)
+	}
+
+	available := map[string]bool{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if name, _, ok := parseArtifactName(entry.Name()); ok {
+			available[name] = true
+		}
+	}
+
+	var missing []string
+	for _, requirement := range requirements {
+		name := requirementName(requirement)
+		if name != "" && !available[normalizeProjectName(name)] {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// requirementName returns the project name of a requirement specifier such as ansible>=9.
+func requirementName(requirement string) string {
+	end := strings.IndexAny(requirement, "<>=!~;[ ")
+	if end < 0 {
+		return requirement
+	}
+	return requirement[:end]
+}
+
 // RHEL 7.
 func ExportRHPython38(userHome string) error {
 	LogInfo("Exporting RHEL7 Python 3.8 environment", "home", userHome)
@@ -202,3 +572,143 @@ func ExportRHPython38(userHome string) error {
 	LogInfo("RHEL7 Python 3.8 environment exported successfully", "home", userHome)
 	return nil
 }
+
+// PackageStatus describes an installed Python package compared to its minimum version.
+type PackageStatus struct {
+	Name      string `json:"name"`
+	Installed string `json:"installed,omitempty"`
+	Minimum   string `json:"minimum"`
+	Missing   bool   `json:"missing"`
+	Outdated  bool   `json:"outdated"`
+}
+
+// ListVenvPackages returns the packages installed in the virtual environment, keyed by normalized name.
+func ListVenvPackages(venvPath string) (map[string]string, error) {
+	pip := filepath.Join(venvPath, "bin", "pip")
+	args := []string{"list", "--format=json", "--disable-pip-version-check"}
+
+	LogCommand(pip, args...)
+	output, err := exec.Command(pip, args...).Output()
+	if err != nil {
+		LogError("Failed to list Python packages", err, "venv", venvPath)
+		return nil, fmt.Errorf("failed to list Python packages: %v", err)
+	}
+
+	return parsePipList(output)
+}
+
+// ReadVenvPackages returns the packages installed in the virtual environment,
+// keyed by normalized name, from the names of its .dist-info directories. Unlike
+// ListVenvPackages it does not run pip.
+func ReadVenvPackages(venvPath string) (map[string]string, error) {
+	distInfos, err := filepath.Glob(filepath.Join(venvPath, "lib*", "python3*", "site-packages", "*.dist-info"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Python packages: %v", err)
+	}
+	if len(distInfos) == 0 {
+		return nil, fmt.Errorf("no Python packages found in %s", venvPath)
+	}
+
+	packages := make(map[string]string, len(distInfos))
+	for _, distInfo := range distInfos {
+		name, version, found := strings.Cut(strings.TrimSuffix(filepath.Base(distInfo), ".dist-info"), "-")
+		if !found || name == "" {
+			continue
+		}
+		packages[normalizeProjectName(name)] = version
+	}
+	return packages, nil
+}
+
+// parsePipList parses the output of pip list --format=json. Lines printed
+// before the JSON document (e.g. notices from older pip versions) are ignored.
+func parsePipList(output []byte) (map[string]string, error) {
+	start := bytes.IndexByte(output, '[')
+	if start < 0 {
+		return nil, fmt.Errorf("unexpected pip list output")
+	}
+
+	var entries []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(output[start:], &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse pip list output: %v", err)
+	}
+
+	packages := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "" {
+			continue
+		}
+		packages[normalizeProjectName(entry.Name)] = entry.Version
+	}
+	return packages, nil
+}
+
+// CheckPackageVersions compares installed packages against the given minimums, in order.
+func CheckPackageVersions(installed map[string]string, minimums []system.PythonPackageMinimum) []PackageStatus {
+	statuses := make([]PackageStatus, 0, len(minimums))
+	for _, minimum := range minimums {
+		status := PackageStatus{Name: minimum.Name, Minimum: minimum.Version}
+		version, found := installed[normalizeProjectName(minimum.Name)]
+		if !found {
+			status.Missing = true
+		} else {
+			status.Installed = version
+			status.Outdated = CompareVersions(version, minimum.Version) < 0
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// CompareVersions compares the numeric release segments of two versions and
+// returns -1, 0 or 1. Pre-release and local suffixes are ignored.
+func CompareVersions(a, b string) int {
+	as, bs := versionSegments(a), versionSegments(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionSegments returns the leading numeric segments of a version, e.g. 2.15.0rc1 gives [2 15 0].
+func versionSegments(version string) []int {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexByte(version, '!'); i >= 0 {
+		version = version[i+1:]
+	}
+
+	var segments []int
+	for _, part := range strings.Split(version, ".") {
+		end := 0
+		for end < len(part) && part[end] >= '0' && part[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			break
+		}
+		segments = append(segments, n)
+		if end < len(part) {
+			break
+		}
+	}
+	return segments
+}
@@ -0,0 +1,427 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lmagdanello/bluebanquise-installer/internal/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArtifactName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		name     string
+		version  string
+		ok       bool
+	}{
+		{"ansible_core-2.17.1-py3-none-any.whl", "ansible-core", "2.17.1", true},
+		{"PyMySQL-1.1.1-py3-none-any.whl", "pymysql", "1.1.1", true},
+		{"MarkupSafe-2.1.5-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl", "markupsafe", "2.1.5", true},
+		{"ClusterShell-1.9.2.tar.gz", "clustershell", "1.9.2", true},
+		{"ansible-core-2.15.0.tar.gz", "ansible-core", "2.15.0", true},
+		{"zope.interface-6.4.zip", "zope-interface", "6.4", true},
+		{"requirements.txt", "", "", false},
+		{"SHA256SUMS", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			name, version, ok := parseArtifactName(tt.fileName)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.name, name)
+			assert.Equal(t, tt.version, version)
+		})
+	}
+}
+
+func TestWritePinnedRequirements(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"ansible-10.1.0-py3-none-any.whl",
+		"ansible_core-2.17.1-py3-none-any.whl",
+		"Jinja2-3.1.4-py3-none-any.whl",
+		"ClusterShell-1.9.2.tar.gz",
+	}
+	for _, file := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte("package"), 0644))
+	}
+	requirementsFile := filepath.Join(dir, "requirements.txt")
+	require.NoError(t, os.WriteFile(requirementsFile, []byte("ansible\nansible-core\njinja2\nclustershell"), 0644))
+
+	require.NoError(t, writePinnedRequirements(dir, requirementsFile))
+
+	data, err := os.ReadFile(requirementsFile)
+	require.NoError(t, err)
+	assert.Equal(t, "ansible==10.1.0\nansible-core==2.17.1\nclustershell==1.9.2\njinja2==3.1.4\n", string(data))
+}
+
+func TestPinnedRequirementsConflictingVersions(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "jinja2-3.1.3-py3-none-any.whl"), []byte("a"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "Jinja2-3.1.4-py3-none-any.whl"), []byte("b"), 0644))
+
+	_, err := PinnedRequirements(dir)
+	assert.Error(t, err)
+}
+
+const pipListFixture = `WARNING: pip is configured with locations that require TLS/SSL.
+[{"name": "ansible", "version": "9.5.1"}, {"name": "ansible-core", "version": "2.16.6"}, {"name": "ClusterShell", "version": "1.9.2"}, {"name": "Jinja2", "version": "3.1.4"}, {"name": "MarkupSafe", "version": "2.1.5"}, {"name": "netaddr", "version": "0.7.19"}, {"name": "pip", "version": "24.0"}]
+`
+
+func TestParsePipList(t *testing.T) {
+	packages, err := parsePipList([]byte(pipListFixture))
+	require.NoError(t, err)
+	assert.Equal(t, "9.5.1", packages["ansible"])
+	assert.Equal(t, "2.16.6", packages["ansible-core"])
+	assert.Equal(t, "1.9.2", packages["clustershell"])
+	assert.Equal(t, "3.1.4", packages["jinja2"])
+	assert.Equal(t, "2.1.5", packages["markupsafe"])
+	assert.Len(t, packages, 7)
+
+	_, err = parsePipList([]byte("not json"))
+	assert.Error(t, err)
+
+	packages, err = parsePipList([]byte("[]"))
+	require.NoError(t, err)
+	assert.Empty(t, packages)
+}
+
+func TestReadVenvPackages(t *testing.T) {
+	venv := t.TempDir()
+	sitePackages := filepath.Join(venv, "lib", "python3.12", "site-packages")
+	for _, name := range []string{"ansible-9.5.1.dist-info", "ansible_core-2.16.6.dist-info", "MarkupSafe-2.1.5.dist-info", "ansible"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sitePackages, name), 0755))
+	}
+
+	packages, err := ReadVenvPackages(venv)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ansible": "9.5.1", "ansible-core": "2.16.6", "markupsafe": "2.1.5"}, packages)
+
+	_, err = ReadVenvPackages(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestMissingRequirementArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"ansible-9.5.1-py3-none-any.whl",
+		"ansible_core-2.16.6-py3-none-any.whl",
+		"Jinja2-3.1.4-py3-none-any.whl",
+		"ClusterShell-1.9.2.tar.gz",
+		"requirements.txt",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+
+	requirements := filepath.Join(dir, "requirements.txt")
+	content := `# BlueBanquise requirements
+ansible==9.5.1
+ansible-core>=2.16 ; python_version >= "3.10"
+jinja2==3.1.4 \
+    --hash=sha256:abc
+clustershell
+netaddr==0.8.0  # not downloaded
+-r extra.txt
+python_dateutil[extras]
+`
+	require.NoError(t, os.WriteFile(requirements, []byte(content), 0644))
+
+	missing, err := MissingRequirementArtifacts(requirements, dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"netaddr", "python_dateutil"}, missing)
+
+	_, err = MissingRequirementArtifacts(filepath.Join(dir, "missing.txt"), dir)
+	assert.Error(t, err)
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2.16.6", "2.14.0", 1},
+		{"2.14", "2.14.0", 0},
+		{"0.7.19", "0.8.0", -1},
+		{"2.15.0rc1", "2.15.0", 0},
+		{"1.10", "1.9", 1},
+		{"3.1.4.post1", "3.1.4", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			assert.Equal(t, tt.expected, CompareVersions(tt.a, tt.b))
+		})
+	}
+}
+
+func TestCheckPackageVersions(t *testing.T) {
+	installed, err := parsePipList([]byte(pipListFixture))
+	require.NoError(t, err)
+
+	statuses := CheckPackageVersions(installed, []system.PythonPackageMinimum{
+		{Name: "ansible", Version: "7.0.0"},
+		{Name: "netaddr", Version: "0.8.0"},
+		{Name: "jmespath", Version: "1.0.0"},
+	})
+
+	assert.Equal(t, []PackageStatus{
+		{Name: "ansible", Installed: "9.5.1", Minimum: "7.0.0"},
+		{Name: "netaddr", Installed: "0.7.19", Minimum: "0.8.0", Outdated: true},
+		{Name: "jmespath", Minimum: "1.0.0", Missing: true},
+	}, statuses)
+}
+
+func TestValidateAnsibleVersion(t *testing.T) {
+	tests := []struct {
+		version     string
+		expectError bool
+	}{
+		{"9.2.0", false},
+		{"10.0.1", false},
+		{"4.0.0", false},
+		{"3.4.0", true},
+		{"9.2", true},
+		{"v9.2.0", true},
+		{"9.2.0rc1", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			err := ValidateAnsibleVersion(tt.version)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPinAnsibleRequirements(t *testing.T) {
+	requirements := []string{"ansible", "ansible-core>=2.14", "netaddr", "jinja2"}
+
+	pinned, err := PinAnsibleRequirements(requirements, "9.2.0")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ansible==9.2.0", "ansible-core~=2.16.0", "netaddr", "jinja2"}, pinned)
+	assert.Equal(t, "ansible", requirements[0], "input must not be modified")
+
+	args := pipInstallArgs(pinned)
+	assert.Equal(t, []string{"-m", "pip", "install", "--upgrade", "pip"}, args[:5])
+	assert.Contains(t, args, "ansible==9.2.0")
+	assert.Contains(t, args, "ansible-core~=2.16.0")
+	assert.NotContains(t, args, "ansible")
+
+	unchanged, err := PinAnsibleRequirements(requirements, "")
+	require.NoError(t, err)
+	assert.Equal(t, requirements, unchanged)
+
+	_, err = PinAnsibleRequirements(requirements, "latest")
+	assert.Error(t, err)
+}
+
+func TestInstallRequirementsRetry(t *testing.T) {
+	InitTestLogger()
+
+	originalRunner, originalPolicy := commandOutput, pipRetryPolicy
+	defer func() { commandOutput, pipRetryPolicy = originalRunner, originalPolicy }()
+	pipRetryPolicy.Delay = 0
+
+	timeout := "WARNING: Retrying (Retry(total=4)) after connection broken by 'ReadTimeoutError(\"HTTPSConnectionPool(host='pypi.org', port=443): Read timed out.\")'"
+	resolution := "ERROR: ResolutionImpossible: for help visit https://pip.pypa.io/en/latest/topics/dependency-resolution"
+
+	tests := []struct {
+		name          string
+		outputs       []string
+		expectedCalls int
+		expectError   bool
+	}{
+		{name: "Fails twice then succeeds", outputs: []string{timeout, timeout, ""}, expectedCalls: 3},
+		{name: "Network failures exhaust attempts", outputs: []string{timeout, timeout, timeout, ""}, expectedCalls: 3, expectError: true},
+		{name: "Resolution error is not retried", outputs: []string{resolution, ""}, expectedCalls: 1, expectError: true},
+		{name: "Succeeds first time", outputs: []string{""}, expectedCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			commandOutput = func(command string, args ...string) (string, error) {
+				output := tt.outputs[calls]
+				calls++
+				assert.Equal(t, filepath.Join("/venv", "bin", "python3"), command)
+				assert.Equal(t, pipInstallArgs([]string{"ansible"}), args)
+				if output != "" {
+					return output, errors.New("exit status 1")
+				}
+				return "Successfully installed ansible", nil
+			}
+
+			err := InstallRequirements(context.Background(), "/venv", []string{"ansible"})
+			assert.Equal(t, tt.expectedCalls, calls)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+const pipConflictFixture = `Requirement already satisfied: pip in /var/lib/bluebanquise/ansible_venv/lib/python3.12/site-packages (24.0)
+Installing collected packages: jinja2, ansible-core
+  Attempting uninstall: jinja2
+    Found existing installation: Jinja2 3.1.4
+    Successfully uninstalled Jinja2-3.1.4
+ERROR: pip's dependency resolver does not currently take into account all the packages that are installed. This behaviour is the source of the following dependency conflicts.
+ansible-core 2.16.6 requires jinja2>=3.0.0, but you have jinja2 2.11.3 which is incompatible.
+ansible 9.5.1 requires resolvelib<1.1.0,>=0.5.3, which is not installed.
+Successfully installed ansible-core-2.16.6 jinja2-2.11.3
+`
+
+func TestPipDependencyConflicts(t *testing.T) {
+	assert.Equal(t, []string{
+		"ansible-core 2.16.6 requires jinja2>=3.0.0, but you have jinja2 2.11.3 which is incompatible.",
+		"ansible 9.5.1 requires resolvelib<1.1.0,>=0.5.3, which is not installed.",
+	}, PipDependencyConflicts(pipConflictFixture))
+
+	assert.Empty(t, PipDependencyConflicts("Successfully installed ansible-9.5.1\n"))
+}
+
+func TestInstallRequirementsConflicts(t *testing.T) {
+	InitTestLogger()
+
+	originalRunner := commandOutput
+	defer func() { commandOutput = originalRunner }()
+	defer SetStrict(false)
+
+	commandOutput = func(command string, args ...string) (string, error) {
+		return pipConflictFixture, nil
+	}
+
+	SetStrict(false)
+	assert.NoError(t, InstallRequirements(context.Background(), "/venv", []string{"ansible"}))
+
+	SetStrict(true)
+	err := InstallRequirements(context.Background(), "/venv", []string{"ansible"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "jinja2 2.11.3 which is incompatible")
+}
+
+func TestInstallRequirementsOfflineUsesVenvPython(t *testing.T) {
+	InitTestLogger()
+
+	venv := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(venv, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "bin", "python3"), []byte(""), 0755))
+	requirements := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(requirements, "requirements.txt"), []byte("ansible==9.2.0\n"), 0644))
+
+	originalRunner := commandOutput
+	defer func() { commandOutput = originalRunner }()
+
+	var commands []string
+	commandOutput = func(command string, args ...string) (string, error) {
+		commands = append(commands, command)
+		return "Successfully installed ansible-9.2.0\n", nil
+	}
+
+	require.NoError(t, InstallRequirementsOffline(venv, requirements, false))
+	assert.Equal(t, []string{filepath.Join(venv, "bin", "python3")}, commands)
+
+	err := InstallRequirementsOffline(t.TempDir(), requirements, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "virtual environment Python not found")
+}
+
+func TestInstallRequirementsOfflineVerifyChecksums(t *testing.T) {
+	InitTestLogger()
+
+	venv := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(venv, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "bin", "python3"), []byte(""), 0755))
+	requirements := t.TempDir()
+	wheel := filepath.Join(requirements, "ansible-9.2.0-py3-none-any.whl")
+	require.NoError(t, os.WriteFile(wheel, []byte("wheel"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(requirements, "requirements.txt"), []byte("ansible==9.2.0\n"), 0644))
+
+	originalRunner := commandOutput
+	defer func() { commandOutput = originalRunner }()
+
+	installs := 0
+	commandOutput = func(command string, args ...string) (string, error) {
+		installs++
+		return "Successfully installed ansible-9.2.0\n", nil
+	}
+
+	// A manifest is required
+	err := InstallRequirementsOffline(venv, requirements, true)
+	assert.ErrorIs(t, err, ErrNoChecksumManifest)
+
+	require.NoError(t, WriteChecksumManifest(requirements))
+	require.NoError(t, InstallRequirementsOffline(venv, requirements, true))
+	assert.Equal(t, 1, installs)
+
+	// A wheel changed after the prerequisite check is not installed
+	require.NoError(t, os.WriteFile(wheel, []byte("tampered"), 0644))
+	err = InstallRequirementsOffline(venv, requirements, true)
+	assert.ErrorContains(t, err, "requirements checksum verification failed")
+	assert.Equal(t, 1, installs)
+
+	// Verification stays opt-in
+	require.NoError(t, InstallRequirementsOffline(venv, requirements, false))
+	assert.Equal(t, 2, installs)
+}
+
+func TestInstallRequirementsOfflineWheelhouse(t *testing.T) {
+	InitTestLogger()
+
+	venv := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(venv, "bin"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(venv, "bin", "python3"), []byte(""), 0755))
+	wheelhouse := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(wheelhouse, "ansible-9.2.0-py3-none-any.whl"), []byte("test"), 0644))
+
+	originalRunner := commandOutput
+	defer func() { commandOutput = originalRunner }()
+
+	var args []string
+	var requirements string
+	commandOutput = func(command string, commandArgs ...string) (string, error) {
+		args = commandArgs
+		data, err := os.ReadFile(commandArgs[len(commandArgs)-1])
+		require.NoError(t, err)
+		requirements = string(data)
+		return "Successfully installed ansible-9.2.0\n", nil
+	}
+
+	require.NoError(t, InstallRequirementsOffline(venv, wheelhouse, false))
+	require.Len(t, args, 8)
+	assert.Equal(t, []string{"-m", "pip", "install", "--no-index", "--find-links", wheelhouse, "-r"}, args[:7])
+	assert.Equal(t, strings.Join(system.PythonRequirements, "\n")+"\n", requirements, "the built-in requirements are installed")
+	assert.NoFileExists(t, args[7], "temporary requirements file is removed")
+}
+
+func TestPipDownloadArgs(t *testing.T) {
+	assert.Equal(t, []string{"-m", "pip", "download", "-r", "/tmp/req/requirements.txt", "-d", "/tmp/req"},
+		PipDownloadArgs("/tmp/req/requirements.txt", "/tmp/req", nil))
+
+	target := &system.PythonTarget{OSID: "rhel", Version: "7", PythonVersion: "3.8", Platforms: []string{"manylinux_2_17_x86_64", "manylinux2014_x86_64"}}
+	assert.Equal(t, []string{
+		"-m", "pip", "download", "-r", "/tmp/req/requirements.txt", "-d", "/tmp/req",
+		"--only-binary=:all:", "--python-version", "3.8",
+		"--platform", "manylinux_2_17_x86_64", "--platform", "manylinux2014_x86_64",
+	}, PipDownloadArgs("/tmp/req/requirements.txt", "/tmp/req", target))
+}
+
+func TestMissingWheelProject(t *testing.T) {
+	output := `ERROR: Could not find a version that satisfies the requirement ClusterShell (from versions: none)
+ERROR: No matching distribution found for ClusterShell`
+	assert.Equal(t, "ClusterShell", missingWheelProject(output))
+	assert.Equal(t, "", missingWheelProject("ERROR: HTTP error 503 while getting https://pypi.org/simple/ansible/"))
+}
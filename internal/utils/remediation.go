@@ -0,0 +1,56 @@
+package utils
+
+import "errors"
+
+// Codes of the validation errors, stable identifiers of their cause.
+const (
+	CodeUnsupportedOS           = "unsupported-os"
+	CodeCollectionsPathMissing  = "collections-path-missing"
+	CodeCollectionsNotFound     = "collections-not-found"
+	CodeRequirementsPathMissing = "requirements-path-missing"
+	CodeRequirementsNotFound    = "requirements-not-found"
+	CodeOfflineBundleMissing    = "offline-bundle-missing"
+)
+
+// Remediations of the validation errors.
+const (
+	remediationDownloadCollections = "run `bluebanquise-installer download --collections --path <dir>` on a connected host, " +
+		"then copy <dir>/collections here and pass it to --collections-path"
+	remediationDownloadRequirements = "run `bluebanquise-installer download --requirements --path <dir>` on a connected host " +
+		"(with --target-os for another OS), then copy <dir>/requirements here and pass it to --requirements-path"
+	remediationDownloadBundle = "run `bluebanquise-installer download --collections --requirements --core-vars --path <dir>` " +
+		"on a connected host, then copy <dir> here and pass it to --from-bundle"
+	// RemediationUnsupportedOS is the remediation of CodeUnsupportedOS.
+	RemediationUnsupportedOS = "install on a supported distribution (see Supported Distributions in the README), " +
+		"or pass --allow-unsupported-os with --python and --packages to continue at your own risk"
+)
+
+// ValidationError is an error with a code and a remediation telling the user
+// what to do about it, printed by the commands below the error.
+type ValidationError struct {
+	Code        string
+	Remediation string
+	Err         error
+}
+
+// NewValidationError returns err with a code and a remediation.
+func NewValidationError(code, remediation string, err error) error {
+	return &ValidationError{Code: code, Remediation: remediation, Err: err}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// AsValidationError returns the validation error err wraps, if any.
+func AsValidationError(err error) (*ValidationError, bool) {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr, true
+	}
+	return nil, false
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationError(t *testing.T) {
+	cause := errors.New("collections path does not exist: /srv/collections")
+	err := fmt.Errorf("collections validation failed: %w", NewValidationError(CodeCollectionsPathMissing, "download them", cause))
+
+	assert.EqualError(t, err, "collections validation failed: collections path does not exist: /srv/collections")
+	assert.ErrorIs(t, err, cause)
+	validationErr, ok := AsValidationError(err)
+	require.True(t, ok)
+	assert.Equal(t, CodeCollectionsPathMissing, validationErr.Code)
+	assert.Equal(t, "download them", validationErr.Remediation)
+
+	_, ok = AsValidationError(cause)
+	assert.False(t, ok)
+}
+
+func TestValidationErrorPaths(t *testing.T) {
+	InitTestLogger()
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name            string
+		run             func() error
+		wantCode        string
+		wantRemediation string
+	}{
+		{
+			name:            "Missing collections path",
+			run:             func() error { _, err := CheckCollectionsPrerequisites(missing); return err },
+			wantCode:        CodeCollectionsPathMissing,
+			wantRemediation: "download --collections --path <dir>",
+		},
+		{
+			name:            "Empty collections directory",
+			run:             func() error { _, err := CheckCollectionsPrerequisites(t.TempDir()); return err },
+			wantCode:        CodeCollectionsNotFound,
+			wantRemediation: "download --collections --path <dir>",
+		},
+		{
+			name: "Pattern matching nothing",
+			run: func() error {
+				_, err := CheckCollectionsPrerequisites(filepath.Join(t.TempDir(), "*.tar.gz"))
+				return err
+			},
+			wantCode:        CodeCollectionsNotFound,
+			wantRemediation: "download --collections --path <dir>",
+		},
+		{
+			name:            "Missing collections bundle",
+			run:             func() error { _, _, err := PrepareCollectionsPath(missing+".tar.gz", false); return err },
+			wantCode:        CodeCollectionsPathMissing,
+			wantRemediation: "pass it to --collections-path",
+		},
+		{
+			name:            "Missing offline bundle",
+			run:             func() error { _, _, err := PrepareOfflineBundle(missing, false); return err },
+			wantCode:        CodeOfflineBundleMissing,
+			wantRemediation: "pass it to --from-bundle",
+		},
+		{
+			name:            "Missing requirements path",
+			run:             func() error { return CheckRequirementsPrerequisites(missing, false) },
+			wantCode:        CodeRequirementsPathMissing,
+			wantRemediation: "download --requirements --path <dir>",
+		},
+		{
+			name:            "Empty requirements directory",
+			run:             func() error { return CheckRequirementsPrerequisites(t.TempDir(), false) },
+			wantCode:        CodeRequirementsNotFound,
+			wantRemediation: "pass it to --requirements-path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validationErr, ok := AsValidationError(tt.run())
+			require.True(t, ok, "expected a validation error")
+			assert.Equal(t, tt.wantCode, validationErr.Code)
+			assert.Contains(t, validationErr.Remediation, tt.wantRemediation)
+		})
+	}
+}
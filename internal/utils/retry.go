@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RetryPolicy is how an operation is retried: up to Retries more attempts after
+// the first one, waiting Delay before the first retry and twice as long each
+// time after.
+type RetryPolicy struct {
+	Retries int
+	Delay   time.Duration
+}
+
+// Overrides of every RetryPolicy set with --retries and --retry-delay, nil
+// keeps the default of each operation.
+var (
+	retriesOverride    *int
+	retryDelayOverride *time.Duration
+)
+
+// SetRetries makes every retried operation retry up to retries times.
+func SetRetries(retries int) error {
+	if retries < 0 {
+		return fmt.Errorf("invalid retries %d: must be 0 or more", retries)
+	}
+	retriesOverride = &retries
+	return nil
+}
+
+// SetRetryDelay makes every retried operation wait delay before its first retry.
+func SetRetryDelay(delay time.Duration) error {
+	if delay < 0 {
+		return fmt.Errorf("invalid retry delay %s: must be 0 or more", delay)
+	}
+	retryDelayOverride = &delay
+	return nil
+}
+
+// withOverrides returns p with the values set by SetRetries and SetRetryDelay.
+func (p RetryPolicy) withOverrides() RetryPolicy {
+	if retriesOverride != nil {
+		p.Retries = *retriesOverride
+	}
+	if retryDelayOverride != nil {
+		p.Delay = *retryDelayOverride
+	}
+	return p
+}
+
+// sleep waits for d or until ctx is done, tests replace it with a fake clock.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Retry calls fn while it fails with an error accepted by retryable, as many
+// times as policy allows once the --retries and --retry-delay overrides are
+// applied. what names the operation in the retry messages. The error of the
+// last attempt is returned, or the context error when ctx is done while waiting.
+func Retry(ctx context.Context, what string, policy RetryPolicy, retryable func(error) bool, fn func() error) error {
+	policy = policy.withOverrides()
+	attempts := policy.Retries + 1
+	delay := policy.Delay
+	for attempt := 1; ; attempt++ {
+		LogInfo("Running "+what, "attempt", attempt, "max_attempts", attempts)
+		err := fn()
+		if err == nil || attempt >= attempts || !retryable(err) {
+			return err
+		}
+
+		LogWarning(what+" failed, retrying", "attempt", attempt, "delay", delay, "error", err)
+		fmt.Printf("%s failed, retrying in %s (attempt %d/%d)...\n", what, delay, attempt+1, attempts)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// transientCommandErrors are output fragments of the network failures of
+// package managers, git and ansible-galaxy worth retrying.
+var transientCommandErrors = []string{
+	"Could not resolve host",
+	"Temporary failure in name resolution",
+	"Temporary failure resolving",
+	"Connection timed out",
+	"Operation timed out",
+	"Connection reset by peer",
+	"Connection refused",
+	"early EOF",
+	"RPC failed",
+	"Curl error",
+	"Failed to fetch",
+	"Cannot download",
+	"Failed to download",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Time-out",
+}
+
+// IsTransientCommandOutput reports whether the output of a command shows a
+// network failure worth retrying.
+func IsTransientCommandOutput(output string) bool {
+	for _, fragment := range transientCommandErrors {
+		if strings.Contains(output, fragment) {
+			return true
+		}
+	}
+	return false
+}
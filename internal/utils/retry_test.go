@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry(t *testing.T) {
+	InitTestLogger()
+
+	originalSleep := sleep
+	defer func() {
+		sleep = originalSleep
+		retriesOverride, retryDelayOverride = nil, nil
+	}()
+
+	errTransient := errors.New("connection reset")
+	errPermanent := errors.New("not found")
+
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		retries    *int
+		delay      *time.Duration
+		failures   []error
+		wantCalls  int
+		wantSleeps []time.Duration
+		wantErr    error
+	}{
+		{
+			name:      "Succeeds first try",
+			policy:    RetryPolicy{Retries: 2, Delay: time.Second},
+			wantCalls: 1,
+		},
+		{
+			name:       "Succeeds after retries",
+			policy:     RetryPolicy{Retries: 2, Delay: time.Second},
+			failures:   []error{errTransient, errTransient},
+			wantCalls:  3,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:       "Gives up after the retries",
+			policy:     RetryPolicy{Retries: 2, Delay: time.Second},
+			failures:   []error{errTransient, errTransient, errTransient, errTransient},
+			wantCalls:  3,
+			wantSleeps: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:    errTransient,
+		},
+		{
+			name:      "Permanent error is not retried",
+			policy:    RetryPolicy{Retries: 2, Delay: time.Second},
+			failures:  []error{errPermanent},
+			wantCalls: 1,
+			wantErr:   errPermanent,
+		},
+		{
+			name:      "Overrides replace the policy",
+			policy:    RetryPolicy{Retries: 2, Delay: time.Second},
+			retries:   new(int),
+			delay:     new(time.Duration),
+			failures:  []error{errTransient},
+			wantCalls: 1,
+			wantErr:   errTransient,
+		},
+		{
+			name:       "More retries with a longer delay",
+			policy:     RetryPolicy{Retries: 1, Delay: time.Second},
+			retries:    func() *int { n := 4; return &n }(),
+			delay:      func() *time.Duration { d := 10 * time.Second; return &d }(),
+			failures:   []error{errTransient, errTransient, errTransient},
+			wantCalls:  4,
+			wantSleeps: []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retriesOverride, retryDelayOverride = tt.retries, tt.delay
+			var sleeps []time.Duration
+			sleep = func(ctx context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				return nil
+			}
+
+			calls := 0
+			err := Retry(context.Background(), "test", tt.policy,
+				func(err error) bool { return errors.Is(err, errTransient) },
+				func() error {
+					calls++
+					if calls <= len(tt.failures) {
+						return tt.failures[calls-1]
+					}
+					return nil
+				})
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantCalls, calls)
+			assert.Equal(t, tt.wantSleeps, sleeps)
+		})
+	}
+
+	t.Run("Cancelled while waiting", func(t *testing.T) {
+		retriesOverride, retryDelayOverride = nil, nil
+		sleep = originalSleep
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		calls := 0
+		err := Retry(ctx, "test", RetryPolicy{Retries: 3, Delay: time.Hour},
+			func(error) bool { return true },
+			func() error { calls++; return errTransient })
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestSetRetries(t *testing.T) {
+	defer func() { retriesOverride, retryDelayOverride = nil, nil }()
+
+	assert.Error(t, SetRetries(-1))
+	assert.Error(t, SetRetryDelay(-time.Second))
+	require.NoError(t, SetRetries(5))
+	require.NoError(t, SetRetryDelay(3*time.Second))
+	assert.Equal(t, RetryPolicy{Retries: 5, Delay: 3 * time.Second}, RetryPolicy{Retries: 1, Delay: time.Second}.withOverrides())
+}
+
+func TestIsTransientCommandOutput(t *testing.T) {
+	tests := []struct {
+		output    string
+		transient bool
+	}{
+		{output: "fatal: unable to access 'https://github.com/bluebanquise/bluebanquise.git/': Could not resolve host: github.com", transient: true},
+		{output: "Curl error (28): Timeout was reached for https://mirrors.rockylinux.org", transient: true},
+		{output: "E: Failed to fetch http://archive.ubuntu.com/ubuntu/pool/main/g/git/git_2.34.1.deb  503 Service Unavailable", transient: true},
+		{output: "error: RPC failed; curl 56 GnuTLS recv error (-9)", transient: true},
+		{output: "Error: Unable to find a match: python3.12-venv"},
+		{output: "ERROR! Neither the collection requirement entry key 'name', nor 'source' point to a concrete resolvable collection artifact."},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.transient, IsTransientCommandOutput(tt.output), tt.output)
+	}
+}
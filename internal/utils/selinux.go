@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SELinuxEnforcing reports whether SELinux is in enforcing mode according to getenforce.
+func SELinuxEnforcing() bool {
+	output, err := commandOutput("getenforce")
+	if err != nil {
+		LogInfo("SELinux status not available", "error", err)
+		return false
+	}
+	mode := strings.TrimSpace(output)
+	LogInfo("SELinux mode detected", "mode", mode)
+	return mode == "Enforcing"
+}
+
+// RestoreconCommand returns the command restoring SELinux labels on path.
+func RestoreconCommand(path string) string {
+	return fmt.Sprintf("restorecon -R %s", path)
+}
+
+// EnsureSELinuxContext restores the SELinux labels of path when SELinux is enforcing.
+// It only warns when the labels cannot be restored, as the installation itself succeeded.
+func EnsureSELinuxContext(path string) {
+	if !SELinuxEnforcing() {
+		return
+	}
+
+	LogInfo("SELinux is enforcing, restoring file contexts", "path", path)
+	fmt.Println("SELinux is enforcing, restoring file contexts...")
+	if output, err := commandOutput("restorecon", "-R", path); err != nil {
+		LogWarning("Failed to restore SELinux contexts", "error", err, "path", path, "output", output)
+		fmt.Printf("Warning: could not restore SELinux contexts, Ansible may fail at runtime. Run: %s\n", RestoreconCommand(path))
+	}
+}
+
+// CheckSELinuxContext reports whether files below path are mislabeled when SELinux is enforcing.
+// It never changes labels, and returns an error describing the fix when some are wrong.
+func CheckSELinuxContext(path string) error {
+	if !SELinuxEnforcing() {
+		return nil
+	}
+
+	output, err := commandOutput("restorecon", "-R", "-n", "-v", path)
+	if err != nil {
+		LogWarning("Failed to check SELinux contexts", "error", err, "path", path, "output", output)
+		return fmt.Errorf("cannot verify SELinux contexts, run: %s", RestoreconCommand(path))
+	}
+	if strings.TrimSpace(output) != "" {
+		LogWarning("SELinux contexts need to be restored", "path", path, "output", output)
+		return fmt.Errorf("SELinux contexts are wrong, run: %s", RestoreconCommand(path))
+	}
+	return nil
+}
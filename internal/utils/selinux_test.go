@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommandOutput replaces commandOutput with responses keyed by command line.
+func fakeCommandOutput(t *testing.T, responses map[string]string, calls *[]string) {
+	original := commandOutput
+	t.Cleanup(func() { commandOutput = original })
+
+	commandOutput = func(command string, args ...string) (string, error) {
+		line := strings.Join(append([]string{command}, args...), " ")
+		*calls = append(*calls, line)
+		output, ok := responses[line]
+		if !ok {
+			return "", errors.New("command not found")
+		}
+		return output, nil
+	}
+}
+
+func TestSELinuxEnforcing(t *testing.T) {
+	var calls []string
+
+	fakeCommandOutput(t, map[string]string{"getenforce": "Enforcing\n"}, &calls)
+	assert.True(t, SELinuxEnforcing())
+
+	fakeCommandOutput(t, map[string]string{"getenforce": "Permissive\n"}, &calls)
+	assert.False(t, SELinuxEnforcing())
+
+	fakeCommandOutput(t, map[string]string{}, &calls)
+	assert.False(t, SELinuxEnforcing())
+}
+
+func TestEnsureSELinuxContext(t *testing.T) {
+	t.Run("Enforcing runs restorecon", func(t *testing.T) {
+		var calls []string
+		fakeCommandOutput(t, map[string]string{
+			"getenforce":                          "Enforcing",
+			"restorecon -R /var/lib/bluebanquise": "",
+		}, &calls)
+
+		EnsureSELinuxContext("/var/lib/bluebanquise")
+		assert.Equal(t, []string{"getenforce", "restorecon -R /var/lib/bluebanquise"}, calls)
+	})
+
+	t.Run("Permissive does nothing", func(t *testing.T) {
+		var calls []string
+		fakeCommandOutput(t, map[string]string{"getenforce": "Permissive"}, &calls)
+
+		EnsureSELinuxContext("/var/lib/bluebanquise")
+		assert.Equal(t, []string{"getenforce"}, calls)
+	})
+}
+
+func TestCheckSELinuxContext(t *testing.T) {
+	t.Run("Mislabeled files", func(t *testing.T) {
+		var calls []string
+		fakeCommandOutput(t, map[string]string{
+			"getenforce": "Enforcing",
+			"restorecon -R -n -v /var/lib/bluebanquise": "Would relabel /var/lib/bluebanquise/.ssh",
+		}, &calls)
+
+		err := CheckSELinuxContext("/var/lib/bluebanquise")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "restorecon -R /var/lib/bluebanquise")
+	})
+
+	t.Run("Correct labels", func(t *testing.T) {
+		var calls []string
+		fakeCommandOutput(t, map[string]string{
+			"getenforce": "Enforcing",
+			"restorecon -R -n -v /var/lib/bluebanquise": "",
+		}, &calls)
+
+		assert.NoError(t, CheckSELinuxContext("/var/lib/bluebanquise"))
+	})
+
+	t.Run("Not enforcing", func(t *testing.T) {
+		var calls []string
+		fakeCommandOutput(t, map[string]string{"getenforce": "Disabled"}, &calls)
+
+		assert.NoError(t, CheckSELinuxContext("/var/lib/bluebanquise"))
+	})
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// stateDir is the directory set with SetStateDir, empty for the default
+// locations.
+var stateDir string
+
+// SetStateDir gathers the files the installer writes outside the user home
+// under dir: the log file goes to dir/logs, unless LOG_DIR is set, and the
+// temporary files to dir/tmp. An empty dir restores the default locations,
+// /var/log/bluebanquise and os.TempDir().
+func SetStateDir(dir string) error {
+	if dir == "" {
+		stateDir = ""
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("state directory must be an absolute path: %s", dir)
+	}
+	// Temporary files are read by the target user, as os.TempDir() would be
+	for _, path := range []string{dir, filepath.Join(dir, "tmp")} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("failed to create state directory %s: %v", path, err)
+		}
+	}
+	stateDir = dir
+	return nil
+}
+
+// StateDir returns the directory set with SetStateDir, empty by default.
+func StateDir() string {
+	return stateDir
+}
+
+// TempDir returns the directory temporary files and directories are created
+// in: the tmp directory of the state directory, or os.TempDir() by default.
+func TempDir() string {
+	if stateDir == "" {
+		return os.TempDir()
+	}
+	return filepath.Join(stateDir, "tmp")
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateDir(t *testing.T) {
+	defer InitTestLogger()
+	defer func() { require.NoError(t, SetStateDir("")) }()
+	t.Setenv("LOG_DIR", "")
+
+	logDir, fallback := resolveLogDir()
+	assert.Equal(t, defaultLogDir, logDir)
+	assert.True(t, fallback)
+	assert.Equal(t, os.TempDir(), TempDir())
+
+	assert.ErrorContains(t, SetStateDir("state"), "must be an absolute path")
+
+	dir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, SetStateDir(dir))
+	assert.Equal(t, dir, StateDir())
+	assert.Equal(t, filepath.Join(dir, "tmp"), TempDir())
+	assert.DirExists(t, TempDir())
+
+	logDir, fallback = resolveLogDir()
+	assert.Equal(t, filepath.Join(dir, "logs"), logDir)
+	assert.False(t, fallback)
+	logFile, err := initLogger(logDir, fallback, defaultLogDirMode, defaultLogFileMode, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "logs", "bluebanquise-installer.log"), logFile)
+	assert.FileExists(t, logFile)
+
+	collectionsTemp, err := makeCollectionsTempDir()
+	require.NoError(t, err)
+	defer os.RemoveAll(collectionsTemp)
+	assert.Equal(t, TempDir(), filepath.Dir(collectionsTemp))
+
+	// LOG_DIR still takes precedence
+	t.Setenv("LOG_DIR", "/srv/logs")
+	logDir, _ = resolveLogDir()
+	assert.Equal(t, "/srv/logs", logDir)
+}
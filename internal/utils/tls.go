@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures the certificate verification of HTTPS downloads and pip,
+// e.g. for internal mirrors signed by a private CA.
+type TLSOptions struct {
+	// CACert is a PEM file of CA certificates trusted in addition to the system ones.
+	CACert string
+	// InsecureSkipVerify disables certificate verification altogether.
+	InsecureSkipVerify bool
+}
+
+// systemCABundles are the PEM bundles of the system roots on the supported
+// distributions, merged with the CA file into the bundle given to pip and
+// ansible-galaxy, as pip --cert replaces its own bundle instead of extending it.
+var systemCABundles = []string{
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/ssl/ca-bundle.pem",
+}
+
+// httpClient is shared by all downloads, ConfigureTLS replaces its transport.
+var httpClient = &http.Client{}
+
+// tlsOptions are the options of the last ConfigureTLS call, applied to pip.
+var tlsOptions TLSOptions
+
+// caBundle is the system roots plus the CA file written by ConfigureTLS, given
+// to pip and ansible-galaxy.
+var caBundle string
+
+// ConfigureTLS applies opts to the shared HTTP client and to pip commands.
+func ConfigureTLS(opts TLSOptions) error {
+	config, err := newTLSConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.InsecureSkipVerify {
+		LogWarning("TLS certificate verification is disabled for downloads and pip, only use this with trusted mirrors")
+		fmt.Println("WARNING: TLS certificate verification is disabled (--insecure-skip-verify)")
+	}
+	if opts.CACert != "" {
+		LogInfo("Trusting additional CA certificates", "file", opts.CACert)
+	}
+
+	bundle := ""
+	if opts.CACert != "" {
+		if bundle, err = writeCABundle(opts.CACert); err != nil {
+			return err
+		}
+	}
+	if opts.InsecureSkipVerify && len(pipIndexHosts()) == 0 {
+		LogWarning("No PIP_INDEX_URL or PIP_EXTRA_INDEX_URL set, pip keeps verifying the certificates of PyPI")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	httpClient = &http.Client{Transport: transport}
+	tlsOptions = opts
+	RemoveCABundle()
+	caBundle = bundle
+	return nil
+}
+
+// writeCABundle writes the first system bundle of systemCABundles followed by
+// the certificates of caCert to a temporary file readable by the BlueBanquise
+// user, which ansible-galaxy runs as.
+func writeCABundle(caCert string) (string, error) {
+	ca, err := os.ReadFile(caCert)
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	var content []byte
+	for _, path := range systemCABundles {
+		if system, err := os.ReadFile(path); err == nil {
+			content = append(system, '\n')
+			break
+		}
+	}
+	if content == nil {
+		LogWarning("No system CA bundle found, pip and ansible-galaxy only trust the CA file", "file", caCert)
+	}
+	content = append(content, ca...)
+
+	file, err := os.CreateTemp(TempDir(), "bluebanquise-ca-bundle-*.pem")
+	if err != nil {
+		return "", fmt.Errorf("failed to create CA bundle: %v", err)
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write CA bundle: %v", err)
+	}
+	LogInfo("CA bundle written for pip and ansible-galaxy", "path", file.Name(), "ca_cert", caCert)
+	return file.Name(), nil
+}
+
+// RemoveCABundle removes the CA bundle written by ConfigureTLS, if any.
+func RemoveCABundle() {
+	if caBundle == "" {
+		return
+	}
+	if err := os.Remove(caBundle); err != nil && !os.IsNotExist(err) {
+		LogWarning("Could not remove CA bundle", "path", caBundle, "error", err)
+	}
+	caBundle = ""
+}
+
+// newTLSConfig returns the TLS configuration of opts, the system roots plus
+// the certificates of opts.CACert.
+func newTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	// InsecureSkipVerify is only set by the explicit --insecure-skip-verify flag
+	config := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: opts.InsecureSkipVerify} //nolint:gosec
+	if opts.CACert == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(opts.CACert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificate found in %s", opts.CACert)
+	}
+	config.RootCAs = roots
+	return config, nil
+}
+
+// PipTLSArgs returns the pip options matching the TLS configuration: --cert
+// for the CA bundle, and --trusted-host for the hosts of PIP_INDEX_URL and
+// PIP_EXTRA_INDEX_URL when verification is disabled. PyPI itself stays verified.
+func PipTLSArgs() []string {
+	var args []string
+	if caBundle != "" {
+		args = append(args, "--cert", caBundle)
+	}
+	if tlsOptions.InsecureSkipVerify {
+		for _, host := range pipIndexHosts() {
+			args = append(args, "--trusted-host", host)
+		}
+	}
+	return args
+}
+
+// pipIndexHosts returns the hosts of PIP_INDEX_URL and of the space separated
+// PIP_EXTRA_INDEX_URL.
+func pipIndexHosts() []string {
+	var hosts []string
+	for _, env := range []string{"PIP_INDEX_URL", "PIP_EXTRA_INDEX_URL"} {
+		for _, index := range strings.Fields(os.Getenv(env)) {
+			if u, err := url.Parse(index); err == nil && u.Hostname() != "" {
+				hosts = append(hosts, u.Hostname())
+			}
+		}
+	}
+	return hosts
+}
+
+// GalaxyTLSCommand returns the command running ansible-galaxy collection
+// action with args from the network, with the TLS configuration of --ca-cert
+// or --insecure-skip-verify: an env command setting galaxyTLSEnv and the
+// options of galaxyTLSArgs.
+func GalaxyTLSCommand(ansibleGalaxy, action string, args ...string) (string, []string) {
+	galaxyArgs := append([]string{"collection", action}, galaxyTLSArgs()...)
+	galaxyArgs = append(galaxyArgs, args...)
+	env := galaxyTLSEnv()
+	if len(env) == 0 {
+		return ansibleGalaxy, galaxyArgs
+	}
+	return "env", append(append(env, ansibleGalaxy), galaxyArgs...)
+}
+
+// galaxyTLSArgs returns the ansible-galaxy collection options matching the TLS
+// configuration: --ignore-certs when verification is disabled.
+func galaxyTLSArgs() []string {
+	if tlsOptions.InsecureSkipVerify {
+		return []string{"--ignore-certs"}
+	}
+	return nil
+}
+
+// galaxyTLSEnv returns the environment of an online ansible-galaxy run matching
+// the TLS configuration: the CA bundle for Python and the git clones of git+
+// sources, or no verification of the git clones when verification is disabled.
+func galaxyTLSEnv() []string {
+	if caBundle != "" {
+		return []string{"SSL_CERT_FILE=" + caBundle, "REQUESTS_CA_BUNDLE=" + caBundle, "GIT_SSL_CAINFO=" + caBundle}
+	}
+	if tlsOptions.InsecureSkipVerify {
+		return []string{"GIT_SSL_NO_VERIFY=true"}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureTLS(t *testing.T) {
+	InitTestLogger()
+
+	originalClient, originalOptions, originalBundles := httpClient, tlsOptions, systemCABundles
+	defer func() {
+		RemoveCABundle()
+		httpClient, tlsOptions, systemCABundles = originalClient, originalOptions, originalBundles
+	}()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caCert := filepath.Join(dir, "mirror-ca.pem")
+	require.NoError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+	dest := filepath.Join(dir, "bb_core.yml")
+	systemBundle := filepath.Join(dir, "ca-bundle.crt")
+	require.NoError(t, os.WriteFile(systemBundle, []byte("system roots"), 0644))
+	systemCABundles = []string{filepath.Join(dir, "missing.crt"), systemBundle}
+
+	// The private CA is rejected by default
+	require.NoError(t, ConfigureTLS(TLSOptions{}))
+	assert.Error(t, DownloadFile(server.URL+"/bb_core.yml", dest, DownloadOptions{}))
+	assert.Empty(t, PipTLSArgs())
+	command, args := GalaxyTLSCommand("ansible-galaxy", "install", "community.general")
+	assert.Equal(t, "ansible-galaxy", command)
+	assert.Equal(t, []string{"collection", "install", "community.general"}, args)
+
+	require.NoError(t, ConfigureTLS(TLSOptions{CACert: caCert}))
+	transport, ok := httpClient.Transport.(*http.Transport)
+	require.True(t, ok)
+	require.NotNil(t, transport.TLSClientConfig.RootCAs)
+	assert.False(t, transport.TLSClientConfig.InsecureSkipVerify)
+	require.NoError(t, DownloadFile(server.URL+"/bb_core.yml", dest, DownloadOptions{}))
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	// pip and ansible-galaxy get the system roots followed by the CA
+	bundle := caBundle
+	assert.Equal(t, []string{"--cert", bundle}, PipTLSArgs())
+	content, err := os.ReadFile(bundle)
+	require.NoError(t, err)
+	ca, err := os.ReadFile(caCert)
+	require.NoError(t, err)
+	assert.Equal(t, "system roots\n"+string(ca), string(content))
+	command, args = GalaxyTLSCommand("ansible-galaxy", "install", "community.general")
+	assert.Equal(t, "env", command)
+	assert.Equal(t, []string{
+		"SSL_CERT_FILE=" + bundle, "REQUESTS_CA_BUNDLE=" + bundle, "GIT_SSL_CAINFO=" + bundle,
+		"ansible-galaxy", "collection", "install", "community.general",
+	}, args)
+
+	// Verification is only disabled for the configured pip indexes, not PyPI
+	require.NoError(t, ConfigureTLS(TLSOptions{InsecureSkipVerify: true}))
+	assert.NoFileExists(t, bundle, "the previous CA bundle is removed")
+	assert.Empty(t, PipTLSArgs())
+	t.Setenv("PIP_INDEX_URL", "https://pypi.example.com/simple")
+	t.Setenv("PIP_EXTRA_INDEX_URL", "https://extra.example.com/simple https://other.example.com/simple")
+	require.NoError(t, DownloadFile(server.URL+"/bb_core.yml", dest, DownloadOptions{}))
+	assert.Equal(t, []string{
+		"--trusted-host", "pypi.example.com",
+		"--trusted-host", "extra.example.com",
+		"--trusted-host", "other.example.com",
+	}, PipTLSArgs())
+	command, args = GalaxyTLSCommand("ansible-galaxy", "download", "community.general")
+	assert.Equal(t, "env", command)
+	assert.Equal(t, []string{"GIT_SSL_NO_VERIFY=true", "ansible-galaxy", "collection", "download", "--ignore-certs", "community.general"}, args)
+
+	// Invalid CA files leave the configuration unchanged
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0644))
+	assert.Error(t, ConfigureTLS(TLSOptions{CACert: invalid}))
+	assert.Error(t, ConfigureTLS(TLSOptions{CACert: filepath.Join(dir, "missing.pem")}))
+	assert.True(t, tlsOptions.InsecureSkipVerify)
+}
@@ -1,18 +1,190 @@
 package utils
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 )
 
-// ShowCompletionMessage displays the completion message.
-func ShowCompletionMessage(userName, userHome string) {
+// Values of the --color option.
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ColorModes lists the accepted values of the --color option.
+var ColorModes = []string{ColorAuto, ColorAlways, ColorNever}
+
+// ANSI escape sequences of the status colors.
+const (
+	ansiGreen  = "\033[32m"
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+	ansiReset  = "\033[0m"
+)
+
+// colorEnabled is set by SetColorMode, output is plain until then.
+var colorEnabled bool
+
+// SetColorMode enables colored output for always, disables it for never and,
+// for auto, enables it when out is a terminal and NO_COLOR is not set.
+func SetColorMode(mode string, out *os.File) error {
+	enabled, err := colorEnabledFor(mode, out)
+	if err != nil {
+		return err
+	}
+	colorEnabled = enabled
+	return nil
+}
+
+// colorEnabledFor reports whether mode enables colors on out.
+func colorEnabledFor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case ColorAlways:
+		return true, nil
+	case ColorNever:
+		return false, nil
+	case ColorAuto, "":
+		return os.Getenv("NO_COLOR") == "" && IsTerminal(out), nil
+	}
+	return false, fmt.Errorf("invalid color mode %q (expected one of: %s)", mode, strings.Join(ColorModes, ", "))
+}
+
+// IsTerminal reports whether f is a character device such as a TTY.
+func IsTerminal(f *os.File) bool {
+	if f == nil {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps text in the ANSI color code when colors are enabled.
+func colorize(code, text string) string {
+	if !colorEnabled {
+		return text
+	}
+	return code + text + ansiReset
+}
+
+// ColorPass colors a passed check, in green.
+func ColorPass(text string) string {
+	return colorize(ansiGreen, text)
+}
+
+// ColorFail colors a failed check or an error, in red.
+func ColorFail(text string) string {
+	return colorize(ansiRed, text)
+}
+
+// ColorWarn colors a warning, in yellow.
+func ColorWarn(text string) string {
+	return colorize(ansiYellow, text)
+}
+
+// ErrNoAnswer is returned by the prompts when the input ends before an answer.
+var ErrNoAnswer = errors.New("no answer, input closed")
+
+// Prompter asks questions on out and reads the answers, one per line, from in.
+type Prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// NewPrompter returns a Prompter reading from in and writing to out.
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	return &Prompter{in: bufio.NewReader(in), out: out}
+}
+
+// readLine returns the next answer without surrounding spaces.
+func (p *Prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", ErrNoAnswer
+		}
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// Ask asks question and returns the answer, def when it is empty.
+func (p *Prompter) Ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// Confirm asks a yes/no question and returns def on an empty answer. Any
+// other answer than yes or no asks again.
+func (p *Prompter) Confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, hint)
+		answer, err := p.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Please answer yes or no.")
+	}
+}
+
+// Choose asks for one of choices and returns def on an empty answer. Any
+// other answer asks again.
+func (p *Prompter) Choose(question string, choices []string, def string) (string, error) {
+	for {
+		answer, err := p.Ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/")), def)
+		if err != nil {
+			return "", err
+		}
+		if slices.Contains(choices, answer) {
+			return answer, nil
+		}
+		fmt.Fprintf(p.out, "Please answer one of: %s.\n", strings.Join(choices, ", "))
+	}
+}
+
+// ShowCompletionMessage displays the completion message. envWrapper is the
+// script running a command in the environment of a user whose shell refuses
+// logins, empty when the user logs in with su.
+func ShowCompletionMessage(userName, userHome, envWrapper string) {
 	fmt.Println()
 	fmt.Println("Bootstrap done.")
-	fmt.Printf("You can now login as %s user via 'su - %s'\n", userName, userName)
-	fmt.Println()
-	fmt.Println("To use BlueBanquise, remember to set Ansible environment variable:")
-	fmt.Printf("ANSIBLE_CONFIG=$HOME/bluebanquise/ansible.cfg\n")
+	if envWrapper != "" {
+		fmt.Printf("The shell of the %s user refuses logins, run BlueBanquise commands from an admin account with:\n", userName)
+		fmt.Printf("sudo -u %s %s ansible-playbook ...\n", userName, envWrapper)
+	} else {
+		fmt.Printf("You can now login as %s user via 'su - %s'\n", userName, userName)
+		fmt.Println()
+		fmt.Println("To use BlueBanquise, remember to set Ansible environment variable:")
+		fmt.Printf("ANSIBLE_CONFIG=$HOME/bluebanquise/ansible.cfg\n")
+	}
 	fmt.Println()
 	fmt.Println("You can find documentation at http://bluebanquise.com/documentation/")
 	fmt.Println("You can ask for help or rise issues at https://github.com/bluebanquise/bluebanquise/")
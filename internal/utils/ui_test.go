@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetColorModeNotTerminal(t *testing.T) {
+	defer func() { colorEnabled = false }()
+
+	reader, writer, err := os.Pipe()
+	require.NoError(t, err)
+	defer reader.Close()
+	defer writer.Close()
+
+	require.NoError(t, SetColorMode(ColorAuto, writer))
+	assert.Equal(t, "✓", ColorPass("✓"))
+	assert.Equal(t, "FAILED", ColorFail("FAILED"))
+	assert.Equal(t, "⚠", ColorWarn("⚠"))
+
+	require.NoError(t, SetColorMode(ColorAlways, writer))
+	assert.Equal(t, "\033[32m✓\033[0m", ColorPass("✓"))
+	assert.Equal(t, "\033[31mFAILED\033[0m", ColorFail("FAILED"))
+	assert.Equal(t, "\033[33m⚠\033[0m", ColorWarn("⚠"))
+
+	require.NoError(t, SetColorMode(ColorNever, writer))
+	assert.Equal(t, "✓", ColorPass("✓"))
+}
+
+func TestColorEnabledFor(t *testing.T) {
+	file, err := os.Create(t.TempDir() + "/output")
+	require.NoError(t, err)
+	defer file.Close()
+
+	enabled, err := colorEnabledFor(ColorAuto, file)
+	require.NoError(t, err)
+	assert.False(t, enabled, "a regular file is not a terminal")
+
+	enabled, err = colorEnabledFor(ColorAuto, nil)
+	require.NoError(t, err)
+	assert.False(t, enabled)
+
+	t.Setenv("NO_COLOR", "1")
+	enabled, err = colorEnabledFor(ColorAlways, file)
+	require.NoError(t, err)
+	assert.True(t, enabled, "always overrides NO_COLOR")
+
+	_, err = colorEnabledFor("rainbow", file)
+	assert.Error(t, err)
+}
+
+func TestPrompter(t *testing.T) {
+	var out bytes.Buffer
+	prompter := NewPrompter(strings.NewReader("\n  admin \nmaybe\nyes\n\nftp\noffline\n"), &out)
+
+	answer, err := prompter.Ask("User name", "bluebanquise")
+	require.NoError(t, err)
+	assert.Equal(t, "bluebanquise", answer, "an empty answer takes the default")
+
+	answer, err = prompter.Ask("User name", "bluebanquise")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", answer)
+
+	confirmed, err := prompter.Confirm("Proceed?", false)
+	require.NoError(t, err)
+	assert.True(t, confirmed, "an invalid answer asks again")
+
+	confirmed, err = prompter.Confirm("Proceed?", true)
+	require.NoError(t, err)
+	assert.True(t, confirmed)
+
+	choice, err := prompter.Choose("Mode", []string{"online", "offline"}, "online")
+	require.NoError(t, err)
+	assert.Equal(t, "offline", choice)
+
+	assert.Contains(t, out.String(), "User name [bluebanquise]: ")
+	assert.Contains(t, out.String(), "Please answer yes or no.")
+	assert.Contains(t, out.String(), "Mode (online/offline) [online]: ")
+	assert.Contains(t, out.String(), "Please answer one of: online, offline.")
+
+	_, err = prompter.Ask("Home", "")
+	assert.ErrorIs(t, err, ErrNoAnswer, "closed input fails instead of taking the default")
+}
@@ -0,0 +1,83 @@
+// Package version holds the build metadata of the installer, set at build time with
+//
+//	go build -ldflags "-X github.com/lmagdanello/bluebanquise-installer/internal/version.Release=3.2.1 \
+//	  -X github.com/lmagdanello/bluebanquise-installer/internal/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/lmagdanello/bluebanquise-installer/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without ldflags, Commit and Date come from the VCS information the go
+// command embeds in the binary, when available.
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+// Build metadata, overridden with -ldflags -X.
+var (
+	// Release is the installer release.
+	Release = "3.2.0"
+	// Commit is the Git commit the binary is built from.
+	Commit = ""
+	// Date is the build date, RFC 3339.
+	Date = ""
+)
+
+// readBuildInfo returns the build information embedded by the go command, tests replace it.
+var readBuildInfo = debug.ReadBuildInfo
+
+// Version returns the release followed by the commit and build date when
+// known, e.g. "3.2.0 (commit 1a2b3c4, built 2025-01-31T10:00:00Z)".
+func Version() string {
+	commit, date := Commit, Date
+	if commit == "" || date == "" {
+		vcsCommit, vcsDate := vcsInfo()
+		if commit == "" {
+			commit = vcsCommit
+		}
+		if date == "" {
+			date = vcsDate
+		}
+	}
+
+	var details []string
+	if commit != "" {
+		details = append(details, "commit "+commit)
+	}
+	if date != "" {
+		details = append(details, "built "+date)
+	}
+	if len(details) == 0 {
+		return Release
+	}
+	return fmt.Sprintf("%s (%s)", Release, strings.Join(details, ", "))
+}
+
+// vcsInfo returns the short revision and time of the VCS information embedded
+// in the binary, a modified tree being marked with -dirty.
+func vcsInfo() (string, string) {
+	info, ok := readBuildInfo()
+	if !ok {
+		return "", ""
+	}
+	var revision, date string
+	dirty := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.time":
+			date = setting.Value
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	if len(revision) > 7 {
+		revision = revision[:7]
+	}
+	if revision != "" && dirty {
+		revision += "-dirty"
+	}
+	return revision, date
+}
@@ -0,0 +1,48 @@
+package version
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	originalRelease, originalCommit, originalDate := Release, Commit, Date
+	originalReadBuildInfo := readBuildInfo
+	defer func() {
+		Release, Commit, Date = originalRelease, originalCommit, originalDate
+		readBuildInfo = originalReadBuildInfo
+	}()
+
+	noBuildInfo := func() (*debug.BuildInfo, bool) { return nil, false }
+	vcsBuildInfo := func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2025-01-31T10:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		}}, true
+	}
+
+	tests := []struct {
+		name          string
+		release       string
+		commit        string
+		date          string
+		readBuildInfo func() (*debug.BuildInfo, bool)
+		expected      string
+	}{
+		{name: "Release only", release: "3.2.0", readBuildInfo: noBuildInfo, expected: "3.2.0"},
+		{name: "Set with ldflags", release: "3.3.0", commit: "abc1234", date: "2025-02-01T00:00:00Z", readBuildInfo: vcsBuildInfo, expected: "3.3.0 (commit abc1234, built 2025-02-01T00:00:00Z)"},
+		{name: "Commit only", release: "3.3.0", commit: "abc1234", readBuildInfo: noBuildInfo, expected: "3.3.0 (commit abc1234)"},
+		{name: "From VCS information", release: "3.2.0", readBuildInfo: vcsBuildInfo, expected: "3.2.0 (commit 0123456-dirty, built 2025-01-31T10:00:00Z)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Release, Commit, Date = tt.release, tt.commit, tt.date
+			readBuildInfo = tt.readBuildInfo
+			assert.Equal(t, tt.expected, Version())
+		})
+	}
+}
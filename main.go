@@ -1,18 +1,11 @@
 package main
 
 import (
-	"log"
-
 	"github.com/lmagdanello/bluebanquise-installer/cmd"
-	"github.com/lmagdanello/bluebanquise-installer/internal/utils"
 )
 
 func main() {
-	// Initialize logger.
-	if err := utils.InitLogger(); err != nil {
-		log.Fatalf("Failed to initialize logger: %v", err)
-	}
-
-	// Execute the root command.
+	// Execute the root command, which opens the log file once the flags,
+	// including --state-dir, are parsed.
 	cmd.Execute()
 }